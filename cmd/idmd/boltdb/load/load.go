@@ -64,7 +64,7 @@ func (l *LDIFLoader) Load(ldifFile string) error {
 
 	for _, entry := range lf.AllEntries() {
 		l.logger.Debugf("Adding '%s'", entry.DN)
-		if err := bdb.EntryPut(entry); err != nil {
+		if err := bdb.EntryPut(entry, ""); err != nil {
 			return fmt.Errorf("error adding Entry '%s': %w", entry.DN, err)
 		}
 	}
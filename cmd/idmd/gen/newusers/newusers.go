@@ -85,7 +85,7 @@ func CommandNewusers() *cobra.Command {
 	}
 
 	newusersCmd.Flags().StringVar(&DefaultFormat, "format", DefaultFormat, "Output format")
-	newusersCmd.Flags().StringVar(&DefaultPasswordScheme, "password-scheme", DefaultPasswordScheme, "Password hash algorithm, supports: {ARGON2}, {CLEARTEXT}")
+	newusersCmd.Flags().StringVar(&DefaultPasswordScheme, "password-scheme", DefaultPasswordScheme, "Password hash algorithm, supports: {ARGON2}, {SCRAM-SHA-1}, {SCRAM-SHA-256}, {CLEARTEXT}")
 	newusersCmd.Flags().Uint32Var(&DefaultArgon2Params.Memory, "argon2-memory", DefaultArgon2Params.Memory, "Amount of memory used for ARGON2 password hashing in Kibibytes")
 	newusersCmd.Flags().Uint32Var(&DefaultArgon2Params.Iterations, "argon2-iterations", DefaultArgon2Params.Iterations, "Number of iterations over memory used for ARGON2 password hashing")
 	newusersCmd.Flags().Uint8Var(&DefaultArgon2Params.Parallelism, "argon2-lanes", DefaultArgon2Params.Parallelism, "Number of lanes used for ARGON2 password hashing")
@@ -51,7 +51,7 @@ func CommandPasswd() *cobra.Command {
 		},
 	}
 
-	passwdCmd.Flags().StringVar(&DefaultPasswordScheme, "password-scheme", DefaultPasswordScheme, "Password hash algorithm, supports: {ARGON2}, {CLEARTEXT}")
+	passwdCmd.Flags().StringVar(&DefaultPasswordScheme, "password-scheme", DefaultPasswordScheme, "Password hash algorithm, supports: {ARGON2}, {SCRAM-SHA-1}, {SCRAM-SHA-256}, {CLEARTEXT}")
 	passwdCmd.Flags().Uint32Var(&DefaultArgon2Params.Memory, "argon2-memory", DefaultArgon2Params.Memory, "Amount of memory used for ARGON2 password hashing in Kibibytes")
 	passwdCmd.Flags().Uint32Var(&DefaultArgon2Params.Iterations, "argon2-iterations", DefaultArgon2Params.Iterations, "Number of iterations over memory used for ARGON2 password hashing")
 	passwdCmd.Flags().Uint8Var(&DefaultArgon2Params.Parallelism, "argon2-lanes", DefaultArgon2Params.Parallelism, "Number of lanes used for ARGON2 password hashing")
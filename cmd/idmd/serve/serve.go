@@ -34,15 +34,29 @@ var (
 	DefaultLDAPListenAddr  = "127.0.0.1:10389"
 	DefaultLDAPSListenAddr = ""
 
-	DefaultTLSCertFile = ""
-	DefaultTLSKeyFile  = ""
+	DefaultTLSCertFile     = ""
+	DefaultTLSKeyFile      = ""
+	DefaultTLSClientCAFile = ""
+
+	DefaultLDAPRequireTLSForBind = false
+
+	DefaultLDAPMaxConcurrentOperations = 16
+
+	DefaultSASLExternalMapping   = "dn"
+	DefaultSASLUsernameAttribute = "uid"
+
+	DefaultLDAPProxyAuthzGroup = ""
 
 	DefaultLDAPBaseDN  = ""
 	DefaultLDAPAdminDN = ""
 
 	DefaultLDAPAllowLocalAnonymousBind = false
 
-	DefaultBoltDBFile = "idmbolt.db"
+	DefaultSchemaDir = ""
+
+	DefaultBoltDBFile        = "idmbolt.db"
+	DefaultBoltDBSchemaCheck = false
+
 	DefaultLDIFMain   = ""
 	DefaultLDIFConfig = ""
 
@@ -105,6 +119,11 @@ func setDefaults() {
 		DefaultTLSKeyFile = envDefaultTLSKeyFile
 	}
 
+	envDefaultTLSClientCAFile := os.Getenv(withEnvBase("DEFAULT_TLS_CLIENT_CA_FILE"))
+	if envDefaultTLSClientCAFile != "" {
+		DefaultTLSClientCAFile = envDefaultTLSClientCAFile
+	}
+
 	envDefaultLDIFCompany := os.Getenv(withEnvBase("DEFAULT_LDIF_TEMPLATE_COMPANY"))
 	if envDefaultLDIFCompany != "" {
 		DefaultLDIFCompany = envDefaultLDIFCompany
@@ -158,8 +177,14 @@ func CommandServe() *cobra.Command {
 	serveCmd.Flags().StringVar(&DefaultLDAPListenAddr, "ldap-listen", DefaultLDAPListenAddr, "TCP listen address for LDAP requests")
 	serveCmd.Flags().StringVar(&DefaultLDAPSListenAddr, "ldaps-listen", DefaultLDAPSListenAddr, "TCP listen address for LDAPS requests")
 
-	serveCmd.Flags().StringVar(&DefaultTLSCertFile, "tls-cert-file", DefaultTLSCertFile, "Server Certificate to use for LDAPS connections")
-	serveCmd.Flags().StringVar(&DefaultTLSKeyFile, "tls-key-file", DefaultTLSKeyFile, "Server Certificate Key to use for LDAPS connections")
+	serveCmd.Flags().StringVar(&DefaultTLSCertFile, "tls-cert-file", DefaultTLSCertFile, "Server Certificate to use for LDAPS and StartTLS connections")
+	serveCmd.Flags().StringVar(&DefaultTLSKeyFile, "tls-key-file", DefaultTLSKeyFile, "Server Certificate Key to use for LDAPS and StartTLS connections")
+	serveCmd.Flags().StringVar(&DefaultTLSClientCAFile, "tls-client-ca-file", DefaultTLSClientCAFile, "CA bundle used to verify TLS client certificates for SASL EXTERNAL binds")
+	serveCmd.Flags().StringVar(&DefaultSASLExternalMapping, "sasl-external-mapping", DefaultSASLExternalMapping, "Rule to map TLS client certificates to entries for SASL EXTERNAL binds (one of dn, mail or uid)")
+	serveCmd.Flags().StringVar(&DefaultSASLUsernameAttribute, "sasl-username-attribute", DefaultSASLUsernameAttribute, "Attribute used to look up the entries of user names in SASL PLAIN and SCRAM binds (e.g. uid or mail)")
+	serveCmd.Flags().BoolVar(&DefaultLDAPRequireTLSForBind, "ldap-require-tls-for-bind", DefaultLDAPRequireTLSForBind, "Reject non-anonymous binds on LDAP connections which did not complete StartTLS")
+	serveCmd.Flags().StringVar(&DefaultLDAPProxyAuthzGroup, "ldap-proxy-authz-group", DefaultLDAPProxyAuthzGroup, "DN of a group whose members may act on behalf of other users with the proxied authorization control")
+	serveCmd.Flags().IntVar(&DefaultLDAPMaxConcurrentOperations, "ldap-max-concurrent-operations", DefaultLDAPMaxConcurrentOperations, "Maximum number of operations processed concurrently per LDAP connection")
 
 	serveCmd.Flags().StringVar(&DefaultLDAPBaseDN, "ldap-base-dn", DefaultLDAPBaseDN, "BaseDN for LDAP requests")
 	serveCmd.Flags().StringVar(&DefaultLDAPAdminDN, "ldap-admin-dn",
@@ -167,7 +192,10 @@ func CommandServe() *cobra.Command {
 
 	serveCmd.Flags().BoolVar(&DefaultLDAPAllowLocalAnonymousBind, "ldap-allow-local-anonymous", DefaultLDAPAllowLocalAnonymousBind, "Allow anonymous LDAP bind for all local LDAP clients")
 
+	serveCmd.Flags().StringVar(&DefaultSchemaDir, "schema-dir", DefaultSchemaDir, "Path to a folder with additional schema files (OpenLDAP .schema and cn=schema .ldif files)")
+
 	serveCmd.Flags().StringVar(&DefaultBoltDBFile, "boltdb-file", DefaultBoltDBFile, "Filename of the database for the BoltDB Handler")
+	serveCmd.Flags().BoolVar(&DefaultBoltDBSchemaCheck, "boltdb-schema-check", DefaultBoltDBSchemaCheck, "Check added and modified entries against the schema in the BoltDB Handler")
 
 	serveCmd.Flags().StringVar(&DefaultLDIFMain, "ldif-main", DefaultLDIFMain, "Path to a LDIF file or .d folder containing LDIF files")
 	serveCmd.Flags().StringVar(&DefaultLDIFConfig, "ldif-config", DefaultLDIFConfig, "Path to a LDIF file for entries used only for bind")
@@ -192,6 +220,9 @@ func checkTLSConfig(_ *cobra.Command, _ []string) error {
 			return fmt.Errorf("LDAPS listener is enabled. Please specify a Certifcate Key File")
 		}
 	}
+	if DefaultLDAPRequireTLSForBind && (DefaultTLSCertFile == "" || DefaultTLSKeyFile == "") {
+		return fmt.Errorf("TLS is required for bind. Please specify a Certificate and Certificate Key File")
+	}
 	return nil
 }
 
@@ -233,21 +264,34 @@ func (bs *bootstrap) configure(ctx context.Context, cmd *cobra.Command, args []s
 		LDAPListenAddr:  DefaultLDAPListenAddr,
 		LDAPSListenAddr: DefaultLDAPSListenAddr,
 
-		TLSCertFile: DefaultTLSCertFile,
-		TLSKeyFile:  DefaultTLSKeyFile,
+		TLSCertFile:     DefaultTLSCertFile,
+		TLSKeyFile:      DefaultTLSKeyFile,
+		TLSClientCAFile: DefaultTLSClientCAFile,
+
+		LDAPRequireTLSForBind: DefaultLDAPRequireTLSForBind,
+
+		LDAPMaxConcurrentOperations: DefaultLDAPMaxConcurrentOperations,
+
+		SASLExternalMapping:   DefaultSASLExternalMapping,
+		SASLUsernameAttribute: DefaultSASLUsernameAttribute,
+
+		LDAPProxyAuthzGroup: DefaultLDAPProxyAuthzGroup,
 
 		LDAPBaseDN:  DefaultLDAPBaseDN,
 		LDAPAdminDN: DefaultLDAPAdminDN,
 
 		LDAPAllowLocalAnonymousBind: DefaultLDAPAllowLocalAnonymousBind,
 
+		SchemaDir: DefaultSchemaDir,
+
 		LDIFMain:   DefaultLDIFMain,
 		LDIFConfig: DefaultLDIFConfig,
 
 		LDIFDefaultCompany:    DefaultLDIFCompany,
 		LDIFDefaultMailDomain: DefaultLDIFMailDomain,
 
-		BoltDBFile: DefaultBoltDBFile,
+		BoltDBFile:        DefaultBoltDBFile,
+		BoltDBSchemaCheck: DefaultBoltDBSchemaCheck,
 
 		OnReady: func(srv *server.Server) {
 			if DefaultSystemdNotify {
@@ -0,0 +1,48 @@
+package ldapentry
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csnTimeLayout is the time part of change sequence numbers.
+const csnTimeLayout = "20060102150405.000000Z"
+
+// NextCSN returns a change sequence number (the values of entryCSN and
+// contextCSN) for a change at now, which is greater than last. CSNs use the
+// format of OpenLDAP, YYYYmmddHHMMSS.uuuuuuZ#count#sid#mod, so that they
+// are ordered by their string value. If the clock did not advance past last,
+// the change count of last is incremented.
+func NextCSN(last string, now time.Time) string {
+	csn := formatCSN(now, 0)
+	if csn > last {
+		return csn
+	}
+	t, count, ok := parseCSN(last)
+	if !ok {
+		return csn
+	}
+	return formatCSN(t, count+1)
+}
+
+func formatCSN(t time.Time, count uint64) string {
+	return fmt.Sprintf("%s#%06x#000#000000", t.UTC().Format(csnTimeLayout), count)
+}
+
+func parseCSN(csn string) (time.Time, uint64, bool) {
+	parts := strings.Split(csn, "#")
+	if len(parts) != 4 {
+		return time.Time{}, 0, false
+	}
+	t, err := time.Parse(csnTimeLayout, parts[0])
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	count, err := strconv.ParseUint(parts[1], 16, 64)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	return t, count, true
+}
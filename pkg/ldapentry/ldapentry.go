@@ -2,12 +2,25 @@ package ldapentry
 
 import (
 	"errors"
+	"sync"
 
 	"github.com/go-ldap/ldap/v3"
 	"golang.org/x/text/cases"
+
+	"github.com/libregraph/idm/pkg/schema"
 )
 
+var builtinSchema = sync.OnceValue(schema.New)
+
+// ApplyModify applies mod to old, comparing values with the equality
+// matching rules of the built-in schema.
 func ApplyModify(old *ldap.Entry, mod *ldap.ModifyRequest) (newEntry *ldap.Entry, err error) {
+	return ApplyModifyWithSchema(builtinSchema(), old, mod)
+}
+
+// ApplyModifyWithSchema applies mod to old, comparing values with the
+// equality matching rules of their attribute types in s.
+func ApplyModifyWithSchema(s *schema.Schema, old *ldap.Entry, mod *ldap.ModifyRequest) (newEntry *ldap.Entry, err error) {
 	oldDN, err := ldap.ParseDN(old.DN)
 	if err != nil {
 		return nil, err
@@ -27,9 +40,10 @@ func ApplyModify(old *ldap.Entry, mod *ldap.ModifyRequest) (newEntry *ldap.Entry
 	newEntry.Attributes = old.Attributes
 	for _, c := range mod.Changes {
 		nType := casefold.String(c.Modification.Type)
+		equal := valuesEqual(s, c.Modification.Type)
 		switch c.Operation {
 		case ldap.AddAttribute:
-			newValues := entryApplyModAdd(newEntry.GetEqualFoldAttributeValues(nType), c.Modification.Vals)
+			newValues := entryApplyModAdd(newEntry.GetEqualFoldAttributeValues(nType), c.Modification.Vals, equal)
 			newEntry.Attributes = entryReplaceValues(newEntry.Attributes, c.Modification.Type, newValues)
 
 		case ldap.ReplaceAttribute:
@@ -37,9 +51,8 @@ func ApplyModify(old *ldap.Entry, mod *ldap.ModifyRequest) (newEntry *ldap.Entry
 			for _, rdnAttr := range rdn.Attributes {
 				if nType == casefold.String(rdnAttr.Type) {
 					rdnPresent := false
-					nRdnVal := casefold.String(rdnAttr.Value)
 					for _, newVal := range c.Modification.Vals {
-						if nRdnVal == casefold.String(newVal) {
+						if equal(rdnAttr.Value, newVal) {
 							rdnPresent = true
 							break
 						}
@@ -57,21 +70,27 @@ func ApplyModify(old *ldap.Entry, mod *ldap.ModifyRequest) (newEntry *ldap.Entry
 					if len(c.Modification.Vals) == 0 {
 						return nil, ldap.NewError(ldap.LDAPResultNotAllowedOnRDN, errors.New(""))
 					}
-					nRdnVal := casefold.String(rdnAttr.Value)
 					for _, delVal := range c.Modification.Vals {
-						if nRdnVal == casefold.String(delVal) {
+						if equal(rdnAttr.Value, delVal) {
 							return nil, ldap.NewError(ldap.LDAPResultNotAllowedOnRDN, errors.New(""))
 						}
 					}
 				}
 			}
-			newValues := entryApplyModDelete(old.GetEqualFoldAttributeValues(nType), c.Modification.Vals)
+			newValues := entryApplyModDelete(old.GetEqualFoldAttributeValues(nType), c.Modification.Vals, equal)
 			newEntry.Attributes = entryReplaceValues(newEntry.Attributes, c.Modification.Type, newValues)
 		}
 	}
 	return newEntry, nil
 }
 
+// SetAttributeValues replaces the values of the attribute attrType of e with
+// values, or adds the attribute if e does not have it. Empty values remove
+// the attribute.
+func SetAttributeValues(e *ldap.Entry, attrType string, values []string) {
+	e.Attributes = entryReplaceValues(e.Attributes, attrType, values)
+}
+
 func entryReplaceValues(ea []*ldap.EntryAttribute, attrType string, newValues []string) (updatedAttrs []*ldap.EntryAttribute) {
 	casefold := cases.Fold()
 	nType := casefold.String(attrType)
@@ -95,13 +114,25 @@ func entryReplaceValues(ea []*ldap.EntryAttribute, attrType string, newValues []
 	return updatedAttrs
 }
 
-func entryApplyModAdd(curVals, addVals []string) (newVals []string) {
+// valuesEqual returns a function which compares two values of attrType with
+// its equality matching rule in s. Values of attribute types without an
+// equality matching rule are compared as octet strings.
+func valuesEqual(s *schema.Schema, attrType string) func(a, b string) bool {
+	rule, ok := s.EqualityRule(attrType)
+	if !ok {
+		return func(a, b string) bool {
+			return a == b
+		}
+	}
+	return rule.Equal
+}
+
+func entryApplyModAdd(curVals, addVals []string, equal func(a, b string) bool) (newVals []string) {
 	newVals = curVals
-	casefold := cases.Fold()
 	for _, newVal := range addVals {
 		present := false
 		for _, val := range curVals {
-			if casefold.String(newVal) == casefold.String(val) {
+			if equal(newVal, val) {
 				present = true
 				break
 			}
@@ -113,16 +144,14 @@ func entryApplyModAdd(curVals, addVals []string) (newVals []string) {
 	return newVals
 }
 
-func entryApplyModDelete(curVals, delVals []string) (newVals []string) {
-	casefold := cases.Fold()
+func entryApplyModDelete(curVals, delVals []string, equal func(a, b string) bool) (newVals []string) {
 	if len(delVals) == 0 {
 		return []string{}
 	}
 	for _, curVal := range curVals {
-		nCurVal := casefold.String(curVal)
 		keep := true
 		for _, del := range delVals {
-			if nCurVal == casefold.String(del) {
+			if equal(curVal, del) {
 				keep = false
 				break
 			}
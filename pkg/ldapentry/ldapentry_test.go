@@ -2,6 +2,7 @@ package ldapentry
 
 import (
 	"testing"
+	"time"
 
 	"github.com/go-ldap/ldap/v3"
 )
@@ -161,3 +162,21 @@ func TestApplyModifyReplaceRDN(t *testing.T) {
 		}
 	}
 }
+
+func TestNextCSN(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+	csn := NextCSN("", now)
+	if csn != "20240102030405.000006Z#000000#000#000000" {
+		t.Fatalf("Unexpected CSN %s", csn)
+	}
+	// The clock did not advance or went backwards.
+	for _, at := range []time.Time{now, now.Add(-time.Second)} {
+		next := NextCSN(csn, at)
+		if next != "20240102030405.000006Z#000001#000#000000" {
+			t.Errorf("Unexpected CSN %s following %s", next, csn)
+		}
+	}
+	if next := NextCSN(csn, now.Add(time.Microsecond)); next <= csn {
+		t.Errorf("CSN %s is not greater than %s", next, csn)
+	}
+}
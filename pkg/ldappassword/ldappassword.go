@@ -82,6 +82,13 @@ func Validate(password string, hash string) (bool, error) {
 		passwordBytes = append(passwordBytes, salt...)
 		hashBytes = decodedBytes
 
+	case "{SCRAM-SHA-1}", "{SCRAM-SHA-256}":
+		v, err := ParseSCRAMVerifier(algorithm + hash)
+		if err != nil {
+			return false, err
+		}
+		return v.ValidatePassword(password)
+
 	default:
 		return false, fmt.Errorf("unsupported password algorithm: %s", algorithm)
 	}
@@ -106,6 +113,13 @@ func Hash(password string, algorithm string) (string, error) {
 		}
 		result = "{ARGON2}" + hash
 
+	case "{SCRAM-SHA-1}", "{SCRAM-SHA-256}":
+		hash, hashErr := hashSCRAM(password, algorithm[1:len(algorithm)-1])
+		if hashErr != nil {
+			return "", fmt.Errorf("password hash error: %w", hashErr)
+		}
+		result = hash
+
 	default:
 		return "", fmt.Errorf("password hash alg not supported: %s", algorithm)
 	}
@@ -0,0 +1,181 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2021 The LibreGraph Authors.
+ */
+
+package ldappassword
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1" //nolint,gosec
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+const (
+	SCRAMSHA1   = "SCRAM-SHA-1"
+	SCRAMSHA256 = "SCRAM-SHA-256"
+)
+
+// SCRAMDefaultIterations is the iteration count used when creating new SCRAM
+// verifiers.
+var SCRAMDefaultIterations = 4096
+
+// SCRAMVerifier holds the server side credentials of a user for SCRAM
+// authentication as defined in RFC 5802.
+type SCRAMVerifier struct {
+	Mechanism  string
+	Salt       []byte
+	Iterations int
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// SCRAMHash returns the hash function used by the passed SCRAM mechanism.
+func SCRAMHash(mechanism string) (func() hash.Hash, error) {
+	switch strings.ToUpper(mechanism) {
+	case SCRAMSHA1:
+		return sha1.New, nil
+	case SCRAMSHA256:
+		return sha256.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported SCRAM mechanism: %s", mechanism)
+	}
+}
+
+// NewSCRAMVerifier derives a SCRAMVerifier for the passed mechanism from a
+// cleartext password.
+func NewSCRAMVerifier(mechanism, password string, salt []byte, iterations int) (*SCRAMVerifier, error) {
+	h, err := SCRAMHash(mechanism)
+	if err != nil {
+		return nil, err
+	}
+	saltedPassword, err := pbkdf2.Key(h, password, salt, iterations, h().Size())
+	if err != nil {
+		return nil, fmt.Errorf("scram error: %w", err)
+	}
+	clientKey := scramHMAC(h, saltedPassword, "Client Key")
+	storedKey := h()
+	storedKey.Write(clientKey)
+	return &SCRAMVerifier{
+		Mechanism:  strings.ToUpper(mechanism),
+		Salt:       salt,
+		Iterations: iterations,
+		StoredKey:  storedKey.Sum(nil),
+		ServerKey:  scramHMAC(h, saltedPassword, "Server Key"),
+	}, nil
+}
+
+// ParseSCRAMVerifier parses a SCRAM password hash in the format
+// {SCRAM-SHA-256}<iterations>,<salt>,<storedkey>,<serverkey> (with base64
+// encoded salt and keys).
+func ParseSCRAMVerifier(hash string) (*SCRAMVerifier, error) {
+	if !strings.HasPrefix(hash, "{") {
+		return nil, fmt.Errorf("scram error: missing password scheme")
+	}
+	schemeEnd := strings.Index(hash, "}")
+	if schemeEnd < 0 {
+		return nil, fmt.Errorf("scram error: invalid password scheme")
+	}
+	mechanism := strings.ToUpper(hash[1:schemeEnd])
+	if _, err := SCRAMHash(mechanism); err != nil {
+		return nil, err
+	}
+	parts := strings.Split(hash[schemeEnd+1:], ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("scram error: invalid verifier format")
+	}
+	v := &SCRAMVerifier{
+		Mechanism: mechanism,
+	}
+	var err error
+	if v.Iterations, err = strconv.Atoi(parts[0]); err != nil || v.Iterations < 1 {
+		return nil, fmt.Errorf("scram error: invalid iteration count")
+	}
+	if v.Salt, err = base64.StdEncoding.DecodeString(parts[1]); err != nil {
+		return nil, fmt.Errorf("scram error: %w", err)
+	}
+	if v.StoredKey, err = base64.StdEncoding.DecodeString(parts[2]); err != nil {
+		return nil, fmt.Errorf("scram error: %w", err)
+	}
+	if v.ServerKey, err = base64.StdEncoding.DecodeString(parts[3]); err != nil {
+		return nil, fmt.Errorf("scram error: %w", err)
+	}
+	return v, nil
+}
+
+// SCRAMVerifierFromHash returns a SCRAMVerifier for mechanism from a stored
+// password. This works for SCRAM hashes of the same mechanism and for
+// cleartext passwords. Other password schemes cannot be used with SCRAM.
+func SCRAMVerifierFromHash(hash, mechanism string) (*SCRAMVerifier, error) {
+	if hash == "" {
+		return nil, fmt.Errorf("scram error: empty password")
+	}
+	if hash[0] == '{' {
+		if strings.HasPrefix(strings.ToUpper(hash), "{CLEARTEXT}") {
+			hash = hash[len("{CLEARTEXT}"):]
+		} else {
+			v, err := ParseSCRAMVerifier(hash)
+			if err != nil {
+				return nil, err
+			}
+			if v.Mechanism != strings.ToUpper(mechanism) {
+				return nil, fmt.Errorf("scram error: password is stored for %s", v.Mechanism)
+			}
+			return v, nil
+		}
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return NewSCRAMVerifier(mechanism, hash, salt, SCRAMDefaultIterations)
+}
+
+// String encodes the verifier as password hash suitable for ParseSCRAMVerifier.
+func (v *SCRAMVerifier) String() string {
+	return fmt.Sprintf("{%s}%d,%s,%s,%s",
+		v.Mechanism,
+		v.Iterations,
+		base64.StdEncoding.EncodeToString(v.Salt),
+		base64.StdEncoding.EncodeToString(v.StoredKey),
+		base64.StdEncoding.EncodeToString(v.ServerKey),
+	)
+}
+
+// ValidatePassword checks the passed cleartext password against the verifier.
+func (v *SCRAMVerifier) ValidatePassword(password string) (bool, error) {
+	other, err := NewSCRAMVerifier(v.Mechanism, password, v.Salt, v.Iterations)
+	if err != nil {
+		return false, err
+	}
+	if subtle.ConstantTimeCompare(v.StoredKey, other.StoredKey) != 1 {
+		return false, fmt.Errorf("invalid credentials")
+	}
+	return true, nil
+}
+
+func hashSCRAM(password, mechanism string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	v, err := NewSCRAMVerifier(mechanism, password, salt, SCRAMDefaultIterations)
+	if err != nil {
+		return "", err
+	}
+	return v.String(), nil
+}
+
+func scramHMAC(h func() hash.Hash, key []byte, data string) []byte {
+	mac := hmac.New(h, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
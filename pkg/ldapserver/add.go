@@ -1,6 +1,7 @@
 package ldapserver
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -9,13 +10,20 @@ import (
 	"github.com/go-ldap/ldap/v3"
 )
 
-func HandleAddRequest(req *ber.Packet, boundDN string, server *Server, conn net.Conn) error {
+func HandleAddRequest(ctx context.Context, req *ber.Packet, controls []ldap.Control, messageID int64, boundDN string, server *Server, conn net.Conn) ([]ldap.Control, error) {
 	if boundDN == "" {
-		return ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("anonymous Write denied"))
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("anonymous Write denied"))
 	}
 	addReq, err := parseAddRequest(req)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	addReq.Controls = controls
+	if err = checkWriteControls(server, controls); err != nil {
+		return nil, err
+	}
+	if queued, err := queueTransactionRequest(ctx, controls, messageID, boundDN, addReq); queued {
+		return nil, err
 	}
 	fnNames := []string{}
 	for k := range server.AddFns {
@@ -29,10 +37,11 @@ func HandleAddRequest(req *ber.Packet, boundDN string, server *Server, conn net.
 		} else {
 			err = fmt.Errorf("handler '%s' does not support add", fn)
 		}
-		return ldap.NewError(ldap.LDAPResultUnwillingToPerform, err)
+		return nil, ldap.NewError(ldap.LDAPResultUnwillingToPerform, err)
 	}
-	code, err := adder.Add(boundDN, addReq, conn)
-	return ldap.NewError(uint16(code), err)
+	ctx, rc := withResponseControls(ctx)
+	code, err := adder.Add(ctx, boundDN, addReq, conn)
+	return rc.get(), ldap.NewError(uint16(code), err)
 }
 
 func parseAddRequest(req *ber.Packet) (*ldap.AddRequest, error) {
@@ -0,0 +1,386 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/libregraph/idm/pkg/ldapdn"
+)
+
+const (
+	aliasedObjectNameAttribute = "aliasedObjectName"
+	aliasFilter                = "(objectClass=alias)"
+)
+
+// isAlias returns true if entry is an alias entry.
+func isAlias(entry *ldap.Entry) bool {
+	for _, value := range entry.GetEqualFoldAttributeValues("objectClass") {
+		if strings.EqualFold(value, "alias") {
+			return true
+		}
+	}
+	return false
+}
+
+// aliasedObject returns the DN of the object which the alias entry refers to
+// together with its normalized form.
+func aliasedObject(entry *ldap.Entry) (string, string, bool) {
+	dn := entry.GetEqualFoldAttributeValue(aliasedObjectNameAttribute)
+	if dn == "" {
+		return "", "", false
+	}
+	nDN, err := ldapdn.ParseNormalize(dn)
+	if err != nil {
+		return "", "", false
+	}
+	return dn, nDN, true
+}
+
+// lookupEntry returns the entry with dn, or nil if it does not exist.
+func lookupEntry(ctx context.Context, server *Server, boundDN string, dn string, conn net.Conn) (*ldap.Entry, error) {
+	nDN, err := ldapdn.ParseNormalize(dn)
+	if err != nil {
+		return nil, nil
+	}
+	req := ldap.NewSearchRequest(
+		dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", []string{"objectClass", aliasedObjectNameAttribute}, nil,
+	)
+	resp, err := server.searcher(dn).Search(ctx, boundDN, req, conn)
+	if ctx.Err() != nil {
+		return nil, ldap.NewError(uint16(ContextResultCode(ctx)), context.Cause(ctx))
+	}
+	if err != nil {
+		return nil, nil
+	}
+	for _, entry := range resp.Entries {
+		if n, err := ldapdn.ParseNormalize(entry.DN); err == nil && n == nDN {
+			return entry, nil
+		}
+	}
+	return nil, nil
+}
+
+// dereferenceSearchBase replaces the base object of searchReq with the
+// object it refers to if it is an alias and aliases are dereferenced in
+// finding the base object (RFC 4511 section 4.5.1.3). Chains of aliases are
+// followed, loops and aliases to objects which do not exist are an alias
+// problem.
+func dereferenceSearchBase(ctx context.Context, server *Server, boundDN string, searchReq *ldap.SearchRequest, conn net.Conn) error {
+	if searchReq.DerefAliases != ldap.DerefFindingBaseObj && searchReq.DerefAliases != ldap.DerefAlways {
+		return nil
+	}
+
+	visited := make(map[string]bool)
+	for {
+		entry, err := lookupEntry(ctx, server, boundDN, searchReq.BaseDN, conn)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			if len(visited) > 0 {
+				return ldap.NewError(ldap.LDAPResultAliasProblem, errors.New("aliased object does not exist"))
+			}
+			// Let the search report the missing base object.
+			return nil
+		}
+		if !isAlias(entry) {
+			return nil
+		}
+		dn, nDN, ok := aliasedObject(entry)
+		if !ok {
+			return ldap.NewError(ldap.LDAPResultAliasProblem, errors.New("alias has no valid aliasedObjectName"))
+		}
+		if visited[nDN] {
+			return ldap.NewError(ldap.LDAPResultAliasProblem, errors.New("alias loop detected"))
+		}
+		visited[nDN] = true
+		searchReq.BaseDN = dn
+	}
+}
+
+// findAliases returns the alias entries in scope of base, except for the
+// entry with the normalized DN except. The search handler can look them up
+// by the filter without searching the whole scope.
+func findAliases(ctx context.Context, server *Server, boundDN string, base string, scope int, except string, conn net.Conn) ([]*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(
+		base, scope, ldap.NeverDerefAliases, 0, 0, false,
+		aliasFilter, []string{"objectClass", aliasedObjectNameAttribute}, nil,
+	)
+	resp, err := server.searcher(base).Search(ctx, boundDN, req, conn)
+	if ctx.Err() != nil {
+		return nil, ldap.NewError(uint16(ContextResultCode(ctx)), context.Cause(ctx))
+	}
+	if err != nil {
+		// Ignore aliases to objects which do not exist.
+		return nil, nil
+	}
+	filterPacket, _ := ldap.CompileFilter(aliasFilter)
+	entries, err := serverFilterEntries(server.Schema(), filterPacket, req, resp.Entries)
+	if err != nil {
+		return nil, err
+	}
+	aliases := entries[:0]
+	for _, entry := range entries {
+		if nDN, err := ldapdn.ParseNormalize(entry.DN); err == nil && nDN != except && isAlias(entry) {
+			aliases = append(aliases, entry)
+		}
+	}
+	return aliases, nil
+}
+
+// dereferenceSearchResult dereferences the aliases in the scope of searchReq
+// if aliases are dereferenced in searching (RFC 4511 section 4.5.1.3). The
+// alias entries subordinate to the base object are removed from the entries
+// of resp and the entries which match the search in the scope of the
+// aliased objects are added instead. Every aliased object is searched only
+// once, which also ends alias loops. Aliases to objects which do not exist
+// are ignored. Searches paged by the search handler are not dereferenced,
+// derefPagingControl leaves only searches without aliases in scope to it.
+func dereferenceSearchResult(ctx context.Context, server *Server, boundDN string, searchReq *ldap.SearchRequest, resp *ServerSearchResult, filterPacket *ber.Packet, conn net.Conn) error {
+	if searchReq.DerefAliases != ldap.DerefInSearching && searchReq.DerefAliases != ldap.DerefAlways {
+		return nil
+	}
+	if searchReq.Scope == ldap.ScopeBaseObject {
+		return nil
+	}
+	if ldap.FindControl(searchReq.Controls, ldap.ControlTypePaging) != nil {
+		return nil
+	}
+	nBaseDN, err := ldapdn.ParseNormalize(searchReq.BaseDN)
+	if err != nil {
+		return nil
+	}
+	aliases, err := findAliases(ctx, server, boundDN, searchReq.BaseDN, searchReq.Scope, nBaseDN, conn)
+	if err != nil || len(aliases) == 0 {
+		return err
+	}
+
+	// Aliased objects are searched with base scope for single level and with
+	// subtree scope for subtree searches.
+	scope := ldap.ScopeWholeSubtree
+	if searchReq.Scope == ldap.ScopeSingleLevel {
+		scope = ldap.ScopeBaseObject
+	}
+
+	// The entries of the search result may not include their object classes,
+	// so alias entries are recognized by their DN.
+	aliasDNs := make(map[string]bool)
+	markAliases := func(found []*ldap.Entry) {
+		for _, entry := range found {
+			if nDN, err := ldapdn.ParseNormalize(entry.DN); err == nil {
+				aliasDNs[nDN] = true
+			}
+		}
+	}
+	seen := make(map[string]bool, len(resp.Entries))
+	addEntries := func(entries []*ldap.Entry, found []*ldap.Entry) []*ldap.Entry {
+		for _, entry := range found {
+			nDN, err := ldapdn.ParseNormalize(entry.DN)
+			if err != nil || seen[nDN] || aliasDNs[nDN] {
+				continue
+			}
+			seen[nDN] = true
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	markAliases(aliases)
+	entries := addEntries(make([]*ldap.Entry, 0, len(resp.Entries)), resp.Entries)
+	visited := make(map[string]bool)
+	for len(aliases) > 0 {
+		alias := aliases[0]
+		aliases = aliases[1:]
+		dn, nDN, ok := aliasedObject(alias)
+		if !ok || visited[nDN] {
+			continue
+		}
+		visited[nDN] = true
+
+		// The aliased object and its subordinates may be aliases themselves.
+		more, err := findAliases(ctx, server, boundDN, dn, scope, nBaseDN, conn)
+		if err != nil {
+			resp.Entries = entries
+			return err
+		}
+		markAliases(more)
+		aliases = append(aliases, more...)
+
+		req := &ldap.SearchRequest{
+			BaseDN:       dn,
+			Scope:        scope,
+			DerefAliases: ldap.NeverDerefAliases,
+			TypesOnly:    searchReq.TypesOnly,
+			Filter:       searchReq.Filter,
+			Attributes:   searchReq.Attributes,
+		}
+		result, err := server.searcher(dn).Search(ctx, boundDN, req, conn)
+		if ctx.Err() != nil {
+			resp.Entries = entries
+			return ldap.NewError(uint16(ContextResultCode(ctx)), context.Cause(ctx))
+		}
+		if err != nil {
+			// Ignore aliases to objects which do not exist.
+			continue
+		}
+		found := result.Entries
+		if filterPacket != nil {
+			if found, err = serverFilterEntries(server.Schema(), filterPacket, req, found); err != nil {
+				resp.Entries = entries
+				return err
+			}
+		}
+		entries = addEntries(entries, found)
+	}
+	resp.Entries = entries
+	return nil
+}
+
+// maxDerefPagings is the number of paged searches dereferencing aliases which
+// can be open on a connection at the same time.
+const maxDerefPagings = 8
+
+type derefPagingsContextKey struct{}
+
+// derefPaging holds the remaining entries of a paged search which
+// dereferences aliases.
+type derefPaging struct {
+	boundDN string
+	baseDN  string
+	scope   int
+	deref   int
+	filter  string
+	entries []*ldap.Entry
+}
+
+// continues returns true if req of boundDN is a request for the next page of
+// the paged search of p.
+func (p *derefPaging) continues(req *ldap.SearchRequest, boundDN string) bool {
+	return boundDN == p.boundDN && req.BaseDN == p.baseDN && req.Scope == p.scope && req.DerefAliases == p.deref && req.Filter == p.filter
+}
+
+// derefPagings tracks the paged searches of a connection which dereference
+// aliases by cookie.
+type derefPagings struct {
+	mutex   sync.Mutex
+	lastID  uint64
+	pagings map[string]*derefPaging
+}
+
+func newDerefPagings() *derefPagings {
+	return &derefPagings{
+		pagings: make(map[string]*derefPaging),
+	}
+}
+
+// withDerefPagings returns a copy of ctx which carries pagings.
+func withDerefPagings(ctx context.Context, pagings *derefPagings) context.Context {
+	return context.WithValue(ctx, derefPagingsContextKey{}, pagings)
+}
+
+// derefPagingsFromContext returns the paged searches dereferencing aliases of
+// the connection which ctx belongs to, or nil.
+func derefPagingsFromContext(ctx context.Context) *derefPagings {
+	pagings, _ := ctx.Value(derefPagingsContextKey{}).(*derefPagings)
+	return pagings
+}
+
+// has returns true if cookie belongs to an open paged search.
+func (d *derefPagings) has(cookie string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	_, ok := d.pagings[cookie]
+	return ok
+}
+
+// start returns the first page of at most size entries of the search req of
+// boundDN. The remaining entries are kept for the following pages, which are
+// requested with the returned cookie. The cookie is empty if there are no
+// more entries.
+func (d *derefPagings) start(req *ldap.SearchRequest, boundDN string, entries []*ldap.Entry, size int) ([]*ldap.Entry, string, error) {
+	if size == 0 || len(entries) <= size {
+		return entries, "", nil
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if len(d.pagings) >= maxDerefPagings {
+		return nil, "", ldap.NewError(ldap.LDAPResultAdminLimitExceeded, errors.New("too many paged searches"))
+	}
+	d.lastID++
+	cookie := strconv.FormatUint(d.lastID, 10)
+	d.pagings[cookie] = &derefPaging{
+		boundDN: boundDN,
+		baseDN:  req.BaseDN,
+		scope:   req.Scope,
+		deref:   req.DerefAliases,
+		filter:  req.Filter,
+		entries: entries[size:],
+	}
+	return entries[:size], cookie, nil
+}
+
+// next returns the next page of at most size entries of the paged search
+// with cookie, together with the cookie for the following page. A size of 0
+// abandons the paged search.
+func (d *derefPagings) next(req *ldap.SearchRequest, boundDN string, cookie string, size int) ([]*ldap.Entry, string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	p, ok := d.pagings[cookie]
+	if !ok || !p.continues(req, boundDN) {
+		return nil, "", ldap.NewError(ldap.LDAPResultUnwillingToPerform, errors.New("invalid paged results cookie"))
+	}
+	if size == 0 || len(p.entries) <= size {
+		delete(d.pagings, cookie)
+		if size == 0 {
+			return nil, "", nil
+		}
+		return p.entries, "", nil
+	}
+	entries := p.entries[:size]
+	p.entries = p.entries[size:]
+	return entries, cookie, nil
+}
+
+// derefPagingControl returns the paged results control of searchReq if the
+// search is paged here instead of by the search handler. This is the case
+// for paged searches which dereference aliases in searching if there are
+// aliases in their scope, as the search handler cannot add the entries of
+// the aliased objects to its pages. The control is removed from searchReq
+// for the first page, the following pages are recognized by their cookie.
+func derefPagingControl(ctx context.Context, server *Server, boundDN string, searchReq *ldap.SearchRequest, conn net.Conn) (*ldap.ControlPaging, error) {
+	if searchReq.DerefAliases != ldap.DerefInSearching && searchReq.DerefAliases != ldap.DerefAlways {
+		return nil, nil
+	}
+	control, ok := ldap.FindControl(searchReq.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+	pagings := derefPagingsFromContext(ctx)
+	if !ok || pagings == nil || searchReq.Scope == ldap.ScopeBaseObject {
+		return nil, nil
+	}
+	if len(control.Cookie) > 0 {
+		if !pagings.has(string(control.Cookie)) {
+			return nil, nil
+		}
+		return control, nil
+	}
+	nBaseDN, err := ldapdn.ParseNormalize(searchReq.BaseDN)
+	if err != nil {
+		return nil, nil
+	}
+	aliases, err := findAliases(ctx, server, boundDN, searchReq.BaseDN, searchReq.Scope, nBaseDN, conn)
+	if err != nil || len(aliases) == 0 {
+		return nil, err
+	}
+	searchReq.Controls = slices.DeleteFunc(slices.Clone(searchReq.Controls), func(c ldap.Control) bool {
+		return c == ldap.Control(control)
+	})
+	return control, nil
+}
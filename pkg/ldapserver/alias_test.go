@@ -0,0 +1,204 @@
+package ldapserver
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func newAliasTestEntries() []*ldap.Entry {
+	return []*ldap.Entry{
+		ldap.NewEntry("o=base", map[string][]string{"objectClass": {"organization"}, "o": {"base"}}),
+		ldap.NewEntry("ou=people,o=base", map[string][]string{"objectClass": {"organizationalUnit"}, "ou": {"people"}}),
+		ldap.NewEntry("uid=a,ou=people,o=base", map[string][]string{"objectClass": {"account"}, "uid": {"a"}}),
+		ldap.NewEntry("uid=b,ou=people,o=base", map[string][]string{"objectClass": {"account"}, "uid": {"b"}}),
+		ldap.NewEntry("ou=links,o=base", map[string][]string{"objectClass": {"organizationalUnit"}, "ou": {"links"}}),
+		ldap.NewEntry("uid=c,ou=links,o=base", map[string][]string{"objectClass": {"account"}, "uid": {"c"}}),
+		ldap.NewEntry("cn=a,ou=links,o=base", map[string][]string{"objectClass": {"alias", "extensibleObject"}, "cn": {"a"}, "aliasedObjectName": {"uid=a,ou=people,o=base"}}),
+		ldap.NewEntry("cn=people,ou=links,o=base", map[string][]string{"objectClass": {"alias", "extensibleObject"}, "cn": {"people"}, "aliasedObjectName": {"ou=people,o=base"}}),
+		ldap.NewEntry("cn=chain,ou=links,o=base", map[string][]string{"objectClass": {"alias", "extensibleObject"}, "cn": {"chain"}, "aliasedObjectName": {"cn=a,ou=links,o=base"}}),
+		ldap.NewEntry("cn=missing,ou=links,o=base", map[string][]string{"objectClass": {"alias", "extensibleObject"}, "cn": {"missing"}, "aliasedObjectName": {"uid=missing,o=base"}}),
+		ldap.NewEntry("cn=loop1,o=base", map[string][]string{"objectClass": {"alias", "extensibleObject"}, "cn": {"loop1"}, "aliasedObjectName": {"cn=loop2,o=base"}}),
+		ldap.NewEntry("cn=loop2,o=base", map[string][]string{"objectClass": {"alias", "extensibleObject"}, "cn": {"loop2"}, "aliasedObjectName": {"cn=loop1,o=base"}}),
+	}
+}
+
+// scopeSearcher returns its entries in the scope of the search.
+type scopeSearcher struct {
+	entries []*ldap.Entry
+}
+
+func (s scopeSearcher) Search(ctx context.Context, boundDN string, req *ldap.SearchRequest, conn net.Conn) (ServerSearchResult, error) {
+	entries := []*ldap.Entry{}
+	for _, entry := range s.entries {
+		_, parent, _ := strings.Cut(entry.DN, ",")
+		if entry.DN == req.BaseDN || (req.Scope == ldap.ScopeWholeSubtree && strings.HasSuffix(entry.DN, ","+req.BaseDN)) || (req.Scope == ldap.ScopeSingleLevel && parent == req.BaseDN) {
+			entries = append(entries, entry)
+		}
+	}
+	return ServerSearchResult{
+		Entries:    entries,
+		ResultCode: ldap.LDAPResultSuccess,
+	}, nil
+}
+
+func sortedDNs(entries []*ldap.Entry) string {
+	dns := []string{}
+	for _, entry := range entries {
+		dns = append(dns, entry.DN)
+	}
+	slices.Sort(dns)
+	return strings.Join(dns, ";")
+}
+
+func TestSearchDerefAliases(t *testing.T) {
+	server := NewServer()
+	server.EnforceLDAP = true
+	server.SearchFunc("", scopeSearcher{entries: newAliasTestEntries()})
+
+	for _, test := range []struct {
+		base   string
+		scope  int
+		deref  int
+		filter string
+		result string
+		code   uint16
+	}{
+		{"ou=links,o=base", ldap.ScopeSingleLevel, ldap.NeverDerefAliases, "(|(uid=*)(cn=*))", "cn=a,ou=links,o=base;cn=chain,ou=links,o=base;cn=missing,ou=links,o=base;cn=people,ou=links,o=base;uid=c,ou=links,o=base", ldap.LDAPResultSuccess},
+		{"ou=links,o=base", ldap.ScopeSingleLevel, ldap.DerefInSearching, "(uid=*)", "uid=a,ou=people,o=base;uid=c,ou=links,o=base", ldap.LDAPResultSuccess},
+		{"ou=links,o=base", ldap.ScopeWholeSubtree, ldap.DerefInSearching, "(uid=*)", "uid=a,ou=people,o=base;uid=b,ou=people,o=base;uid=c,ou=links,o=base", ldap.LDAPResultSuccess},
+		{"ou=links,o=base", ldap.ScopeWholeSubtree, ldap.DerefInSearching, "(uid=b)", "uid=b,ou=people,o=base", ldap.LDAPResultSuccess},
+		{"cn=people,ou=links,o=base", ldap.ScopeSingleLevel, ldap.DerefFindingBaseObj, "(uid=*)", "uid=a,ou=people,o=base;uid=b,ou=people,o=base", ldap.LDAPResultSuccess},
+		{"cn=chain,ou=links,o=base", ldap.ScopeBaseObject, ldap.DerefAlways, "(objectClass=*)", "uid=a,ou=people,o=base", ldap.LDAPResultSuccess},
+		{"cn=chain,ou=links,o=base", ldap.ScopeBaseObject, ldap.DerefInSearching, "(objectClass=*)", "cn=chain,ou=links,o=base", ldap.LDAPResultSuccess},
+		{"o=base", ldap.ScopeWholeSubtree, ldap.DerefAlways, "(uid=*)", "uid=a,ou=people,o=base;uid=b,ou=people,o=base;uid=c,ou=links,o=base", ldap.LDAPResultSuccess},
+		{"cn=loop1,o=base", ldap.ScopeBaseObject, ldap.DerefFindingBaseObj, "(objectClass=*)", "", ldap.LDAPResultAliasProblem},
+		{"cn=missing,ou=links,o=base", ldap.ScopeBaseObject, ldap.DerefAlways, "(objectClass=*)", "", ldap.LDAPResultAliasProblem},
+	} {
+		// Failed searches close the connection.
+		l := startTestConn(t, server)
+		res, err := l.Search(ldap.NewSearchRequest(test.base, test.scope, test.deref, 0, 0, false, test.filter, nil, nil))
+		l.Close()
+		if test.code != ldap.LDAPResultSuccess {
+			if !ldap.IsErrorWithCode(err, test.code) {
+				t.Errorf("Search of %s with deref %d should fail with %d, got: %v", test.base, test.deref, test.code, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Search of %s with deref %d failed: %s", test.base, test.deref, err)
+			continue
+		}
+		if got := sortedDNs(res.Entries); got != test.result {
+			t.Errorf("Search of %s with scope %d and deref %d returned %s, expected %s", test.base, test.scope, test.deref, got, test.result)
+		}
+	}
+}
+
+func TestSearchDerefAliasesPaged(t *testing.T) {
+	server := NewServer()
+	server.EnforceLDAP = true
+	server.SearchFunc("", scopeSearcher{entries: newAliasTestEntries()})
+
+	for _, test := range []struct {
+		base   string
+		deref  int
+		result string
+	}{
+		{"ou=links,o=base", ldap.DerefInSearching, "uid=a,ou=people,o=base;uid=b,ou=people,o=base;uid=c,ou=links,o=base"},
+		{"ou=links,o=base", ldap.DerefAlways, "uid=a,ou=people,o=base;uid=b,ou=people,o=base;uid=c,ou=links,o=base"},
+		{"ou=links,o=base", ldap.DerefFindingBaseObj, "uid=c,ou=links,o=base"},
+		{"ou=people,o=base", ldap.DerefAlways, "uid=a,ou=people,o=base;uid=b,ou=people,o=base"},
+	} {
+		l := startTestConn(t, server)
+		res, err := l.SearchWithPaging(ldap.NewSearchRequest(test.base, ldap.ScopeWholeSubtree, test.deref, 0, 0, false, "(uid=*)", nil, nil), 1)
+		l.Close()
+		if err != nil {
+			t.Errorf("Paged search of %s with deref %d failed: %s", test.base, test.deref, err)
+			continue
+		}
+		if got := sortedDNs(res.Entries); got != test.result {
+			t.Errorf("Paged search of %s with deref %d returned %s, expected %s", test.base, test.deref, got, test.result)
+		}
+	}
+
+	search := func(l *ldap.Conn, filter string, size uint32, cookie []byte) (*ldap.SearchResult, []byte, error) {
+		t.Helper()
+		paging := ldap.NewControlPaging(size)
+		paging.SetCookie(cookie)
+		res, err := l.Search(ldap.NewSearchRequest("ou=links,o=base", ldap.ScopeWholeSubtree, ldap.DerefInSearching, 0, 0, false, filter, nil, []ldap.Control{paging}))
+		if err != nil {
+			return res, nil, err
+		}
+		if control, ok := ldap.FindControl(res.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging); ok {
+			return res, control.Cookie, nil
+		}
+		return res, nil, nil
+	}
+
+	// The cookie cannot be used by other searches. Failed searches close the
+	// connection.
+	l := startTestConn(t, server)
+	_, cookie, err := search(l, "(uid=*)", 1, nil)
+	if err != nil || len(cookie) == 0 {
+		t.Fatalf("First page returned cookie %q: %v", cookie, err)
+	}
+	if _, _, err = search(l, "(cn=*)", 1, cookie); !ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform) {
+		t.Errorf("Next page of other search should fail with UnwillingToPerform, got: %v", err)
+	}
+	l.Close()
+
+	// A page size of 0 abandons the paged search.
+	l = startTestConn(t, server)
+	defer l.Close()
+	if _, cookie, err = search(l, "(uid=*)", 1, nil); err != nil {
+		t.Fatalf("First page failed: %s", err)
+	}
+	res, next, err := search(l, "(uid=*)", 0, cookie)
+	if err != nil || len(res.Entries) != 0 || len(next) != 0 {
+		t.Errorf("Abandoning paged search returned %d entries and cookie %q: %v", len(res.Entries), next, err)
+	}
+}
+
+// slowSearcher returns its entries only after the context is done.
+type slowSearcher struct {
+	entries []*ldap.Entry
+}
+
+func (s slowSearcher) Search(ctx context.Context, boundDN string, req *ldap.SearchRequest, conn net.Conn) (ServerSearchResult, error) {
+	<-ctx.Done()
+	return ServerSearchResult{
+		Entries:    append([]*ldap.Entry{}, s.entries...),
+		ResultCode: ContextResultCode(ctx),
+	}, context.Cause(ctx)
+}
+
+func TestSearchTimeLimit(t *testing.T) {
+	server := NewServer()
+	server.EnforceLDAP = true
+	server.SearchFunc("", slowSearcher{entries: newSortTestEntries()})
+	l := startTestConn(t, server)
+	defer l.Close()
+
+	start := time.Now()
+	res, err := l.Search(ldap.NewSearchRequest("o=base", ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 1, false, "(uid=a)", nil, nil))
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultTimeLimitExceeded) {
+		t.Fatalf("Search should fail with TimeLimitExceeded, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Search ended before its time limit after %s", elapsed)
+	}
+	if got := sortedUIDs(res.Entries); got != "a" {
+		t.Errorf("Expected the partial result to be returned, got: %s", got)
+	}
+
+	// The connection stays usable after a search exceeded its time limit.
+	if _, err = l.Search(ldap.NewSearchRequest("o=base", ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 1, false, "(uid=b)", nil, nil)); !ldap.IsErrorWithCode(err, ldap.LDAPResultTimeLimitExceeded) {
+		t.Errorf("Second search should fail with TimeLimitExceeded, got: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package ldapserver
+
+import (
+	"errors"
+	"fmt"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/libregraph/idm/pkg/schema"
+)
+
+// ControlTypeAssertion is the OID of the assertion control (RFC 4528).
+const ControlTypeAssertion = "1.3.6.1.1.12"
+
+// ControlAssertion is the assertion control. The operation it is attached to
+// is only performed if its target entry matches Filter.
+type ControlAssertion struct {
+	Criticality bool
+	Filter      string
+}
+
+// GetControlType returns the OID of the control.
+func (c *ControlAssertion) GetControlType() string {
+	return ControlTypeAssertion
+}
+
+// Encode returns the ber packet representation of the control.
+func (c *ControlAssertion) Encode() *ber.Packet {
+	filterPacket, err := ldap.CompileFilter(c.Filter)
+	if err != nil {
+		filterPacket = nil
+	}
+	return encodeControl(c.GetControlType(), c.Criticality, filterPacket)
+}
+
+// String returns a human-readable description of the control.
+func (c *ControlAssertion) String() string {
+	return fmt.Sprintf("Control Type: %s (%q)  Criticality: %t  Filter: %s", "Assertion", c.GetControlType(), c.Criticality, c.Filter)
+}
+
+func decodeControlAssertion(criticality bool, value *ber.Packet) (ldap.Control, error) {
+	if value == nil {
+		return nil, errors.New("invalid assertion control")
+	}
+	filter, err := ldap.DecompileFilter(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid assertion control filter: %w", err)
+	}
+	return &ControlAssertion{Criticality: criticality, Filter: filter}, nil
+}
+
+// CheckAssertion evaluates the assertion control in controls, if there is
+// one, on entry, which is the target entry of the operation. For add
+// operations it is the entry to be added. It returns an error with the
+// assertionFailed result code if entry does not match.
+func CheckAssertion(s *schema.Schema, controls []ldap.Control, entry *ldap.Entry) error {
+	control, ok := ldap.FindControl(controls, ControlTypeAssertion).(*ControlAssertion)
+	if !ok {
+		return nil
+	}
+	filterPacket, err := ldap.CompileFilter(control.Filter)
+	if err != nil {
+		return ldap.NewError(ldap.LDAPResultProtocolError, err)
+	}
+	if entry == nil {
+		return ldap.NewError(ldap.LDAPResultAssertionFailed, errors.New("assertion failed"))
+	}
+	if match, resultCode := ServerApplyFilterWithSchema(s, filterPacket, entry); !match || resultCode != ldap.LDAPResultSuccess {
+		return ldap.NewError(ldap.LDAPResultAssertionFailed, errors.New("assertion failed"))
+	}
+	return nil
+}
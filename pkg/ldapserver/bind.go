@@ -12,59 +12,67 @@ import (
 	"github.com/go-ldap/ldap/v3"
 )
 
-func HandleBindRequest(req *ber.Packet, fns map[string]Binder, conn net.Conn) (resultCode LDAPResultCode) {
+// HandleBindRequest processes a bind request and returns the LDAP result code
+// together with the (not normalized) DN of the identity which was
+// authenticated and the optional serverSaslCreds for SASL binds. The passed
+// state tracks multi-step SASL binds on the connection.
+func HandleBindRequest(req *ber.Packet, server *Server, conn net.Conn, state *SASLBindState) (resultCode LDAPResultCode, boundDN string, serverSaslCreds []byte) {
 	defer func() {
 		if r := recover(); r != nil {
 			logger.Error(nil, "Panic during bind request", "panic", r, "remote_addr", conn.RemoteAddr().String())
 			resultCode = ldap.LDAPResultOperationsError
+			boundDN = ""
+			serverSaslCreds = nil
+			state.Reset()
 		}
 	}()
 
 	// we only support ldapv3
 	ldapVersion, ok := req.Children[0].Value.(int64)
 	if !ok {
-		return ldap.LDAPResultProtocolError
+		return ldap.LDAPResultProtocolError, "", nil
 	}
 	if ldapVersion != 3 {
 		logger.V(1).Info("Unsupported LDAP version", "version", ldapVersion)
-		return ldap.LDAPResultInappropriateAuthentication
+		return ldap.LDAPResultInappropriateAuthentication, "", nil
 	}
 
 	// auth types
 	bindDN, ok := req.Children[1].Value.(string)
 	if !ok {
-		return ldap.LDAPResultProtocolError
+		return ldap.LDAPResultProtocolError, "", nil
 	}
 	bindAuth := req.Children[2]
 	switch bindAuth.Tag {
 	default:
+		state.Reset()
 		logger.V(1).Info("Unknown LDAP authentication method", "tag", bindAuth.Tag)
-		return ldap.LDAPResultInappropriateAuthentication
+		return ldap.LDAPResultInappropriateAuthentication, "", nil
 	case LDAPBindAuthSimple:
+		// A simple bind aborts any SASL bind in progress.
+		state.Reset()
 		if len(req.Children) == 3 {
 			fnNames := []string{}
-			for k := range fns {
+			for k := range server.BindFns {
 				fnNames = append(fnNames, k)
 			}
 			fn := routeFunc(bindDN, fnNames)
-			resultCode, err := fns[fn].Bind(bindDN, bindAuth.Data.String(), conn)
+			resultCode, err := server.BindFns[fn].Bind(bindDN, bindAuth.Data.String(), conn)
 			if err != nil {
 				logger.Error(err, "BindFn Error")
-				return ldap.LDAPResultOperationsError
+				return ldap.LDAPResultOperationsError, "", nil
 			}
-			return resultCode
+			return resultCode, bindDN, nil
 		} else {
 			logger.V(1).Info("Simple bind request has wrong # children.  len(req.Children) != 3")
-			return ldap.LDAPResultInappropriateAuthentication
+			return ldap.LDAPResultInappropriateAuthentication, "", nil
 		}
 	case LDAPBindAuthSASL:
-		logger.V(1).Info("SASL authentication is not supported")
-		return ldap.LDAPResultInappropriateAuthentication
+		return handleSASLBind(bindAuth, server, conn, state)
 	}
-	return ldap.LDAPResultOperationsError
 }
 
-func encodeBindResponse(messageID int64, ldapResultCode LDAPResultCode) *ber.Packet {
+func encodeBindResponse(messageID int64, ldapResultCode LDAPResultCode, serverSaslCreds []byte) *ber.Packet {
 	responsePacket := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
 	responsePacket.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
 
@@ -72,9 +80,27 @@ func encodeBindResponse(messageID int64, ldapResultCode LDAPResultCode) *ber.Pac
 	bindReponse.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(ldapResultCode), "resultCode: "))
 	bindReponse.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN: "))
 	bindReponse.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "errorMessage: "))
+	if serverSaslCreds != nil {
+		// serverSaslCreds    [7] OCTET STRING OPTIONAL
+		bindReponse.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 7, string(serverSaslCreds), "serverSaslCreds: "))
+	}
 
 	responsePacket.AppendChild(bindReponse)
 
 	// ber.PrintPacket(responsePacket)
 	return responsePacket
 }
+
+// isAnonymousBindRequest returns true if the passed bind request is a simple
+// bind without name and password.
+func isAnonymousBindRequest(req *ber.Packet) bool {
+	if len(req.Children) != 3 {
+		return false
+	}
+	bindDN, ok := req.Children[1].Value.(string)
+	if !ok || bindDN != "" {
+		return false
+	}
+	bindAuth := req.Children[2]
+	return bindAuth.Tag == LDAPBindAuthSimple && bindAuth.Data.Len() == 0
+}
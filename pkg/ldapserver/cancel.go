@@ -0,0 +1,53 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const cancelOID = "1.3.6.1.1.8"
+
+func init() {
+	RegisterExtendedOperation(cancelOID, HandleCancelExOp)
+}
+
+// HandleCancelExOp cancels an outstanding operation of the connection as
+// defined in RFC 3909. It waits until the canceled operation has been
+// responded. The connection handler runs it independently of the other
+// operations.
+func HandleCancelExOp(ctx context.Context, req *ber.Packet, boundDN string, server *Server, conn net.Conn) (*ber.Packet, error) {
+	logger.V(1).Info("HandleCancelExOp")
+	cancelID, err := parseCancelRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	ops := operationsFromContext(ctx)
+	if ops == nil {
+		return nil, ldap.NewError(ldap.LDAPResultCannotCancel, errors.New("no outstanding operations"))
+	}
+	if resultCode := ops.cancel(ctx, cancelID); resultCode != ldap.LDAPResultSuccess {
+		return nil, ldap.NewError(uint16(resultCode), errors.New(ldap.LDAPResultCodeMap[uint16(resultCode)]))
+	}
+	return nil, nil
+}
+
+func parseCancelRequest(req *ber.Packet) (int64, error) {
+	// cancelRequestValue ::= SEQUENCE {
+	//         cancelID        MessageID }
+	if req == nil {
+		return 0, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("missing cancel request value"))
+	}
+	inner, err := ber.DecodePacketErr(req.Data.Bytes())
+	if err != nil || len(inner.Children) != 1 {
+		return 0, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("invalid cancel request value"))
+	}
+	cancelID, ok := inner.Children[0].Value.(int64)
+	if !ok {
+		return 0, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("invalid cancelID"))
+	}
+	return cancelID, nil
+}
@@ -0,0 +1,108 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/libregraph/idm/pkg/ldappassword"
+)
+
+func HandleCompareRequest(ctx context.Context, req *ber.Packet, boundDN string, server *Server, conn net.Conn) error {
+	compareReq, err := parseCompareRequest(req)
+	if err != nil {
+		return err
+	}
+	fnNames := []string{}
+	for k := range server.CompareFns {
+		fnNames = append(fnNames, k)
+	}
+	fn := routeFunc(compareReq.DN, fnNames)
+	var comparer Comparer
+	if comparer = server.CompareFns[fn]; comparer == nil {
+		if fn == "" {
+			err = fmt.Errorf("no suitable handler found for dn: '%s'", compareReq.DN)
+		} else {
+			err = fmt.Errorf("handler '%s' does not support compare", fn)
+		}
+		return ldap.NewError(ldap.LDAPResultUnwillingToPerform, err)
+	}
+	code, err := comparer.Compare(ctx, boundDN, compareReq, conn)
+	return ldap.NewError(uint16(code), err)
+}
+
+func parseCompareRequest(req *ber.Packet) (*ldap.CompareRequest, error) {
+	// CompareRequest ::= [APPLICATION 14] SEQUENCE {
+	//      entry           LDAPDN,
+	//      ava             AttributeValueAssertion }
+	if len(req.Children) != 2 {
+		return nil, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("invalid compare request"))
+	}
+
+	dn, ok := req.Children[0].Value.(string)
+	if !ok {
+		return nil, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("error decoding entry DN"))
+	}
+	_, err := ldap.ParseDN(dn)
+	if err != nil {
+		return nil, ldap.NewError(ldap.LDAPResultInvalidDNSyntax, err)
+	}
+
+	// AttributeValueAssertion ::= SEQUENCE {
+	//      attributeDesc   AttributeDescription,
+	//      assertionValue  AssertionValue }
+	ava := req.Children[1]
+	if len(ava.Children) != 2 {
+		return nil, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("invalid attribute value assertion"))
+	}
+	attribute, ok := ava.Children[0].Value.(string)
+	if !ok || attribute == "" {
+		return nil, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("error decoding attribute description"))
+	}
+
+	return &ldap.CompareRequest{
+		DN:        dn,
+		Attribute: attribute,
+		Value:     ava.Children[1].Data.String(),
+	}, nil
+}
+
+// ServerCompareAttribute compares value against the values of attr and returns
+// LDAPResultCompareTrue or LDAPResultCompareFalse. If attr is nil the result is
+// LDAPResultNoSuchAttribute. Values of the userPassword attribute are compared
+// as password hashes.
+func ServerCompareAttribute(attr *ldap.EntryAttribute, value string) LDAPResultCode {
+	if attr == nil || len(attr.Values) == 0 {
+		return ldap.LDAPResultNoSuchAttribute
+	}
+	isPassword := strings.EqualFold(attr.Name, "userPassword")
+	for _, v := range attr.Values {
+		if isPassword {
+			if v == "" {
+				continue
+			}
+			if match, err := ldappassword.Validate(value, v); err == nil && match {
+				return ldap.LDAPResultCompareTrue
+			}
+		} else if strings.EqualFold(v, value) {
+			return ldap.LDAPResultCompareTrue
+		}
+	}
+	return ldap.LDAPResultCompareFalse
+}
+
+// ServerCompareEntry compares value against the values of the named attribute
+// of entry, see ServerCompareAttribute.
+func ServerCompareEntry(entry *ldap.Entry, attribute, value string) LDAPResultCode {
+	for _, attr := range entry.Attributes {
+		if strings.EqualFold(attr.Name, attribute) {
+			return ServerCompareAttribute(attr, value)
+		}
+	}
+	return ldap.LDAPResultNoSuchAttribute
+}
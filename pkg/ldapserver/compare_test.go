@@ -0,0 +1,69 @@
+package ldapserver
+
+import (
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestParseCompareRequest(t *testing.T) {
+	newRequest := func(dn, attribute, value string) *ber.Packet {
+		req := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationCompareRequest, nil, "Compare Request")
+		req.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "DN"))
+		ava := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "AttributeValueAssertion")
+		ava.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attribute, "AttributeDesc"))
+		ava.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "AssertionValue"))
+		req.AppendChild(ava)
+		return req
+	}
+
+	compareReq, err := parseCompareRequest(newRequest("cn=group,dc=example,dc=org", "member", "uid=user,dc=example,dc=org"))
+	if err != nil {
+		t.Fatalf("valid LDAP Compare Request should succeed. Got: %v", err)
+	}
+	if compareReq.DN != "cn=group,dc=example,dc=org" || compareReq.Attribute != "member" || compareReq.Value != "uid=user,dc=example,dc=org" {
+		t.Errorf("Unexpected compare request: %v", compareReq)
+	}
+
+	_, err = parseCompareRequest(newRequest("invalid", "member", "value"))
+	if err == nil || !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidDNSyntax) {
+		t.Errorf("LDAP Compare Request with invalid DN should give InvalidDNSyntax. Got: %v", err)
+	}
+
+	_, err = parseCompareRequest(newRequest("cn=group,dc=example,dc=org", "", "value"))
+	if err == nil || !ldap.IsErrorWithCode(err, ldap.LDAPResultProtocolError) {
+		t.Errorf("LDAP Compare Request without attribute should give Protocol Error. Got: %v", err)
+	}
+
+	incomplete := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationCompareRequest, nil, "Compare Request")
+	incomplete.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "cn=group,dc=example,dc=org", "DN"))
+	_, err = parseCompareRequest(incomplete)
+	if err == nil || !ldap.IsErrorWithCode(err, ldap.LDAPResultProtocolError) {
+		t.Errorf("LDAP Compare Request without assertion should give Protocol Error. Got: %v", err)
+	}
+}
+
+func TestServerCompareEntry(t *testing.T) {
+	entry := ldap.NewEntry("cn=group,dc=example,dc=org", map[string][]string{
+		"member":       {"uid=user1,dc=example,dc=org", "uid=user2,dc=example,dc=org"},
+		"userPassword": {"secret"},
+	})
+
+	for _, tc := range []struct {
+		attribute  string
+		value      string
+		resultCode LDAPResultCode
+	}{
+		{"member", "uid=user2,dc=example,dc=org", ldap.LDAPResultCompareTrue},
+		{"Member", "UID=User1,dc=example,dc=org", ldap.LDAPResultCompareTrue},
+		{"member", "uid=user3,dc=example,dc=org", ldap.LDAPResultCompareFalse},
+		{"mail", "user@example.org", ldap.LDAPResultNoSuchAttribute},
+		{"userPassword", "secret", ldap.LDAPResultCompareTrue},
+		{"userPassword", "wrong", ldap.LDAPResultCompareFalse},
+	} {
+		if resultCode := ServerCompareEntry(entry, tc.attribute, tc.value); resultCode != tc.resultCode {
+			t.Errorf("Compare %s=%s: expected result %d, got %d", tc.attribute, tc.value, tc.resultCode, resultCode)
+		}
+	}
+}
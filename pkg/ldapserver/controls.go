@@ -0,0 +1,398 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// controlDecoders decode the values of request controls which are not
+// decoded correctly by ldap.DecodeControl, keyed by control type.
+var controlDecoders = map[string]func(criticality bool, value *ber.Packet) (ldap.Control, error){
+	ldap.ControlTypeServerSideSorting: decodeControlServerSideSorting,
+	ldap.ControlTypeVLVRequest:        decodeControlVLVRequest,
+	ldap.ControlTypeSyncRequest:       decodeControlSyncRequest,
+	ControlTypePersistentSearch:       decodeControlPersistentSearch,
+	ControlTypeAssertion:              decodeControlAssertion,
+	ControlTypePreRead:                decodeControlReadEntry(ControlTypePreRead),
+	ControlTypePostRead:               decodeControlReadEntry(ControlTypePostRead),
+}
+
+// rawControlDecoders decode the values of request controls which are not BER
+// encoded, keyed by control type.
+var rawControlDecoders = map[string]func(criticality bool, value []byte) (ldap.Control, error){
+	ControlTypeTransactionSpecification: decodeControlTransactionSpecification,
+	ControlTypeProxiedAuthorization:     decodeControlProxiedAuthorization,
+}
+
+// writeControlTypes are the types of the request controls of write
+// operations, which are processed by their handlers.
+var writeControlTypes = []string{ControlTypeAssertion, ControlTypePreRead, ControlTypePostRead}
+
+// checkWriteControls returns an error with the unavailableCriticalExtension
+// result code if one of the critical controls of a write operation is not
+// supported by the server.
+func checkWriteControls(server *Server, controls []ldap.Control) error {
+	for _, control := range controls {
+		controlType := control.GetControlType()
+		if !slices.Contains(writeControlTypes, controlType) || slices.Contains(server.SupportedControls, controlType) {
+			continue
+		}
+		critical := false
+		switch c := control.(type) {
+		case *ControlAssertion:
+			critical = c.Criticality
+		case *ControlReadEntry:
+			critical = c.Criticality
+		}
+		if critical {
+			return ldap.NewError(ldap.LDAPResultUnavailableCriticalExtension, fmt.Errorf("control %s not supported", controlType))
+		}
+	}
+	return nil
+}
+
+type responseControlsContextKey struct{}
+
+// responseControls collects the response controls of an operation.
+type responseControls struct {
+	mutex    sync.Mutex
+	controls []ldap.Control
+}
+
+// withResponseControls returns a copy of ctx which collects the response
+// controls added with AddResponseControls.
+func withResponseControls(ctx context.Context) (context.Context, *responseControls) {
+	rc := &responseControls{}
+	return context.WithValue(ctx, responseControlsContextKey{}, rc), rc
+}
+
+// AddResponseControls adds controls to the response of the write operation
+// which ctx belongs to. It has no effect for other operations.
+func AddResponseControls(ctx context.Context, controls ...ldap.Control) {
+	rc, ok := ctx.Value(responseControlsContextKey{}).(*responseControls)
+	if !ok {
+		return
+	}
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	rc.controls = append(rc.controls, controls...)
+}
+
+// get returns the collected controls.
+func (rc *responseControls) get() []ldap.Control {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	return rc.controls
+}
+
+// appendResponseControls appends the controls to responsePacket, if there
+// are any.
+func appendResponseControls(responsePacket *ber.Packet, controls []ldap.Control) *ber.Packet {
+	if len(controls) == 0 {
+		return responsePacket
+	}
+	controlsPacket := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+	for _, control := range controls {
+		controlsPacket.AppendChild(control.Encode())
+	}
+	responsePacket.AppendChild(controlsPacket)
+	return responsePacket
+}
+
+// isCriticalControl returns true if the control packet, which might be
+// malformed, is marked as critical.
+func isCriticalControl(packet *ber.Packet) bool {
+	for _, child := range packet.Children {
+		if criticality, ok := child.Value.(bool); ok {
+			return criticality
+		}
+	}
+	return false
+}
+
+// decodeControl decodes a request control. Controls without a registered
+// decoder are decoded with ldap.DecodeControl, the values of raw controls are
+// passed as they are.
+func decodeControl(packet *ber.Packet) (control ldap.Control, err error) {
+	if len(packet.Children) == 0 || len(packet.Children) > 3 {
+		return nil, errors.New("invalid control")
+	}
+	controlType, ok := packet.Children[0].Value.(string)
+	if !ok {
+		return nil, errors.New("invalid control type")
+	}
+	decode, ok := controlDecoders[controlType]
+	decodeRaw, raw := rawControlDecoders[controlType]
+	if !ok && !raw {
+		// ldap.DecodeControl panics on some malformed control values.
+		defer func() {
+			if r := recover(); r != nil {
+				control, err = nil, fmt.Errorf("invalid control %s: %v", controlType, r)
+			}
+		}()
+		return ldap.DecodeControl(packet)
+	}
+
+	criticality := false
+	var value *ber.Packet
+	var rawValue []byte
+	for _, child := range packet.Children[1:] {
+		switch v := child.Value.(type) {
+		case bool:
+			criticality = v
+		default:
+			if child.Data == nil || child.Data.Len() == 0 {
+				continue
+			}
+			if raw {
+				rawValue = child.Data.Bytes()
+			} else if value, err = ber.DecodePacketErr(child.Data.Bytes()); err != nil {
+				return nil, fmt.Errorf("invalid control value of %s: %w", controlType, err)
+			}
+		}
+	}
+	if raw {
+		return decodeRaw(criticality, rawValue)
+	}
+	return decode(criticality, value)
+}
+
+// ControlServerSideSorting is the server side sort request control
+// (RFC 2891).
+type ControlServerSideSorting struct {
+	Criticality bool
+	SortKeys    []*ldap.SortKey
+}
+
+// GetControlType returns the OID of the control.
+func (c *ControlServerSideSorting) GetControlType() string {
+	return ldap.ControlTypeServerSideSorting
+}
+
+// Encode returns the ber packet representation of the control.
+func (c *ControlServerSideSorting) Encode() *ber.Packet {
+	keys := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "SortKeyList")
+	for _, key := range c.SortKeys {
+		seq := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "SortKey")
+		seq.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, key.AttributeType, "attributeType"))
+		if key.MatchingRule != "" {
+			seq.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, key.MatchingRule, "orderingRule"))
+		}
+		if key.Reverse {
+			seq.AppendChild(ber.NewBoolean(ber.ClassContext, ber.TypePrimitive, 1, key.Reverse, "reverseOrder"))
+		}
+		keys.AppendChild(seq)
+	}
+	return encodeControl(c.GetControlType(), c.Criticality, keys)
+}
+
+// String returns a human-readable description of the control.
+func (c *ControlServerSideSorting) String() string {
+	keys := ""
+	for _, key := range c.SortKeys {
+		keys += fmt.Sprintf(" %+v", *key)
+	}
+	return fmt.Sprintf("Control Type: %s (%q)  Criticality: %t  SortKeys:%s", ldap.ControlTypeMap[c.GetControlType()], c.GetControlType(), c.Criticality, keys)
+}
+
+func decodeControlServerSideSorting(criticality bool, value *ber.Packet) (ldap.Control, error) {
+	if value == nil {
+		return nil, errors.New("sort control without value")
+	}
+	c := &ControlServerSideSorting{Criticality: criticality}
+	for _, seq := range value.Children {
+		if len(seq.Children) == 0 {
+			return nil, errors.New("sort key without attribute type")
+		}
+		key := &ldap.SortKey{}
+		var ok bool
+		if key.AttributeType, ok = seq.Children[0].Value.(string); !ok {
+			return nil, errors.New("invalid sort key attribute type")
+		}
+		for _, child := range seq.Children[1:] {
+			if child.ClassType != ber.ClassContext {
+				return nil, errors.New("invalid sort key")
+			}
+			switch child.Tag {
+			case 0:
+				key.MatchingRule = child.Data.String()
+			case 1:
+				key.Reverse = len(child.Data.Bytes()) == 1 && child.Data.Bytes()[0] != 0
+			default:
+				return nil, errors.New("invalid sort key")
+			}
+		}
+		c.SortKeys = append(c.SortKeys, key)
+	}
+	if len(c.SortKeys) == 0 {
+		return nil, errors.New("sort control without sort keys")
+	}
+	return c, nil
+}
+
+// ControlServerSideSortingResult is the server side sort response control
+// (RFC 2891).
+type ControlServerSideSortingResult struct {
+	Result        ldap.ControlServerSideSortingCode
+	AttributeType string
+}
+
+// GetControlType returns the OID of the control.
+func (c *ControlServerSideSortingResult) GetControlType() string {
+	return ldap.ControlTypeServerSideSortingResult
+}
+
+// Encode returns the ber packet representation of the control.
+func (c *ControlServerSideSortingResult) Encode() *ber.Packet {
+	seq := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "SortResult")
+	seq.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(c.Result), "sortResult"))
+	if c.AttributeType != "" {
+		seq.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, c.AttributeType, "attributeType"))
+	}
+	return encodeControl(c.GetControlType(), false, seq)
+}
+
+// String returns a human-readable description of the control.
+func (c *ControlServerSideSortingResult) String() string {
+	return fmt.Sprintf("Control Type: %s (%q)  Result: %d  AttributeType: %s", ldap.ControlTypeMap[c.GetControlType()], c.GetControlType(), c.Result, c.AttributeType)
+}
+
+// ControlVLVRequest is the virtual list view request control
+// (draft-ietf-ldapext-ldapv3-vlv-09).
+type ControlVLVRequest struct {
+	Criticality bool
+	BeforeCount int
+	AfterCount  int
+	// Offset and ContentCount select the target entry if GreaterThanOrEqual
+	// is nil.
+	Offset       int
+	ContentCount int
+	// GreaterThanOrEqual is the assertion value selecting the target entry.
+	GreaterThanOrEqual *string
+	ContextID          []byte
+}
+
+// GetControlType returns the OID of the control.
+func (c *ControlVLVRequest) GetControlType() string {
+	return ldap.ControlTypeVLVRequest
+}
+
+// Encode returns the ber packet representation of the control.
+func (c *ControlVLVRequest) Encode() *ber.Packet {
+	seq := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "VirtualListViewRequest")
+	seq.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, int64(c.BeforeCount), "beforeCount"))
+	seq.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, int64(c.AfterCount), "afterCount"))
+	if c.GreaterThanOrEqual != nil {
+		seq.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 1, *c.GreaterThanOrEqual, "greaterThanOrEqual"))
+	} else {
+		byOffset := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "byOffset")
+		byOffset.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, int64(c.Offset), "offset"))
+		byOffset.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, int64(c.ContentCount), "contentCount"))
+		seq.AppendChild(byOffset)
+	}
+	if c.ContextID != nil {
+		seq.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(c.ContextID), "contextID"))
+	}
+	return encodeControl(c.GetControlType(), c.Criticality, seq)
+}
+
+// String returns a human-readable description of the control.
+func (c *ControlVLVRequest) String() string {
+	target := fmt.Sprintf("Offset: %d  ContentCount: %d", c.Offset, c.ContentCount)
+	if c.GreaterThanOrEqual != nil {
+		target = fmt.Sprintf("GreaterThanOrEqual: %q", *c.GreaterThanOrEqual)
+	}
+	return fmt.Sprintf("Control Type: %s (%q)  Criticality: %t  BeforeCount: %d  AfterCount: %d  %s", "Virtual List View Request", c.GetControlType(), c.Criticality, c.BeforeCount, c.AfterCount, target)
+}
+
+func decodeControlVLVRequest(criticality bool, value *ber.Packet) (ldap.Control, error) {
+	if value == nil || len(value.Children) < 3 || len(value.Children) > 4 {
+		return nil, errors.New("invalid virtual list view control")
+	}
+	c := &ControlVLVRequest{Criticality: criticality}
+	before, ok := value.Children[0].Value.(int64)
+	if !ok || before < 0 {
+		return nil, errors.New("invalid virtual list view beforeCount")
+	}
+	after, ok := value.Children[1].Value.(int64)
+	if !ok || after < 0 {
+		return nil, errors.New("invalid virtual list view afterCount")
+	}
+	c.BeforeCount, c.AfterCount = int(before), int(after)
+
+	target := value.Children[2]
+	switch {
+	case target.ClassType == ber.ClassContext && target.Tag == 0 && len(target.Children) == 2:
+		offset, ok := target.Children[0].Value.(int64)
+		if !ok || offset < 0 {
+			return nil, errors.New("invalid virtual list view offset")
+		}
+		contentCount, ok := target.Children[1].Value.(int64)
+		if !ok || contentCount < 0 {
+			return nil, errors.New("invalid virtual list view contentCount")
+		}
+		c.Offset, c.ContentCount = int(offset), int(contentCount)
+	case target.ClassType == ber.ClassContext && target.Tag == 1:
+		assertion := target.Data.String()
+		c.GreaterThanOrEqual = &assertion
+	default:
+		return nil, errors.New("invalid virtual list view target")
+	}
+
+	if len(value.Children) == 4 {
+		c.ContextID = value.Children[3].Data.Bytes()
+	}
+	return c, nil
+}
+
+// ControlVLVResponse is the virtual list view response control
+// (draft-ietf-ldapext-ldapv3-vlv-09).
+type ControlVLVResponse struct {
+	TargetPosition int
+	ContentCount   int
+	Result         uint16
+	ContextID      []byte
+}
+
+// GetControlType returns the OID of the control.
+func (c *ControlVLVResponse) GetControlType() string {
+	return ldap.ControlTypeVLVResponse
+}
+
+// Encode returns the ber packet representation of the control.
+func (c *ControlVLVResponse) Encode() *ber.Packet {
+	seq := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "VirtualListViewResponse")
+	seq.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, int64(c.TargetPosition), "targetPosition"))
+	seq.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, int64(c.ContentCount), "contentCount"))
+	seq.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(c.Result), "virtualListViewResult"))
+	if c.ContextID != nil {
+		seq.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(c.ContextID), "contextID"))
+	}
+	return encodeControl(c.GetControlType(), false, seq)
+}
+
+// String returns a human-readable description of the control.
+func (c *ControlVLVResponse) String() string {
+	return fmt.Sprintf("Control Type: %s (%q)  TargetPosition: %d  ContentCount: %d  Result: %d", "Virtual List View Response", c.GetControlType(), c.TargetPosition, c.ContentCount, c.Result)
+}
+
+// encodeControl encodes a control with the passed ber encoded value.
+func encodeControl(controlType string, criticality bool, value *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Control")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, controlType, "Control Type ("+ldap.ControlTypeMap[controlType]+")"))
+	if criticality {
+		packet.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, criticality, "Criticality"))
+	}
+	if value != nil {
+		valuePacket := ber.Encode(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, nil, "Control Value")
+		valuePacket.AppendChild(value)
+		packet.AppendChild(valuePacket)
+	}
+	return packet
+}
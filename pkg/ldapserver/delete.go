@@ -1,6 +1,7 @@
 package ldapserver
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -9,13 +10,20 @@ import (
 	"github.com/go-ldap/ldap/v3"
 )
 
-func HandleDeleteRequest(req *ber.Packet, boundDN string, server *Server, conn net.Conn) error {
+func HandleDeleteRequest(ctx context.Context, req *ber.Packet, controls []ldap.Control, messageID int64, boundDN string, server *Server, conn net.Conn) ([]ldap.Control, error) {
 	if boundDN == "" {
-		return ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("anonymous Write denied"))
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("anonymous Write denied"))
 	}
 	delReq, err := parseDeleteRequest(req)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	delReq.Controls = controls
+	if err = checkWriteControls(server, controls); err != nil {
+		return nil, err
+	}
+	if queued, err := queueTransactionRequest(ctx, controls, messageID, boundDN, delReq); queued {
+		return nil, err
 	}
 	fnNames := []string{}
 	for k := range server.DeleteFns {
@@ -29,10 +37,11 @@ func HandleDeleteRequest(req *ber.Packet, boundDN string, server *Server, conn n
 		} else {
 			err = fmt.Errorf("handler '%s' does not support add", fn)
 		}
-		return ldap.NewError(ldap.LDAPResultUnwillingToPerform, err)
+		return nil, ldap.NewError(ldap.LDAPResultUnwillingToPerform, err)
 	}
-	code, err := del.Delete(boundDN, delReq, conn)
-	return ldap.NewError(uint16(code), err)
+	ctx, rc := withResponseControls(ctx)
+	code, err := del.Delete(ctx, boundDN, delReq, conn)
+	return rc.get(), ldap.NewError(uint16(code), err)
 }
 
 func parseDeleteRequest(req *ber.Packet) (*ldap.DelRequest, error) {
@@ -1,6 +1,7 @@
 package ldapserver
 
 import (
+	"context"
 	"errors"
 	"net"
 
@@ -8,7 +9,7 @@ import (
 	"github.com/go-ldap/ldap/v3"
 )
 
-type ExopHandler func(req *ber.Packet, boundDN string, server *Server, conn net.Conn) (*ber.Packet, error)
+type ExopHandler func(ctx context.Context, req *ber.Packet, boundDN string, server *Server, conn net.Conn) (*ber.Packet, error)
 
 type ExtendedRequest struct {
 	OID  string
@@ -21,14 +22,14 @@ func RegisterExtendedOperation(oid string, handler ExopHandler) {
 	exopRegistry[oid] = handler
 }
 
-func HandleExtendedRequest(req *ber.Packet, boundDN string, server *Server, conn net.Conn) (*ber.Packet, error) {
+func HandleExtendedRequest(ctx context.Context, req *ber.Packet, boundDN string, server *Server, conn net.Conn) (*ber.Packet, error) {
 	extReq, err := parseExtendedRequest(req)
 	if err != nil {
 		logger.V(1).Info("parsing extened request failed", "error", err)
 		return nil, err
 	}
 	if handler, ok := exopRegistry[extReq.OID]; ok {
-		innerBer, err := handler(extReq.Body, boundDN, server, conn)
+		innerBer, err := handler(ctx, extReq.Body, boundDN, server, conn)
 		var resCode LDAPResultCode = ldap.LDAPResultSuccess
 		msg := ""
 		if err != nil {
@@ -81,7 +82,12 @@ func encodeExtendedResponse(rescode LDAPResultCode, msg, oid string, responseVal
 	respBer.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN: "))
 	respBer.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, msg, "errorMessage: "))
 	respBer.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 10, oid, "responseName"))
-	if responseValue != nil {
+	switch {
+	case responseValue == nil:
+	case responseValue.ClassType == ber.ClassContext && responseValue.Tag == 11:
+		// The response value is not BER encoded.
+		respBer.AppendChild(responseValue)
+	default:
 		encValue := ber.Encode(ber.ClassContext, ber.TypePrimitive, 11, nil, "responseValue")
 		encValue.AppendChild(responseValue)
 		respBer.AppendChild(encValue)
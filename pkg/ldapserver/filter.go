@@ -6,11 +6,16 @@
 package ldapserver
 
 import (
+	"bytes"
 	"strings"
+	"sync"
+	"unicode"
 
 	ber "github.com/go-asn1-ber/asn1-ber"
 	"github.com/go-ldap/ldap/v3"
 	"golang.org/x/text/cases"
+
+	"github.com/libregraph/idm/pkg/schema"
 )
 
 const (
@@ -45,7 +50,20 @@ func DecompileFilter(packet *ber.Packet) (ret string, err error) {
 	return ldap.DecompileFilter(packet)
 }
 
+// builtinSchema is the schema used by ServerApplyFilter and
+// ServerFilterAttributes.
+var builtinSchema = sync.OnceValue(schema.New)
+
+// ServerApplyFilter returns true if entry matches the filter f, comparing
+// values according to the matching rules of the built-in schema.
 func ServerApplyFilter(f *ber.Packet, entry *ldap.Entry) (bool, LDAPResultCode) {
+	return ServerApplyFilterWithSchema(builtinSchema(), f, entry)
+}
+
+// ServerApplyFilterWithSchema returns true if entry matches the filter f,
+// comparing values according to the matching rules of the attribute types
+// in s.
+func ServerApplyFilterWithSchema(s *schema.Schema, f *ber.Packet, entry *ldap.Entry) (bool, LDAPResultCode) {
 	switch FilterMap[uint64(f.Tag)] {
 	default:
 		//log.Fatalf("Unknown LDAP filter code: %d", f.Tag)
@@ -55,14 +73,18 @@ func ServerApplyFilter(f *ber.Packet, entry *ldap.Entry) (bool, LDAPResultCode)
 			return false, ldap.LDAPResultOperationsError
 		}
 		attribute := f.Children[0].Value.(string)
-		value := f.Children[1].Value.(string)
-		for _, a := range entry.Attributes {
-			if strings.EqualFold(a.Name, attribute) {
-				for _, v := range a.Values {
-					if strings.EqualFold(v, value) {
-						return true, ldap.LDAPResultSuccess
-					}
-				}
+		rule, ok := s.EqualityRule(attribute)
+		if !ok {
+			// Undefined without an equality rule.
+			return false, ldap.LDAPResultSuccess
+		}
+		assertion, ok := rule.Normalize(f.Children[1].Value.(string))
+		if !ok {
+			return false, ldap.LDAPResultSuccess
+		}
+		for _, v := range filterAttributeValues(s, entry, attribute) {
+			if value, ok := rule.Normalize(v); ok && value == assertion {
+				return true, ldap.LDAPResultSuccess
 			}
 		}
 	case "Present":
@@ -73,7 +95,7 @@ func ServerApplyFilter(f *ber.Packet, entry *ldap.Entry) (bool, LDAPResultCode)
 		}
 	case "And":
 		for _, child := range f.Children {
-			ok, exitCode := ServerApplyFilter(child, entry)
+			ok, exitCode := ServerApplyFilterWithSchema(s, child, entry)
 			if exitCode != ldap.LDAPResultSuccess {
 				return false, exitCode
 			}
@@ -85,7 +107,7 @@ func ServerApplyFilter(f *ber.Packet, entry *ldap.Entry) (bool, LDAPResultCode)
 	case "Or":
 		anyOk := false
 		for _, child := range f.Children {
-			ok, exitCode := ServerApplyFilter(child, entry)
+			ok, exitCode := ServerApplyFilterWithSchema(s, child, entry)
 			if exitCode != ldap.LDAPResultSuccess {
 				return false, exitCode
 			} else if ok {
@@ -99,7 +121,7 @@ func ServerApplyFilter(f *ber.Packet, entry *ldap.Entry) (bool, LDAPResultCode)
 		if len(f.Children) != 1 {
 			return false, ldap.LDAPResultOperationsError
 		}
-		ok, exitCode := ServerApplyFilter(f.Children[0], entry)
+		ok, exitCode := ServerApplyFilterWithSchema(s, f.Children[0], entry)
 		if exitCode != ldap.LDAPResultSuccess {
 			return false, exitCode
 		} else if !ok {
@@ -110,42 +132,278 @@ func ServerApplyFilter(f *ber.Packet, entry *ldap.Entry) (bool, LDAPResultCode)
 			return false, ldap.LDAPResultOperationsError
 		}
 		attribute := f.Children[0].Value.(string)
-		bytes := f.Children[1].Children[0].Data.Bytes()
-		value := casefold.String(string(bytes))
+		assertion, ok := ParseSubstringAssertion(f.Children[1])
+		if !ok {
+			return false, ldap.LDAPResultOperationsError
+		}
+		assertion = assertion.Map(casefold.String)
 		for _, a := range entry.Attributes {
 			if strings.EqualFold(a.Name, attribute) {
 				for _, v := range a.Values {
-					v = casefold.String(v)
-					switch f.Children[1].Children[0].Tag {
-					case FilterSubstringsInitial:
-						if strings.HasPrefix(v, value) {
-							return true, ldap.LDAPResultSuccess
-						}
-					case FilterSubstringsAny:
-						if strings.Contains(v, value) {
-							return true, ldap.LDAPResultSuccess
-						}
-					case FilterSubstringsFinal:
-						if strings.HasSuffix(v, value) {
-							return true, ldap.LDAPResultSuccess
-						}
+					if assertion.Match(casefold.String(v)) {
+						return true, ldap.LDAPResultSuccess
 					}
 				}
 			}
 		}
-	case "FilterGreaterOrEqual": // TODO
-		return false, ldap.LDAPResultOperationsError
-	case "FilterLessOrEqual": // TODO
-		return false, ldap.LDAPResultOperationsError
-	case "FilterApproxMatch": // TODO
-		return false, ldap.LDAPResultOperationsError
-	case "FilterExtensibleMatch": // TODO
+	case "Greater Or Equal", "Less Or Equal":
+		if len(f.Children) != 2 {
+			return false, ldap.LDAPResultOperationsError
+		}
+		attribute := f.Children[0].Value.(string)
+		rule, ok := filterOrderingRule(s, attribute)
+		if !ok {
+			// Undefined without an ordering rule.
+			return false, ldap.LDAPResultSuccess
+		}
+		assertion, ok := rule.OrderingKey(f.Children[1].Value.(string))
+		if !ok {
+			return false, ldap.LDAPResultSuccess
+		}
+		for _, v := range filterAttributeValues(s, entry, attribute) {
+			key, ok := rule.OrderingKey(v)
+			if !ok {
+				continue
+			}
+			c := bytes.Compare(key, assertion)
+			if (f.Tag == FilterGreaterOrEqual && c >= 0) || (f.Tag == FilterLessOrEqual && c <= 0) {
+				return true, ldap.LDAPResultSuccess
+			}
+		}
+	case "Approx Match":
+		if len(f.Children) != 2 {
+			return false, ldap.LDAPResultOperationsError
+		}
+		attribute := f.Children[0].Value.(string)
+		assertion := f.Children[1].Value.(string)
+		for _, v := range filterAttributeValues(s, entry, attribute) {
+			if approxMatch(v, assertion) {
+				return true, ldap.LDAPResultSuccess
+			}
+		}
+	case "Extensible Match":
+		return serverApplyExtensibleMatch(s, f, entry)
+	}
+
+	return false, ldap.LDAPResultSuccess
+}
+
+// SubstringAssertion is the assertion of a substrings filter (RFC 4511
+// 4.5.1.7.2). Empty Initial and Final components are absent.
+type SubstringAssertion struct {
+	Initial string
+	Any     []string
+	Final   string
+}
+
+// ParseSubstringAssertion returns the assertion of the substrings of a
+// substrings filter. It returns false if there are no substrings or they are
+// not in order, which is at most one initial substring first, followed by
+// any number of any substrings and at most one final substring last.
+func ParseSubstringAssertion(substrings *ber.Packet) (*SubstringAssertion, bool) {
+	if len(substrings.Children) == 0 {
+		return nil, false
+	}
+	assertion := &SubstringAssertion{}
+	last := len(substrings.Children) - 1
+	for i, child := range substrings.Children {
+		value := ber.DecodeString(child.Data.Bytes())
+		switch {
+		case child.Tag == FilterSubstringsInitial && i == 0:
+			assertion.Initial = value
+		case child.Tag == FilterSubstringsAny:
+			assertion.Any = append(assertion.Any, value)
+		case child.Tag == FilterSubstringsFinal && i == last:
+			assertion.Final = value
+		default:
+			return nil, false
+		}
+	}
+	return assertion, true
+}
+
+// Map returns a copy of the assertion with mapping applied to all of its
+// components, for example to fold their case.
+func (a *SubstringAssertion) Map(mapping func(string) string) *SubstringAssertion {
+	mapped := &SubstringAssertion{
+		Initial: mapping(a.Initial),
+		Any:     make([]string, len(a.Any)),
+		Final:   mapping(a.Final),
+	}
+	for i, any := range a.Any {
+		mapped.Any[i] = mapping(any)
+	}
+	return mapped
+}
+
+// Match returns true if value starts with the initial substring, contains
+// the any substrings in order without overlap after it and ends with the
+// final substring after them.
+func (a *SubstringAssertion) Match(value string) bool {
+	if !strings.HasPrefix(value, a.Initial) {
+		return false
+	}
+	value = value[len(a.Initial):]
+	for _, any := range a.Any {
+		i := strings.Index(value, any)
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(any):]
+	}
+	return strings.HasSuffix(value, a.Final)
+}
+
+// serverApplyExtensibleMatch returns true if entry matches the extensible
+// match filter f (RFC 4511 4.5.1.7.7). Without a matching rule, the equality
+// rule of the attribute type is used. Without an attribute type, all
+// attributes are matched with the rule. With dnAttributes, the attributes of
+// the RDNs of the entry DN are matched as well.
+func serverApplyExtensibleMatch(s *schema.Schema, f *ber.Packet, entry *ldap.Entry) (bool, LDAPResultCode) {
+	var ruleName, attribute, assertion string
+	var dnAttributes bool
+	for _, child := range f.Children {
+		switch child.Tag {
+		case ldap.MatchingRuleAssertionMatchingRule:
+			ruleName = ber.DecodeString(child.Data.Bytes())
+		case ldap.MatchingRuleAssertionType:
+			attribute = ber.DecodeString(child.Data.Bytes())
+		case ldap.MatchingRuleAssertionMatchValue:
+			assertion = ber.DecodeString(child.Data.Bytes())
+		case ldap.MatchingRuleAssertionDNAttributes:
+			dnAttributes, _ = child.Value.(bool)
+		}
+	}
+	if ruleName == "" && attribute == "" {
 		return false, ldap.LDAPResultOperationsError
 	}
+	var rule *schema.MatchingRule
+	if ruleName != "" {
+		var ok bool
+		if rule, ok = s.MatchingRule(ruleName); !ok {
+			return false, ldap.LDAPResultInappropriateMatching
+		}
+	}
 
+	attributes := entry.Attributes
+	if dnAttributes {
+		if dn, err := ldap.ParseDN(entry.DN); err == nil {
+			attributes = append([]*ldap.EntryAttribute{}, attributes...)
+			for _, rdn := range dn.RDNs {
+				for _, a := range rdn.Attributes {
+					attributes = append(attributes, ldap.NewEntryAttribute(a.Type, []string{a.Value}))
+				}
+			}
+		}
+	}
+	for _, a := range attributes {
+		if attribute != "" && !filterAttributeMatches(s, attribute, a.Name) {
+			continue
+		}
+		r := rule
+		if r == nil {
+			var ok bool
+			if r, ok = s.EqualityRule(a.Name); !ok {
+				continue
+			}
+		}
+		for _, v := range a.Values {
+			if ok, defined := r.Match(v, assertion); defined && ok {
+				return true, ldap.LDAPResultSuccess
+			}
+		}
+	}
 	return false, ldap.LDAPResultSuccess
 }
 
+// filterAttributeMatches returns true if the attribute description name of
+// an entry attribute matches the attribute description of a filter.
+func filterAttributeMatches(s *schema.Schema, attribute string, name string) bool {
+	if strings.EqualFold(attribute, name) {
+		return true
+	}
+	a, ok := s.AttributeType(attribute)
+	return ok && a.HasName(name)
+}
+
+// filterAttributeValues returns the values of the attributes of entry which
+// match the attribute description of a filter.
+func filterAttributeValues(s *schema.Schema, entry *ldap.Entry, attribute string) []string {
+	var values []string
+	for _, a := range entry.Attributes {
+		if filterAttributeMatches(s, attribute, a.Name) {
+			values = append(values, a.Values...)
+		}
+	}
+	return values
+}
+
+// filterOrderingRule returns the ordering matching rule of attribute.
+// Attributes which are not in the schema are ordered as case-ignore strings.
+func filterOrderingRule(s *schema.Schema, attribute string) (*schema.MatchingRule, bool) {
+	if a, ok := s.AttributeType(attribute); ok {
+		return s.OrderingRule(a)
+	}
+	return s.MatchingRule("caseIgnoreOrderingMatch")
+}
+
+// approxMatch returns true if value approximately matches assertion. This is
+// the case if both are equal ignoring case, punctuation and spacing, or if
+// their words sound alike.
+func approxMatch(value, assertion string) bool {
+	valueWords := approxWords(value)
+	assertionWords := approxWords(assertion)
+	if strings.Join(valueWords, "") == strings.Join(assertionWords, "") {
+		return true
+	}
+	if len(valueWords) != len(assertionWords) {
+		return false
+	}
+	for i, word := range valueWords {
+		if soundex(word) != soundex(assertionWords[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// approxWords returns the case folded words of value.
+func approxWords(value string) []string {
+	return strings.FieldsFunc(casefold.String(value), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// soundex returns the American Soundex code of word. Words which do not
+// start with an ASCII letter are their own code.
+func soundex(word string) string {
+	const codes = "01230120022455012623010202"
+	if word == "" || word[0] < 'a' || word[0] > 'z' {
+		return word
+	}
+	code := []byte{word[0] - 'a' + 'A'}
+	last := codes[word[0]-'a']
+	for i := 1; i < len(word) && len(code) < 4; i++ {
+		c := word[i]
+		if c < 'a' || c > 'z' {
+			last = '0'
+			continue
+		}
+		digit := codes[c-'a']
+		if digit != '0' && digit != last {
+			code = append(code, digit)
+		}
+		// H and W do not separate letters with the same code.
+		if c != 'h' && c != 'w' {
+			last = digit
+		}
+	}
+	for len(code) < 4 {
+		code = append(code, '0')
+	}
+	return string(code)
+}
+
 func ServerFilterScope(baseDN string, scope int, entry *ldap.Entry) (bool, LDAPResultCode) {
 	// constrained search scope
 	parsedBaseDn, err := ldap.ParseDN(baseDN)
@@ -180,14 +438,21 @@ func ServerFilterScope(baseDN string, scope int, entry *ldap.Entry) (bool, LDAPR
 	return true, ldap.LDAPResultSuccess
 }
 
+// ServerFilterAttributes reduces the attributes of entry to the requested
+// ones, classifying operational attributes with the built-in schema.
 func ServerFilterAttributes(attributes []string, entry *ldap.Entry) (LDAPResultCode, error) {
-	// attributes
-	if len(attributes) > 1 || (len(attributes) == 1 && len(attributes[0]) > 0) {
-		_, err := filterAttributes(entry, attributes)
-		if err != nil {
-			return ldap.LDAPResultOperationsError, err
-		}
+	return ServerFilterAttributesWithSchema(builtinSchema(), attributes, entry)
+}
+
+// ServerFilterAttributesWithSchema reduces the attributes of entry to the
+// requested ones. User attributes are kept for an empty list or "*",
+// operational attributes only when named or with "+". Attributes are
+// classified as operational by s.
+func ServerFilterAttributesWithSchema(s *schema.Schema, attributes []string, entry *ldap.Entry) (LDAPResultCode, error) {
+	if len(attributes) == 1 && len(attributes[0]) == 0 {
+		attributes = nil
 	}
+	entry.Attributes = selectAttributes(s, entry.Attributes, attributes)
 
 	return ldap.LDAPResultSuccess, nil
 }
@@ -101,3 +101,79 @@ func TestServerFilterScope(t *testing.T) {
 		})
 	}
 }
+
+func TestServerApplyFilter(t *testing.T) {
+	entry := ldap.NewEntry("uid=jsmith,ou=users,o=libregraph-idm", map[string][]string{
+		"objectClass":     {"inetOrgPerson", "posixAccount"},
+		"uid":             {"jsmith"},
+		"cn":              {"John Smith"},
+		"sn":              {"Smith"},
+		"uidNumber":       {"1000"},
+		"createTimestamp": {"20210304112233Z"},
+		"userAccountMode": {"514"},
+	})
+	tests := []struct {
+		filter string
+		want   bool
+		code   LDAPResultCode
+	}{
+		{"(cn=jo*n*smith)", true, ldap.LDAPResultSuccess},
+		{"(cn=jo*smith*n)", false, ldap.LDAPResultSuccess},
+		{"(cn=*OHN*MI*)", true, ldap.LDAPResultSuccess},
+		{"(cn=john*n smith)", false, ldap.LDAPResultSuccess},
+		{"(cn=j*h*h)", true, ldap.LDAPResultSuccess},
+		{"(cn=j*h*h*h)", false, ldap.LDAPResultSuccess},
+		{"(uidNumber>=1000)", true, ldap.LDAPResultSuccess},
+		{"(uidNumber>=999)", true, ldap.LDAPResultSuccess},
+		{"(uidNumber>=10000)", false, ldap.LDAPResultSuccess},
+		{"(uidNumber<=999)", false, ldap.LDAPResultSuccess},
+		{"(uidNumber<=1000)", true, ldap.LDAPResultSuccess},
+		{"(uidNumber>=abc)", false, ldap.LDAPResultSuccess},
+		{"(createTimestamp>=20210304120000+0100)", true, ldap.LDAPResultSuccess},
+		{"(createTimestamp<=20210304100000Z)", false, ldap.LDAPResultSuccess},
+		{"(sn>=SMITH)", true, ldap.LDAPResultSuccess},
+		{"(surname<=Adams)", false, ldap.LDAPResultSuccess},
+		{"(objectClass>=a)", false, ldap.LDAPResultSuccess},
+		{"(sn~=smyth)", true, ldap.LDAPResultSuccess},
+		{"(cn~=jon smith)", true, ldap.LDAPResultSuccess},
+		{"(cn~=john-smith)", true, ldap.LDAPResultSuccess},
+		{"(cn~=jane doe)", false, ldap.LDAPResultSuccess},
+		{"(sn:=smith)", true, ldap.LDAPResultSuccess},
+		{"(sn:caseExactMatch:=smith)", false, ldap.LDAPResultSuccess},
+		{"(sn:2.5.13.5:=Smith)", true, ldap.LDAPResultSuccess},
+		{"(uidNumber:integerOrderingMatch:=1001)", true, ldap.LDAPResultSuccess},
+		{"(userAccountMode:1.2.840.113556.1.4.803:=2)", true, ldap.LDAPResultSuccess},
+		{"(userAccountMode:1.2.840.113556.1.4.803:=3)", false, ldap.LDAPResultSuccess},
+		{"(ou:dn:=users)", true, ldap.LDAPResultSuccess},
+		{"(ou:=users)", false, ldap.LDAPResultSuccess},
+		{"(:dn:caseIgnoreMatch:=libregraph-idm)", true, ldap.LDAPResultSuccess},
+		{"(sn:unknownMatch:=smith)", false, ldap.LDAPResultInappropriateMatching},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, err := CompileFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("CompileFilter() error = %v", err)
+			}
+			got, code := ServerApplyFilter(f, entry)
+			if got != tt.want || code != tt.code {
+				t.Errorf("ServerApplyFilter() = %v, %v, want %v, %v", got, code, tt.want, tt.code)
+			}
+		})
+	}
+}
+
+func TestSoundex(t *testing.T) {
+	for word, code := range map[string]string{
+		"robert":   "R163",
+		"rupert":   "R163",
+		"ashcraft": "A261",
+		"tymczak":  "T522",
+		"pfister":  "P236",
+		"lee":      "L000",
+	} {
+		if got := soundex(word); got != code {
+			t.Errorf("soundex(%q) = %s, want %s", word, got, code)
+		}
+	}
+}
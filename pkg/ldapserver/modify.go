@@ -1,6 +1,7 @@
 package ldapserver
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -9,13 +10,20 @@ import (
 	"github.com/go-ldap/ldap/v3"
 )
 
-func HandleModifyRequest(req *ber.Packet, boundDN string, server *Server, conn net.Conn) error {
+func HandleModifyRequest(ctx context.Context, req *ber.Packet, controls []ldap.Control, messageID int64, boundDN string, server *Server, conn net.Conn) ([]ldap.Control, error) {
 	if boundDN == "" {
-		return ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("anonymous Write denied"))
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("anonymous Write denied"))
 	}
 	modReq, err := parseModifyRequest(req)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	modReq.Controls = controls
+	if err = checkWriteControls(server, controls); err != nil {
+		return nil, err
+	}
+	if queued, err := queueTransactionRequest(ctx, controls, messageID, boundDN, modReq); queued {
+		return nil, err
 	}
 
 	logger.V(1).Info("Parsed Modification", "request", dumpModRequest(modReq))
@@ -32,10 +40,11 @@ func HandleModifyRequest(req *ber.Packet, boundDN string, server *Server, conn n
 		} else {
 			err = fmt.Errorf("handler '%s' does not support modify", fn)
 		}
-		return ldap.NewError(ldap.LDAPResultUnwillingToPerform, err)
+		return nil, ldap.NewError(ldap.LDAPResultUnwillingToPerform, err)
 	}
-	code, err := modifier.Modify(boundDN, modReq, conn)
-	return ldap.NewError(uint16(code), err)
+	ctx, rc := withResponseControls(ctx)
+	code, err := modifier.Modify(ctx, boundDN, modReq, conn)
+	return rc.get(), ldap.NewError(uint16(code), err)
 }
 
 func dumpModRequest(mr *ldap.ModifyRequest) string {
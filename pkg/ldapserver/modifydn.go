@@ -1,6 +1,7 @@
 package ldapserver
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -9,14 +10,21 @@ import (
 	"github.com/go-ldap/ldap/v3"
 )
 
-func HandleModifyDNRequest(req *ber.Packet, boundDN string, server *Server, conn net.Conn) error {
+func HandleModifyDNRequest(ctx context.Context, req *ber.Packet, controls []ldap.Control, messageID int64, boundDN string, server *Server, conn net.Conn) ([]ldap.Control, error) {
 	if boundDN == "" {
-		return ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("anonymous Write denied"))
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("anonymous Write denied"))
 	}
 
 	modDNReq, err := parseModifyDNRequest(req)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	modDNReq.Controls = controls
+	if err = checkWriteControls(server, controls); err != nil {
+		return nil, err
+	}
+	if queued, err := queueTransactionRequest(ctx, controls, messageID, boundDN, modDNReq); queued {
+		return nil, err
 	}
 
 	fnNames := []string{}
@@ -31,11 +39,12 @@ func HandleModifyDNRequest(req *ber.Packet, boundDN string, server *Server, conn
 		} else {
 			err = fmt.Errorf("handler '%s' does not support rename", fn)
 		}
-		return ldap.NewError(ldap.LDAPResultUnwillingToPerform, err)
+		return nil, ldap.NewError(ldap.LDAPResultUnwillingToPerform, err)
 	}
-	code, err := rename.ModifyDN(boundDN, modDNReq, conn)
-	return ldap.NewError(uint16(code), err)
-	return ldap.NewError(ldap.LDAPResultProtocolError, errors.New("invalid ModifyDN request"))
+	ctx, rc := withResponseControls(ctx)
+	code, err := rename.ModifyDN(ctx, boundDN, modDNReq, conn)
+	return rc.get(), ldap.NewError(uint16(code), err)
+	return nil, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("invalid ModifyDN request"))
 }
 
 func parseModifyDNRequest(req *ber.Packet) (*ldap.ModifyDNRequest, error) {
@@ -74,9 +83,15 @@ func parseModifyDNRequest(req *ber.Packet) (*ldap.ModifyDNRequest, error) {
 
 	modDNReq.DeleteOldRDN = removeOld
 
-	// moving to a new subtree is not yet supported
 	if len(req.Children) == 4 {
-		return nil, ldap.NewError(ldap.LDAPResultUnwillingToPerform, errors.New("moving to 'newSuperior' is not implemented"))
+		newSuperior := req.Children[3]
+		if newSuperior.ClassType != ber.ClassContext || newSuperior.Tag != 0 {
+			return nil, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("error decoding 'newSuperior'"))
+		}
+		if _, err = ldap.ParseDN(newSuperior.Data.String()); err != nil {
+			return nil, ldap.NewError(ldap.LDAPResultProtocolError, err)
+		}
+		modDNReq.NewSuperior = newSuperior.Data.String()
 	}
 
 	return &modDNReq, nil
@@ -0,0 +1,50 @@
+package ldapserver
+
+import (
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestParseModifyDNRequest(t *testing.T) {
+	newRequest := func(dn, newRDN string, newSuperior *ber.Packet) *ber.Packet {
+		req := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationModifyDNRequest, nil, "Modify DN Request")
+		req.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "DN"))
+		req.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, newRDN, "New RDN"))
+		req.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, true, "Delete old RDN"))
+		if newSuperior != nil {
+			req.AppendChild(newSuperior)
+		}
+		return req
+	}
+
+	modDNReq, err := parseModifyDNRequest(newRequest("uid=user,ou=a,dc=example,dc=org", "uid=renamed", nil))
+	if err != nil {
+		t.Fatalf("valid LDAP ModifyDN Request should succeed. Got: %v", err)
+	}
+	if modDNReq.NewRDN != "uid=renamed" || !modDNReq.DeleteOldRDN || modDNReq.NewSuperior != "" {
+		t.Errorf("Unexpected modify DN request: %v", modDNReq)
+	}
+
+	newSuperior := ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, "ou=b,dc=example,dc=org", "New Superior")
+	modDNReq, err = parseModifyDNRequest(newRequest("uid=user,ou=a,dc=example,dc=org", "uid=user", newSuperior))
+	if err != nil {
+		t.Fatalf("LDAP ModifyDN Request with newSuperior should succeed. Got: %v", err)
+	}
+	if modDNReq.NewSuperior != "ou=b,dc=example,dc=org" {
+		t.Errorf("Unexpected newSuperior: %q", modDNReq.NewSuperior)
+	}
+
+	invalid := ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, "invalid", "New Superior")
+	_, err = parseModifyDNRequest(newRequest("uid=user,ou=a,dc=example,dc=org", "uid=user", invalid))
+	if err == nil || !ldap.IsErrorWithCode(err, ldap.LDAPResultProtocolError) {
+		t.Errorf("LDAP ModifyDN Request with invalid newSuperior should give Protocol Error. Got: %v", err)
+	}
+
+	wrongTag := ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "ou=b,dc=example,dc=org", "New Superior")
+	_, err = parseModifyDNRequest(newRequest("uid=user,ou=a,dc=example,dc=org", "uid=user", wrongTag))
+	if err == nil || !ldap.IsErrorWithCode(err, ldap.LDAPResultProtocolError) {
+		t.Errorf("LDAP ModifyDN Request with untagged newSuperior should give Protocol Error. Got: %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package ldapserver
+
+import (
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/libregraph/idm/pkg/ldapentry"
+	"github.com/libregraph/idm/pkg/schema"
+)
+
+// Operational attributes of entries (RFC 4512 3.4, RFC 3045, RFC 4530 and
+// RFC 5020). Searches return them only when they are requested by name or
+// with "+".
+const (
+	CreateTimestampAttribute       = "createTimestamp"
+	ModifyTimestampAttribute       = "modifyTimestamp"
+	CreatorsNameAttribute          = "creatorsName"
+	ModifiersNameAttribute         = "modifiersName"
+	EntryUUIDAttribute             = "entryUUID"
+	EntryDNAttribute               = "entryDN"
+	StructuralObjectClassAttribute = "structuralObjectClass"
+	HasSubordinatesAttribute       = "hasSubordinates"
+	SubschemaSubentryAttribute     = "subschemaSubentry"
+)
+
+// GeneralizedTime formats t with the Generalized Time syntax in UTC, as used
+// by createTimestamp and modifyTimestamp.
+func GeneralizedTime(t time.Time) string {
+	return t.UTC().Format("20060102150405Z")
+}
+
+// SetOperationalAttributes sets the operational attributes of entry which
+// are derived from the entry itself: entryDN, subschemaSubentry and
+// structuralObjectClass, if the object classes of entry determine it in s.
+// Existing values of these attributes are replaced.
+func SetOperationalAttributes(s *schema.Schema, entry *ldap.Entry) {
+	ldapentry.SetAttributeValues(entry, EntryDNAttribute, []string{entry.DN})
+	ldapentry.SetAttributeValues(entry, SubschemaSubentryAttribute, []string{SubschemaSubentryDN})
+	if o, err := s.StructuralObjectClass(entry); err == nil {
+		ldapentry.SetAttributeValues(entry, StructuralObjectClassAttribute, []string{o.Name()})
+	}
+}
@@ -0,0 +1,78 @@
+package ldapserver
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/libregraph/idm/pkg/schema"
+)
+
+func attributeNames(entry *ldap.Entry) string {
+	names := []string{}
+	for _, attr := range entry.Attributes {
+		names = append(names, attr.Name)
+	}
+	slices.Sort(names)
+	return strings.Join(names, ",")
+}
+
+// newOperationalTestSearcher returns a searcher of an entry with operational
+// attributes. The attributes of the entry are reduced by the search.
+func newOperationalTestSearcher() Searcher {
+	entry := ldap.NewEntry("uid=a,o=base", map[string][]string{
+		"objectClass":     {"account"},
+		"uid":             {"a"},
+		"createTimestamp": {"20240101000000Z"},
+		"entryUUID":       {"b1f8c2a4-7d4e-4b8a-9c1e-0f2d3a4b5c6d"},
+	})
+	SetOperationalAttributes(schema.New(), entry)
+	return scopeSearcher{entries: []*ldap.Entry{entry}}
+}
+
+func TestSearchOperationalAttributes(t *testing.T) {
+	server := NewServer()
+	server.EnforceLDAP = true
+
+	for _, test := range []struct {
+		attributes []string
+		result     string
+	}{
+		{nil, "objectClass,uid"},
+		{[]string{"*"}, "objectClass,uid"},
+		{[]string{"+"}, "createTimestamp,entryDN,entryUUID,structuralObjectClass,subschemaSubentry"},
+		{[]string{"*", "+"}, "createTimestamp,entryDN,entryUUID,objectClass,structuralObjectClass,subschemaSubentry,uid"},
+		{[]string{"uid", "entryuuid"}, "entryUUID,uid"},
+		{[]string{"1.1"}, ""},
+	} {
+		server.SearchFunc("", newOperationalTestSearcher())
+		l := startTestConn(t, server)
+		res, err := l.Search(ldap.NewSearchRequest("uid=a,o=base", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", test.attributes, nil))
+		l.Close()
+		if err != nil || len(res.Entries) != 1 {
+			t.Errorf("Search with attributes %v failed: %v", test.attributes, err)
+			continue
+		}
+		if got := attributeNames(res.Entries[0]); got != test.result {
+			t.Errorf("Search with attributes %v returned %s, expected %s", test.attributes, got, test.result)
+		}
+	}
+
+	server.SearchFunc("", newOperationalTestSearcher())
+	l := startTestConn(t, server)
+	defer l.Close()
+	res, err := l.Search(ldap.NewSearchRequest("uid=a,o=base", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, true, "(entryDN=uid=a,o=base)", []string{"uid", "structuralObjectClass"}, nil))
+	if err != nil || len(res.Entries) != 1 {
+		t.Fatalf("Search with types only failed: %v", err)
+	}
+	if got := attributeNames(res.Entries[0]); got != "structuralObjectClass,uid" {
+		t.Errorf("Search with types only returned %s", got)
+	}
+	for _, attr := range res.Entries[0].Attributes {
+		if len(attr.Values) != 0 {
+			t.Errorf("Search with types only returned values of %s: %v", attr.Name, attr.Values)
+		}
+	}
+}
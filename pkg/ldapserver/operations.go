@@ -0,0 +1,196 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	// errAbandoned is the context cause of operations which were abandoned by
+	// the client. No response is sent for them.
+	errAbandoned = errors.New("operation abandoned")
+	// errCanceled is the context cause of operations which were canceled with
+	// the Cancel extended operation. They are responded with canceled.
+	errCanceled = errors.New("operation canceled")
+)
+
+type operationsContextKey struct{}
+
+// operation is a single outstanding LDAP operation of a connection.
+type operation struct {
+	ctx        context.Context
+	cancel     context.CancelCauseFunc
+	cancelable bool
+	persistent bool
+	responding bool
+	done       chan struct{}
+}
+
+// operations tracks the outstanding operations of a connection by message ID.
+type operations struct {
+	mutex sync.Mutex
+	ops   map[int64]*operation
+}
+
+func newOperations() *operations {
+	return &operations{
+		ops: make(map[int64]*operation),
+	}
+}
+
+// withOperations returns a copy of ctx which carries ops.
+func withOperations(ctx context.Context, ops *operations) context.Context {
+	return context.WithValue(ctx, operationsContextKey{}, ops)
+}
+
+// operationsFromContext returns the operations of the connection which
+// ctx belongs to, or nil.
+func operationsFromContext(ctx context.Context) *operations {
+	ops, _ := ctx.Value(operationsContextKey{}).(*operations)
+	return ops
+}
+
+// start registers a new operation with messageID and returns its context.
+// It returns false if an operation with the same message ID is outstanding.
+// Persistent operations do not complete until they are abandoned.
+func (o *operations) start(ctx context.Context, messageID int64, cancelable, persistent bool) (context.Context, bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if _, exists := o.ops[messageID]; exists {
+		return nil, false
+	}
+	opCtx, cancel := context.WithCancelCause(ctx)
+	o.ops[messageID] = &operation{
+		ctx:        opCtx,
+		cancel:     cancel,
+		cancelable: cancelable,
+		persistent: persistent,
+		done:       make(chan struct{}),
+	}
+	return opCtx, true
+}
+
+// respond marks the operation with messageID as about to send its final
+// response. From then on it can no longer be canceled. The returned error is
+// the cause if the operation was canceled or abandoned before.
+func (o *operations) respond(messageID int64) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	op, ok := o.ops[messageID]
+	if !ok {
+		return nil
+	}
+	if op.ctx.Err() != nil {
+		return context.Cause(op.ctx)
+	}
+	op.responding = true
+	return nil
+}
+
+// finish removes the operation with messageID.
+func (o *operations) finish(messageID int64) {
+	o.mutex.Lock()
+	op, ok := o.ops[messageID]
+	delete(o.ops, messageID)
+	o.mutex.Unlock()
+	if ok {
+		op.cancel(nil)
+		close(op.done)
+	}
+}
+
+// abandon abandons the operation with messageID. Unknown operations are
+// ignored as required by RFC 4511 4.11.
+func (o *operations) abandon(messageID int64) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if op, ok := o.ops[messageID]; ok && op.cancelable && !op.responding {
+		op.cancel(errAbandoned)
+	}
+}
+
+// abandonPersistent abandons all persistent operations, which would never
+// complete otherwise.
+func (o *operations) abandonPersistent() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	for _, op := range o.ops {
+		if op.persistent && !op.responding {
+			op.cancel(errAbandoned)
+		}
+	}
+}
+
+// cancel cancels the operation with messageID and waits until it is done or
+// ctx is done. It returns the result code for the Cancel operation as defined
+// in RFC 3909.
+func (o *operations) cancel(ctx context.Context, messageID int64) LDAPResultCode {
+	o.mutex.Lock()
+	op, ok := o.ops[messageID]
+	switch {
+	case !ok:
+		o.mutex.Unlock()
+		return ldap.LDAPResultNoSuchOperation
+	case !op.cancelable:
+		o.mutex.Unlock()
+		return ldap.LDAPResultCannotCancel
+	case op.responding || op.ctx.Err() != nil:
+		o.mutex.Unlock()
+		return ldap.LDAPResultTooLate
+	}
+	op.cancel(errCanceled)
+	o.mutex.Unlock()
+
+	select {
+	case <-op.done:
+		return ldap.LDAPResultSuccess
+	case <-ctx.Done():
+		return ldap.LDAPResultCanceled
+	}
+}
+
+// outstanding returns the number of outstanding operations.
+func (o *operations) outstanding() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return len(o.ops)
+}
+
+// isCancelableRequest returns false for the operations which cannot be
+// abandoned or canceled (RFC 3909 2).
+func isCancelableRequest(req *ber.Packet) bool {
+	switch req.Tag {
+	case ldap.ApplicationBindRequest, ldap.ApplicationUnbindRequest, ldap.ApplicationAbandonRequest:
+		return false
+	case ldap.ApplicationExtendedRequest:
+		switch extendedRequestOID(req) {
+		case startTLSOID, cancelOID:
+			return false
+		}
+	}
+	return true
+}
+
+// isPersistentRequest returns true for the searches which deliver changes
+// until they are abandoned.
+func isPersistentRequest(req *ber.Packet, controls []ldap.Control) bool {
+	return req.Tag == ldap.ApplicationSearchRequest && IsPersistent(&ldap.SearchRequest{Controls: controls})
+}
+
+// extendedRequestOID returns the requestName of the passed extended request.
+func extendedRequestOID(req *ber.Packet) string {
+	if req.Tag != ldap.ApplicationExtendedRequest || len(req.Children) < 1 {
+		return ""
+	}
+	return req.Children[0].Data.String()
+}
+
+// parseAbandonRequest returns the message ID of the operation to abandon.
+func parseAbandonRequest(req *ber.Packet) (int64, error) {
+	// AbandonRequest ::= [APPLICATION 16] MessageID
+	return ber.ParseInt64(req.Data.Bytes())
+}
@@ -0,0 +1,240 @@
+package ldapserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// blockingSearcher blocks searches until their context is done.
+type blockingSearcher struct {
+	started chan struct{}
+	stopped chan error
+}
+
+func (s *blockingSearcher) Search(ctx context.Context, boundDN string, req *ldap.SearchRequest, conn net.Conn) (ServerSearchResult, error) {
+	s.started <- struct{}{}
+	<-ctx.Done()
+	s.stopped <- context.Cause(ctx)
+	return ServerSearchResult{ResultCode: ldap.LDAPResultCanceled}, context.Cause(ctx)
+}
+
+func writeTestRequest(t *testing.T, conn net.Conn, messageID int64, op *ber.Packet) {
+	t.Helper()
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Request")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+	packet.AppendChild(op)
+	if _, err := conn.Write(packet.Bytes()); err != nil {
+		t.Fatalf("Error sending request: %s", err)
+	}
+}
+
+func readTestResponse(t *testing.T, conn net.Conn) (int64, *ber.Packet) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	packet, err := ber.ReadPacket(conn)
+	if err != nil {
+		t.Fatalf("Error reading response: %s", err)
+	}
+	return packet.Children[0].Value.(int64), packet.Children[1]
+}
+
+func newTestSearchRequest() *ber.Packet {
+	return newTestSearchRequestWithBase("o=base")
+}
+
+func newTestSearchRequestWithBase(baseDN string) *ber.Packet {
+	req := &ldap.SearchRequest{
+		BaseDN: baseDN,
+		Scope:  ldap.ScopeWholeSubtree,
+		Filter: "(objectClass=*)",
+	}
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchRequest, nil, "Search Request")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, req.BaseDN, "Base DN"))
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(req.Scope), "Scope"))
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(req.DerefAliases), "Deref Aliases"))
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, uint64(req.SizeLimit), "Size Limit"))
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, uint64(req.TimeLimit), "Time Limit"))
+	packet.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, req.TypesOnly, "Types Only"))
+	filter, _ := ldap.CompileFilter(req.Filter)
+	packet.AppendChild(filter)
+	packet.AppendChild(ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes"))
+	return packet
+}
+
+func newTestCancelRequest(cancelID int64) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationExtendedRequest, nil, "Extended Request")
+	packet.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, cancelOID, "Extended Request Name"))
+	value := ber.NewSequence("cancelRequestValue")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, cancelID, "cancelID"))
+	extValue := ber.Encode(ber.ClassContext, ber.TypePrimitive, 1, nil, "Extended Request Value")
+	extValue.Data.Write(value.Bytes())
+	packet.AppendChild(extValue)
+	return packet
+}
+
+func TestAbandonSearch(t *testing.T) {
+	searcher := &blockingSearcher{started: make(chan struct{}, 1), stopped: make(chan error, 1)}
+	server := NewServer()
+	server.SearchFunc("", searcher)
+	conn := startTestRawConn(t, server)
+	defer conn.Close()
+
+	writeTestRequest(t, conn, 1, newTestSearchRequest())
+	<-searcher.started
+	writeTestRequest(t, conn, 2, ber.NewInteger(ber.ClassApplication, ber.TypePrimitive, ldap.ApplicationAbandonRequest, int64(1), "Abandon Request"))
+
+	select {
+	case cause := <-searcher.stopped:
+		if cause != errAbandoned {
+			t.Errorf("Expected search to be abandoned, got: %v", cause)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Search was not abandoned")
+	}
+
+	// No response is sent for the abandoned search, the next response must
+	// be the one for the following request.
+	writeTestRequest(t, conn, 3, newTestCancelRequest(1))
+	messageID, resp := readTestResponse(t, conn)
+	if messageID != 3 {
+		t.Fatalf("Expected response for message 3, got %d", messageID)
+	}
+	if code := resp.Children[0].Value.(int64); code != ldap.LDAPResultNoSuchOperation {
+		t.Errorf("Expected noSuchOperation for cancel of abandoned search, got %d", code)
+	}
+}
+
+func TestCancelSearch(t *testing.T) {
+	searcher := &blockingSearcher{started: make(chan struct{}, 1), stopped: make(chan error, 1)}
+	server := NewServer()
+	server.SearchFunc("", searcher)
+	conn := startTestRawConn(t, server)
+	defer conn.Close()
+
+	writeTestRequest(t, conn, 1, newTestSearchRequest())
+	<-searcher.started
+	writeTestRequest(t, conn, 2, newTestCancelRequest(1))
+
+	results := map[int64]int64{}
+	for i := 0; i < 2; i++ {
+		messageID, resp := readTestResponse(t, conn)
+		results[messageID] = resp.Children[0].Value.(int64)
+	}
+	if results[1] != ldap.LDAPResultCanceled {
+		t.Errorf("Expected canceled search, got %d", results[1])
+	}
+	if results[2] != ldap.LDAPResultSuccess {
+		t.Errorf("Expected successful cancel, got %d", results[2])
+	}
+	if cause := <-searcher.stopped; cause != errCanceled {
+		t.Errorf("Expected search to be canceled, got: %v", cause)
+	}
+}
+
+// gatedSearcher blocks searches for base "o=slow" until released.
+type gatedSearcher struct {
+	release chan struct{}
+}
+
+func (s *gatedSearcher) Search(ctx context.Context, boundDN string, req *ldap.SearchRequest, conn net.Conn) (ServerSearchResult, error) {
+	if req.BaseDN == "o=slow" {
+		select {
+		case <-s.release:
+		case <-ctx.Done():
+		}
+	}
+	return ServerSearchResult{ResultCode: ldap.LDAPResultSuccess}, nil
+}
+
+func newTestBindRequest(dn, password string) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationBindRequest, nil, "Bind Request")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 3, "Version"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "User Name"))
+	packet.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, password, "Password"))
+	return packet
+}
+
+func TestConcurrentOperations(t *testing.T) {
+	searcher := &gatedSearcher{release: make(chan struct{})}
+	server := NewServer()
+	server.BindFunc("", testBinder{})
+	server.SearchFunc("", searcher)
+	conn := startTestRawConn(t, server)
+	defer conn.Close()
+
+	// A fast search is answered while a slow search is outstanding.
+	writeTestRequest(t, conn, 1, newTestSearchRequestWithBase("o=slow"))
+	writeTestRequest(t, conn, 2, newTestSearchRequestWithBase("o=fast"))
+	if messageID, _ := readTestResponse(t, conn); messageID != 2 {
+		t.Fatalf("Expected response for fast search first, got %d", messageID)
+	}
+
+	// Bind waits for the outstanding slow search.
+	writeTestRequest(t, conn, 3, newTestBindRequest("cn=test,o=base", "secret"))
+	time.Sleep(50 * time.Millisecond)
+	close(searcher.release)
+	if messageID, _ := readTestResponse(t, conn); messageID != 1 {
+		t.Fatalf("Expected response for slow search before bind, got %d", messageID)
+	}
+	messageID, resp := readTestResponse(t, conn)
+	if messageID != 3 || resp.Children[0].Value.(int64) != ldap.LDAPResultSuccess {
+		t.Fatalf("Expected successful bind response, got %d", messageID)
+	}
+}
+
+func TestConcurrentOperationsLimit(t *testing.T) {
+	searcher := &gatedSearcher{release: make(chan struct{})}
+	server := NewServer()
+	server.MaxConcurrentOperations = 1
+	server.SearchFunc("", searcher)
+	conn := startTestRawConn(t, server)
+	defer conn.Close()
+
+	writeTestRequest(t, conn, 1, newTestSearchRequestWithBase("o=slow"))
+	writeTestRequest(t, conn, 2, newTestSearchRequestWithBase("o=fast"))
+	time.Sleep(50 * time.Millisecond)
+	close(searcher.release)
+	if messageID, _ := readTestResponse(t, conn); messageID != 1 {
+		t.Fatalf("Expected response for slow search first with limit 1, got %d", messageID)
+	}
+	if messageID, _ := readTestResponse(t, conn); messageID != 2 {
+		t.Fatalf("Expected response for fast search second, got %d", messageID)
+	}
+}
+
+func TestConcurrentOperationsBusy(t *testing.T) {
+	searcher := &gatedSearcher{release: make(chan struct{})}
+	server := NewServer()
+	server.MaxConcurrentOperations = 1
+	server.SearchFunc("", searcher)
+	conn := startTestRawConn(t, server)
+	defer conn.Close()
+
+	// Requests which do not fit into the queue are answered with busy
+	// instead of blocking the connection.
+	const count = maxQueuedRequests + 8
+	go func() {
+		for messageID := int64(1); messageID <= count; messageID++ {
+			writeTestRequest(t, conn, messageID, newTestSearchRequestWithBase("o=slow"))
+		}
+	}()
+	messageID, resp := readTestResponse(t, conn)
+	if code := resp.Children[0].Value.(int64); code != ldap.LDAPResultBusy || messageID == 1 {
+		t.Fatalf("Expected busy response for a later request, got %d for %d", code, messageID)
+	}
+
+	close(searcher.release)
+	seen := map[int64]bool{messageID: true}
+	for len(seen) < count {
+		messageID, _ := readTestResponse(t, conn)
+		if seen[messageID] {
+			t.Fatalf("Duplicate response for %d", messageID)
+		}
+		seen[messageID] = true
+	}
+}
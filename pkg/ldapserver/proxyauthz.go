@@ -0,0 +1,163 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/libregraph/idm/pkg/ldapdn"
+)
+
+// ControlTypeProxiedAuthorization is the proxied authorization v2 control
+// (RFC 4370).
+const ControlTypeProxiedAuthorization = "2.16.840.1.113730.3.4.18"
+
+// ProxyAuthorizationRule decides whether the identity boundDN may act as the
+// identity authzDN with the proxied authorization control. authzDN is empty
+// for the anonymous identity.
+type ProxyAuthorizationRule func(boundDN, authzDN string, conn net.Conn) bool
+
+// ControlProxiedAuthorization is the proxied authorization v2 control
+// (RFC 4370). AuthzID is an authzId (RFC 4513 5.2.1.8), or empty for the
+// anonymous identity.
+type ControlProxiedAuthorization struct {
+	Criticality bool
+	AuthzID     string
+}
+
+// GetControlType returns the OID of the control.
+func (c *ControlProxiedAuthorization) GetControlType() string {
+	return ControlTypeProxiedAuthorization
+}
+
+// Encode returns the ber packet representation of the control. The value of
+// the control is the authzId itself.
+func (c *ControlProxiedAuthorization) Encode() *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Control")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, c.GetControlType(), "Control Type"))
+	if c.Criticality {
+		packet.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, c.Criticality, "Criticality"))
+	}
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, c.AuthzID, "Control Value"))
+	return packet
+}
+
+// String returns a human-readable description of the control.
+func (c *ControlProxiedAuthorization) String() string {
+	return fmt.Sprintf("Control Type: %s (%q)  Criticality: %t  AuthzID: %q", "Proxied Authorization", c.GetControlType(), c.Criticality, c.AuthzID)
+}
+
+// decodeControlProxiedAuthorization decodes the control regardless of its
+// criticality. A non-critical control is rejected when the request is
+// processed rather than ignored, so that the request does not run with the
+// identity of the proxy.
+func decodeControlProxiedAuthorization(criticality bool, value []byte) (ldap.Control, error) {
+	return &ControlProxiedAuthorization{Criticality: criticality, AuthzID: string(value)}, nil
+}
+
+type authenticatedDNContextKey struct{}
+
+// AuthenticatedDN returns the DN which the connection of ctx is bound as.
+// It differs from the boundDN passed to the handlers if the operation uses
+// proxied authorization, otherwise it is boundDN.
+func AuthenticatedDN(ctx context.Context, boundDN string) string {
+	if dn, ok := ctx.Value(authenticatedDNContextKey{}).(string); ok {
+		return dn
+	}
+	return boundDN
+}
+
+// ProxyGroupRule returns a proxy authorization rule which allows the members
+// of the group groupDN to act as the identities below baseDN, or as any
+// identity if baseDN is empty. They may never act as one of deniedDNs, like
+// the admin, or as another member of the group, as that would give them the
+// rights of these identities. The membership is looked up with the
+// registered IdentityMapper handlers.
+func (server *Server) ProxyGroupRule(groupDN, baseDN string, deniedDNs ...string) ProxyAuthorizationRule {
+	nBaseDN, err := ldapdn.ParseNormalize(baseDN)
+	if err != nil {
+		return func(boundDN, authzDN string, conn net.Conn) bool {
+			return false
+		}
+	}
+	denied := make(map[string]bool, len(deniedDNs))
+	for _, dn := range deniedDNs {
+		if nDN, err := ldapdn.ParseNormalize(dn); err == nil && nDN != "" {
+			denied[nDN] = true
+		}
+	}
+	isMember := func(dn string, conn net.Conn) bool {
+		member := ldap.EscapeFilter(dn)
+		_, err := server.mapIdentity(&ldap.SearchRequest{
+			BaseDN: groupDN,
+			Scope:  ldap.ScopeBaseObject,
+			Filter: "(|(member=" + member + ")(uniqueMember=" + member + "))",
+		}, conn)
+		return err == nil
+	}
+	return func(boundDN, authzDN string, conn net.Conn) bool {
+		if boundDN == "" || !isMember(boundDN, conn) {
+			return false
+		}
+		switch {
+		case authzDN == "" || authzDN == boundDN:
+			return true
+		case denied[authzDN]:
+			return false
+		case nBaseDN != "" && authzDN != nBaseDN && !strings.HasSuffix(authzDN, ","+nBaseDN):
+			return false
+		}
+		return !isMember(authzDN, conn)
+	}
+}
+
+// proxiedAuthorization returns the identity as which an operation of the
+// identity boundDN is processed. If the request has a proxied authorization
+// control, it is the asserted identity and ctx is extended to carry boundDN
+// as the authenticated identity.
+func (server *Server) proxiedAuthorization(ctx context.Context, controls []ldap.Control, boundDN string, conn net.Conn) (context.Context, string, error) {
+	var proxy *ControlProxiedAuthorization
+	for _, control := range controls {
+		if c, ok := control.(*ControlProxiedAuthorization); ok {
+			if proxy != nil {
+				return nil, "", ldap.NewError(ldap.LDAPResultProtocolError, errors.New("duplicate proxied authorization control"))
+			}
+			proxy = c
+		}
+	}
+	switch {
+	case proxy == nil:
+		return ctx, boundDN, nil
+	case !proxy.Criticality:
+		return nil, "", ldap.NewError(ldap.LDAPResultProtocolError, errors.New("proxied authorization control must be critical"))
+	case server.ProxyAuthorization == nil:
+		return nil, "", ldap.NewError(ldap.LDAPResultUnavailableCriticalExtension, fmt.Errorf("control %s not supported", ControlTypeProxiedAuthorization))
+	case boundDN == "":
+		return nil, "", ldap.NewError(ldap.LDAPResultAuthorizationDenied, errors.New("anonymous proxied authorization denied"))
+	}
+
+	authzDN := ""
+	if proxy.AuthzID != "" {
+		if !strings.HasPrefix(proxy.AuthzID, "dn:") && !strings.HasPrefix(proxy.AuthzID, "u:") {
+			return nil, "", ldap.NewError(ldap.LDAPResultAuthorizationDenied, fmt.Errorf("invalid authorization identity '%s'", proxy.AuthzID))
+		}
+		dn, err := server.mapAuthenticationID(proxy.AuthzID, conn)
+		if err == nil {
+			authzDN, err = ldapdn.ParseNormalize(dn)
+		}
+		if err != nil {
+			return nil, "", ldap.NewError(ldap.LDAPResultAuthorizationDenied, fmt.Errorf("authorization identity '%s' not found", proxy.AuthzID))
+		}
+	}
+	if !server.ProxyAuthorization(boundDN, authzDN, conn) {
+		logger.V(1).Info("Proxied authorization denied", "bind_dn", boundDN, "authz_dn", authzDN)
+		return nil, "", ldap.NewError(ldap.LDAPResultAuthorizationDenied, fmt.Errorf("'%s' may not act as '%s'", boundDN, proxy.AuthzID))
+	}
+	logger.V(1).Info("Proxied authorization", "bind_dn", boundDN, "authz_dn", authzDN)
+	return context.WithValue(ctx, authenticatedDNContextKey{}, boundDN), authzDN, nil
+}
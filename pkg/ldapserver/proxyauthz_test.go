@@ -0,0 +1,132 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// proxyTestMapper maps uid=alice and the entries below o=base by DN. The
+// group cn=proxies,o=base has the members cn=test,o=base and
+// cn=proxy2,o=base.
+type proxyTestMapper struct{}
+
+func (m proxyTestMapper) MapIdentity(req *ldap.SearchRequest, conn net.Conn) (string, error) {
+	switch {
+	case strings.Contains(req.Filter, "(member="):
+		if req.BaseDN == "cn=proxies,o=base" && (strings.Contains(req.Filter, "(member=cn=test,o=base)") || strings.Contains(req.Filter, "(member=cn=proxy2,o=base)")) {
+			return req.BaseDN, nil
+		}
+	case req.Filter == "(uid=alice)":
+		return "uid=alice,o=base", nil
+	case req.Scope == ldap.ScopeBaseObject && strings.HasSuffix(req.BaseDN, ",o=base"):
+		return req.BaseDN, nil
+	}
+	return "", errors.New("not found")
+}
+
+// identitySearcher records the identities of the last search operation.
+type identitySearcher struct {
+	boundDN         string
+	authenticatedDN string
+}
+
+func (s *identitySearcher) Search(ctx context.Context, boundDN string, req *ldap.SearchRequest, conn net.Conn) (ServerSearchResult, error) {
+	s.boundDN, s.authenticatedDN = boundDN, AuthenticatedDN(ctx, boundDN)
+	return ServerSearchResult{ResultCode: ldap.LDAPResultSuccess}, nil
+}
+
+// identityAdder records the identity of the last add operation and, like the
+// boltdb handler, only allows the admin cn=admin,o=base to write.
+type identityAdder struct {
+	boundDN string
+}
+
+func (a *identityAdder) Add(ctx context.Context, boundDN string, req *ldap.AddRequest, conn net.Conn) (LDAPResultCode, error) {
+	a.boundDN = boundDN
+	if boundDN != "cn=admin,o=base" {
+		return ldap.LDAPResultInsufficientAccessRights, nil
+	}
+	return ldap.LDAPResultSuccess, nil
+}
+
+func TestProxiedAuthorization(t *testing.T) {
+	searcher := &identitySearcher{}
+	server := NewServer()
+	server.BindFunc("", testBinder{})
+	server.SearchFunc("", searcher)
+	adder := &identityAdder{}
+	server.AddFunc("", adder)
+	server.IdentityMapperFunc("", proxyTestMapper{})
+	l := startTestConn(t, server)
+	defer l.Close()
+	if err := l.Bind("cn=test,o=base", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(controls ...ldap.Control) error {
+		searcher.boundDN, searcher.authenticatedDN = "-", "-"
+		_, err := l.Search(ldap.NewSearchRequest("o=base", ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", nil, controls))
+		return err
+	}
+
+	proxy := &ControlProxiedAuthorization{Criticality: true, AuthzID: "dn:uid=alice,o=base"}
+	if err := search(proxy); !ldap.IsErrorWithCode(err, ldap.LDAPResultUnavailableCriticalExtension) {
+		t.Errorf("Proxied authorization without rule returned %v", err)
+	}
+
+	server.ProxyAuthorization = server.ProxyGroupRule("cn=proxies,o=base", "o=base", "cn=admin,o=base")
+	if err := search(); err != nil || searcher.boundDN != "cn=test,o=base" || searcher.authenticatedDN != "cn=test,o=base" {
+		t.Errorf("Search without control ran as %q (%q): %v", searcher.boundDN, searcher.authenticatedDN, err)
+	}
+	for _, authzID := range []string{"dn:uid=alice,o=base", "u:alice"} {
+		if err := search(&ControlProxiedAuthorization{Criticality: true, AuthzID: authzID}); err != nil {
+			t.Errorf("Proxied authorization as %s failed: %v", authzID, err)
+		}
+		if searcher.boundDN != "uid=alice,o=base" || searcher.authenticatedDN != "cn=test,o=base" {
+			t.Errorf("Proxied authorization as %s ran as %q (%q)", authzID, searcher.boundDN, searcher.authenticatedDN)
+		}
+	}
+	if err := search(&ControlProxiedAuthorization{Criticality: true}); err != nil || searcher.boundDN != "" {
+		t.Errorf("Proxied anonymous authorization ran as %q: %v", searcher.boundDN, err)
+	}
+
+	for _, test := range []struct {
+		control *ControlProxiedAuthorization
+		code    uint16
+	}{
+		{&ControlProxiedAuthorization{AuthzID: "dn:uid=alice,o=base"}, ldap.LDAPResultProtocolError},
+		{&ControlProxiedAuthorization{Criticality: true, AuthzID: "dn:uid=alice,o=other"}, ldap.LDAPResultAuthorizationDenied},
+		{&ControlProxiedAuthorization{Criticality: true, AuthzID: "alice"}, ldap.LDAPResultAuthorizationDenied},
+		{&ControlProxiedAuthorization{Criticality: true, AuthzID: "dn:cn=admin,o=base"}, ldap.LDAPResultAuthorizationDenied},
+		{&ControlProxiedAuthorization{Criticality: true, AuthzID: "dn:CN=Admin, O=Base"}, ldap.LDAPResultAuthorizationDenied},
+		{&ControlProxiedAuthorization{Criticality: true, AuthzID: "dn:cn=proxy2,o=base"}, ldap.LDAPResultAuthorizationDenied},
+	} {
+		if err := search(test.control); !ldap.IsErrorWithCode(err, test.code) || searcher.boundDN != "-" {
+			t.Errorf("Proxied authorization with %s returned %v, expected %d", test.control, err, test.code)
+		}
+	}
+
+	// Proxy group members can not write as the admin.
+	add := ldap.NewAddRequest("cn=new,o=base", []ldap.Control{&ControlProxiedAuthorization{Criticality: true, AuthzID: "dn:cn=admin,o=base"}})
+	add.Attribute("objectClass", []string{"top"})
+	if err := l.Add(add); !ldap.IsErrorWithCode(err, ldap.LDAPResultAuthorizationDenied) || adder.boundDN != "" {
+		t.Errorf("Proxied add as admin ran as %q: %v", adder.boundDN, err)
+	}
+
+	// Targets outside of the base are denied.
+	server.ProxyAuthorization = server.ProxyGroupRule("cn=proxies,o=base", "ou=people,o=base")
+	if err := search(proxy); !ldap.IsErrorWithCode(err, ldap.LDAPResultAuthorizationDenied) {
+		t.Errorf("Proxied authorization outside of base returned %v", err)
+	}
+
+	// Identities which are not members of the proxy group are denied.
+	server.ProxyAuthorization = server.ProxyGroupRule("cn=other,o=base", "")
+	if err := search(proxy); !ldap.IsErrorWithCode(err, ldap.LDAPResultAuthorizationDenied) {
+		t.Errorf("Proxied authorization of non-member returned %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/libregraph/idm/pkg/ldapdn"
+)
+
+// Persistent search (draft-ietf-ldapext-psearch-03) control types.
+const (
+	ControlTypePersistentSearch        = "2.16.840.1.113730.3.4.3"
+	ControlTypeEntryChangeNotification = "2.16.840.1.113730.3.4.7"
+)
+
+// The change types of persistent searches.
+const (
+	PersistentSearchChangeTypeAdd    = 1
+	PersistentSearchChangeTypeDelete = 2
+	PersistentSearchChangeTypeModify = 4
+	PersistentSearchChangeTypeModDN  = 8
+)
+
+// ControlPersistentSearch is the persistent search request control.
+type ControlPersistentSearch struct {
+	Criticality bool
+	ChangeTypes int64
+	ChangesOnly bool
+	ReturnECs   bool
+}
+
+// GetControlType returns the OID of the control.
+func (c *ControlPersistentSearch) GetControlType() string {
+	return ControlTypePersistentSearch
+}
+
+// Encode returns the ber packet representation of the control.
+func (c *ControlPersistentSearch) Encode() *ber.Packet {
+	seq := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "PersistentSearch")
+	seq.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, c.ChangeTypes, "changeTypes"))
+	seq.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, c.ChangesOnly, "changesOnly"))
+	seq.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, c.ReturnECs, "returnECs"))
+	return encodeControl(c.GetControlType(), c.Criticality, seq)
+}
+
+// String returns a human-readable description of the control.
+func (c *ControlPersistentSearch) String() string {
+	return fmt.Sprintf("Control Type: %s (%q)  Criticality: %t  ChangeTypes: %d  ChangesOnly: %t  ReturnECs: %t", ldap.ControlTypeMap[c.GetControlType()], c.GetControlType(), c.Criticality, c.ChangeTypes, c.ChangesOnly, c.ReturnECs)
+}
+
+// ControlEntryChangeNotification is the entry change notification control,
+// which is returned with the changed entries of persistent searches.
+// PreviousDN is only set for the PersistentSearchChangeTypeModDN change type.
+type ControlEntryChangeNotification struct {
+	ChangeType int64
+	PreviousDN string
+}
+
+// GetControlType returns the OID of the control.
+func (c *ControlEntryChangeNotification) GetControlType() string {
+	return ControlTypeEntryChangeNotification
+}
+
+// Encode returns the ber packet representation of the control.
+func (c *ControlEntryChangeNotification) Encode() *ber.Packet {
+	seq := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "EntryChangeNotification")
+	seq.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, c.ChangeType, "changeType"))
+	if c.ChangeType == PersistentSearchChangeTypeModDN {
+		seq.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, c.PreviousDN, "previousDN"))
+	}
+	return encodeControl(c.GetControlType(), false, seq)
+}
+
+// String returns a human-readable description of the control.
+func (c *ControlEntryChangeNotification) String() string {
+	return fmt.Sprintf("Control Type: %s (%q)  ChangeType: %d  PreviousDN: %s", ldap.ControlTypeMap[c.GetControlType()], c.GetControlType(), c.ChangeType, c.PreviousDN)
+}
+
+func decodeControlPersistentSearch(criticality bool, value *ber.Packet) (ldap.Control, error) {
+	if value == nil || len(value.Children) != 3 {
+		return nil, errors.New("invalid persistent search control")
+	}
+	changeTypes, ok := value.Children[0].Value.(int64)
+	if !ok || changeTypes <= 0 || changeTypes > PersistentSearchChangeTypeAdd|PersistentSearchChangeTypeDelete|PersistentSearchChangeTypeModify|PersistentSearchChangeTypeModDN {
+		return nil, errors.New("invalid persistent search change types")
+	}
+	changesOnly, ok := value.Children[1].Value.(bool)
+	if !ok {
+		return nil, errors.New("invalid persistent search control")
+	}
+	returnECs, ok := value.Children[2].Value.(bool)
+	if !ok {
+		return nil, errors.New("invalid persistent search control")
+	}
+	return &ControlPersistentSearch{
+		Criticality: criticality,
+		ChangeTypes: changeTypes,
+		ChangesOnly: changesOnly,
+		ReturnECs:   returnECs,
+	}, nil
+}
+
+// handlePersistentSearch processes a search with the persistent search
+// control. Unless only changes are requested, the entries matching searchReq
+// are sent first. Then the changed entries are sent until ctx is done, which
+// is when the search is abandoned or the connection is closed.
+func handlePersistentSearch(ctx context.Context, syncer Syncer, searchReq *ldap.SearchRequest, control *ControlPersistentSearch, messageID int64, boundDN string, server *Server, conn net.Conn) error {
+	filterPacket, err := ldap.CompileFilter(searchReq.Filter)
+	if err != nil {
+		return ldap.NewError(ldap.LDAPResultOperationsError, err)
+	}
+
+	syncResp, err := syncer.Sync(ctx, boundDN, searchReq, conn)
+	if err != nil {
+		return ldap.NewError(uint16(syncResp.ResultCode), err)
+	}
+	if syncResp.Changes == nil {
+		return ldap.NewError(ldap.LDAPResultUnwillingToPerform, errors.New("persistent search not supported"))
+	}
+
+	s := server.Schema()
+	if !control.ChangesOnly {
+		for _, entry := range syncResp.Entries {
+			if ctx.Err() != nil {
+				return ldap.NewError(uint16(ContextResultCode(ctx)), context.Cause(ctx))
+			}
+			if !syncMatch(s, filterPacket, searchReq, entry) {
+				continue
+			}
+			if err = sendEntryWithControl(conn, messageID, s, searchReq, entry, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ldap.NewError(uint16(ContextResultCode(ctx)), context.Cause(ctx))
+		case change, ok := <-syncResp.Changes:
+			if !ok {
+				if ctx.Err() != nil {
+					return ldap.NewError(uint16(ContextResultCode(ctx)), context.Cause(ctx))
+				}
+				return ldap.NewError(ldap.LDAPResultAdminLimitExceeded, errors.New("too many changes"))
+			}
+			if syncResp.CSN != "" && change.CSN <= syncResp.CSN {
+				// The change is included in the initial entries.
+				continue
+			}
+			if err = sendPersistentSearchChange(conn, messageID, server, filterPacket, searchReq, control, change); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendPersistentSearchChange sends the entry of change, if its change type
+// was requested and the entry matches searchReq. Deleted entries are matched
+// before, all others after the change. Entries which no longer match after
+// a change are not sent.
+func sendPersistentSearchChange(conn net.Conn, messageID int64, server *Server, filterPacket *ber.Packet, searchReq *ldap.SearchRequest, control *ControlPersistentSearch, change SyncChange) error {
+	ecn := &ControlEntryChangeNotification{}
+	entry := change.NewEntry
+	switch {
+	case change.NewEntry == nil:
+		ecn.ChangeType = PersistentSearchChangeTypeDelete
+		entry = change.OldEntry
+	case change.OldEntry == nil:
+		ecn.ChangeType = PersistentSearchChangeTypeAdd
+	case !sameDN(change.OldEntry.DN, change.NewEntry.DN):
+		ecn.ChangeType = PersistentSearchChangeTypeModDN
+		ecn.PreviousDN = change.OldEntry.DN
+	default:
+		ecn.ChangeType = PersistentSearchChangeTypeModify
+	}
+	if control.ChangeTypes&ecn.ChangeType == 0 || entry == nil {
+		return nil
+	}
+	s := server.Schema()
+	if !syncMatch(s, filterPacket, searchReq, entry) {
+		return nil
+	}
+	var responseControl ldap.Control
+	if control.ReturnECs {
+		responseControl = ecn
+	}
+	return sendEntryWithControl(conn, messageID, s, searchReq, entry, responseControl)
+}
+
+// sameDN returns true if the DNs a and b are equal after normalization.
+func sameDN(a, b string) bool {
+	na, err := ldapdn.ParseNormalize(a)
+	if err != nil {
+		return a == b
+	}
+	nb, err := ldapdn.ParseNormalize(b)
+	if err != nil {
+		return a == b
+	}
+	return na == nb
+}
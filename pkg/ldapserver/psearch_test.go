@@ -0,0 +1,174 @@
+package ldapserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// entryChangeNotification returns the change type and previous DN of the
+// entry change notification control in controls.
+func entryChangeNotification(t *testing.T, controls []ldap.Control) (int64, string) {
+	t.Helper()
+	c, ok := ldap.FindControl(controls, ControlTypeEntryChangeNotification).(*ldap.ControlString)
+	if !ok {
+		t.Fatalf("Missing entry change notification control in %v", controls)
+	}
+	value, err := ber.DecodePacketErr([]byte(c.ControlValue))
+	if err != nil || len(value.Children) == 0 {
+		t.Fatalf("Invalid entry change notification control: %v", err)
+	}
+	previousDN := ""
+	if len(value.Children) > 1 {
+		previousDN = value.Children[1].Value.(string)
+	}
+	return value.Children[0].Value.(int64), previousDN
+}
+
+func TestPersistentSearch(t *testing.T) {
+	syncer := newTestSyncer()
+	server := NewServer()
+	server.SyncFunc("", syncer)
+	l := startTestConn(t, server)
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	control := &ControlPersistentSearch{
+		ChangeTypes: PersistentSearchChangeTypeAdd | PersistentSearchChangeTypeDelete | PersistentSearchChangeTypeModDN,
+		ReturnECs:   true,
+	}
+	req := ldap.NewSearchRequest("o=base", ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, "(uid=*)", []string{"uid"}, []ldap.Control{control})
+	r := l.SearchAsync(ctx, req, 0)
+
+	// The initial entries are sent without entry change notifications.
+	for _, dn := range []string{"uid=a,o=base", "uid=b,o=base"} {
+		if !r.Next() || r.Entry() == nil || r.Entry().DN != dn {
+			t.Fatalf("Persistent search did not return %s: %v", dn, r.Err())
+		}
+		if len(r.Controls()) != 0 {
+			t.Errorf("Persistent search returned %s with controls %v", dn, r.Controls())
+		}
+	}
+
+	a := newSyncTestEntry("a", "b1f8c2a4-7d4e-4b8a-9c1e-0f2d3a4b5c6d", testSyncCSN3)
+	renamed := newSyncTestEntry("d", "b1f8c2a4-7d4e-4b8a-9c1e-0f2d3a4b5c6d", testSyncCSN3)
+	c := newSyncTestEntry("c", "d3b0e4c6-9f6a-4d0c-9e3a-2b4f5c6d7e8f", testSyncCSN3)
+	outside := ldap.NewEntry("uid=e,o=other", map[string][]string{"uid": {"e"}})
+	syncer.changes.Publish(SyncChange{OldEntry: syncer.entries[0], NewEntry: a, CSN: testSyncCSN3})
+	syncer.changes.Publish(SyncChange{NewEntry: outside, CSN: testSyncCSN3})
+	syncer.changes.Publish(SyncChange{NewEntry: c, CSN: testSyncCSN3})
+	syncer.changes.Publish(SyncChange{OldEntry: a, NewEntry: renamed, CSN: testSyncCSN3})
+	syncer.changes.Publish(SyncChange{OldEntry: syncer.entries[1], CSN: testSyncCSN3})
+
+	// The modification is not requested and the entry outside of the base is
+	// not sent.
+	for _, expected := range []struct {
+		dn         string
+		changeType int64
+		previousDN string
+	}{
+		{"uid=c,o=base", PersistentSearchChangeTypeAdd, ""},
+		{"uid=d,o=base", PersistentSearchChangeTypeModDN, "uid=a,o=base"},
+		{"uid=b,o=base", PersistentSearchChangeTypeDelete, ""},
+	} {
+		if !r.Next() || r.Entry() == nil {
+			t.Fatalf("Persistent search ended before %s: %v", expected.dn, r.Err())
+		}
+		if r.Entry().DN != expected.dn {
+			t.Errorf("Persistent search returned %s, expected %s", r.Entry().DN, expected.dn)
+			continue
+		}
+		changeType, previousDN := entryChangeNotification(t, r.Controls())
+		if changeType != expected.changeType || previousDN != expected.previousDN {
+			t.Errorf("Persistent search returned %s with change type %d and previous DN %q", expected.dn, changeType, previousDN)
+		}
+	}
+}
+
+// notifySyncer closes synced after the first Sync.
+type notifySyncer struct {
+	testSyncer
+	synced chan struct{}
+}
+
+func (s notifySyncer) Sync(ctx context.Context, boundDN string, req *ldap.SearchRequest, conn net.Conn) (ServerSyncResult, error) {
+	defer close(s.synced)
+	return s.testSyncer.Sync(ctx, boundDN, req, conn)
+}
+
+func TestPersistentSearchChangesOnly(t *testing.T) {
+	syncer := newTestSyncer()
+	synced := make(chan struct{})
+	server := NewServer()
+	server.SyncFunc("", notifySyncer{syncer, synced})
+	l := startTestConn(t, server)
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	control := &ControlPersistentSearch{ChangeTypes: PersistentSearchChangeTypeModify, ChangesOnly: true}
+	req := ldap.NewSearchRequest("uid=a,o=base", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", []string{"entryCSN"}, []ldap.Control{control})
+	r := l.SearchAsync(ctx, req, 0)
+
+	// Wait for the subscription before publishing the change.
+	select {
+	case <-synced:
+	case <-ctx.Done():
+		t.Fatal("Persistent search did not sync")
+	}
+	a := newSyncTestEntry("a", "b1f8c2a4-7d4e-4b8a-9c1e-0f2d3a4b5c6d", testSyncCSN3)
+	syncer.changes.Publish(SyncChange{OldEntry: syncer.entries[0], NewEntry: a, CSN: testSyncCSN3})
+
+	if !r.Next() || r.Entry() == nil || r.Entry().DN != "uid=a,o=base" {
+		t.Fatalf("Persistent search did not return the modified entry: %v", r.Err())
+	}
+	if got := r.Entry().GetAttributeValue("entryCSN"); got != testSyncCSN3 {
+		t.Errorf("Persistent search returned entryCSN %s, expected %s", got, testSyncCSN3)
+	}
+	if len(r.Controls()) != 0 {
+		t.Errorf("Persistent search returned controls %v without returnECs", r.Controls())
+	}
+}
+
+func TestPersistentSearchBind(t *testing.T) {
+	syncer := newTestSyncer()
+	synced := make(chan struct{})
+	server := NewServer()
+	server.BindFunc("", testBinder{})
+	server.SyncFunc("", notifySyncer{syncer, synced})
+	l := startTestConn(t, server)
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	control := &ControlPersistentSearch{ChangeTypes: PersistentSearchChangeTypeAdd, ChangesOnly: true}
+	req := ldap.NewSearchRequest("o=base", ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, "(uid=*)", nil, []ldap.Control{control})
+	l.SearchAsync(ctx, req, 0)
+	select {
+	case <-synced:
+	case <-ctx.Done():
+		t.Fatal("Persistent search did not sync")
+	}
+
+	// The bind abandons the persistent search instead of waiting for it.
+	bound := make(chan error, 1)
+	go func() {
+		bound <- l.Bind("cn=test,o=base", "secret")
+	}()
+	select {
+	case err := <-bound:
+		if err != nil {
+			t.Fatalf("Bind after persistent search failed: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("Bind after persistent search did not complete")
+	}
+	if _, err := l.Search(ldap.NewSearchRequest("o=base", ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, "(uid=*)", nil, nil)); err != nil {
+		t.Errorf("Search after bind failed: %v", err)
+	}
+}
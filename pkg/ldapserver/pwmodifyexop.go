@@ -1,6 +1,7 @@
 package ldapserver
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -23,7 +24,7 @@ func init() {
 	RegisterExtendedOperation(pwmodOID, HandlePasswordModifyExOp)
 }
 
-func HandlePasswordModifyExOp(req *ber.Packet, boundDN string, server *Server, conn net.Conn) (*ber.Packet, error) {
+func HandlePasswordModifyExOp(ctx context.Context, req *ber.Packet, boundDN string, server *Server, conn net.Conn) (*ber.Packet, error) {
 	var passwordGenerated bool
 	logger.V(1).Info("HandlePasswordModifyExOp")
 	if boundDN == "" {
@@ -70,7 +71,7 @@ func HandlePasswordModifyExOp(req *ber.Packet, boundDN string, server *Server, c
 		}
 		return nil, ldap.NewError(ldap.LDAPResultUnwillingToPerform, err)
 	}
-	code, err := pwUpdatefn.ModifyPasswordExop(boundDN, pwReq, conn)
+	code, err := pwUpdatefn.ModifyPasswordExop(ctx, boundDN, pwReq, conn)
 	if code != ldap.LDAPResultSuccess {
 		return nil, ldap.NewError(uint16(code), err)
 	}
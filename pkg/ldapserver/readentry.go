@@ -0,0 +1,119 @@
+package ldapserver
+
+import (
+	"errors"
+	"fmt"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/libregraph/idm/pkg/schema"
+)
+
+// Read entry controls (RFC 4527) control types.
+const (
+	ControlTypePreRead  = "1.3.6.1.1.13.1"
+	ControlTypePostRead = "1.3.6.1.1.13.2"
+)
+
+// ControlReadEntry is the pre-read or post-read request control, which
+// requests Attributes of the target entry of a write operation before or
+// after it was changed.
+type ControlReadEntry struct {
+	ControlType string
+	Criticality bool
+	Attributes  []string
+}
+
+// GetControlType returns the OID of the control.
+func (c *ControlReadEntry) GetControlType() string {
+	return c.ControlType
+}
+
+// Encode returns the ber packet representation of the control.
+func (c *ControlReadEntry) Encode() *ber.Packet {
+	seq := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "AttributeSelection")
+	for _, attribute := range c.Attributes {
+		seq.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attribute, "Attribute"))
+	}
+	return encodeControl(c.GetControlType(), c.Criticality, seq)
+}
+
+// String returns a human-readable description of the control.
+func (c *ControlReadEntry) String() string {
+	return fmt.Sprintf("Control Type: %s (%q)  Criticality: %t  Attributes: %v", readEntryControlName(c.ControlType), c.GetControlType(), c.Criticality, c.Attributes)
+}
+
+// ControlReadEntryResponse is the pre-read or post-read response control
+// with the requested attributes of the target entry.
+type ControlReadEntryResponse struct {
+	ControlType string
+	Entry       *ldap.Entry
+}
+
+// GetControlType returns the OID of the control.
+func (c *ControlReadEntryResponse) GetControlType() string {
+	return c.ControlType
+}
+
+// Encode returns the ber packet representation of the control.
+func (c *ControlReadEntryResponse) Encode() *ber.Packet {
+	return encodeControl(c.GetControlType(), false, encodeSearchResultEntry(c.Entry, false))
+}
+
+// String returns a human-readable description of the control.
+func (c *ControlReadEntryResponse) String() string {
+	return fmt.Sprintf("Control Type: %s (%q)  Entry: %s", readEntryControlName(c.ControlType), c.GetControlType(), c.Entry.DN)
+}
+
+func readEntryControlName(controlType string) string {
+	if controlType == ControlTypePreRead {
+		return "Pre-Read"
+	}
+	return "Post-Read"
+}
+
+// decodeControlReadEntry returns the decoder of the read entry control with
+// controlType.
+func decodeControlReadEntry(controlType string) func(criticality bool, value *ber.Packet) (ldap.Control, error) {
+	return func(criticality bool, value *ber.Packet) (ldap.Control, error) {
+		if value == nil {
+			return nil, errors.New("invalid read entry control")
+		}
+		c := &ControlReadEntry{ControlType: controlType, Criticality: criticality}
+		for _, child := range value.Children {
+			attribute, ok := child.Value.(string)
+			if !ok {
+				return nil, errors.New("invalid read entry control attribute")
+			}
+			c.Attributes = append(c.Attributes, attribute)
+		}
+		return c, nil
+	}
+}
+
+// ReadEntryControls returns the response controls for the pre-read and
+// post-read controls in controls with the requested attributes of pre, the
+// target entry before the operation, and post, the entry after it. No
+// response control is returned for nil entries. The entries are not changed.
+func ReadEntryControls(s *schema.Schema, controls []ldap.Control, pre, post *ldap.Entry) []ldap.Control {
+	var responseControls []ldap.Control
+	for _, read := range []struct {
+		controlType string
+		entry       *ldap.Entry
+	}{
+		{ControlTypePreRead, pre},
+		{ControlTypePostRead, post},
+	} {
+		control, ok := ldap.FindControl(controls, read.controlType).(*ControlReadEntry)
+		if !ok || read.entry == nil {
+			continue
+		}
+		entry := &ldap.Entry{DN: read.entry.DN, Attributes: read.entry.Attributes}
+		if _, err := ServerFilterAttributesWithSchema(s, control.Attributes, entry); err != nil {
+			continue
+		}
+		responseControls = append(responseControls, &ControlReadEntryResponse{ControlType: read.controlType, Entry: entry})
+	}
+	return responseControls
+}
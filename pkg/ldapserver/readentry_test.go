@@ -0,0 +1,167 @@
+package ldapserver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/libregraph/idm/pkg/ldapentry"
+)
+
+// controlModifier modifies its entry, if it matches the assertion, and
+// returns the read entry controls.
+type controlModifier struct {
+	entry *ldap.Entry
+}
+
+func (m *controlModifier) Modify(ctx context.Context, boundDN string, req *ldap.ModifyRequest, conn net.Conn) (LDAPResultCode, error) {
+	if err := CheckAssertion(builtinSchema(), req.Controls, m.entry); err != nil {
+		return LDAPResultCode(err.(*ldap.Error).ResultCode), err
+	}
+	newEntry, err := ldapentry.ApplyModify(m.entry, req)
+	if err != nil {
+		return ldap.LDAPResultOther, err
+	}
+	AddResponseControls(ctx, ReadEntryControls(builtinSchema(), req.Controls, m.entry, newEntry)...)
+	m.entry = newEntry
+	return ldap.LDAPResultSuccess, nil
+}
+
+// readEntryResponse returns the entry of the read entry response control
+// with controlType in controls.
+func readEntryResponse(t *testing.T, controls []ldap.Control, controlType string) *ldap.Entry {
+	t.Helper()
+	c, ok := ldap.FindControl(controls, controlType).(*ldap.ControlString)
+	if !ok {
+		t.Fatalf("Missing read entry control %s in %v", controlType, controls)
+	}
+	value, err := ber.DecodePacketErr([]byte(c.ControlValue))
+	if err != nil || len(value.Children) != 2 {
+		t.Fatalf("Invalid read entry control %s: %v", controlType, err)
+	}
+	entry := &ldap.Entry{DN: value.Children[0].Value.(string)}
+	for _, attr := range value.Children[1].Children {
+		values := []string{}
+		for _, v := range attr.Children[1].Children {
+			values = append(values, v.Value.(string))
+		}
+		entry.Attributes = append(entry.Attributes, ldap.NewEntryAttribute(attr.Children[0].Value.(string), values))
+	}
+	return entry
+}
+
+func TestModifyWriteControls(t *testing.T) {
+	modifier := &controlModifier{
+		entry: ldap.NewEntry("uid=a,o=base", map[string][]string{
+			"objectClass": {"account"},
+			"uid":         {"a"},
+			"description": {"one"},
+		}),
+	}
+	server := NewServer()
+	server.SupportedControls = append(server.SupportedControls, ControlTypeAssertion, ControlTypePreRead, ControlTypePostRead)
+	server.BindFunc("", testBinder{})
+	server.ModifyFunc("", modifier)
+	l := startTestConn(t, server)
+	defer l.Close()
+	if err := l.Bind("cn=test,o=base", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	req := ldap.NewModifyRequest("uid=a,o=base", []ldap.Control{
+		&ControlAssertion{Criticality: true, Filter: "(description=two)"},
+	})
+	req.Replace("description", []string{"three"})
+	if _, err := l.ModifyWithResult(req); !ldap.IsErrorWithCode(err, ldap.LDAPResultAssertionFailed) {
+		t.Errorf("Modify with failing assertion returned %v", err)
+	}
+	if got := modifier.entry.GetAttributeValue("description"); got != "one" {
+		t.Errorf("Modify with failing assertion changed description to %s", got)
+	}
+
+	req = ldap.NewModifyRequest("uid=a,o=base", []ldap.Control{
+		&ControlAssertion{Criticality: true, Filter: "(description=one)"},
+		&ControlReadEntry{ControlType: ControlTypePreRead, Criticality: true, Attributes: []string{"description"}},
+		&ControlReadEntry{ControlType: ControlTypePostRead, Criticality: true},
+	})
+	req.Replace("description", []string{"two"})
+	res, err := l.ModifyWithResult(req)
+	if err != nil {
+		t.Fatalf("Modify with matching assertion failed: %v", err)
+	}
+	pre := readEntryResponse(t, res.Controls, ControlTypePreRead)
+	if pre.DN != "uid=a,o=base" || len(pre.Attributes) != 1 || pre.GetAttributeValue("description") != "one" {
+		t.Errorf("Pre-read returned %s with %v", pre.DN, pre.Attributes)
+	}
+	post := readEntryResponse(t, res.Controls, ControlTypePostRead)
+	if len(post.Attributes) != 3 || post.GetAttributeValue("description") != "two" {
+		t.Errorf("Post-read returned %s with %v", post.DN, post.Attributes)
+	}
+}
+
+func TestWriteControlsUnsupported(t *testing.T) {
+	server := NewServer()
+	server.BindFunc("", testBinder{})
+	server.ModifyFunc("", &controlModifier{entry: ldap.NewEntry("uid=a,o=base", nil)})
+	l := startTestConn(t, server)
+	defer l.Close()
+	if err := l.Bind("cn=test,o=base", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	req := ldap.NewModifyRequest("uid=a,o=base", []ldap.Control{
+		&ControlReadEntry{ControlType: ControlTypePostRead, Criticality: true},
+	})
+	req.Replace("description", []string{"two"})
+	if err := l.Modify(req); !ldap.IsErrorWithCode(err, ldap.LDAPResultUnavailableCriticalExtension) {
+		t.Errorf("Modify with unsupported critical control returned %v", err)
+	}
+
+	// Controls which are not critical are passed to the handler.
+	req.Controls = []ldap.Control{&ControlReadEntry{ControlType: ControlTypePostRead}}
+	res, err := l.ModifyWithResult(req)
+	if err != nil {
+		t.Fatalf("Modify with unsupported control failed: %v", err)
+	}
+	if len(res.Controls) != 1 {
+		t.Errorf("Modify returned controls %v", res.Controls)
+	}
+}
+
+func TestModifyMalformedAssertion(t *testing.T) {
+	modifier := &controlModifier{
+		entry: ldap.NewEntry("uid=a,o=base", map[string][]string{"description": {"one"}}),
+	}
+	server := NewServer()
+	server.SupportedControls = append(server.SupportedControls, ControlTypeAssertion)
+	server.BindFunc("", testBinder{})
+	server.ModifyFunc("", modifier)
+	l := startTestConn(t, server)
+	defer l.Close()
+	if err := l.Bind("cn=test,o=base", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The value is an octet string instead of a filter.
+	malformed := &ldap.ControlString{ControlType: ControlTypeAssertion, Criticality: true, ControlValue: "\x04\x01x"}
+	req := ldap.NewModifyRequest("uid=a,o=base", []ldap.Control{malformed})
+	req.Replace("description", []string{"two"})
+	if err := l.Modify(req); !ldap.IsErrorWithCode(err, ldap.LDAPResultProtocolError) {
+		t.Errorf("Modify with malformed critical assertion returned %v", err)
+	}
+	if got := modifier.entry.GetAttributeValue("description"); got != "one" {
+		t.Errorf("Modify with malformed critical assertion changed description to %s", got)
+	}
+
+	// Malformed controls which are not critical are ignored.
+	malformed.Criticality = false
+	if err := l.Modify(req); err != nil {
+		t.Errorf("Modify with malformed assertion failed: %v", err)
+	}
+	if got := modifier.entry.GetAttributeValue("description"); got != "two" {
+		t.Errorf("Modify with malformed assertion set description to %s", got)
+	}
+}
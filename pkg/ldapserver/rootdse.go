@@ -0,0 +1,125 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/libregraph/idm/version"
+)
+
+const (
+	// SubschemaSubentryDN is the DN of the subschema subentry advertised in
+	// the Root DSE.
+	SubschemaSubentryDN = "cn=Subschema"
+
+	vendorName = "LibreGraph"
+)
+
+// isRootDSESearch returns true if req is a base object search of the empty
+// DN.
+func isRootDSESearch(req *ldap.SearchRequest) bool {
+	return req.BaseDN == "" && req.Scope == ldap.ScopeBaseObject
+}
+
+// HandleRootDSESearch answers a search of the Root DSE (RFC 4512 5.1) with
+// the built-in Root DSE entry of the server.
+func HandleRootDSESearch(ctx context.Context, req *ldap.SearchRequest, messageID int64, server *Server, conn net.Conn) error {
+	return sendBuiltinEntry(ctx, req, messageID, server, conn, server.RootDSE())
+}
+
+// sendBuiltinEntry sends entry if it matches the filter of req. Only the
+// requested attributes are returned.
+func sendBuiltinEntry(ctx context.Context, req *ldap.SearchRequest, messageID int64, server *Server, conn net.Conn, entry *ldap.Entry) error {
+	filterPacket, err := ldap.CompileFilter(req.Filter)
+	if err != nil {
+		return ldap.NewError(ldap.LDAPResultOperationsError, err)
+	}
+	keep, resultCode := ServerApplyFilterWithSchema(server.Schema(), filterPacket, entry)
+	if resultCode != ldap.LDAPResultSuccess {
+		return ldap.NewError(uint16(resultCode), errors.New("ServerApplyFilter error"))
+	}
+	if !keep {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ldap.NewError(ldap.LDAPResultCanceled, context.Cause(ctx))
+	}
+
+	entry.Attributes = selectAttributes(server.Schema(), entry.Attributes, req.Attributes)
+	if err = sendPacket(conn, encodeSearchResponse(messageID, req, entry)); err != nil {
+		return ldap.NewError(ldap.LDAPResultOperationsError, err)
+	}
+	return nil
+}
+
+// RootDSE returns the Root DSE entry of the server including all of its
+// operational attributes.
+func (server *Server) RootDSE() *ldap.Entry {
+	attributes := map[string][]string{
+		"objectClass":             {"top"},
+		"namingContexts":          server.namingContexts(),
+		"subschemaSubentry":       {SubschemaSubentryDN},
+		"supportedControl":        server.supportedControls(),
+		"supportedExtension":      supportedExtensions(),
+		"supportedLDAPVersion":    {"3"},
+		"supportedSASLMechanisms": SupportedSASLMechanisms(),
+		"vendorName":              {vendorName},
+		"vendorVersion":           {version.Version},
+	}
+	for name, values := range attributes {
+		if len(values) == 0 {
+			delete(attributes, name)
+		}
+	}
+	return ldap.NewEntry("", attributes)
+}
+
+// namingContexts returns the base DNs of the registered search handlers and
+// the configured NamingContexts.
+func (server *Server) namingContexts() []string {
+	seen := map[string]bool{}
+	namingContexts := []string{}
+	add := func(dn string) {
+		if dn == "" || seen[strings.ToLower(dn)] {
+			return
+		}
+		seen[strings.ToLower(dn)] = true
+		namingContexts = append(namingContexts, dn)
+	}
+	for _, dn := range server.NamingContexts {
+		add(dn)
+	}
+	fnNames := []string{}
+	for k := range server.SearchFns {
+		fnNames = append(fnNames, k)
+	}
+	sort.Strings(fnNames)
+	for _, dn := range fnNames {
+		add(dn)
+	}
+	return namingContexts
+}
+
+// supportedControls returns the sorted OIDs of the controls supported by the
+// server.
+func (server *Server) supportedControls() []string {
+	controls := append([]string{}, server.SupportedControls...)
+	sort.Strings(controls)
+	return controls
+}
+
+// supportedExtensions returns the sorted OIDs of the registered extended
+// operations.
+func supportedExtensions() []string {
+	extensions := make([]string, 0, len(exopRegistry))
+	for oid := range exopRegistry {
+		extensions = append(extensions, oid)
+	}
+	sort.Strings(extensions)
+	return extensions
+}
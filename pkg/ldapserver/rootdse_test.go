@@ -0,0 +1,69 @@
+package ldapserver
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestRootDSESearch(t *testing.T) {
+	server := NewServer()
+	server.SearchFunc("o=other", defaultHandler{})
+	server.NamingContexts = []string{"o=base"}
+	server.SupportedControls = []string{ldap.ControlTypePaging}
+	l := startTestConn(t, server)
+	defer l.Close()
+
+	res, err := l.Search(ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", nil, nil))
+	if err != nil {
+		t.Fatalf("Root DSE search failed: %s", err)
+	}
+	if len(res.Entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(res.Entries))
+	}
+	if res.Entries[0].GetAttributeValue("objectClass") != "top" || res.Entries[0].GetAttributeValue("namingContexts") != "" {
+		t.Errorf("Expected only user attributes without attribute list")
+	}
+
+	res, err = l.Search(ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", []string{"+"}, nil))
+	if err != nil {
+		t.Fatalf("Root DSE search failed: %s", err)
+	}
+	entry := res.Entries[0]
+	if got := entry.GetAttributeValues("namingContexts"); len(got) != 2 || got[0] != "o=base" || got[1] != "o=other" {
+		t.Errorf("Unexpected namingContexts: %v", got)
+	}
+	if got := entry.GetAttributeValue("supportedLDAPVersion"); got != "3" {
+		t.Errorf("Unexpected supportedLDAPVersion: %s", got)
+	}
+	if got := entry.GetAttributeValue("supportedControl"); got != ldap.ControlTypePaging {
+		t.Errorf("Unexpected supportedControl: %s", got)
+	}
+	extensions := entry.GetAttributeValues("supportedExtension")
+	for _, oid := range []string{startTLSOID, pwmodOID, cancelOID} {
+		found := false
+		for _, extension := range extensions {
+			found = found || extension == oid
+		}
+		if !found {
+			t.Errorf("Extension %s not advertised: %v", oid, extensions)
+		}
+	}
+	if got := entry.GetAttributeValues("supportedSASLMechanisms"); len(got) != len(SupportedSASLMechanisms()) {
+		t.Errorf("Unexpected supportedSASLMechanisms: %v", got)
+	}
+	if got := entry.GetAttributeValue("subschemaSubentry"); got != SubschemaSubentryDN {
+		t.Errorf("Unexpected subschemaSubentry: %s", got)
+	}
+	if entry.GetAttributeValue("vendorName") == "" || entry.GetAttributeValue("vendorVersion") == "" {
+		t.Errorf("Expected vendorName and vendorVersion")
+	}
+
+	res, err = l.Search(ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", []string{"supportedLDAPVersion"}, nil))
+	if err != nil {
+		t.Fatalf("Root DSE search failed: %s", err)
+	}
+	if attrs := res.Entries[0].Attributes; len(attrs) != 1 || attrs[0].Name != "supportedLDAPVersion" {
+		t.Errorf("Expected only the requested attribute, got %v", attrs)
+	}
+}
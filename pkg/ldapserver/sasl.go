@@ -0,0 +1,195 @@
+package ldapserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/libregraph/idm/pkg/ldapdn"
+)
+
+// SASLSession is the server side of a single SASL authentication exchange.
+type SASLSession interface {
+	// Next processes the credentials sent by the client. It returns the
+	// challenge to send back to the client (might be nil) and the DN of the
+	// authenticated identity once the exchange is complete. Errors should be
+	// of type *ldap.Error to signal a specific result code.
+	Next(credentials []byte) (challenge []byte, boundDN string, done bool, err error)
+}
+
+// SASLMechanism creates a new SASLSession for a bind request on conn.
+type SASLMechanism func(server *Server, conn net.Conn) SASLSession
+
+var saslRegistry = map[string]SASLMechanism{}
+
+// RegisterSASLMechanism makes the SASL mechanism name available for binds.
+func RegisterSASLMechanism(name string, mechanism SASLMechanism) {
+	saslRegistry[strings.ToUpper(name)] = mechanism
+}
+
+// SupportedSASLMechanisms returns the sorted names of all registered SASL
+// mechanisms.
+func SupportedSASLMechanisms() []string {
+	names := make([]string, 0, len(saslRegistry))
+	for name := range saslRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SASLBindState keeps track of a multi-step SASL bind on a connection.
+type SASLBindState struct {
+	mechanism string
+	session   SASLSession
+}
+
+// Reset aborts the SASL bind in progress (if any).
+func (state *SASLBindState) Reset() {
+	state.mechanism = ""
+	state.session = nil
+}
+
+func handleSASLBind(bindAuth *ber.Packet, server *Server, conn net.Conn, state *SASLBindState) (LDAPResultCode, string, []byte) {
+	// SaslCredentials ::= SEQUENCE {
+	//         mechanism               LDAPString,
+	//         credentials             OCTET STRING OPTIONAL }
+	if len(bindAuth.Children) < 1 || len(bindAuth.Children) > 2 {
+		state.Reset()
+		return ldap.LDAPResultProtocolError, "", nil
+	}
+	mechanism, ok := bindAuth.Children[0].Value.(string)
+	if !ok {
+		state.Reset()
+		return ldap.LDAPResultProtocolError, "", nil
+	}
+	mechanism = strings.ToUpper(mechanism)
+	var credentials []byte
+	if len(bindAuth.Children) == 2 {
+		credentials = bindAuth.Children[1].Data.Bytes()
+	}
+
+	// A bind request with a different mechanism aborts the exchange in
+	// progress and starts a new one (RFC 4513 5.2.1.2).
+	if state.session == nil || state.mechanism != mechanism {
+		newSession, ok := saslRegistry[mechanism]
+		if !ok {
+			state.Reset()
+			logger.V(1).Info("Unsupported SASL mechanism", "mechanism", mechanism)
+			return ldap.LDAPResultAuthMethodNotSupported, "", nil
+		}
+		state.mechanism = mechanism
+		state.session = newSession(server, conn)
+	}
+
+	challenge, boundDN, done, err := state.session.Next(credentials)
+	if err != nil {
+		state.Reset()
+		var lErr *ldap.Error
+		if errors.As(err, &lErr) {
+			logger.V(1).Info("SASL bind failed", "mechanism", mechanism, "error", err.Error())
+			return LDAPResultCode(lErr.ResultCode), "", nil
+		}
+		logger.Error(err, "SASL bind error", "mechanism", mechanism)
+		return ldap.LDAPResultOperationsError, "", nil
+	}
+	if !done {
+		return ldap.LDAPResultSaslBindInProgress, "", challenge
+	}
+	state.Reset()
+	logger.V(1).Info("SASL bind success", "mechanism", mechanism, "dn", boundDN)
+	return ldap.LDAPResultSuccess, boundDN, challenge
+}
+
+// mapIdentity resolves the passed request to the DN of a single entry using
+// the registered IdentityMapper handlers.
+func (server *Server) mapIdentity(req *ldap.SearchRequest, conn net.Conn) (string, error) {
+	fnNames := []string{}
+	for k := range server.IdentityMapperFns {
+		fnNames = append(fnNames, k)
+	}
+	fn := routeFunc(req.BaseDN, fnNames)
+	mapper := server.IdentityMapperFns[fn]
+	if mapper == nil {
+		return "", fmt.Errorf("no identity mapper found for dn: '%s'", req.BaseDN)
+	}
+	return mapper.MapIdentity(req, conn)
+}
+
+// ValidateSASLUsernameAttribute returns an error if the passed attribute
+// cannot be used to look up the entries of SASL user names.
+func ValidateSASLUsernameAttribute(attribute string) error {
+	if attribute == "" || !isAttributeDescriptor(attribute) {
+		return fmt.Errorf("invalid SASL username attribute: '%s'", attribute)
+	}
+	return nil
+}
+
+// isAttributeDescriptor reports whether name is a valid attribute type name
+// (RFC 4512 descr).
+func isAttributeDescriptor(name string) bool {
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '-'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// mapAuthenticationID resolves a SASL authentication identity (RFC 4513 5.2.1.8
+// authzId syntax, or a plain user name) to the DN of an entry.
+func (server *Server) mapAuthenticationID(authcID string, conn net.Conn) (string, error) {
+	switch {
+	case strings.HasPrefix(authcID, "dn:"):
+		dn, err := ldapdn.ParseNormalize(authcID[3:])
+		if err != nil {
+			return "", err
+		}
+		return server.mapIdentity(&ldap.SearchRequest{
+			BaseDN: dn,
+			Scope:  ldap.ScopeBaseObject,
+			Filter: "(objectClass=*)",
+		}, conn)
+	case strings.HasPrefix(authcID, "u:"):
+		authcID = authcID[2:]
+	}
+	if authcID == "" {
+		return "", errors.New("empty authentication identity")
+	}
+	return server.mapIdentity(&ldap.SearchRequest{
+		Scope:  ldap.ScopeWholeSubtree,
+		Filter: "(" + server.SASLUsernameAttribute + "=" + ldap.EscapeFilter(authcID) + ")",
+	}, conn)
+}
+
+// checkAuthorizationID verifies that the authorization identity requested
+// in a SASL exchange matches the authenticated identity.
+func checkAuthorizationID(authzID, boundDN string) error {
+	if authzID == "" {
+		return nil
+	}
+	if strings.HasPrefix(authzID, "dn:") && equalDN(authzID[3:], boundDN) {
+		return nil
+	}
+	return ldap.NewError(ldap.LDAPResultInsufficientAccessRights, fmt.Errorf("authorization identity '%s' rejected", authzID))
+}
+
+func equalDN(a, b string) bool {
+	na, err := ldapdn.ParseNormalize(a)
+	if err != nil {
+		return false
+	}
+	nb, err := ldapdn.ParseNormalize(b)
+	if err != nil {
+		return false
+	}
+	return na == nb
+}
@@ -0,0 +1,295 @@
+package ldapserver
+
+import (
+	"crypto/pbkdf2"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/libregraph/idm/pkg/ldappassword"
+)
+
+type testIdentityMapper struct {
+	req *ldap.SearchRequest
+	dn  string
+}
+
+func (m *testIdentityMapper) MapIdentity(req *ldap.SearchRequest, conn net.Conn) (string, error) {
+	m.req = req
+	if m.dn == "" {
+		return "", errors.New("not found")
+	}
+	return m.dn, nil
+}
+
+func TestCertificateMapRequest(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:         "service",
+			OrganizationalUnit: []string{"Services"},
+			Organization:       []string{"Example"},
+		},
+		EmailAddresses: []string{"service@example.com"},
+	}
+
+	req, err := certificateMapRequest(cert, SASLExternalMappingDN)
+	if err != nil {
+		t.Fatalf("Mapping by DN should succeed. Got: %v", err)
+	}
+	if req.BaseDN != "cn=service,ou=services,o=example" || req.Scope != ldap.ScopeBaseObject {
+		t.Errorf("Unexpected DN mapping request: %s (scope %d)", req.BaseDN, req.Scope)
+	}
+
+	req, err = certificateMapRequest(cert, SASLExternalMappingMail)
+	if err != nil {
+		t.Fatalf("Mapping by mail should succeed. Got: %v", err)
+	}
+	if req.Filter != "(mail=service@example.com)" || req.Scope != ldap.ScopeWholeSubtree {
+		t.Errorf("Unexpected mail mapping request: %s", req.Filter)
+	}
+
+	req, err = certificateMapRequest(cert, SASLExternalMappingUID)
+	if err != nil {
+		t.Fatalf("Mapping by uid should succeed. Got: %v", err)
+	}
+	if req.Filter != "(uid=service)" {
+		t.Errorf("Unexpected uid mapping request: %s", req.Filter)
+	}
+
+	cert.EmailAddresses = nil
+	if _, err = certificateMapRequest(cert, SASLExternalMappingMail); err == nil {
+		t.Errorf("Mapping by mail without e-mail address should fail")
+	}
+}
+
+func startTestTLSConn(t *testing.T, server *Server, clientCerts []tls.Certificate) *ldap.Conn {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	go server.handleConnection(tls.Server(serverConn, server.TLSConfig))
+	l := ldap.NewConn(tls.Client(clientConn, &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       clientCerts,
+	}), true)
+	l.Start()
+	return l
+}
+
+func TestSASLExternalBind(t *testing.T) {
+	clientCert := newTestCertificate(t, pkix.Name{CommonName: "service", Organization: []string{"Example"}})
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCert.Leaf)
+
+	mapper := &testIdentityMapper{dn: "cn=service,o=example"}
+	server := NewServer()
+	server.IdentityMapperFunc("", mapper)
+	server.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{newTestCertificate(t, pkix.Name{CommonName: "localhost"})},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+
+	l := startTestTLSConn(t, server, []tls.Certificate{clientCert})
+	if err := l.ExternalBind(); err != nil {
+		t.Errorf("SASL EXTERNAL bind with client certificate should succeed. Got: %v", err)
+	}
+	if mapper.req == nil || mapper.req.BaseDN != "cn=service,o=example" {
+		t.Errorf("Expected identity mapper to be called with certificate subject. Got: %v", mapper.req)
+	}
+	l.Close()
+
+	l = startTestTLSConn(t, server, nil)
+	err := l.ExternalBind()
+	if err == nil || !ldap.IsErrorWithCode(err, ldap.LDAPResultInappropriateAuthentication) {
+		t.Errorf("SASL EXTERNAL bind without client certificate should fail. Got: %v", err)
+	}
+	l.Close()
+
+	mapper.dn = ""
+	l = startTestTLSConn(t, server, []tls.Certificate{clientCert})
+	err = l.ExternalBind()
+	if err == nil || !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		t.Errorf("SASL EXTERNAL bind for unknown identity should fail. Got: %v", err)
+	}
+	l.Close()
+}
+
+func TestSASLExternalBindCleartext(t *testing.T) {
+	server := NewServer()
+	l := startTestConn(t, server)
+	defer l.Close()
+
+	err := l.ExternalBind()
+	if err == nil || !ldap.IsErrorWithCode(err, ldap.LDAPResultInappropriateAuthentication) {
+		t.Errorf("SASL EXTERNAL bind on cleartext connection should fail. Got: %v", err)
+	}
+}
+
+type testSCRAMProvider struct {
+	verifier *ldappassword.SCRAMVerifier
+}
+
+func (p *testSCRAMProvider) SCRAMVerifier(dn, mechanism string, conn net.Conn) (*ldappassword.SCRAMVerifier, error) {
+	if dn != "cn=test,o=base" || p.verifier.Mechanism != mechanism {
+		return nil, errors.New("not found")
+	}
+	return p.verifier, nil
+}
+
+// sendSASLBind sends a SASL bind request on conn and returns the result code
+// and serverSaslCreds of the response.
+func sendSASLBind(t *testing.T, conn net.Conn, messageID int64, mechanism string, credentials []byte) (LDAPResultCode, []byte) {
+	t.Helper()
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Request")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+	bindRequest := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationBindRequest, nil, "Bind Request")
+	bindRequest.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 3, "Version"))
+	bindRequest.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "User Name"))
+	auth := ber.Encode(ber.ClassContext, ber.TypeConstructed, LDAPBindAuthSASL, "", "authentication")
+	auth.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, mechanism, "SASL Mech"))
+	if credentials != nil {
+		auth.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(credentials), "Credentials"))
+	}
+	bindRequest.AppendChild(auth)
+	packet.AppendChild(bindRequest)
+	if _, err := conn.Write(packet.Bytes()); err != nil {
+		t.Fatalf("Error sending bind request: %s", err)
+	}
+
+	response, err := ber.ReadPacket(conn)
+	if err != nil {
+		t.Fatalf("Error reading bind response: %s", err)
+	}
+	bindResponse := response.Children[1]
+	var serverSaslCreds []byte
+	if len(bindResponse.Children) > 3 && bindResponse.Children[3].Tag == 7 {
+		serverSaslCreds = bindResponse.Children[3].Data.Bytes()
+	}
+	return LDAPResultCode(bindResponse.Children[0].Value.(int64)), serverSaslCreds
+}
+
+func startTestRawConn(t *testing.T, server *Server) net.Conn {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	go server.handleConnection(serverConn)
+	return clientConn
+}
+
+func TestSASLPlainBind(t *testing.T) {
+	server := NewServer()
+	server.BindFunc("", testBinder{})
+	server.IdentityMapperFunc("", &testIdentityMapper{dn: "cn=test,o=base"})
+	conn := startTestRawConn(t, server)
+	defer conn.Close()
+
+	for i, tc := range []struct {
+		credentials string
+		resultCode  LDAPResultCode
+	}{
+		{"\x00test\x00secret", ldap.LDAPResultSuccess},
+		{"\x00dn:cn=test,o=base\x00secret", ldap.LDAPResultSuccess},
+		{"dn:cn=Test,o=Base\x00u:test\x00secret", ldap.LDAPResultSuccess},
+		{"\x00test\x00wrong", ldap.LDAPResultInvalidCredentials},
+		{"dn:cn=other,o=base\x00test\x00secret", ldap.LDAPResultInsufficientAccessRights},
+		{"test\x00secret", ldap.LDAPResultProtocolError},
+	} {
+		resultCode, _ := sendSASLBind(t, conn, int64(i+1), SASLMechanismPlain, []byte(tc.credentials))
+		if resultCode != tc.resultCode {
+			t.Errorf("SASL PLAIN bind %q: expected result %d, got %d", tc.credentials, tc.resultCode, resultCode)
+		}
+	}
+}
+
+func TestSASLSCRAMBind(t *testing.T) {
+	for _, mechanism := range []string{SASLMechanismSCRAMSHA1, SASLMechanismSCRAMSHA256} {
+		t.Run(mechanism, func(t *testing.T) {
+			verifier, err := ldappassword.NewSCRAMVerifier(mechanism, "secret", []byte("salt"), 4096)
+			if err != nil {
+				t.Fatalf("Error creating SCRAM verifier: %s", err)
+			}
+			server := NewServer()
+			server.IdentityMapperFunc("", &testIdentityMapper{dn: "cn=test,o=base"})
+			server.SCRAMProviderFunc("", &testSCRAMProvider{verifier: verifier})
+			conn := startTestRawConn(t, server)
+			defer conn.Close()
+
+			for i, password := range []string{"secret", "wrong"} {
+				h, _ := ldappassword.SCRAMHash(mechanism)
+				clientFirstBare := "n=test,r=clientnonce"
+				resultCode, serverFirst := sendSASLBind(t, conn, int64(2*i+1), mechanism, []byte("n,,"+clientFirstBare))
+				if resultCode != ldap.LDAPResultSaslBindInProgress {
+					t.Fatalf("Expected SASL bind in progress, got %d", resultCode)
+				}
+				attrs := strings.Split(string(serverFirst), ",")
+				if len(attrs) != 3 || !strings.HasPrefix(attrs[0], "r=clientnonce") || attrs[1] != "s="+base64.StdEncoding.EncodeToString([]byte("salt")) || attrs[2] != "i=4096" {
+					t.Fatalf("Unexpected server-first-message: %s", serverFirst)
+				}
+
+				clientFinalWithoutProof := "c=" + base64.StdEncoding.EncodeToString([]byte("n,,")) + "," + attrs[0]
+				authMessage := clientFirstBare + "," + string(serverFirst) + "," + clientFinalWithoutProof
+				saltedPassword, _ := pbkdf2.Key(h, password, []byte("salt"), 4096, h().Size())
+				clientKey := scramHMAC(h, saltedPassword, "Client Key")
+				storedKey := h()
+				storedKey.Write(clientKey)
+				clientSignature := scramHMAC(h, storedKey.Sum(nil), authMessage)
+				proof := make([]byte, len(clientKey))
+				for j := range clientKey {
+					proof[j] = clientKey[j] ^ clientSignature[j]
+				}
+
+				resultCode, serverFinal := sendSASLBind(t, conn, int64(2*i+2), mechanism, []byte(clientFinalWithoutProof+",p="+base64.StdEncoding.EncodeToString(proof)))
+				if password != "secret" {
+					if resultCode != ldap.LDAPResultInvalidCredentials {
+						t.Errorf("SCRAM bind with wrong password should fail. Got: %d", resultCode)
+					}
+					continue
+				}
+				if resultCode != ldap.LDAPResultSuccess {
+					t.Fatalf("SCRAM bind should succeed. Got: %d", resultCode)
+				}
+				serverSignature := scramHMAC(h, scramHMAC(h, saltedPassword, "Server Key"), authMessage)
+				if string(serverFinal) != "v="+base64.StdEncoding.EncodeToString(serverSignature) {
+					t.Errorf("Unexpected server-final-message: %s", serverFinal)
+				}
+			}
+		})
+	}
+}
+
+func TestSASLSCRAMBindUnknownUser(t *testing.T) {
+	server := NewServer()
+	server.IdentityMapperFunc("", &testIdentityMapper{})
+	conn := startTestRawConn(t, server)
+	defer conn.Close()
+
+	// Unknown users get a challenge like existing users, with a salt which
+	// is stable across binds, and fail with the final message.
+	var salt string
+	for i := range 2 {
+		resultCode, serverFirst := sendSASLBind(t, conn, int64(2*i+1), SASLMechanismSCRAMSHA256, []byte("n,,n=unknown,r=clientnonce"))
+		if resultCode != ldap.LDAPResultSaslBindInProgress {
+			t.Fatalf("Expected SASL bind in progress for unknown user, got %d", resultCode)
+		}
+		attrs := strings.Split(string(serverFirst), ",")
+		if len(attrs) != 3 || !strings.HasPrefix(attrs[0], "r=clientnonce") || attrs[2] != "i=4096" {
+			t.Fatalf("Unexpected server-first-message: %s", serverFirst)
+		}
+		if salt != "" && attrs[1] != salt {
+			t.Errorf("Salt of unknown user changed from %s to %s", salt, attrs[1])
+		}
+		salt = attrs[1]
+
+		proof := base64.StdEncoding.EncodeToString(make([]byte, 32))
+		clientFinal := "c=" + base64.StdEncoding.EncodeToString([]byte("n,,")) + "," + attrs[0] + ",p=" + proof
+		if resultCode, _ = sendSASLBind(t, conn, int64(2*i+2), SASLMechanismSCRAMSHA256, []byte(clientFinal)); resultCode != ldap.LDAPResultInvalidCredentials {
+			t.Errorf("SCRAM bind of unknown user should fail. Got: %d", resultCode)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package ldapserver
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/libregraph/idm/pkg/ldapdn"
+)
+
+const (
+	SASLMechanismExternal = "EXTERNAL"
+)
+
+// Supported rules for mapping TLS client certificates to directory entries
+// for SASL EXTERNAL binds.
+const (
+	// The certificate subject DN is the DN of the entry.
+	SASLExternalMappingDN = "dn"
+	// The entry is searched by the e-mail address of the certificate (SAN or
+	// subject emailAddress).
+	SASLExternalMappingMail = "mail"
+	// The entry is searched by the uid attribute of the certificate subject,
+	// with a fallback to the subject common name.
+	SASLExternalMappingUID = "uid"
+)
+
+var (
+	oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+	oidUID          = asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}
+)
+
+func init() {
+	RegisterSASLMechanism(SASLMechanismExternal, newSASLExternalSession)
+}
+
+// ValidateSASLExternalMapping returns an error if the passed SASL EXTERNAL
+// mapping rule is not supported.
+func ValidateSASLExternalMapping(mapping string) error {
+	switch mapping {
+	case SASLExternalMappingDN, SASLExternalMappingMail, SASLExternalMappingUID:
+		return nil
+	default:
+		return fmt.Errorf("unsupported SASL EXTERNAL mapping: '%s'", mapping)
+	}
+}
+
+// saslExternalSession authenticates a client by the TLS client certificate
+// it presented on the current connection (RFC 4513 5.2.3).
+type saslExternalSession struct {
+	server *Server
+	conn   net.Conn
+}
+
+func newSASLExternalSession(server *Server, conn net.Conn) SASLSession {
+	return &saslExternalSession{
+		server: server,
+		conn:   conn,
+	}
+}
+
+func (s *saslExternalSession) Next(credentials []byte) ([]byte, string, bool, error) {
+	tlsConn, ok := asTLSConn(s.conn)
+	if !ok {
+		return nil, "", false, ldap.NewError(ldap.LDAPResultInappropriateAuthentication, errors.New("SASL EXTERNAL bind on non TLS connection"))
+	}
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil, "", false, ldap.NewError(ldap.LDAPResultInappropriateAuthentication, errors.New("SASL EXTERNAL bind without verified client certificate"))
+	}
+	cert := state.PeerCertificates[0]
+
+	mapReq, err := certificateMapRequest(cert, s.server.SASLExternalMapping)
+	if err != nil {
+		return nil, "", false, ldap.NewError(ldap.LDAPResultInvalidCredentials, err)
+	}
+	dn, err := s.server.mapIdentity(mapReq, s.conn)
+	if err != nil {
+		return nil, "", false, ldap.NewError(ldap.LDAPResultInvalidCredentials, err)
+	}
+
+	// An authorization identity might be requested in the credentials, we only
+	// allow it if it matches the authenticated identity.
+	if err = checkAuthorizationID(string(credentials), dn); err != nil {
+		return nil, "", false, err
+	}
+	return nil, dn, true, nil
+}
+
+// certificateMapRequest builds the search request which is used to map the
+// passed client certificate to a directory entry according to mapping.
+func certificateMapRequest(cert *x509.Certificate, mapping string) (*ldap.SearchRequest, error) {
+	switch mapping {
+	case SASLExternalMappingDN, "":
+		dn, err := ldap.ParseDN(cert.Subject.String())
+		if err != nil || len(dn.RDNs) == 0 {
+			return nil, fmt.Errorf("invalid certificate subject: '%s'", cert.Subject.String())
+		}
+		return &ldap.SearchRequest{
+			BaseDN: ldapdn.Normalize(dn),
+			Scope:  ldap.ScopeBaseObject,
+			Filter: "(objectClass=*)",
+		}, nil
+
+	case SASLExternalMappingMail:
+		mail := ""
+		if len(cert.EmailAddresses) > 0 {
+			mail = cert.EmailAddresses[0]
+		} else {
+			mail = subjectAttribute(cert, oidEmailAddress)
+		}
+		if mail == "" {
+			return nil, errors.New("certificate has no e-mail address")
+		}
+		return &ldap.SearchRequest{
+			Scope:  ldap.ScopeWholeSubtree,
+			Filter: "(mail=" + ldap.EscapeFilter(mail) + ")",
+		}, nil
+
+	case SASLExternalMappingUID:
+		uid := subjectAttribute(cert, oidUID)
+		if uid == "" {
+			uid = cert.Subject.CommonName
+		}
+		if uid == "" {
+			return nil, errors.New("certificate has no uid or common name")
+		}
+		return &ldap.SearchRequest{
+			Scope:  ldap.ScopeWholeSubtree,
+			Filter: "(uid=" + ldap.EscapeFilter(uid) + ")",
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported SASL EXTERNAL mapping: '%s'", mapping)
+}
+
+func subjectAttribute(cert *x509.Certificate, oid asn1.ObjectIdentifier) string {
+	for _, atv := range cert.Subject.Names {
+		if atv.Type.Equal(oid) {
+			if v, ok := atv.Value.(string); ok {
+				return v
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+package ldapserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	SASLMechanismPlain = "PLAIN"
+)
+
+func init() {
+	RegisterSASLMechanism(SASLMechanismPlain, newSASLPlainSession)
+}
+
+// saslPlainSession implements the SASL PLAIN mechanism as defined in RFC 4616.
+// The password is verified with the Binder responsible for the authenticated
+// identity.
+type saslPlainSession struct {
+	server *Server
+	conn   net.Conn
+}
+
+func newSASLPlainSession(server *Server, conn net.Conn) SASLSession {
+	return &saslPlainSession{
+		server: server,
+		conn:   conn,
+	}
+}
+
+func (s *saslPlainSession) Next(credentials []byte) ([]byte, string, bool, error) {
+	// message   = [authzid] UTF8NUL authcid UTF8NUL passwd
+	parts := bytes.Split(credentials, []byte{0})
+	if len(parts) != 3 {
+		return nil, "", false, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("invalid SASL PLAIN message"))
+	}
+	authzID, authcID, password := string(parts[0]), string(parts[1]), string(parts[2])
+	if authcID == "" || password == "" {
+		return nil, "", false, ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("empty SASL PLAIN credentials"))
+	}
+
+	dn, err := s.server.mapAuthenticationID(authcID, s.conn)
+	if err != nil {
+		return nil, "", false, ldap.NewError(ldap.LDAPResultInvalidCredentials, err)
+	}
+
+	fnNames := []string{}
+	for k := range s.server.BindFns {
+		fnNames = append(fnNames, k)
+	}
+	fn := routeFunc(dn, fnNames)
+	resultCode, err := s.server.BindFns[fn].Bind(dn, password, s.conn)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if resultCode != ldap.LDAPResultSuccess {
+		return nil, "", false, ldap.NewError(uint16(resultCode), fmt.Errorf("SASL PLAIN bind failed for '%s'", dn))
+	}
+
+	if err = checkAuthorizationID(authzID, dn); err != nil {
+		return nil, "", false, err
+	}
+	return nil, dn, true, nil
+}
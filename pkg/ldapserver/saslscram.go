@@ -0,0 +1,265 @@
+package ldapserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/libregraph/idm/pkg/ldappassword"
+)
+
+const (
+	SASLMechanismSCRAMSHA1   = ldappassword.SCRAMSHA1
+	SASLMechanismSCRAMSHA256 = ldappassword.SCRAMSHA256
+)
+
+// scramUnknownSaltKey is the key from which the salts of unknown users are
+// derived. It is random per process, so that the salts cannot be predicted
+// but remain stable across repeated binds of the same username.
+var scramUnknownSaltKey = make([]byte, 32)
+
+func init() {
+	rand.Read(scramUnknownSaltKey)
+	RegisterSASLMechanism(SASLMechanismSCRAMSHA1, func(server *Server, conn net.Conn) SASLSession {
+		return newSASLSCRAMSession(SASLMechanismSCRAMSHA1, server, conn)
+	})
+	RegisterSASLMechanism(SASLMechanismSCRAMSHA256, func(server *Server, conn net.Conn) SASLSession {
+		return newSASLSCRAMSession(SASLMechanismSCRAMSHA256, server, conn)
+	})
+}
+
+// saslSCRAMSession implements the server side of the SCRAM SASL mechanisms as
+// defined in RFC 5802 (without channel binding).
+type saslSCRAMSession struct {
+	mechanism string
+	server    *Server
+	conn      net.Conn
+
+	step            int
+	gs2Header       string
+	clientFirstBare string
+	serverFirst     string
+	nonce           string
+	authzID         string
+	username        string
+	dn              string
+	verifier        *ldappassword.SCRAMVerifier
+	// unknown is set if no verifier was found for the username. The
+	// exchange then continues with a made-up verifier and fails at the
+	// client-final-message, so that unknown users cannot be told apart from
+	// wrong passwords.
+	unknown bool
+}
+
+func newSASLSCRAMSession(mechanism string, server *Server, conn net.Conn) SASLSession {
+	return &saslSCRAMSession{
+		mechanism: mechanism,
+		server:    server,
+		conn:      conn,
+	}
+}
+
+func (s *saslSCRAMSession) Next(credentials []byte) ([]byte, string, bool, error) {
+	s.step++
+	switch s.step {
+	case 1:
+		challenge, err := s.handleClientFirst(string(credentials))
+		return challenge, "", false, err
+	case 2:
+		challenge, err := s.handleClientFinal(string(credentials))
+		if err != nil {
+			return nil, "", false, err
+		}
+		return challenge, s.dn, true, nil
+	default:
+		return nil, "", false, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("unexpected SCRAM message"))
+	}
+}
+
+func (s *saslSCRAMSession) handleClientFirst(msg string) ([]byte, error) {
+	// client-first-message = gs2-header client-first-message-bare
+	// gs2-header           = gs2-cbind-flag "," [ authzid ] ","
+	parts := strings.SplitN(msg, ",", 3)
+	if len(parts) != 3 {
+		return nil, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("invalid SCRAM client-first-message"))
+	}
+	switch {
+	case parts[0] == "n" || parts[0] == "y":
+	case strings.HasPrefix(parts[0], "p="):
+		return nil, ldap.NewError(ldap.LDAPResultInappropriateAuthentication, errors.New("SCRAM channel binding is not supported"))
+	default:
+		return nil, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("invalid SCRAM channel binding flag"))
+	}
+	if parts[1] != "" {
+		if !strings.HasPrefix(parts[1], "a=") {
+			return nil, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("invalid SCRAM authzid"))
+		}
+		authzID, err := decodeSASLName(parts[1][2:])
+		if err != nil {
+			return nil, ldap.NewError(ldap.LDAPResultProtocolError, err)
+		}
+		s.authzID = authzID
+	}
+	s.gs2Header = parts[0] + "," + parts[1] + ","
+	s.clientFirstBare = parts[2]
+
+	// client-first-message-bare = [reserved-mext ","] username "," nonce ["," extensions]
+	attrs := strings.Split(s.clientFirstBare, ",")
+	if len(attrs) < 2 || !strings.HasPrefix(attrs[0], "n=") || !strings.HasPrefix(attrs[1], "r=") {
+		return nil, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("invalid SCRAM client-first-message-bare"))
+	}
+	username, err := decodeSASLName(attrs[0][2:])
+	if err != nil {
+		return nil, ldap.NewError(ldap.LDAPResultProtocolError, err)
+	}
+	clientNonce := attrs[1][2:]
+	if clientNonce == "" {
+		return nil, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("empty SCRAM nonce"))
+	}
+
+	s.username = username
+	if s.verifier, err = s.lookupVerifier(username); err != nil {
+		logger.V(1).Info("SCRAM verifier lookup failed", "mechanism", s.mechanism, "username", username, "error", err.Error())
+		s.dn = ""
+		s.unknown = true
+		s.verifier = unknownSCRAMVerifier(s.mechanism, username)
+	}
+
+	serverNonce := make([]byte, 18)
+	if _, err = rand.Read(serverNonce); err != nil {
+		return nil, err
+	}
+	s.nonce = clientNonce + base64.RawStdEncoding.EncodeToString(serverNonce)
+	s.serverFirst = "r=" + s.nonce +
+		",s=" + base64.StdEncoding.EncodeToString(s.verifier.Salt) +
+		",i=" + strconv.Itoa(s.verifier.Iterations)
+	return []byte(s.serverFirst), nil
+}
+
+func (s *saslSCRAMSession) handleClientFinal(msg string) ([]byte, error) {
+	// client-final-message = channel-binding "," nonce ["," extensions] "," proof
+	proofIdx := strings.LastIndex(msg, ",p=")
+	if proofIdx < 0 {
+		return nil, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("invalid SCRAM client-final-message"))
+	}
+	withoutProof := msg[:proofIdx]
+	attrs := strings.Split(withoutProof, ",")
+	if len(attrs) < 2 || !strings.HasPrefix(attrs[0], "c=") || !strings.HasPrefix(attrs[1], "r=") {
+		return nil, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("invalid SCRAM client-final-message"))
+	}
+	cbind, err := base64.StdEncoding.DecodeString(attrs[0][2:])
+	if err != nil || string(cbind) != s.gs2Header {
+		return nil, ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("SCRAM channel binding mismatch"))
+	}
+	if attrs[1][2:] != s.nonce {
+		return nil, ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("SCRAM nonce mismatch"))
+	}
+	proof, err := base64.StdEncoding.DecodeString(msg[proofIdx+3:])
+	if err != nil {
+		return nil, ldap.NewError(ldap.LDAPResultProtocolError, err)
+	}
+
+	h, err := ldappassword.SCRAMHash(s.mechanism)
+	if err != nil {
+		return nil, err
+	}
+	authMessage := s.clientFirstBare + "," + s.serverFirst + "," + withoutProof
+	clientSignature := scramHMAC(h, s.verifier.StoredKey, authMessage)
+	if len(proof) != len(clientSignature) {
+		return nil, ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid SCRAM proof"))
+	}
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ clientSignature[i]
+	}
+	storedKey := h()
+	storedKey.Write(clientKey)
+	if subtle.ConstantTimeCompare(storedKey.Sum(nil), s.verifier.StoredKey) != 1 || s.unknown {
+		return nil, ldap.NewError(ldap.LDAPResultInvalidCredentials, fmt.Errorf("SCRAM authentication failed for '%s'", s.username))
+	}
+
+	if err = checkAuthorizationID(s.authzID, s.dn); err != nil {
+		return nil, err
+	}
+
+	serverSignature := scramHMAC(h, s.verifier.ServerKey, authMessage)
+	return []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature)), nil
+}
+
+// lookupVerifier returns the SCRAM verifier of the entry which username is
+// mapped to and stores the DN of the entry in the session.
+func (s *saslSCRAMSession) lookupVerifier(username string) (*ldappassword.SCRAMVerifier, error) {
+	dn, err := s.server.mapAuthenticationID(username, s.conn)
+	if err != nil {
+		return nil, err
+	}
+	fnNames := []string{}
+	for k := range s.server.SCRAMProviderFns {
+		fnNames = append(fnNames, k)
+	}
+	fn := routeFunc(dn, fnNames)
+	provider := s.server.SCRAMProviderFns[fn]
+	if provider == nil {
+		return nil, fmt.Errorf("no SCRAM provider found for dn: '%s'", dn)
+	}
+	s.dn = dn
+	return provider.SCRAMVerifier(dn, s.mechanism, s.conn)
+}
+
+// unknownSCRAMVerifier returns a verifier for a username without one. Its
+// salt is derived from the username and its iteration count is the default,
+// so that it looks like the verifier of an existing user. No proof matches
+// its keys.
+func unknownSCRAMVerifier(mechanism, username string) *ldappassword.SCRAMVerifier {
+	h, err := ldappassword.SCRAMHash(mechanism)
+	if err != nil {
+		h = sha256.New
+	}
+	salt := scramHMAC(sha256.New, scramUnknownSaltKey, mechanism+"\x00"+username)[:16]
+	key := scramHMAC(h, scramUnknownSaltKey, "key\x00"+mechanism+"\x00"+username)
+	return &ldappassword.SCRAMVerifier{
+		Mechanism:  mechanism,
+		Salt:       salt,
+		Iterations: ldappassword.SCRAMDefaultIterations,
+		StoredKey:  key,
+		ServerKey:  key,
+	}
+}
+
+// decodeSASLName decodes a SCRAM saslname, where "," and "=" are encoded as
+// "=2C" and "=3D".
+func decodeSASLName(name string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '=' {
+			b.WriteByte(name[i])
+			continue
+		}
+		switch {
+		case strings.HasPrefix(name[i:], "=2C"):
+			b.WriteByte(',')
+		case strings.HasPrefix(name[i:], "=3D"):
+			b.WriteByte('=')
+		default:
+			return "", errors.New("invalid SCRAM saslname encoding")
+		}
+		i += 2
+	}
+	return b.String(), nil
+}
+
+func scramHMAC(h func() hash.Hash, key []byte, data string) []byte {
+	mac := hmac.New(h, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
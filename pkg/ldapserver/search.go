@@ -6,20 +6,31 @@
 package ldapserver
 
 import (
+	"context"
 	"errors"
 	"net"
 	"strings"
+	"time"
 
 	ber "github.com/go-asn1-ber/asn1-ber"
 	"github.com/go-ldap/ldap/v3"
+
+	"github.com/libregraph/idm/pkg/schema"
 )
 
-func HandleSearchRequest(req *ber.Packet, controls *[]ldap.Control, messageID int64, boundDN string, server *Server, conn net.Conn) (doneControls *[]ldap.Control, resultErr error) {
+func HandleSearchRequest(ctx context.Context, req *ber.Packet, controls *[]ldap.Control, messageID int64, boundDN string, server *Server, conn net.Conn) (doneControls *[]ldap.Control, resultErr error) {
 	searchReq, err := parseSearchRequest(boundDN, req, controls)
 	if err != nil {
 		return nil, ldap.NewError(ldap.LDAPResultOperationsError, err)
 	}
 
+	if isRootDSESearch(searchReq) {
+		return nil, HandleRootDSESearch(ctx, searchReq, messageID, server, conn)
+	}
+	if isSubschemaSearch(searchReq) {
+		return nil, HandleSubschemaSearch(ctx, searchReq, messageID, server, conn)
+	}
+
 	var filterPacket *ber.Packet
 	if server.EnforceLDAP {
 		filterPacket, err = ldap.CompileFilter(searchReq.Filter)
@@ -27,49 +38,108 @@ func HandleSearchRequest(req *ber.Packet, controls *[]ldap.Control, messageID in
 			return nil, ldap.NewError(ldap.LDAPResultOperationsError, err)
 		}
 	}
+	if vlvResponse, err := checkVLVRequest(searchReq); err != nil {
+		return &[]ldap.Control{vlvResponse}, err
+	}
 
-	fnNames := []string{}
-	for k := range server.SearchFns {
-		fnNames = append(fnNames, k)
+	if searchReq.TimeLimit > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, time.Duration(searchReq.TimeLimit)*time.Second, ErrTimeLimitExceeded)
+		defer cancel()
+	}
+
+	if control, ok := ldap.FindControl(searchReq.Controls, ldap.ControlTypeSyncRequest).(*ldap.ControlSyncRequest); ok {
+		if syncer := server.syncer(searchReq.BaseDN); syncer != nil {
+			return handleSyncSearch(ctx, syncer, searchReq, control, messageID, boundDN, server, conn)
+		}
+		if control.Criticality {
+			return nil, ldap.NewError(ldap.LDAPResultUnavailableCriticalExtension, errors.New("content synchronization not supported"))
+		}
+	}
+	if control, ok := ldap.FindControl(searchReq.Controls, ControlTypePersistentSearch).(*ControlPersistentSearch); ok {
+		if syncer := server.syncer(searchReq.BaseDN); syncer != nil {
+			return nil, handlePersistentSearch(ctx, syncer, searchReq, control, messageID, boundDN, server, conn)
+		}
+		if control.Criticality {
+			return nil, ldap.NewError(ldap.LDAPResultUnavailableCriticalExtension, errors.New("persistent search not supported"))
+		}
 	}
-	fn := routeFunc(searchReq.BaseDN, fnNames)
-	searchResp, err := server.SearchFns[fn].Search(boundDN, searchReq, conn)
+
+	if err = dereferenceSearchBase(ctx, server, boundDN, searchReq, conn); err != nil {
+		return nil, err
+	}
+	pagingControl, err := derefPagingControl(ctx, server, boundDN, searchReq, conn)
 	if err != nil {
-		return &searchResp.Controls, ldap.NewError(uint16(searchResp.ResultCode), err)
+		return nil, err
+	}
+	if pagingControl != nil && len(pagingControl.Cookie) > 0 {
+		entries, cookie, err := derefPagingsFromContext(ctx).next(searchReq, boundDN, string(pagingControl.Cookie), int(pagingControl.PagingSize))
+		if err != nil {
+			return nil, err
+		}
+		doneControls := []ldap.Control{&ldap.ControlPaging{Cookie: []byte(cookie)}}
+		return &doneControls, sendSearchEntries(ctx, server, searchReq, messageID, entries, nil, conn)
 	}
 
+	searchResp, err := server.searcher(searchReq.BaseDN).Search(ctx, boundDN, searchReq, conn)
+	if err == nil && ctx.Err() != nil {
+		err = context.Cause(ctx)
+		searchResp.ResultCode = ContextResultCode(ctx)
+	}
+	if err != nil {
+		if searchResp.ResultCode != ldap.LDAPResultTimeLimitExceeded {
+			return &searchResp.Controls, ldap.NewError(uint16(searchResp.ResultCode), err)
+		}
+		// Return the partial result of the search.
+		resultErr = ldap.NewError(ldap.LDAPResultTimeLimitExceeded, err)
+	}
 	if server.EnforceLDAP {
-		if searchReq.DerefAliases != ldap.NeverDerefAliases { // [-a {never|always|search|find}
-			// TODO: Server DerefAliases not supported: RFC4511 4.5.1.3
+		if searchResp.Entries, err = serverFilterEntries(server.Schema(), filterPacket, searchReq, searchResp.Entries); err != nil {
+			return &searchResp.Controls, err
 		}
-		if searchReq.TimeLimit > 0 {
-			// TODO: Server TimeLimit not implemented
+	}
+	if resultErr == nil {
+		if err = dereferenceSearchResult(ctx, server, boundDN, searchReq, &searchResp, filterPacket, conn); err != nil {
+			if !ldap.IsErrorWithCode(err, ldap.LDAPResultTimeLimitExceeded) {
+				return &searchResp.Controls, err
+			}
+			resultErr = err
 		}
 	}
+	if resultErr == nil {
+		if err = sortSearchResult(server, searchReq, &searchResp); err != nil {
+			return &searchResp.Controls, err
+		}
+		if err = vlvSearchResult(server, searchReq, &searchResp); err != nil {
+			return &searchResp.Controls, err
+		}
+		if pagingControl != nil {
+			var cookie string
+			if searchResp.Entries, cookie, err = derefPagingsFromContext(ctx).start(searchReq, boundDN, searchResp.Entries, int(pagingControl.PagingSize)); err != nil {
+				return &searchResp.Controls, err
+			}
+			searchResp.Controls = append(searchResp.Controls, &ldap.ControlPaging{Cookie: []byte(cookie)})
+		}
+	}
+	return &searchResp.Controls, sendSearchEntries(ctx, server, searchReq, messageID, searchResp.Entries, resultErr, conn)
+}
 
+// sendSearchEntries sends the entries of the result of searchReq. resultErr
+// is the error of a search which exceeded its time limit, whose partial
+// result is sent anyway. It returns the result of the search.
+func sendSearchEntries(ctx context.Context, server *Server, searchReq *ldap.SearchRequest, messageID int64, entries []*ldap.Entry, resultErr error, conn net.Conn) error {
 	i := 0
-	for _, entry := range searchResp.Entries {
+	for _, entry := range entries {
+		// Stop sending entries when the operation was abandoned, canceled or
+		// exceeded its time limit, unless this is the partial result of a
+		// search which exceeded its time limit.
+		if ctx.Err() != nil && resultErr == nil {
+			return ldap.NewError(uint16(ContextResultCode(ctx)), context.Cause(ctx))
+		}
 		if server.EnforceLDAP {
-			// filter
-			keep, resultCode := ServerApplyFilter(filterPacket, entry)
-			if resultCode != ldap.LDAPResultSuccess {
-				return &searchResp.Controls, ldap.NewError(uint16(resultCode), errors.New("ServerApplyFilter error"))
-			}
-			if !keep {
-				continue
-			}
-
-			keep, resultCode = ServerFilterScope(searchReq.BaseDN, searchReq.Scope, entry)
-			if resultCode != ldap.LDAPResultSuccess {
-				return &searchResp.Controls, ldap.NewError(uint16(resultCode), errors.New("ServerApplyScope error"))
-			}
-			if !keep {
-				continue
-			}
-
-			resultCode, err = ServerFilterAttributes(searchReq.Attributes, entry)
+			resultCode, err := ServerFilterAttributesWithSchema(server.Schema(), searchReq.Attributes, entry)
 			if err != nil {
-				return &searchResp.Controls, ldap.NewError(uint16(resultCode), err)
+				return ldap.NewError(uint16(resultCode), err)
 			}
 
 			// size limit
@@ -85,11 +155,87 @@ func HandleSearchRequest(req *ber.Packet, controls *[]ldap.Control, messageID in
 
 		// respond
 		responsePacket := encodeSearchResponse(messageID, searchReq, entry)
-		if err = sendPacket(conn, responsePacket); err != nil {
-			return &searchResp.Controls, ldap.NewError(ldap.LDAPResultOperationsError, err)
+		if err := sendPacket(conn, responsePacket); err != nil {
+			return ldap.NewError(ldap.LDAPResultOperationsError, err)
 		}
 	}
-	return &searchResp.Controls, resultErr
+	return resultErr
+}
+
+// ErrTimeLimitExceeded is the cause of the context of a search which
+// exceeded its time limit.
+var ErrTimeLimitExceeded = errors.New(ldap.LDAPResultCodeMap[ldap.LDAPResultTimeLimitExceeded])
+
+// ContextResultCode returns the result code of an operation which ended
+// because ctx is done. This is timeLimitExceeded for searches which exceeded
+// their time limit and canceled otherwise.
+func ContextResultCode(ctx context.Context) LDAPResultCode {
+	if errors.Is(context.Cause(ctx), ErrTimeLimitExceeded) {
+		return ldap.LDAPResultTimeLimitExceeded
+	}
+	return ldap.LDAPResultCanceled
+}
+
+// searcher returns the search handler for dn.
+func (server *Server) searcher(dn string) Searcher {
+	fnNames := []string{}
+	for k := range server.SearchFns {
+		fnNames = append(fnNames, k)
+	}
+	return server.SearchFns[routeFunc(dn, fnNames)]
+}
+
+// serverFilterEntries returns the entries which match the filter and the
+// scope of searchReq.
+func serverFilterEntries(s *schema.Schema, filterPacket *ber.Packet, searchReq *ldap.SearchRequest, entries []*ldap.Entry) ([]*ldap.Entry, error) {
+	filtered := make([]*ldap.Entry, 0, len(entries))
+	for _, entry := range entries {
+		keep, err := serverFilterEntry(s, filterPacket, searchReq, entry)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered, nil
+}
+
+// serverFilterEntry returns true if entry matches the filter and the scope of
+// searchReq.
+func serverFilterEntry(s *schema.Schema, filterPacket *ber.Packet, searchReq *ldap.SearchRequest, entry *ldap.Entry) (bool, error) {
+	keep, resultCode := ServerApplyFilterWithSchema(s, filterPacket, entry)
+	if resultCode != ldap.LDAPResultSuccess {
+		return false, ldap.NewError(uint16(resultCode), errors.New("ServerApplyFilter error"))
+	}
+	if !keep {
+		return false, nil
+	}
+
+	keep, resultCode = ServerFilterScope(searchReq.BaseDN, searchReq.Scope, entry)
+	if resultCode != ldap.LDAPResultSuccess {
+		return false, ldap.NewError(uint16(resultCode), errors.New("ServerApplyScope error"))
+	}
+	return keep, nil
+}
+
+// sortSearchResult sorts the entries of resp if searchReq has a server side
+// sort control which was not handled by the search handler.
+func sortSearchResult(server *Server, searchReq *ldap.SearchRequest, resp *ServerSearchResult) error {
+	control, ok := ldap.FindControl(searchReq.Controls, ldap.ControlTypeServerSideSorting).(*ControlServerSideSorting)
+	if !ok || ldap.FindControl(resp.Controls, ldap.ControlTypeServerSideSortingResult) != nil {
+		return nil
+	}
+	sorter, result := NewEntrySorter(server.Schema(), control)
+	resp.Controls = append(resp.Controls, result)
+	if sorter == nil {
+		if control.Criticality {
+			return ldap.NewError(ldap.LDAPResultUnavailableCriticalExtension, errors.New("unable to sort search result"))
+		}
+		return nil
+	}
+	sorter.Sort(resp.Entries)
+	return nil
 }
 
 func parseSearchRequest(boundDN string, req *ber.Packet, controls *[]ldap.Control) (*ldap.SearchRequest, error) {
@@ -156,38 +302,65 @@ func parseSearchRequest(boundDN string, req *ber.Packet, controls *[]ldap.Contro
 	return searchReq, nil
 }
 
-func filterAttributes(entry *ldap.Entry, attributes []string) (*ldap.Entry, error) {
-	// Only return requested attributes.
-	newAttributes := []*ldap.EntryAttribute{}
+// selectAttributes returns the attributes selected by the requested attribute
+// list (RFC 4511 4.5.1.8). User attributes are returned for an empty list or
+// "*", operational attributes when named or with "+" (RFC 3673). Attributes
+// are classified as operational by s.
+func selectAttributes(s *schema.Schema, attributes []*ldap.EntryAttribute, requested []string) []*ldap.EntryAttribute {
+	allUser := len(requested) == 0
+	allOperational := false
+	for _, name := range requested {
+		switch name {
+		case "*":
+			allUser = true
+		case "+":
+			allOperational = true
+		}
+	}
 
-	for _, attr := range entry.Attributes {
-		for _, requested := range attributes {
-			if requested == "*" || strings.EqualFold(attr.Name, requested) {
-				newAttributes = append(newAttributes, attr)
+	selected := []*ldap.EntryAttribute{}
+	for _, attr := range attributes {
+		keep := allUser
+		if a, ok := s.AttributeType(attr.Name); ok && a.IsOperational() {
+			keep = allOperational
+		}
+		for _, name := range requested {
+			if strings.EqualFold(attr.Name, name) {
+				keep = true
 			}
 		}
+		if keep {
+			selected = append(selected, attr)
+		}
 	}
-	entry.Attributes = newAttributes
-
-	return entry, nil
+	return selected
 }
 
 func encodeSearchResponse(messageID int64, req *ldap.SearchRequest, res *ldap.Entry) *ber.Packet {
 	responsePacket := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
 	responsePacket.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	responsePacket.AppendChild(encodeSearchResultEntry(res, req.TypesOnly))
 
+	return responsePacket
+}
+
+// encodeSearchResultEntry encodes the SearchResultEntry of res. With
+// typesOnly only the attribute descriptions are encoded.
+func encodeSearchResultEntry(res *ldap.Entry, typesOnly bool) *ber.Packet {
 	searchEntry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
 	searchEntry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, res.DN, "Object Name"))
 
 	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes: ")
 	for _, attribute := range res.Attributes {
+		if typesOnly {
+			attrs.AppendChild(encodeSearchAttribute(attribute.Name, nil))
+			continue
+		}
 		attrs.AppendChild(encodeSearchAttribute(attribute.Name, attribute.Values))
 	}
 
 	searchEntry.AppendChild(attrs)
-	responsePacket.AppendChild(searchEntry)
-
-	return responsePacket
+	return searchEntry
 }
 
 func encodeSearchAttribute(name string, values []string) *ber.Packet {
@@ -6,12 +6,15 @@
 package ldapserver
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"io"
 	"log"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	ber "github.com/go-asn1-ber/asn1-ber"
 	"github.com/go-ldap/ldap/v3"
@@ -19,34 +22,55 @@ import (
 	"github.com/go-logr/stdr"
 
 	"github.com/libregraph/idm/pkg/ldapdn"
+	"github.com/libregraph/idm/pkg/ldappassword"
+	"github.com/libregraph/idm/pkg/schema"
 )
 
 type Adder interface {
-	Add(boundDN string, req *ldap.AddRequest, conn net.Conn) (LDAPResultCode, error)
+	Add(ctx context.Context, boundDN string, req *ldap.AddRequest, conn net.Conn) (LDAPResultCode, error)
 }
 
 type Binder interface {
 	Bind(bindDN, bindSimplePw string, conn net.Conn) (LDAPResultCode, error)
 }
 
+type Comparer interface {
+	Compare(ctx context.Context, boundDN string, req *ldap.CompareRequest, conn net.Conn) (LDAPResultCode, error)
+}
+
 type Deleter interface {
-	Delete(boundDN string, req *ldap.DelRequest, conn net.Conn) (LDAPResultCode, error)
+	Delete(ctx context.Context, boundDN string, req *ldap.DelRequest, conn net.Conn) (LDAPResultCode, error)
 }
 
 type Modifier interface {
-	Modify(boundDN string, req *ldap.ModifyRequest, conn net.Conn) (LDAPResultCode, error)
+	Modify(ctx context.Context, boundDN string, req *ldap.ModifyRequest, conn net.Conn) (LDAPResultCode, error)
 }
 
 type PasswordUpdater interface {
-	ModifyPasswordExop(boundDN string, req *ldap.PasswordModifyRequest, conn net.Conn) (LDAPResultCode, error)
+	ModifyPasswordExop(ctx context.Context, boundDN string, req *ldap.PasswordModifyRequest, conn net.Conn) (LDAPResultCode, error)
 }
 
 type Renamer interface {
-	ModifyDN(boundDN string, req *ldap.ModifyDNRequest, conn net.Conn) (LDAPResultCode, error)
+	ModifyDN(ctx context.Context, boundDN string, req *ldap.ModifyDNRequest, conn net.Conn) (LDAPResultCode, error)
 }
 
 type Searcher interface {
-	Search(boundDN string, req *ldap.SearchRequest, conn net.Conn) (ServerSearchResult, error)
+	Search(ctx context.Context, boundDN string, req *ldap.SearchRequest, conn net.Conn) (ServerSearchResult, error)
+}
+
+// IdentityMapper is implemented by handlers which can map an authentication
+// identity, which is not a DN (e.g. a TLS client certificate), to a directory
+// entry. The search request describes the entry to look up, an empty BaseDN
+// means the base DN of the handler. It returns the DN of the single matching
+// entry or an error.
+type IdentityMapper interface {
+	MapIdentity(req *ldap.SearchRequest, conn net.Conn) (string, error)
+}
+
+// SCRAMProvider is implemented by handlers which can provide the SCRAM
+// verifier of an entry for SASL SCRAM binds.
+type SCRAMProvider interface {
+	SCRAMVerifier(dn, mechanism string, conn net.Conn) (*ldappassword.SCRAMVerifier, error)
 }
 
 type Closer interface {
@@ -58,16 +82,38 @@ var logger logr.Logger = stdr.New(log.Default())
 type Server struct {
 	AddFns                  map[string]Adder
 	BindFns                 map[string]Binder
+	CompareFns              map[string]Comparer
 	DeleteFns               map[string]Deleter
 	ModifyFns               map[string]Modifier
 	ModifyDNFns             map[string]Renamer
 	PasswordExOpFns         map[string]PasswordUpdater
 	SearchFns               map[string]Searcher
+	SyncFns                 map[string]Syncer
+	TransactionFns          map[string]Transactor
+	IdentityMapperFns       map[string]IdentityMapper
+	SCRAMProviderFns        map[string]SCRAMProvider
 	CloseFns                map[string]Closer
 	Quit                    chan bool
 	EnforceLDAP             bool
 	GeneratedPasswordLength int
 	Stats                   *Stats
+	TLSConfig               *tls.Config
+	RequireTLSForBind       bool
+	SASLExternalMapping     string
+	SASLUsernameAttribute   string
+	MaxConcurrentOperations int
+	// NamingContexts are advertised in the Root DSE in addition to the base
+	// DNs of the registered search handlers.
+	NamingContexts []string
+	// SupportedControls are the OIDs of the controls advertised in the Root
+	// DSE.
+	SupportedControls []string
+	// ProxyAuthorization decides which identities may act as other
+	// identities with the proxied authorization control. The control is not
+	// supported if it is nil.
+	ProxyAuthorization ProxyAuthorizationRule
+
+	schema atomic.Pointer[schema.Schema]
 }
 
 type ServerSearchResult struct {
@@ -84,16 +130,26 @@ func NewServer() *Server {
 	d := defaultHandler{}
 	s.AddFns = make(map[string]Adder)
 	s.BindFns = make(map[string]Binder)
+	s.CompareFns = make(map[string]Comparer)
 	s.DeleteFns = make(map[string]Deleter)
 	s.ModifyFns = make(map[string]Modifier)
 	s.ModifyDNFns = make(map[string]Renamer)
 	s.PasswordExOpFns = make(map[string]PasswordUpdater)
 	s.SearchFns = make(map[string]Searcher)
+	s.SyncFns = make(map[string]Syncer)
+	s.TransactionFns = make(map[string]Transactor)
+	s.IdentityMapperFns = make(map[string]IdentityMapper)
+	s.SCRAMProviderFns = make(map[string]SCRAMProvider)
 	s.CloseFns = make(map[string]Closer)
 	s.BindFunc("", d)
 	s.SearchFunc("", d)
 	s.CloseFunc("", d)
 	s.GeneratedPasswordLength = 16
+	s.SASLExternalMapping = SASLExternalMappingDN
+	s.SASLUsernameAttribute = "uid"
+	s.MaxConcurrentOperations = DefaultMaxConcurrentOperations
+	s.SupportedControls = []string{ldap.ControlTypeServerSideSorting, ldap.ControlTypeVLVRequest}
+	s.SetSchema(schema.New())
 	s.Stats = nil
 	return s
 }
@@ -110,6 +166,10 @@ func (server *Server) BindFunc(baseDN string, f Binder) {
 	server.BindFns[baseDN] = f
 }
 
+func (server *Server) CompareFunc(baseDN string, f Comparer) {
+	server.CompareFns[baseDN] = f
+}
+
 func (server *Server) DeleteFunc(baseDN string, f Deleter) {
 	server.DeleteFns[baseDN] = f
 }
@@ -130,22 +190,46 @@ func (server *Server) SearchFunc(baseDN string, f Searcher) {
 	server.SearchFns[baseDN] = f
 }
 
+func (server *Server) IdentityMapperFunc(baseDN string, f IdentityMapper) {
+	server.IdentityMapperFns[baseDN] = f
+}
+
+func (server *Server) SCRAMProviderFunc(baseDN string, f SCRAMProvider) {
+	server.SCRAMProviderFns[baseDN] = f
+}
+
 func (server *Server) CloseFunc(baseDN string, f Closer) {
 	server.CloseFns[baseDN] = f
 }
 
+// Schema returns the schema of the server.
+func (server *Server) Schema() *schema.Schema {
+	return server.schema.Load()
+}
+
+// SetSchema replaces the schema of the server. It is safe to call while the
+// server is running.
+func (server *Server) SetSchema(s *schema.Schema) {
+	server.schema.Store(s)
+}
+
 func (server *Server) QuitChannel(quit chan bool) {
 	server.Quit = quit
 }
 
+// ListenAndServeTLS listens for LDAPS connections. If the server has a
+// TLSConfig, it is used and certFile and keyFile are ignored.
 func (server *Server) ListenAndServeTLS(listenString string, certFile string, keyFile string) error {
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-	if err != nil {
-		return err
+	tlsConfig := server.TLSConfig
+	if tlsConfig == nil {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		tlsConfig.ServerName = "localhost"
 	}
-	tlsConfig := tls.Config{Certificates: []tls.Certificate{cert}}
-	tlsConfig.ServerName = "localhost"
-	ln, err := tls.Listen("tcp", listenString, &tlsConfig)
+	ln, err := tls.Listen("tcp", listenString, tlsConfig)
 	if err != nil {
 		return err
 	}
@@ -210,14 +294,150 @@ listener:
 	return nil
 }
 
+// maxQueuedRequests is the number of requests of a connection which are read
+// ahead while waiting for a free slot, so that abandon and cancel requests are
+// seen while other operations are processed.
+const maxQueuedRequests = 64
+
+// maxConcurrentCancels is the number of cancel requests of a connection which
+// are processed besides the other operations, as they wait for the canceled
+// operation.
+const maxConcurrentCancels = 4
+
+// DefaultMaxConcurrentOperations is the default number of operations which
+// are processed concurrently for a single connection.
+const DefaultMaxConcurrentOperations = 16
+
+// connState is the state of a client connection. The bound DN is only changed
+// by Bind, which is processed when no other operation is outstanding.
+type connState struct {
+	conn      *serverConn
+	boundDN   string // "" == anonymous
+	saslState *SASLBindState
+	ops       *operations
+}
+
+// serverConn serializes the writes of concurrently processed operations to a
+// client connection, so that every LDAP message is written as a whole. The
+// underlying connection is replaced by StartTLS.
+type serverConn struct {
+	net.Conn
+	writeMutex sync.Mutex
+}
+
+func (c *serverConn) Write(b []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.Conn.Write(b)
+}
+
+// NetConn returns the underlying connection.
+func (c *serverConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *serverConn) replace(conn net.Conn) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.Conn = conn
+}
+
+// request is a single LDAP request of a connection. controlErr is set if
+// one of its critical controls could not be decoded.
+type request struct {
+	ctx        context.Context
+	messageID  int64
+	req        *ber.Packet
+	controls   []ldap.Control
+	controlErr error
+}
+
+// responseTypes maps the requests which have a response to the application
+// tags of their final responses.
+var responseTypes = map[ber.Tag]uint8{
+	ldap.ApplicationAddRequest:      ldap.ApplicationAddResponse,
+	ldap.ApplicationBindRequest:     ldap.ApplicationBindResponse,
+	ldap.ApplicationCompareRequest:  ldap.ApplicationCompareResponse,
+	ldap.ApplicationDelRequest:      ldap.ApplicationDelResponse,
+	ldap.ApplicationExtendedRequest: ldap.ApplicationExtendedResponse,
+	ldap.ApplicationModifyDNRequest: ldap.ApplicationModifyDNResponse,
+	ldap.ApplicationModifyRequest:   ldap.ApplicationModifyResponse,
+	ldap.ApplicationSearchRequest:   ldap.ApplicationSearchResultDone,
+}
+
+// isBarrierRequest returns true for requests which must be processed while no
+// other operation is outstanding on the connection (RFC 4511 4.2.1 and
+// 4.14.1).
+func isBarrierRequest(req *ber.Packet) bool {
+	return req.Tag == ldap.ApplicationBindRequest || extendedRequestOID(req) == startTLSOID
+}
+
 func (server *Server) handleConnection(conn net.Conn) {
-	boundDN := "" // "" == anonymous
+	ops := newOperations()
+	ctx, cancel := context.WithCancel(withDerefPagings(withTransactions(withOperations(context.Background(), ops), newTransactions()), newDerefPagings()))
+	defer cancel()
+
+	state := &connState{
+		conn:      &serverConn{Conn: conn},
+		saslState: &SASLBindState{},
+		ops:       ops,
+	}
+
+	maxConcurrent := server.MaxConcurrentOperations
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	var wg sync.WaitGroup
+	var closeOnce sync.Once
+	process := func(r *request) {
+		defer wg.Done()
+		if !server.handleRequest(state, r) {
+			// Stop reading, which ends the connection.
+			closeOnce.Do(func() {
+				state.conn.Close()
+			})
+		}
+		ops.finish(r.messageID)
+	}
+
+	// busy answers a request which cannot be queued, so that the reader does
+	// not block. It returns false if the connection must be closed.
+	busy := func(r *request) bool {
+		defer wg.Done()
+		defer ops.finish(r.messageID)
+		responseType, ok := responseTypes[r.req.Tag]
+		if !ok {
+			return false
+		}
+		logger.V(1).Info("Too many outstanding operations", "message_id", r.messageID)
+		_, err := server.sendResponse(state, r, encodeResultResponse(r.messageID, responseType, ldap.NewError(ldap.LDAPResultBusy, errors.New("too many outstanding operations"))))
+		return err == nil
+	}
 
-handler:
+	// Queued requests are started in the order they were received as soon as
+	// one of the concurrency slots is free.
+	queue := make(chan *request, maxQueuedRequests)
+	cancels := make(chan struct{}, maxConcurrentCancels)
+	dispatched := make(chan struct{})
+	go func() {
+		defer close(dispatched)
+		active := make(chan struct{}, maxConcurrent)
+		for r := range queue {
+			active <- struct{}{}
+			go func() {
+				defer func() {
+					<-active
+				}()
+				process(r)
+			}()
+		}
+	}()
+
+reader:
 	for {
 		// Read incoming LDAP packet.
-		packet, err := ber.ReadPacket(conn)
-		if err == io.EOF || err == io.ErrUnexpectedEOF { // Client closed connection.
+		packet, err := ber.ReadPacket(state.conn)
+		if err == io.EOF || err == io.ErrUnexpectedEOF || errors.Is(err, net.ErrClosed) { // Client closed connection.
 			break
 		} else if err != nil {
 			logger.Error(err, "handleConnection ber.ReadPacket")
@@ -240,13 +460,18 @@ handler:
 			logger.V(1).Info("req.ClassType != ber.ClassApplication")
 			break
 		}
-		// Handle controls if present.
+		// Handle controls if present. Malformed critical controls fail the
+		// request, others are ignored.
 		controls := []ldap.Control{}
+		var controlErr error
 		if len(packet.Children) > 2 {
 			for _, child := range packet.Children[2].Children {
-				c, err := ldap.DecodeControl(child)
+				c, err := decodeControl(child)
 				if err != nil {
 					logger.Error(err, "handleConnection decode control")
+					if controlErr == nil && isCriticalControl(child) {
+						controlErr = ldap.NewError(ldap.LDAPResultProtocolError, err)
+					}
 					continue
 				}
 				controls = append(controls, c)
@@ -256,186 +481,282 @@ handler:
 		// log.Printf("DEBUG: handling operation: %s [%d]", ldap.ApplicationMap[uint8(req.Tag)], req.Tag)
 		// ber.PrintPacket(packet) // DEBUG
 
-		// Dispatch the LDAP operation.
-		switch req.Tag { // LDAP op code.
-		default:
-			op, ok := ldap.ApplicationMap[uint8(req.Tag)]
-			if !ok {
-				op = "unknown"
+		// Abandon and unbind are handled right away, they have no response.
+		switch req.Tag {
+		case ldap.ApplicationAbandonRequest:
+			abandonID, err := parseAbandonRequest(req)
+			if err != nil {
+				logger.V(1).Info("malformed abandon request", "error", err.Error())
+				break reader
 			}
+			logger.V(1).Info("Abandon operation", "message_id", abandonID)
+			ops.abandon(abandonID)
+			continue
+		case ldap.ApplicationUnbindRequest:
+			server.Stats.countUnbinds(1)
+			break reader // Simply disconnect.
+		}
 
-			logger.V(1).Info("Unhandled operation", "type", op, "tag", req.Tag)
-			break handler
+		if isBarrierRequest(req) {
+			// Wait for all outstanding operations and process the request
+			// before reading the next one. Persistent searches never
+			// complete, so they are abandoned (RFC 4511 4.2.1).
+			ops.abandonPersistent()
+			wg.Wait()
+		}
 
-		case ldap.ApplicationAddRequest:
-			server.Stats.countAdds(1)
+		opCtx, ok := ops.start(ctx, messageID, isCancelableRequest(req), isPersistentRequest(req, controls))
+		if !ok {
+			logger.V(1).Info("Duplicate message ID of outstanding operation", "message_id", messageID)
+			break
+		}
+		r := &request{
+			ctx:        opCtx,
+			messageID:  messageID,
+			req:        req,
+			controls:   controls,
+			controlErr: controlErr,
+		}
 
-			resultCode := uint16(ldap.LDAPResultSuccess)
-			resultMsg := ""
-			if err = HandleAddRequest(req, boundDN, server, conn); err != nil {
-				var lErr *ldap.Error
-				if errors.As(err, &lErr) {
-					resultCode = lErr.ResultCode
-					if lErr.Err != nil {
-						resultMsg = lErr.Err.Error()
-					}
-				} else {
-					resultCode = ldap.LDAPResultOperationsError
-					resultMsg = err.Error()
+		wg.Add(1)
+		switch {
+		case isBarrierRequest(req):
+			process(r)
+
+		case extendedRequestOID(req) == cancelOID:
+			// Cancel waits for the canceled operation, so it must not wait
+			// for a free slot behind it.
+			select {
+			case cancels <- struct{}{}:
+				go func() {
+					defer func() {
+						<-cancels
+					}()
+					process(r)
+				}()
+			default:
+				if !busy(r) {
+					break reader
 				}
 			}
 
-			responsePacket := encodeLDAPResponse(messageID, ldap.ApplicationAddResponse, LDAPResultCode(resultCode), resultMsg)
-			if err = sendPacket(conn, responsePacket); err != nil {
-				logger.Error(err, "sendPacket error")
-				break handler
+		default:
+			select {
+			case queue <- r:
+			default:
+				if !busy(r) {
+					break reader
+				}
 			}
+		}
+	}
 
-		case ldap.ApplicationBindRequest:
-			server.Stats.countBinds(1)
-			ldapResultCode := HandleBindRequest(req, server.BindFns, conn)
-			if ldapResultCode == ldap.LDAPResultSuccess {
-				boundDN, ok = req.Children[1].Value.(string)
-				if !ok {
-					logger.V(1).Info("Malformed Bind DN")
-					break handler
-				}
-				if boundDN, err = ldapdn.ParseNormalize(boundDN); err != nil {
-					logger.V(1).Info("Error normalizing Bind DN", "error", err.Error())
-					break handler
-				}
+	// Abandon all outstanding operations.
+	cancel()
+	close(queue)
+	<-dispatched
+	wg.Wait()
 
-			}
-			responsePacket := encodeBindResponse(messageID, ldapResultCode)
-			if err = sendPacket(conn, responsePacket); err != nil {
-				logger.Error(err, "sendPacket error")
-				break handler
-			}
+	for _, c := range server.CloseFns {
+		c.Close(state.boundDN, state.conn)
+	}
 
-		case ldap.ApplicationCompareRequest:
-			responsePacket := encodeLDAPResponse(messageID, ldap.ApplicationCompareRequest, ldap.LDAPResultOperationsError, "Unsupported operation: compare")
-			if err = sendPacket(conn, responsePacket); err != nil {
-				logger.Error(err, "sendPacket error")
-			}
-			logger.V(1).Info("Unhandled operation", "type", ldap.ApplicationMap[uint8(req.Tag)], "tag", req.Tag)
-			break handler
-
-		case ldap.ApplicationDelRequest:
-			server.Stats.countDeletes(1)
-			resultCode := uint16(ldap.LDAPResultSuccess)
-			resultMsg := ""
-			if err = HandleDeleteRequest(req, boundDN, server, conn); err != nil {
-				var lErr *ldap.Error
-				if errors.As(err, &lErr) {
-					resultCode = lErr.ResultCode
-					if lErr.Err != nil {
-						resultMsg = lErr.Err.Error()
-					}
-				} else {
-					resultCode = ldap.LDAPResultOperationsError
-					resultMsg = err.Error()
-				}
-			}
+	state.conn.Close()
+	server.Stats.countConnsClose(1)
+}
 
-			responsePacket := encodeLDAPResponse(messageID, ldap.ApplicationDelResponse, LDAPResultCode(resultCode), resultMsg)
-			if err = sendPacket(conn, responsePacket); err != nil {
-				logger.Error(err, "sendPacket error")
-				break handler
-			}
+// handleRequest processes a single request and sends the response. It returns
+// false if the connection must be closed.
+func (server *Server) handleRequest(state *connState, r *request) bool {
+	var err error
+	conn := state.conn
+	messageID := r.messageID
+	req := r.req
 
-		case ldap.ApplicationExtendedRequest:
-			resultCode := uint16(ldap.LDAPResultSuccess)
-			resultMsg := ""
-			var innerBer, responsePacket *ber.Packet
-			if innerBer, err = HandleExtendedRequest(req, boundDN, server, conn); err != nil {
-				resultCode = ldap.LDAPResultOperationsError
-				resultMsg = err.Error()
-				responsePacket = encodeLDAPResponse(messageID, ldap.ApplicationExtendedResponse, LDAPResultCode(resultCode), resultMsg)
-			} else {
-				responsePacket = ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
-				responsePacket.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
-				responsePacket.AppendChild(innerBer)
-			}
+	// Requests with a malformed critical control are not processed (RFC 4511
+	// 4.1.11).
+	if responseType, ok := responseTypes[req.Tag]; ok && r.controlErr != nil {
+		if req.Tag == ldap.ApplicationBindRequest {
+			state.saslState.Reset()
+		}
+		_, err = server.sendResponse(state, r, encodeResultResponse(messageID, responseType, r.controlErr))
+		return err == nil
+	}
 
-			if err = sendPacket(conn, responsePacket); err != nil {
-				logger.Error(err, "sendPacket error")
-				break handler
-			}
+	// Operations with the proxied authorization control are processed with
+	// the asserted identity.
+	boundDN := state.boundDN
+	if responseType, ok := responseTypes[req.Tag]; ok && req.Tag != ldap.ApplicationBindRequest {
+		if r.ctx, boundDN, err = server.proxiedAuthorization(r.ctx, r.controls, boundDN, conn); err != nil {
+			_, err = server.sendResponse(state, r, encodeResultResponse(messageID, responseType, err))
+			return err == nil
+		}
+	}
 
-		case ldap.ApplicationModifyDNRequest:
-			server.Stats.countModifyDNs(1)
-			resultCode := uint16(ldap.LDAPResultSuccess)
-			resultMsg := ""
-			if err = HandleModifyDNRequest(req, boundDN, server, conn); err != nil {
-				var lErr *ldap.Error
-				if errors.As(err, &lErr) {
-					resultCode = lErr.ResultCode
-					if lErr.Err != nil {
-						resultMsg = lErr.Err.Error()
-					}
-				} else {
-					resultCode = ldap.LDAPResultOperationsError
-					resultMsg = err.Error()
-				}
-			}
-			responsePacket := encodeLDAPResponse(messageID, ldap.ApplicationModifyDNResponse, LDAPResultCode(resultCode), resultMsg)
-			if err = sendPacket(conn, responsePacket); err != nil {
-				logger.Error(err, "sendPacket error")
-				break handler
-			}
+	// Dispatch the LDAP operation.
+	var responsePacket *ber.Packet
+	switch req.Tag { // LDAP op code.
+	default:
+		op, ok := ldap.ApplicationMap[uint8(req.Tag)]
+		if !ok {
+			op = "unknown"
+		}
 
-		case ldap.ApplicationModifyRequest:
-			server.Stats.countModifies(1)
-			resultCode := uint16(ldap.LDAPResultSuccess)
-			resultMsg := ""
-			if err = HandleModifyRequest(req, boundDN, server, conn); err != nil {
-				var lErr *ldap.Error
-				if errors.As(err, &lErr) {
-					resultCode = lErr.ResultCode
-					if lErr.Err != nil {
-						resultMsg = lErr.Err.Error()
-					}
-				} else {
-					resultCode = ldap.LDAPResultOperationsError
-					resultMsg = err.Error()
-				}
+		logger.V(1).Info("Unhandled operation", "type", op, "tag", req.Tag)
+		return false
+
+	case ldap.ApplicationAddRequest:
+		server.Stats.countAdds(1)
+		responseControls, err := HandleAddRequest(r.ctx, req, r.controls, messageID, boundDN, server, conn)
+		responsePacket = appendResponseControls(encodeResultResponse(messageID, ldap.ApplicationAddResponse, err), responseControls)
+
+	case ldap.ApplicationBindRequest:
+		server.Stats.countBinds(1)
+		var ldapResultCode LDAPResultCode
+		var bindDN string
+		var serverSaslCreds []byte
+		if server.RequireTLSForBind && !isTLSConn(conn) && !isAnonymousBindRequest(req) {
+			logger.V(1).Info("Rejecting bind over cleartext connection", "remote_addr", conn.RemoteAddr().String())
+			ldapResultCode = ldap.LDAPResultConfidentialityRequired
+			state.saslState.Reset()
+		} else {
+			ldapResultCode, bindDN, serverSaslCreds = HandleBindRequest(req, server, conn, state.saslState)
+		}
+		if ldapResultCode == ldap.LDAPResultSuccess {
+			if state.boundDN, err = ldapdn.ParseNormalize(bindDN); err != nil {
+				logger.V(1).Info("Error normalizing Bind DN", "error", err.Error())
+				return false
 			}
-			responsePacket := encodeLDAPResponse(messageID, ldap.ApplicationModifyResponse, LDAPResultCode(resultCode), resultMsg)
-			if err = sendPacket(conn, responsePacket); err != nil {
-				logger.Error(err, "sendPacket error")
-				break handler
+		}
+		responsePacket = encodeBindResponse(messageID, ldapResultCode, serverSaslCreds)
+
+	case ldap.ApplicationCompareRequest:
+		server.Stats.countCompares(1)
+		err = HandleCompareRequest(r.ctx, req, boundDN, server, conn)
+		responsePacket = encodeResultResponse(messageID, ldap.ApplicationCompareResponse, err)
+
+	case ldap.ApplicationDelRequest:
+		server.Stats.countDeletes(1)
+		responseControls, err := HandleDeleteRequest(r.ctx, req, r.controls, messageID, boundDN, server, conn)
+		responsePacket = appendResponseControls(encodeResultResponse(messageID, ldap.ApplicationDelResponse, err), responseControls)
+
+	case ldap.ApplicationExtendedRequest:
+		responsePacket = server.handleExtendedRequest(r, boundDN, conn)
+
+	case ldap.ApplicationModifyDNRequest:
+		server.Stats.countModifyDNs(1)
+		responseControls, err := HandleModifyDNRequest(r.ctx, req, r.controls, messageID, boundDN, server, conn)
+		responsePacket = appendResponseControls(encodeResultResponse(messageID, ldap.ApplicationModifyDNResponse, err), responseControls)
+
+	case ldap.ApplicationModifyRequest:
+		server.Stats.countModifies(1)
+		responseControls, err := HandleModifyRequest(r.ctx, req, r.controls, messageID, boundDN, server, conn)
+		responsePacket = appendResponseControls(encodeResultResponse(messageID, ldap.ApplicationModifyResponse, err), responseControls)
+
+	case ldap.ApplicationSearchRequest:
+		server.Stats.countSearches(1)
+		controls := r.controls
+		if doneControls, err := HandleSearchRequest(r.ctx, req, &controls, messageID, boundDN, server, conn); err != nil {
+			// TODO: make this more testable/better err handling - stop using log, stop using breaks?
+			logger.V(1).Info("handleSearchRequest", "error", err.Error())
+			e := err.(*ldap.Error)
+			sent, err := server.sendResponse(state, r, encodeSearchDone(messageID, LDAPResultCode(e.ResultCode), doneControls))
+			if err != nil {
+				return false
 			}
-
-		case ldap.ApplicationSearchRequest:
-			server.Stats.countSearches(1)
-			if doneControls, err := HandleSearchRequest(req, &controls, messageID, boundDN, server, conn); err != nil {
-				// TODO: make this more testable/better err handling - stop using log, stop using breaks?
-				logger.V(1).Info("handleSearchRequest", "error", err.Error())
-				e := err.(*ldap.Error)
-				if err = sendPacket(conn, encodeSearchDone(messageID, LDAPResultCode(e.ResultCode), doneControls)); err != nil {
-					logger.Error(err, "sendPacket error")
-					break handler
-				}
-				break handler
-			} else {
-				if err = sendPacket(conn, encodeSearchDone(messageID, ldap.LDAPResultSuccess, doneControls)); err != nil {
-					logger.Error(err, "sendPacket error")
-					break handler
-				}
+			// Searches which exceeded their limits, persistent searches
+			// which fell behind and content synchronizations which require
+			// a refresh keep the connection open.
+			if sent && r.ctx.Err() == nil && e.ResultCode != ldap.LDAPResultTimeLimitExceeded && e.ResultCode != ldap.LDAPResultSizeLimitExceeded && e.ResultCode != ldap.LDAPResultAdminLimitExceeded && e.ResultCode != ldap.LDAPResultSyncRefreshRequired {
+				return false
 			}
-		case ldap.ApplicationUnbindRequest:
-			server.Stats.countUnbinds(1)
-			break handler // Simply disconnect.
+			return true
+		} else {
+			responsePacket = encodeSearchDone(messageID, ldap.LDAPResultSuccess, doneControls)
+		}
+	}
+
+	if _, err = server.sendResponse(state, r, responsePacket); err != nil {
+		return false
+	}
 
+	if req.Tag == ldap.ApplicationExtendedRequest && isStartTLSResponse(responsePacket.Children[1]) {
+		tlsConn := tls.Server(state.conn.NetConn(), server.TLSConfig)
+		if err = tlsConn.Handshake(); err != nil {
+			logger.Error(err, "StartTLS handshake failed", "remote_addr", conn.RemoteAddr().String())
+			return false
 		}
+		state.conn.replace(tlsConn)
 	}
+	return true
+}
 
-	for _, c := range server.CloseFns {
-		c.Close(boundDN, conn)
+// handleExtendedRequest processes an extended request and returns the
+// response packet.
+func (server *Server) handleExtendedRequest(r *request, boundDN string, conn net.Conn) *ber.Packet {
+	innerBer, err := HandleExtendedRequest(r.ctx, r.req, boundDN, server, conn)
+	if err != nil {
+		return encodeLDAPResponse(r.messageID, ldap.ApplicationExtendedResponse, ldap.LDAPResultOperationsError, err.Error())
 	}
+	responsePacket := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	responsePacket.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, r.messageID, "Message ID"))
+	responsePacket.AppendChild(innerBer)
+	return responsePacket
+}
 
-	conn.Close()
-	server.Stats.countConnsClose(1)
+// sendResponse sends the final response of the request r, unless it was
+// abandoned. Canceled operations are responded with canceled. It returns
+// false if no response was sent.
+func (server *Server) sendResponse(state *connState, r *request, responsePacket *ber.Packet) (bool, error) {
+	if cause := state.ops.respond(r.messageID); cause != nil {
+		if !errors.Is(cause, errCanceled) {
+			logger.V(1).Info("Operation abandoned", "message_id", r.messageID)
+			return false, nil
+		}
+		responsePacket = encodeCanceledResponse(responsePacket)
+	}
+	if err := sendPacket(state.conn, responsePacket); err != nil {
+		logger.Error(err, "sendPacket error")
+		return false, err
+	}
+	return true, nil
+}
+
+// encodeResultResponse encodes the response for an error returned by one of
+// the HandleXXXRequest functions.
+func encodeResultResponse(messageID int64, responseType uint8, err error) *ber.Packet {
+	resultCode := uint16(ldap.LDAPResultSuccess)
+	resultMsg := ""
+	if err != nil {
+		var lErr *ldap.Error
+		if errors.As(err, &lErr) {
+			resultCode = lErr.ResultCode
+			if lErr.Err != nil {
+				resultMsg = lErr.Err.Error()
+			}
+		} else {
+			resultCode = ldap.LDAPResultOperationsError
+			resultMsg = err.Error()
+		}
+	}
+	return encodeLDAPResponse(messageID, responseType, LDAPResultCode(resultCode), resultMsg)
+}
+
+// encodeCanceledResponse returns a copy of the passed response packet with
+// the result code replaced by canceled.
+func encodeCanceledResponse(responsePacket *ber.Packet) *ber.Packet {
+	canceledPacket := ber.Encode(responsePacket.ClassType, responsePacket.TagType, responsePacket.Tag, nil, responsePacket.Description)
+	for i, child := range responsePacket.Children {
+		if i == 1 {
+			response := ber.Encode(child.ClassType, child.TagType, child.Tag, nil, child.Description)
+			response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(ldap.LDAPResultCanceled), "resultCode: "))
+			response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN: "))
+			response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, ldap.LDAPResultCodeMap[ldap.LDAPResultCanceled], "errorMessage: "))
+			child = response
+		}
+		canceledPacket.AppendChild(child)
+	}
+	return canceledPacket
 }
 
 func sendPacket(conn net.Conn, packet *ber.Packet) error {
@@ -481,7 +802,7 @@ func (h defaultHandler) Bind(bindDN, bindSimplePw string, conn net.Conn) (LDAPRe
 	return ldap.LDAPResultInvalidCredentials, nil
 }
 
-func (h defaultHandler) Search(boundDN string, req *ldap.SearchRequest, conn net.Conn) (ServerSearchResult, error) {
+func (h defaultHandler) Search(ctx context.Context, boundDN string, req *ldap.SearchRequest, conn net.Conn) (ServerSearchResult, error) {
 	return ServerSearchResult{make([]*ldap.Entry, 0), []string{}, []ldap.Control{}, ldap.LDAPResultSuccess}, nil
 }
 
@@ -0,0 +1,152 @@
+package ldapserver
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/libregraph/idm/pkg/schema"
+)
+
+// EntrySorter sorts search result entries by the sort keys of a server side
+// sort request control (RFC 2891).
+type EntrySorter struct {
+	schema *schema.Schema
+	keys   []entrySortKey
+}
+
+type entrySortKey struct {
+	attribute *schema.AttributeType
+	rule      *schema.MatchingRule
+	reverse   bool
+}
+
+// NewEntrySorter resolves the sort keys of control with s. It returns the
+// sort response control to send with the search result. If a sort key cannot
+// be used, the returned EntrySorter is nil and the response control contains
+// the error.
+func NewEntrySorter(s *schema.Schema, control *ControlServerSideSorting) (*EntrySorter, *ControlServerSideSortingResult) {
+	sorter := &EntrySorter{
+		schema: s,
+	}
+	for _, key := range control.SortKeys {
+		a, ok := s.AttributeType(key.AttributeType)
+		if !ok {
+			return nil, &ControlServerSideSortingResult{
+				Result:        ldap.ControlServerSideSortingCodeNoSuchAttribute,
+				AttributeType: key.AttributeType,
+			}
+		}
+		var rule *schema.MatchingRule
+		if key.MatchingRule != "" {
+			rule, ok = s.MatchingRule(key.MatchingRule)
+			ok = ok && rule.IsOrdering()
+		} else {
+			rule, ok = s.OrderingRule(a)
+		}
+		if !ok {
+			return nil, &ControlServerSideSortingResult{
+				Result:        ldap.ControlServerSideSortingCodeInappropriateMatching,
+				AttributeType: key.AttributeType,
+			}
+		}
+		sorter.keys = append(sorter.keys, entrySortKey{
+			attribute: a,
+			rule:      rule,
+			reverse:   key.Reverse,
+		})
+	}
+	return sorter, &ControlServerSideSortingResult{
+		Result: ldap.ControlServerSideSortingCodeSuccess,
+	}
+}
+
+// Sort sorts entries stably by the sort keys of sorter.
+func (sorter *EntrySorter) Sort(entries []*ldap.Entry) {
+	SortFunc(sorter, entries, func(entry *ldap.Entry) *ldap.Entry {
+		return entry
+	})
+}
+
+// SortFunc sorts items stably by the sort keys of sorter, applied to the
+// entry of each item.
+func SortFunc[T any](sorter *EntrySorter, items []T, entry func(T) *ldap.Entry) {
+	type sortItem struct {
+		item   T
+		values []*string
+	}
+	sortItems := make([]sortItem, len(items))
+	for i, item := range items {
+		sortItems[i] = sortItem{
+			item:   item,
+			values: sorter.sortValues(entry(item)),
+		}
+	}
+	slices.SortStableFunc(sortItems, func(a, b sortItem) int {
+		for i, key := range sorter.keys {
+			result := 0
+			switch {
+			case a.values[i] == nil && b.values[i] == nil:
+			case a.values[i] == nil:
+				// Entries without the attribute sort after all others.
+				result = 1
+			case b.values[i] == nil:
+				result = -1
+			default:
+				result = key.rule.Compare(*a.values[i], *b.values[i])
+			}
+			if key.reverse {
+				result = -result
+			}
+			if result != 0 {
+				return result
+			}
+		}
+		return 0
+	})
+	for i := range sortItems {
+		items[i] = sortItems[i].item
+	}
+}
+
+// sortValues returns the value of each sort key of entry, which is its least
+// value, or nil if entry has no value for the key.
+func (sorter *EntrySorter) sortValues(entry *ldap.Entry) []*string {
+	values := make([]*string, len(sorter.keys))
+	for _, attribute := range entry.Attributes {
+		if strings.Contains(attribute.Name, ";") {
+			continue
+		}
+		a, ok := sorter.schema.AttributeType(attribute.Name)
+		if !ok {
+			continue
+		}
+		for i, key := range sorter.keys {
+			if a != key.attribute {
+				continue
+			}
+			for _, value := range attribute.Values {
+				if values[i] == nil || key.rule.Compare(value, *values[i]) < 0 {
+					values[i] = &value
+				}
+			}
+		}
+	}
+	return values
+}
+
+// atOrAfter returns true if entry sorts at or after an entry with value for
+// the first sort key of sorter.
+func (sorter *EntrySorter) atOrAfter(entry *ldap.Entry, value string) bool {
+	key := sorter.keys[0]
+	entryValue := sorter.sortValues(entry)[0]
+	if entryValue == nil {
+		return !key.reverse
+	}
+	result := key.rule.Compare(*entryValue, value)
+	if key.reverse {
+		result = -result
+	}
+	return result >= 0
+}
@@ -0,0 +1,133 @@
+package ldapserver
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+type sortSearcher struct {
+	entries []*ldap.Entry
+}
+
+func (s sortSearcher) Search(ctx context.Context, boundDN string, req *ldap.SearchRequest, conn net.Conn) (ServerSearchResult, error) {
+	return ServerSearchResult{
+		Entries:    append([]*ldap.Entry{}, s.entries...),
+		ResultCode: ldap.LDAPResultSuccess,
+	}, nil
+}
+
+func newSortTestEntries() []*ldap.Entry {
+	return []*ldap.Entry{
+		ldap.NewEntry("uid=a,o=base", map[string][]string{"uid": {"a"}, "sn": {"Smith"}, "givenName": {"John"}, "uidNumber": {"10"}}),
+		ldap.NewEntry("uid=b,o=base", map[string][]string{"uid": {"b"}, "sn": {"adams"}, "givenName": {"Zoe"}, "uidNumber": {"9"}}),
+		ldap.NewEntry("uid=c,o=base", map[string][]string{"uid": {"c"}, "givenName": {"Anna"}, "uidNumber": {"-1"}}),
+		ldap.NewEntry("uid=d,o=base", map[string][]string{"uid": {"d"}, "sn": {"smith"}, "givenName": {"Adam", "Zed"}, "uidNumber": {"100"}}),
+	}
+}
+
+func sortedUIDs(entries []*ldap.Entry) string {
+	uids := []string{}
+	for _, entry := range entries {
+		uids = append(uids, entry.GetAttributeValue("uid"))
+	}
+	return strings.Join(uids, ",")
+}
+
+func TestEntrySorter(t *testing.T) {
+	server := NewServer()
+	for _, tc := range []struct {
+		keys     []*ldap.SortKey
+		expected string
+	}{
+		{[]*ldap.SortKey{{AttributeType: "sn"}, {AttributeType: "givenName"}}, "b,d,a,c"},
+		{[]*ldap.SortKey{{AttributeType: "sn", Reverse: true}, {AttributeType: "givenName"}}, "c,d,a,b"},
+		{[]*ldap.SortKey{{AttributeType: "uidNumber"}}, "c,b,a,d"},
+		{[]*ldap.SortKey{{AttributeType: "uidNumber", MatchingRule: "octetStringOrderingMatch"}}, "c,a,d,b"},
+	} {
+		sorter, result := NewEntrySorter(server.Schema(), &ControlServerSideSorting{SortKeys: tc.keys})
+		if sorter == nil || result.Result != ldap.ControlServerSideSortingCodeSuccess {
+			t.Fatalf("Unexpected sort result: %v", result)
+		}
+		entries := newSortTestEntries()
+		sorter.Sort(entries)
+		if got := sortedUIDs(entries); got != tc.expected {
+			t.Errorf("Expected order %s, got %s", tc.expected, got)
+		}
+	}
+
+	for _, tc := range []struct {
+		key    *ldap.SortKey
+		result ldap.ControlServerSideSortingCode
+	}{
+		{&ldap.SortKey{AttributeType: "unknown"}, ldap.ControlServerSideSortingCodeNoSuchAttribute},
+		{&ldap.SortKey{AttributeType: "sn", MatchingRule: "caseIgnoreMatch"}, ldap.ControlServerSideSortingCodeInappropriateMatching},
+		{&ldap.SortKey{AttributeType: "jpegPhoto"}, ldap.ControlServerSideSortingCodeInappropriateMatching},
+	} {
+		sorter, result := NewEntrySorter(server.Schema(), &ControlServerSideSorting{SortKeys: []*ldap.SortKey{tc.key}})
+		if sorter != nil || result.Result != tc.result || result.AttributeType != tc.key.AttributeType {
+			t.Errorf("Expected sort result %d for %+v, got %v", tc.result, tc.key, result)
+		}
+	}
+}
+
+func TestControlServerSideSortingDecode(t *testing.T) {
+	control := &ControlServerSideSorting{
+		Criticality: true,
+		SortKeys: []*ldap.SortKey{
+			{AttributeType: "sn", MatchingRule: "caseIgnoreOrderingMatch"},
+			{AttributeType: "givenName", Reverse: true},
+		},
+	}
+	packet, err := ber.DecodePacketErr(control.Encode().Bytes())
+	if err != nil {
+		t.Fatalf("Failed to decode packet: %s", err)
+	}
+	decoded, err := decodeControl(packet)
+	if err != nil {
+		t.Fatalf("Failed to decode control: %s", err)
+	}
+	if got, want := decoded.String(), control.String(); got != want {
+		t.Errorf("Unexpected decoded control: %s, expected %s", got, want)
+	}
+}
+
+func TestSearchServerSideSorting(t *testing.T) {
+	server := NewServer()
+	server.EnforceLDAP = true
+	server.SearchFunc("", sortSearcher{entries: newSortTestEntries()})
+	l := startTestConn(t, server)
+	defer l.Close()
+
+	sortControl := &ControlServerSideSorting{
+		SortKeys: []*ldap.SortKey{{AttributeType: "sn"}, {AttributeType: "givenName"}},
+	}
+	res, err := l.Search(ldap.NewSearchRequest("o=base", ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, "(uid=*)", nil, []ldap.Control{sortControl}))
+	if err != nil {
+		t.Fatalf("Sorted search failed: %s", err)
+	}
+	if got := sortedUIDs(res.Entries); got != "b,d,a,c" {
+		t.Errorf("Unexpected sort order: %s", got)
+	}
+	if ldap.FindControl(res.Controls, ldap.ControlTypeServerSideSortingResult) == nil {
+		t.Errorf("Expected sort response control")
+	}
+
+	sortControl = &ControlServerSideSorting{
+		SortKeys: []*ldap.SortKey{{AttributeType: "unknown"}},
+	}
+	res, err = l.Search(ldap.NewSearchRequest("o=base", ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, "(uid=*)", nil, []ldap.Control{sortControl}))
+	if err != nil || len(res.Entries) != 4 {
+		t.Errorf("Non-critical sort with unknown attribute should return unsorted entries, got: %v", err)
+	}
+
+	sortControl.Criticality = true
+	_, err = l.Search(ldap.NewSearchRequest("o=base", ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, "(uid=*)", nil, []ldap.Control{sortControl}))
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultUnavailableCriticalExtension) {
+		t.Errorf("Critical sort with unknown attribute should fail with UnavailableCriticalExtension, got: %v", err)
+	}
+}
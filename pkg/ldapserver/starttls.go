@@ -0,0 +1,67 @@
+package ldapserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+func init() {
+	RegisterExtendedOperation(startTLSOID, HandleStartTLSExOp)
+}
+
+// HandleStartTLSExOp validates a StartTLS extended request (RFC 4511 4.14).
+// The actual TLS handshake is performed by the connection handler after the
+// (cleartext) response has been sent to the client.
+func HandleStartTLSExOp(ctx context.Context, req *ber.Packet, boundDN string, server *Server, conn net.Conn) (*ber.Packet, error) {
+	logger.V(1).Info("HandleStartTLSExOp")
+	if req != nil {
+		return nil, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("StartTLS request must not have a value"))
+	}
+	if server.TLSConfig == nil {
+		return nil, ldap.NewError(ldap.LDAPResultUnavailable, errors.New("StartTLS is not configured"))
+	}
+	if isTLSConn(conn) {
+		return nil, ldap.NewError(ldap.LDAPResultOperationsError, errors.New("TLS already established"))
+	}
+	if ops := operationsFromContext(ctx); ops != nil && ops.outstanding() > 1 {
+		return nil, ldap.NewError(ldap.LDAPResultOperationsError, errors.New("outstanding operations on connection"))
+	}
+	return nil, nil
+}
+
+// isStartTLSResponse returns true if the passed extended response packet is a
+// successful response to a StartTLS request.
+func isStartTLSResponse(resp *ber.Packet) bool {
+	if resp == nil || len(resp.Children) < 4 {
+		return false
+	}
+	if resp.Children[3].Data.String() != startTLSOID {
+		return false
+	}
+	code, err := ber.ParseInt64(resp.Children[0].Data.Bytes())
+	if err != nil {
+		return false
+	}
+	return code == ldap.LDAPResultSuccess
+}
+
+func isTLSConn(conn net.Conn) bool {
+	_, ok := asTLSConn(conn)
+	return ok
+}
+
+// asTLSConn returns the TLS connection of conn, if any.
+func asTLSConn(conn net.Conn) (*tls.Conn, bool) {
+	if c, ok := conn.(*serverConn); ok {
+		conn = c.NetConn()
+	}
+	tlsConn, ok := conn.(*tls.Conn)
+	return tlsConn, ok
+}
@@ -0,0 +1,106 @@
+package ldapserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+type testBinder struct{}
+
+func (b testBinder) Bind(bindDN, bindSimplePw string, conn net.Conn) (LDAPResultCode, error) {
+	if bindDN == "cn=test,o=base" && bindSimplePw == "secret" {
+		return ldap.LDAPResultSuccess, nil
+	}
+	return ldap.LDAPResultInvalidCredentials, nil
+}
+
+func newTestCertificate(t *testing.T, subject pkix.Name) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      subject,
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %s", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Error parsing certificate: %s", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// startTestConn connects a go-ldap client to server via an in-memory pipe.
+func startTestConn(t *testing.T, server *Server) *ldap.Conn {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	go server.handleConnection(serverConn)
+	l := ldap.NewConn(clientConn, false)
+	l.Start()
+	return l
+}
+
+func TestStartTLSNotConfigured(t *testing.T) {
+	server := NewServer()
+	l := startTestConn(t, server)
+	defer l.Close()
+
+	err := l.StartTLS(&tls.Config{InsecureSkipVerify: true})
+	if err == nil || !ldap.IsErrorWithCode(err, ldap.LDAPResultUnavailable) {
+		t.Errorf("StartTLS without TLS config should fail with Unavailable. Got: %v", err)
+	}
+}
+
+func TestStartTLSRequireTLSForBind(t *testing.T) {
+	server := NewServer()
+	server.BindFunc("", testBinder{})
+	server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{newTestCertificate(t, pkix.Name{CommonName: "localhost"})}}
+	server.RequireTLSForBind = true
+
+	l := startTestConn(t, server)
+	defer l.Close()
+
+	err := l.Bind("cn=test,o=base", "secret")
+	if err == nil || !ldap.IsErrorWithCode(err, ldap.LDAPResultConfidentialityRequired) {
+		t.Errorf("Bind over cleartext should fail with ConfidentialityRequired. Got: %v", err)
+	}
+
+	if err = l.StartTLS(&tls.Config{InsecureSkipVerify: true}); err != nil {
+		t.Fatalf("StartTLS should succeed. Got: %v", err)
+	}
+	if _, ok := l.TLSConnectionState(); !ok {
+		t.Errorf("Expected connection to use TLS after StartTLS")
+	}
+
+	if err = l.Bind("cn=test,o=base", "secret"); err != nil {
+		t.Errorf("Bind after StartTLS should succeed. Got: %v", err)
+	}
+
+	err = l.StartTLS(&tls.Config{InsecureSkipVerify: true})
+	if err == nil {
+		t.Errorf("StartTLS on TLS connection should fail")
+	}
+}
@@ -15,6 +15,7 @@ type Stats struct {
 	ConnsMax     uint64
 	Adds         uint64
 	Binds        uint64
+	Compares     uint64
 	Deletes      uint64
 	ModifyDNs    uint64
 	Modifies     uint64
@@ -59,6 +60,14 @@ func (stats *Stats) countBinds(delta uint64) {
 	}
 }
 
+func (stats *Stats) countCompares(delta uint64) {
+	if stats != nil {
+		stats.statsMutex.Lock()
+		stats.Compares += delta
+		stats.statsMutex.Unlock()
+	}
+}
+
 func (stats *Stats) countDeletes(delta uint64) {
 	if stats != nil {
 		stats.statsMutex.Lock()
@@ -108,6 +117,7 @@ func (stats *Stats) Clone() *Stats {
 		s2.ConnsCurrent = stats.ConnsCurrent
 		s2.Adds = stats.Adds
 		s2.Binds = stats.Binds
+		s2.Compares = stats.Compares
 		s2.Deletes = stats.Deletes
 		s2.ModifyDNs = stats.ModifyDNs
 		s2.Modifies = stats.Modifies
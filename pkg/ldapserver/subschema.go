@@ -0,0 +1,29 @@
+package ldapserver
+
+import (
+	"context"
+	"net"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// isSubschemaSearch returns true if req is a search of the subschema subentry.
+// The subschema subentry has no subordinates, so one level searches are not
+// answered by it.
+func isSubschemaSearch(req *ldap.SearchRequest) bool {
+	if req.Scope == ldap.ScopeSingleLevel {
+		return false
+	}
+	dn, err := ldap.ParseDN(req.BaseDN)
+	if err != nil {
+		return false
+	}
+	subschemaDN, _ := ldap.ParseDN(SubschemaSubentryDN)
+	return dn.EqualFold(subschemaDN)
+}
+
+// HandleSubschemaSearch answers a search of the subschema subentry (RFC 4512
+// 4.2) with the definitions of the schema of the server.
+func HandleSubschemaSearch(ctx context.Context, req *ldap.SearchRequest, messageID int64, server *Server, conn net.Conn) error {
+	return sendBuiltinEntry(ctx, req, messageID, server, conn, server.Schema().Entry(SubschemaSubentryDN))
+}
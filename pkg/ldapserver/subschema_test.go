@@ -0,0 +1,39 @@
+package ldapserver
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestSubschemaSearch(t *testing.T) {
+	server := NewServer()
+	l := startTestConn(t, server)
+	defer l.Close()
+
+	res, err := l.Search(ldap.NewSearchRequest("cn=subschema", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=subschema)", []string{"attributeTypes", "objectClasses"}, nil))
+	if err != nil {
+		t.Fatalf("Subschema search failed: %s", err)
+	}
+	if len(res.Entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(res.Entries))
+	}
+	entry := res.Entries[0]
+	if entry.DN != SubschemaSubentryDN {
+		t.Errorf("Unexpected DN: %s", entry.DN)
+	}
+	if got := len(entry.GetAttributeValues("attributeTypes")); got != len(server.Schema().AttributeTypes()) {
+		t.Errorf("Expected %d attributeTypes, got %d", len(server.Schema().AttributeTypes()), got)
+	}
+	if len(entry.GetAttributeValues("objectClasses")) == 0 {
+		t.Errorf("Expected objectClasses")
+	}
+	if len(entry.GetAttributeValues("ldapSyntaxes")) != 0 {
+		t.Errorf("Expected only the requested attributes")
+	}
+
+	res, err = l.Search(ldap.NewSearchRequest("cn=subschema", ldap.ScopeSingleLevel, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", nil, nil))
+	if err == nil && len(res.Entries) != 0 {
+		t.Errorf("Expected no entries for one level search of the subschema subentry")
+	}
+}
@@ -0,0 +1,412 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+
+	"github.com/libregraph/idm/pkg/ldapdn"
+	"github.com/libregraph/idm/pkg/schema"
+)
+
+// EntryCSNAttribute is the change sequence number of an entry, which is
+// maintained by handlers supporting content synchronization.
+const EntryCSNAttribute = "entryCSN"
+
+// Syncer is implemented by handlers which provide content synchronization
+// (RFC 4533) and persistent searches. Sync returns the entries in the scope
+// of req, unfiltered, and the CSN of the last change included in them. For
+// persistent requests (see IsPersistent) the changes made after the entries
+// were read must be delivered with Changes until ctx is done.
+type Syncer interface {
+	Sync(ctx context.Context, boundDN string, req *ldap.SearchRequest, conn net.Conn) (ServerSyncResult, error)
+}
+
+// ServerSyncResult is the result of a Syncer.
+type ServerSyncResult struct {
+	Entries    []*ldap.Entry
+	CSN        string
+	Changes    <-chan SyncChange
+	ResultCode LDAPResultCode
+}
+
+// SyncChange is a change of an entry. OldEntry is nil for added entries and
+// NewEntry is nil for deleted entries. CSN is the change sequence number of
+// the change.
+type SyncChange struct {
+	OldEntry *ldap.Entry
+	NewEntry *ldap.Entry
+	CSN      string
+}
+
+// syncChangesBuffer is the number of changes which are buffered for each
+// subscriber of SyncChanges.
+const syncChangesBuffer = 256
+
+// SyncChanges passes published changes to its subscribers. Subscribers which
+// do not keep up with the changes are dropped by closing their channel. The
+// zero value is ready to use.
+type SyncChanges struct {
+	mutex       sync.Mutex
+	subscribers map[chan SyncChange]struct{}
+}
+
+// Subscribe returns a channel which receives the changes published until ctx
+// is done. Then the channel is closed.
+func (c *SyncChanges) Subscribe(ctx context.Context) <-chan SyncChange {
+	ch := make(chan SyncChange, syncChangesBuffer)
+	c.mutex.Lock()
+	if c.subscribers == nil {
+		c.subscribers = make(map[chan SyncChange]struct{})
+	}
+	c.subscribers[ch] = struct{}{}
+	c.mutex.Unlock()
+
+	context.AfterFunc(ctx, func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.drop(ch)
+	})
+	return ch
+}
+
+// Publish passes change to all subscribers without blocking.
+func (c *SyncChanges) Publish(change SyncChange) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for ch := range c.subscribers {
+		select {
+		case ch <- change:
+		default:
+			c.drop(ch)
+		}
+	}
+}
+
+func (c *SyncChanges) drop(ch chan SyncChange) {
+	if _, ok := c.subscribers[ch]; ok {
+		delete(c.subscribers, ch)
+		close(ch)
+	}
+}
+
+// IsPersistent returns true if the changes of the entries must be delivered
+// for req, which is a content synchronization search in refreshAndPersist
+// mode or a persistent search.
+func IsPersistent(req *ldap.SearchRequest) bool {
+	if _, ok := ldap.FindControl(req.Controls, ControlTypePersistentSearch).(*ControlPersistentSearch); ok {
+		return true
+	}
+	control, ok := ldap.FindControl(req.Controls, ldap.ControlTypeSyncRequest).(*ldap.ControlSyncRequest)
+	return ok && control.Mode == ldap.SyncRequestModeRefreshAndPersist
+}
+
+// SyncFunc registers the content synchronization handler for baseDN.
+func (server *Server) SyncFunc(baseDN string, f Syncer) {
+	server.SyncFns[baseDN] = f
+}
+
+// syncer returns the content synchronization handler for dn or nil.
+func (server *Server) syncer(dn string) Syncer {
+	fnNames := []string{}
+	for k := range server.SyncFns {
+		fnNames = append(fnNames, k)
+	}
+	return server.SyncFns[routeFunc(dn, fnNames)]
+}
+
+// handleSyncSearch processes a search with the sync request control. The
+// refresh stage is a present phase: entries changed since the CSN of the
+// cookie are sent, the UUIDs of the unchanged ones are listed in a syncIdSet.
+// In refreshAndPersist mode the changes are sent until ctx is done.
+func handleSyncSearch(ctx context.Context, syncer Syncer, searchReq *ldap.SearchRequest, control *ldap.ControlSyncRequest, messageID int64, boundDN string, server *Server, conn net.Conn) (*[]ldap.Control, error) {
+	filterPacket, err := ldap.CompileFilter(searchReq.Filter)
+	if err != nil {
+		return nil, ldap.NewError(ldap.LDAPResultOperationsError, err)
+	}
+	cookieCSN := parseSyncCookie(control.Cookie)
+
+	syncResp, err := syncer.Sync(ctx, boundDN, searchReq, conn)
+	if err != nil {
+		return nil, ldap.NewError(uint16(syncResp.ResultCode), err)
+	}
+
+	s := server.Schema()
+	var present []uuid.UUID
+	for _, entry := range syncResp.Entries {
+		if ctx.Err() != nil {
+			return nil, ldap.NewError(uint16(ContextResultCode(ctx)), context.Cause(ctx))
+		}
+		if !syncMatch(s, filterPacket, searchReq, entry) {
+			continue
+		}
+		entryUUID := syncEntryUUID(entry)
+		if cookieCSN != "" {
+			if entryCSN := entry.GetEqualFoldAttributeValue(EntryCSNAttribute); entryCSN != "" && entryCSN <= cookieCSN {
+				present = append(present, entryUUID)
+				continue
+			}
+		}
+		if err = sendSyncEntry(conn, messageID, s, searchReq, entry, ldap.SyncStateAdd, entryUUID, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	cookie := syncCookie(syncResp.CSN)
+	if len(present) > 0 {
+		if err = sendPacket(conn, encodeSyncInfo(messageID, encodeSyncIDSet(cookie, present))); err != nil {
+			return nil, ldap.NewError(ldap.LDAPResultOperationsError, err)
+		}
+	}
+	if control.Mode != ldap.SyncRequestModeRefreshAndPersist || syncResp.Changes == nil {
+		return &[]ldap.Control{&ControlSyncDone{Cookie: cookie}}, nil
+	}
+
+	if err = sendPacket(conn, encodeSyncInfo(messageID, encodeSyncRefreshPresent(cookie))); err != nil {
+		return nil, ldap.NewError(ldap.LDAPResultOperationsError, err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ldap.NewError(uint16(ContextResultCode(ctx)), context.Cause(ctx))
+		case change, ok := <-syncResp.Changes:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil, ldap.NewError(uint16(ContextResultCode(ctx)), context.Cause(ctx))
+				}
+				return nil, ldap.NewError(ldap.LDAPResultSyncRefreshRequired, errors.New("too many changes, refresh required"))
+			}
+			if syncResp.CSN != "" && change.CSN <= syncResp.CSN {
+				// The change is included in the refresh.
+				continue
+			}
+			if err = sendSyncChange(conn, messageID, s, filterPacket, searchReq, change); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// sendSyncChange sends change to the consumer, if it affects the entries
+// matching searchReq.
+func sendSyncChange(conn net.Conn, messageID int64, s *schema.Schema, filterPacket *ber.Packet, searchReq *ldap.SearchRequest, change SyncChange) error {
+	oldMatch := change.OldEntry != nil && syncMatch(s, filterPacket, searchReq, change.OldEntry)
+	newMatch := change.NewEntry != nil && syncMatch(s, filterPacket, searchReq, change.NewEntry)
+	cookie := syncCookie(change.CSN)
+	switch {
+	case newMatch && oldMatch:
+		return sendSyncEntry(conn, messageID, s, searchReq, change.NewEntry, ldap.SyncStateModify, syncEntryUUID(change.NewEntry), cookie)
+	case newMatch:
+		return sendSyncEntry(conn, messageID, s, searchReq, change.NewEntry, ldap.SyncStateAdd, syncEntryUUID(change.NewEntry), cookie)
+	case oldMatch:
+		// Deleted entries are sent without attributes.
+		deleted := &ldap.Entry{DN: change.OldEntry.DN}
+		return sendSyncEntry(conn, messageID, s, searchReq, deleted, ldap.SyncStateDelete, syncEntryUUID(change.OldEntry), cookie)
+	}
+	return nil
+}
+
+// sendSyncEntry sends entry with a sync state control. The attributes of
+// entry are not changed.
+func sendSyncEntry(conn net.Conn, messageID int64, s *schema.Schema, searchReq *ldap.SearchRequest, entry *ldap.Entry, state ldap.ControlSyncStateState, entryUUID uuid.UUID, cookie []byte) error {
+	return sendEntryWithControl(conn, messageID, s, searchReq, entry, &ControlSyncState{State: state, EntryUUID: entryUUID, Cookie: cookie})
+}
+
+// sendEntryWithControl sends entry with the attributes selected by searchReq
+// and control, if it is not nil. The attributes of entry are not changed.
+func sendEntryWithControl(conn net.Conn, messageID int64, s *schema.Schema, searchReq *ldap.SearchRequest, entry *ldap.Entry, control ldap.Control) error {
+	e := &ldap.Entry{DN: entry.DN, Attributes: entry.Attributes}
+	if resultCode, err := ServerFilterAttributesWithSchema(s, searchReq.Attributes, e); err != nil {
+		return ldap.NewError(uint16(resultCode), err)
+	}
+	responsePacket := encodeSearchResponse(messageID, searchReq, e)
+	if control != nil {
+		controls := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+		controls.AppendChild(control.Encode())
+		responsePacket.AppendChild(controls)
+	}
+	if err := sendPacket(conn, responsePacket); err != nil {
+		return ldap.NewError(ldap.LDAPResultOperationsError, err)
+	}
+	return nil
+}
+
+// syncMatch returns true if entry matches the filter and is in the scope of
+// searchReq.
+func syncMatch(s *schema.Schema, filterPacket *ber.Packet, searchReq *ldap.SearchRequest, entry *ldap.Entry) bool {
+	if keep, resultCode := ServerApplyFilterWithSchema(s, filterPacket, entry); !keep || resultCode != ldap.LDAPResultSuccess {
+		return false
+	}
+	base, err := ldap.ParseDN(searchReq.BaseDN)
+	if err != nil {
+		return false
+	}
+	dn, err := ldap.ParseDN(entry.DN)
+	if err != nil {
+		return false
+	}
+	switch searchReq.Scope {
+	case ldap.ScopeBaseObject:
+		return dn.EqualFold(base)
+	case ldap.ScopeSingleLevel:
+		return len(dn.RDNs) == len(base.RDNs)+1 && base.AncestorOfFold(dn)
+	default:
+		return dn.EqualFold(base) || base.AncestorOfFold(dn)
+	}
+}
+
+// syncEntryUUID returns the entryUUID of entry. Entries without a valid
+// entryUUID get a UUID derived from their DN.
+func syncEntryUUID(entry *ldap.Entry) uuid.UUID {
+	if entryUUID, err := uuid.Parse(entry.GetEqualFoldAttributeValue(EntryUUIDAttribute)); err == nil {
+		return entryUUID
+	}
+	nDN, err := ldapdn.ParseNormalize(entry.DN)
+	if err != nil {
+		nDN = entry.DN
+	}
+	return uuid.NewSHA1(uuid.NameSpaceX500, []byte(nDN))
+}
+
+// syncCookie returns the cookie for the state of the content at csn.
+func syncCookie(csn string) []byte {
+	if csn == "" {
+		return nil
+	}
+	return []byte("csn=" + csn)
+}
+
+// parseSyncCookie returns the CSN of cookie. It also accepts the cookies of
+// OpenLDAP, which have additional comma separated fields.
+func parseSyncCookie(cookie []byte) string {
+	for _, field := range strings.Split(string(cookie), ",") {
+		if csn, ok := strings.CutPrefix(field, "csn="); ok {
+			return csn
+		}
+	}
+	return ""
+}
+
+func decodeControlSyncRequest(criticality bool, value *ber.Packet) (ldap.Control, error) {
+	if value == nil || len(value.Children) == 0 || len(value.Children) > 3 {
+		return nil, errors.New("invalid sync request control")
+	}
+	mode, ok := value.Children[0].Value.(int64)
+	if !ok || (ldap.ControlSyncRequestMode(mode) != ldap.SyncRequestModeRefreshOnly && ldap.ControlSyncRequestMode(mode) != ldap.SyncRequestModeRefreshAndPersist) {
+		return nil, errors.New("invalid sync request mode")
+	}
+	c := &ldap.ControlSyncRequest{Criticality: criticality, Mode: ldap.ControlSyncRequestMode(mode)}
+	for _, child := range value.Children[1:] {
+		switch child.Tag {
+		case ber.TagOctetString:
+			c.Cookie = child.Data.Bytes()
+		case ber.TagBoolean:
+			c.ReloadHint, _ = child.Value.(bool)
+		default:
+			return nil, errors.New("invalid sync request control")
+		}
+	}
+	return c, nil
+}
+
+// ControlSyncState is the sync state control (RFC 4533 2.3).
+type ControlSyncState struct {
+	State     ldap.ControlSyncStateState
+	EntryUUID uuid.UUID
+	Cookie    []byte
+}
+
+// GetControlType returns the OID of the control.
+func (c *ControlSyncState) GetControlType() string {
+	return ldap.ControlTypeSyncState
+}
+
+// Encode returns the ber packet representation of the control.
+func (c *ControlSyncState) Encode() *ber.Packet {
+	seq := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "syncStateValue")
+	seq.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(c.State), "state"))
+	seq.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(c.EntryUUID[:]), "entryUUID"))
+	if c.Cookie != nil {
+		seq.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(c.Cookie), "cookie"))
+	}
+	return encodeControl(c.GetControlType(), false, seq)
+}
+
+// String returns a human-readable description of the control.
+func (c *ControlSyncState) String() string {
+	return fmt.Sprintf("Control Type: %s (%q)  State: %d  EntryUUID: %s  Cookie: %s", ldap.ControlTypeMap[c.GetControlType()], c.GetControlType(), c.State, c.EntryUUID, c.Cookie)
+}
+
+// ControlSyncDone is the sync done control (RFC 4533 2.4).
+type ControlSyncDone struct {
+	Cookie         []byte
+	RefreshDeletes bool
+}
+
+// GetControlType returns the OID of the control.
+func (c *ControlSyncDone) GetControlType() string {
+	return ldap.ControlTypeSyncDone
+}
+
+// Encode returns the ber packet representation of the control.
+func (c *ControlSyncDone) Encode() *ber.Packet {
+	seq := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "syncDoneValue")
+	if c.Cookie != nil {
+		seq.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(c.Cookie), "cookie"))
+	}
+	if c.RefreshDeletes {
+		seq.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, c.RefreshDeletes, "refreshDeletes"))
+	}
+	return encodeControl(c.GetControlType(), false, seq)
+}
+
+// String returns a human-readable description of the control.
+func (c *ControlSyncDone) String() string {
+	return fmt.Sprintf("Control Type: %s (%q)  Cookie: %s  RefreshDeletes: %t", ldap.ControlTypeMap[c.GetControlType()], c.GetControlType(), c.Cookie, c.RefreshDeletes)
+}
+
+// encodeSyncInfo encodes the Sync Info Message (RFC 4533 2.5), an
+// intermediate response with the syncInfoValue value.
+func encodeSyncInfo(messageID int64, value *ber.Packet) *ber.Packet {
+	responsePacket := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	responsePacket.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationIntermediateResponse, nil, "Intermediate Response")
+	response.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, ldap.ControlTypeSyncInfo, "responseName"))
+	response.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 1, string(value.Bytes()), "responseValue"))
+	responsePacket.AppendChild(response)
+	return responsePacket
+}
+
+// encodeSyncRefreshPresent encodes the refreshPresent syncInfoValue which
+// ends the refresh stage of refreshAndPersist mode.
+func encodeSyncRefreshPresent(cookie []byte) *ber.Packet {
+	value := ber.Encode(ber.ClassContext, ber.TypeConstructed, 2, nil, "refreshPresent")
+	if cookie != nil {
+		value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(cookie), "cookie"))
+	}
+	return value
+}
+
+// encodeSyncIDSet encodes the syncIdSet syncInfoValue listing the UUIDs of
+// present entries. refreshDeletes is included although it has its default
+// value, as some consumers expect it.
+func encodeSyncIDSet(cookie []byte, entryUUIDs []uuid.UUID) *ber.Packet {
+	value := ber.Encode(ber.ClassContext, ber.TypeConstructed, 3, nil, "syncIdSet")
+	if cookie != nil {
+		value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(cookie), "cookie"))
+	}
+	value.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, false, "refreshDeletes"))
+	set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "syncUUIDs")
+	for _, entryUUID := range entryUUIDs {
+		set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(entryUUID[:]), "syncUUID"))
+	}
+	value.AppendChild(set)
+	return value
+}
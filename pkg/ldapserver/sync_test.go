@@ -0,0 +1,155 @@
+package ldapserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+type testSyncer struct {
+	entries []*ldap.Entry
+	csn     string
+	changes *SyncChanges
+}
+
+func (s testSyncer) Sync(ctx context.Context, boundDN string, req *ldap.SearchRequest, conn net.Conn) (ServerSyncResult, error) {
+	var changes <-chan SyncChange
+	if IsPersistent(req) {
+		changes = s.changes.Subscribe(ctx)
+	}
+	return ServerSyncResult{
+		Entries:    s.entries,
+		CSN:        s.csn,
+		Changes:    changes,
+		ResultCode: ldap.LDAPResultSuccess,
+	}, nil
+}
+
+func newSyncTestEntry(uid, entryUUID, csn string) *ldap.Entry {
+	return ldap.NewEntry("uid="+uid+",o=base", map[string][]string{
+		"objectClass": {"account"},
+		"uid":         {uid},
+		"entryUUID":   {entryUUID},
+		"entryCSN":    {csn},
+	})
+}
+
+const (
+	testSyncCSN1 = "20240101000000.000000Z#000000#000#000000"
+	testSyncCSN2 = "20240102000000.000000Z#000000#000#000000"
+	testSyncCSN3 = "20240103000000.000000Z#000000#000#000000"
+)
+
+func newTestSyncer() testSyncer {
+	return testSyncer{
+		entries: []*ldap.Entry{
+			newSyncTestEntry("a", "b1f8c2a4-7d4e-4b8a-9c1e-0f2d3a4b5c6d", testSyncCSN1),
+			newSyncTestEntry("b", "c2a9d3b5-8e5f-4c9b-8d2f-1a3e4b5c6d7e", testSyncCSN2),
+		},
+		csn:     testSyncCSN2,
+		changes: &SyncChanges{},
+	}
+}
+
+func TestSyncRefreshOnly(t *testing.T) {
+	server := NewServer()
+	server.SyncFunc("", newTestSyncer())
+
+	for _, test := range []struct {
+		cookie  []byte
+		entries []string
+		present int
+	}{
+		{nil, []string{"uid=a,o=base", "uid=b,o=base"}, 0},
+		{[]byte("csn=" + testSyncCSN1), []string{"uid=b,o=base"}, 1},
+		{[]byte("csn=" + testSyncCSN2), nil, 2},
+	} {
+		l := startTestConn(t, server)
+		req := ldap.NewSearchRequest("o=base", ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=account)", []string{"uid"}, nil)
+		r := l.Syncrepl(context.Background(), req, 0, ldap.SyncRequestModeRefreshOnly, test.cookie, false)
+		var entries []string
+		present := 0
+		var done *ldap.ControlSyncDone
+		for r.Next() {
+			if entry := r.Entry(); entry != nil {
+				entries = append(entries, entry.DN)
+				if state, ok := r.Controls()[0].(*ldap.ControlSyncState); !ok || state.State != ldap.SyncStateAdd {
+					t.Errorf("Sync with cookie %q returned %s without add state", test.cookie, entry.DN)
+				}
+				continue
+			}
+			for _, control := range r.Controls() {
+				switch c := control.(type) {
+				case *ldap.ControlSyncInfo:
+					present += len(c.SyncIdSet.SyncUUIDs)
+				case *ldap.ControlSyncDone:
+					done = c
+				}
+			}
+		}
+		l.Close()
+		if err := r.Err(); err != nil {
+			t.Errorf("Sync with cookie %q failed: %v", test.cookie, err)
+			continue
+		}
+		if len(entries) != len(test.entries) || (len(entries) > 0 && entries[0] != test.entries[0]) {
+			t.Errorf("Sync with cookie %q returned %v, expected %v", test.cookie, entries, test.entries)
+		}
+		if present != test.present {
+			t.Errorf("Sync with cookie %q returned %d present entries, expected %d", test.cookie, present, test.present)
+		}
+		if done == nil || string(done.Cookie) != "csn="+testSyncCSN2 {
+			t.Errorf("Sync with cookie %q returned done control %v", test.cookie, done)
+		}
+	}
+}
+
+func TestSyncRefreshAndPersist(t *testing.T) {
+	syncer := newTestSyncer()
+	server := NewServer()
+	server.SyncFunc("", syncer)
+	l := startTestConn(t, server)
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req := ldap.NewSearchRequest("o=base", ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, "(uid=*)", []string{"uid"}, nil)
+	r := l.Syncrepl(ctx, req, 0, ldap.SyncRequestModeRefreshAndPersist, []byte("csn="+testSyncCSN2), false)
+
+	// The refresh returns no entries, all of them are present.
+	for r.Next() {
+		if info, ok := r.Controls()[0].(*ldap.ControlSyncInfo); ok && info.Value == ldap.SyncInfoRefreshPresent {
+			break
+		}
+	}
+
+	a := newSyncTestEntry("a", "b1f8c2a4-7d4e-4b8a-9c1e-0f2d3a4b5c6d", testSyncCSN3)
+	c := newSyncTestEntry("c", "d3b0e4c6-9f6a-4d0c-9e3a-2b4f5c6d7e8f", testSyncCSN3)
+	syncer.changes.Publish(SyncChange{NewEntry: c, CSN: testSyncCSN3})
+	syncer.changes.Publish(SyncChange{OldEntry: syncer.entries[0], NewEntry: a, CSN: testSyncCSN3})
+	syncer.changes.Publish(SyncChange{OldEntry: syncer.entries[1], CSN: testSyncCSN3})
+
+	for _, expected := range []struct {
+		dn    string
+		state ldap.ControlSyncStateState
+	}{
+		{"uid=c,o=base", ldap.SyncStateAdd},
+		{"uid=a,o=base", ldap.SyncStateModify},
+		{"uid=b,o=base", ldap.SyncStateDelete},
+	} {
+		if !r.Next() {
+			t.Fatalf("Sync ended before %s: %v", expected.dn, r.Err())
+		}
+		state, ok := r.Controls()[0].(*ldap.ControlSyncState)
+		if r.Entry() == nil || r.Entry().DN != expected.dn || !ok || state.State != expected.state {
+			t.Errorf("Sync returned %v with %v, expected %s with state %v", r.Entry(), r.Controls(), expected.dn, expected.state)
+			continue
+		}
+		if string(state.Cookie) != "csn="+testSyncCSN3 {
+			t.Errorf("Sync returned cookie %q for %s", state.Cookie, expected.dn)
+		}
+	}
+}
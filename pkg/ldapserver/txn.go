@@ -0,0 +1,335 @@
+package ldapserver
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// The OIDs of the LDAP transactions (RFC 5805).
+const (
+	startTransactionOID = "1.3.6.1.1.21.1"
+	endTransactionOID   = "1.3.6.1.1.21.3"
+
+	// ControlTypeTransactionSpecification is the control which tags an
+	// update request as part of a transaction.
+	ControlTypeTransactionSpecification = "1.3.6.1.1.21.2"
+)
+
+const (
+	// maxTransactions is the number of transactions which a connection can
+	// have open at the same time.
+	maxTransactions = 8
+	// maxTransactionRequests is the number of update requests of a single
+	// transaction.
+	maxTransactionRequests = 1024
+)
+
+func init() {
+	RegisterExtendedOperation(startTransactionOID, HandleStartTransactionExOp)
+	RegisterExtendedOperation(endTransactionOID, HandleEndTransactionExOp)
+}
+
+// Transactor is implemented by handlers which can apply the update requests
+// of a transaction atomically. The requests are in the order of their message
+// IDs. Either all of them are applied or none.
+type Transactor interface {
+	CommitTransaction(ctx context.Context, boundDN string, requests []TransactionRequest, conn net.Conn) (TransactionResult, error)
+}
+
+// TransactionRequest is an update request of a transaction. Request is one
+// of *ldap.AddRequest, *ldap.DelRequest, *ldap.ModifyRequest and
+// *ldap.ModifyDNRequest.
+type TransactionRequest struct {
+	MessageID int64
+	Request   any
+}
+
+// TransactionResult is the result of a transaction. If the transaction
+// failed, MessageID is the message ID of the update request which caused
+// the failure.
+type TransactionResult struct {
+	ResultCode LDAPResultCode
+	MessageID  int64
+}
+
+// TransactionFunc registers the transaction handler for baseDN.
+func (server *Server) TransactionFunc(baseDN string, f Transactor) {
+	server.TransactionFns[baseDN] = f
+}
+
+// ControlTransactionSpecification is the transaction specification control
+// (RFC 5805).
+type ControlTransactionSpecification struct {
+	Identifier string
+}
+
+// GetControlType returns the OID of the control.
+func (c *ControlTransactionSpecification) GetControlType() string {
+	return ControlTypeTransactionSpecification
+}
+
+// Encode returns the ber packet representation of the control. The control
+// is always critical and its value is the transaction identifier itself.
+func (c *ControlTransactionSpecification) Encode() *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Control")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, c.GetControlType(), "Control Type"))
+	packet.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, true, "Criticality"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, c.Identifier, "Control Value"))
+	return packet
+}
+
+// String returns a human-readable description of the control.
+func (c *ControlTransactionSpecification) String() string {
+	return fmt.Sprintf("Control Type: %s (%q)  Identifier: %q", "Transaction Specification", c.GetControlType(), c.Identifier)
+}
+
+func decodeControlTransactionSpecification(criticality bool, value []byte) (ldap.Control, error) {
+	if len(value) == 0 {
+		return nil, errors.New("transaction specification control without identifier")
+	}
+	return &ControlTransactionSpecification{Identifier: string(value)}, nil
+}
+
+type transactionsContextKey struct{}
+
+// transaction is an open transaction of a connection.
+type transaction struct {
+	boundDN  string
+	requests []TransactionRequest
+}
+
+// transactions tracks the open transactions of a connection by identifier.
+type transactions struct {
+	mutex  sync.Mutex
+	lastID uint64
+	txns   map[string]*transaction
+}
+
+func newTransactions() *transactions {
+	return &transactions{
+		txns: make(map[string]*transaction),
+	}
+}
+
+// withTransactions returns a copy of ctx which carries txns.
+func withTransactions(ctx context.Context, txns *transactions) context.Context {
+	return context.WithValue(ctx, transactionsContextKey{}, txns)
+}
+
+// transactionsFromContext returns the transactions of the connection which
+// ctx belongs to, or nil.
+func transactionsFromContext(ctx context.Context) *transactions {
+	txns, _ := ctx.Value(transactionsContextKey{}).(*transactions)
+	return txns
+}
+
+// start opens a new transaction for boundDN and returns its identifier.
+func (t *transactions) start(boundDN string) (string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.txns) >= maxTransactions {
+		return "", ldap.NewError(ldap.LDAPResultAdminLimitExceeded, errors.New("too many open transactions"))
+	}
+	t.lastID++
+	id := strconv.FormatUint(t.lastID, 10)
+	t.txns[id] = &transaction{boundDN: boundDN}
+	return id, nil
+}
+
+// queue adds an update request to the transaction id.
+func (t *transactions) queue(id, boundDN string, request TransactionRequest) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	txn, ok := t.txns[id]
+	switch {
+	case !ok:
+		return ldap.NewError(ldap.LDAPResultUnwillingToPerform, fmt.Errorf("unknown transaction '%s'", id))
+	case txn.boundDN != boundDN:
+		return ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("transaction was started by a different identity"))
+	case len(txn.requests) >= maxTransactionRequests:
+		return ldap.NewError(ldap.LDAPResultAdminLimitExceeded, errors.New("too many requests in transaction"))
+	}
+	txn.requests = append(txn.requests, request)
+	return nil
+}
+
+// end removes the transaction id and returns it.
+func (t *transactions) end(id, boundDN string) (*transaction, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	txn, ok := t.txns[id]
+	if !ok {
+		return nil, ldap.NewError(ldap.LDAPResultNoSuchOperation, fmt.Errorf("unknown transaction '%s'", id))
+	}
+	if txn.boundDN != boundDN {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("transaction was started by a different identity"))
+	}
+	delete(t.txns, id)
+	return txn, nil
+}
+
+// queueTransactionRequest adds the update request to the transaction named
+// by the transaction specification control of the request. It returns false
+// if the request is not part of a transaction and must be processed right
+// away.
+func queueTransactionRequest(ctx context.Context, controls []ldap.Control, messageID int64, boundDN string, request any) (bool, error) {
+	var spec *ControlTransactionSpecification
+	for _, control := range controls {
+		if c, ok := control.(*ControlTransactionSpecification); ok {
+			spec = c
+			break
+		}
+	}
+	if spec == nil {
+		return false, nil
+	}
+	txns := transactionsFromContext(ctx)
+	if txns == nil {
+		return true, ldap.NewError(ldap.LDAPResultUnwillingToPerform, errors.New("transactions are not supported"))
+	}
+	return true, txns.queue(spec.Identifier, boundDN, TransactionRequest{MessageID: messageID, Request: request})
+}
+
+// HandleStartTransactionExOp starts a transaction as defined in RFC 5805.
+// The response value is the identifier of the transaction.
+func HandleStartTransactionExOp(ctx context.Context, req *ber.Packet, boundDN string, server *Server, conn net.Conn) (*ber.Packet, error) {
+	logger.V(1).Info("HandleStartTransactionExOp")
+	if req != nil {
+		return nil, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("start transaction request with value"))
+	}
+	if boundDN == "" {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("anonymous Write denied"))
+	}
+	txns := transactionsFromContext(ctx)
+	if txns == nil {
+		return nil, ldap.NewError(ldap.LDAPResultUnwillingToPerform, errors.New("transactions are not supported"))
+	}
+	id, err := txns.start(boundDN)
+	if err != nil {
+		return nil, err
+	}
+	return ber.NewString(ber.ClassContext, ber.TypePrimitive, 11, id, "responseValue"), nil
+}
+
+// HandleEndTransactionExOp commits or aborts a transaction as defined in
+// RFC 5805. If the commit fails, the response value holds the message ID of
+// the update request which caused the failure.
+func HandleEndTransactionExOp(ctx context.Context, req *ber.Packet, boundDN string, server *Server, conn net.Conn) (*ber.Packet, error) {
+	logger.V(1).Info("HandleEndTransactionExOp")
+	commit, id, err := parseEndTransactionRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	txns := transactionsFromContext(ctx)
+	if txns == nil {
+		return nil, ldap.NewError(ldap.LDAPResultNoSuchOperation, fmt.Errorf("unknown transaction '%s'", id))
+	}
+	txn, err := txns.end(id, boundDN)
+	if err != nil {
+		return nil, err
+	}
+	if !commit || len(txn.requests) == 0 {
+		logger.V(1).Info("Transaction ended", "id", id, "commit", commit)
+		return nil, nil
+	}
+
+	requests := txn.requests
+	slices.SortStableFunc(requests, func(a, b TransactionRequest) int {
+		return cmp.Compare(a.MessageID, b.MessageID)
+	})
+	fnNames := []string{}
+	for k := range server.TransactionFns {
+		fnNames = append(fnNames, k)
+	}
+	fn := routeFunc(transactionRequestDN(requests[0]), fnNames)
+	for _, request := range requests[1:] {
+		if routeFunc(transactionRequestDN(request), fnNames) != fn {
+			return encodeEndTransactionResponse(request.MessageID), ldap.NewError(ldap.LDAPResultAffectsMultipleDSAs, errors.New("transaction spans multiple handlers"))
+		}
+	}
+	var transactor Transactor
+	if transactor = server.TransactionFns[fn]; transactor == nil {
+		if fn == "" {
+			err = fmt.Errorf("no suitable handler found for dn: '%s'", transactionRequestDN(requests[0]))
+		} else {
+			err = fmt.Errorf("handler '%s' does not support transactions", fn)
+		}
+		return encodeEndTransactionResponse(requests[0].MessageID), ldap.NewError(ldap.LDAPResultUnwillingToPerform, err)
+	}
+	result, err := transactor.CommitTransaction(ctx, boundDN, requests, conn)
+	if result.ResultCode != ldap.LDAPResultSuccess {
+		logger.V(1).Info("Transaction failed", "id", id, "message_id", result.MessageID, "result_code", result.ResultCode)
+		if err == nil {
+			err = errors.New(ldap.LDAPResultCodeMap[uint16(result.ResultCode)])
+		}
+		return encodeEndTransactionResponse(result.MessageID), ldap.NewError(uint16(result.ResultCode), err)
+	}
+	logger.V(1).Info("Transaction committed", "id", id, "requests", len(requests))
+	return nil, nil
+}
+
+// transactionRequestDN returns the DN of the entry targeted by request.
+func transactionRequestDN(request TransactionRequest) string {
+	switch r := request.Request.(type) {
+	case *ldap.AddRequest:
+		return r.DN
+	case *ldap.DelRequest:
+		return r.DN
+	case *ldap.ModifyRequest:
+		return r.DN
+	case *ldap.ModifyDNRequest:
+		return r.DN
+	}
+	return ""
+}
+
+func parseEndTransactionRequest(req *ber.Packet) (bool, string, error) {
+	// txnEndReq ::= SEQUENCE {
+	//         commit         BOOLEAN DEFAULT TRUE,
+	//         identifier     OCTET STRING }
+	if req == nil {
+		return false, "", ldap.NewError(ldap.LDAPResultProtocolError, errors.New("missing end transaction request value"))
+	}
+	inner, err := ber.DecodePacketErr(req.Data.Bytes())
+	if err != nil || len(inner.Children) < 1 || len(inner.Children) > 2 {
+		return false, "", ldap.NewError(ldap.LDAPResultProtocolError, errors.New("invalid end transaction request value"))
+	}
+	commit := true
+	if len(inner.Children) == 2 {
+		var ok bool
+		if commit, ok = inner.Children[0].Value.(bool); !ok {
+			return false, "", ldap.NewError(ldap.LDAPResultProtocolError, errors.New("invalid end transaction commit flag"))
+		}
+	}
+	identifier := inner.Children[len(inner.Children)-1]
+	if identifier.Tag != ber.TagOctetString {
+		return false, "", ldap.NewError(ldap.LDAPResultProtocolError, errors.New("invalid transaction identifier"))
+	}
+	return commit, identifier.Data.String(), nil
+}
+
+// encodeEndTransactionResponse returns the value of an end transaction
+// response for a transaction which failed at the update request messageID.
+// It returns nil if the failure is not caused by a single update request.
+func encodeEndTransactionResponse(messageID int64) *ber.Packet {
+	if messageID == 0 {
+		return nil
+	}
+	// txnEndRes ::= SEQUENCE {
+	//         messageID             MessageID OPTIONAL,
+	//         updatesControls       SEQUENCE OF updateControls SEQUENCE {
+	//                 messageID    MessageID,
+	//                 controls     Controls } OPTIONAL }
+	seq := ber.NewSequence("txnEndRes")
+	seq.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "messageID"))
+	return seq
+}
@@ -0,0 +1,127 @@
+package ldapserver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// testTransactor records the committed transactions. Transactions with a
+// delete request fail at that request.
+type testTransactor struct {
+	committed [][]TransactionRequest
+}
+
+func (tr *testTransactor) CommitTransaction(ctx context.Context, boundDN string, requests []TransactionRequest, conn net.Conn) (TransactionResult, error) {
+	for _, request := range requests {
+		if _, ok := request.Request.(*ldap.DelRequest); ok {
+			return TransactionResult{ResultCode: ldap.LDAPResultNoSuchObject, MessageID: request.MessageID}, nil
+		}
+	}
+	tr.committed = append(tr.committed, requests)
+	return TransactionResult{ResultCode: ldap.LDAPResultSuccess}, nil
+}
+
+func startTransaction(t *testing.T, l *ldap.Conn) string {
+	t.Helper()
+	res, err := l.Extended(ldap.NewExtendedRequest(startTransactionOID, nil))
+	if err != nil || res.Value == nil {
+		t.Fatalf("Start transaction failed: %v", err)
+	}
+	return res.Value.Data.String()
+}
+
+func endTransaction(l *ldap.Conn, id string, commit bool) error {
+	seq := ber.NewSequence("txnEndReq")
+	seq.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, commit, "commit"))
+	seq.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, id, "identifier"))
+	value := ber.Encode(ber.ClassContext, ber.TypePrimitive, 1, nil, "requestValue")
+	value.AppendChild(seq)
+	_, err := l.Extended(ldap.NewExtendedRequest(endTransactionOID, value))
+	return err
+}
+
+func TestTransaction(t *testing.T) {
+	transactor := &testTransactor{}
+	server := NewServer()
+	server.BindFunc("", testBinder{})
+	server.TransactionFunc("", transactor)
+	l := startTestConn(t, server)
+	defer l.Close()
+	if err := l.Bind("cn=test,o=base", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	id := startTransaction(t, l)
+	txnControls := []ldap.Control{&ControlTransactionSpecification{Identifier: id}}
+	addReq := ldap.NewAddRequest("uid=a,o=base", txnControls)
+	addReq.Attribute("objectClass", []string{"account"})
+	addReq.Attribute("uid", []string{"a"})
+	if err := l.Add(addReq); err != nil {
+		t.Fatalf("Queueing add failed: %v", err)
+	}
+	modReq := ldap.NewModifyRequest("cn=group,o=base", txnControls)
+	modReq.Add("member", []string{"uid=a,o=base"})
+	if err := l.Modify(modReq); err != nil {
+		t.Fatalf("Queueing modify failed: %v", err)
+	}
+	if len(transactor.committed) != 0 {
+		t.Fatalf("Requests were committed before the end of the transaction")
+	}
+	if err := endTransaction(l, id, true); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if len(transactor.committed) != 1 || len(transactor.committed[0]) != 2 {
+		t.Fatalf("Unexpected committed transactions: %v", transactor.committed)
+	}
+	requests := transactor.committed[0]
+	if add, ok := requests[0].Request.(*ldap.AddRequest); !ok || add.DN != "uid=a,o=base" {
+		t.Errorf("Unexpected first request %v", requests[0].Request)
+	}
+	if mod, ok := requests[1].Request.(*ldap.ModifyRequest); !ok || mod.DN != "cn=group,o=base" {
+		t.Errorf("Unexpected second request %v", requests[1].Request)
+	}
+	if requests[0].MessageID >= requests[1].MessageID {
+		t.Errorf("Requests are not ordered by message ID: %d, %d", requests[0].MessageID, requests[1].MessageID)
+	}
+	if err := endTransaction(l, id, true); !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchOperation) {
+		t.Errorf("Ending a committed transaction returned %v", err)
+	}
+
+	// Aborted transactions are not committed.
+	id = startTransaction(t, l)
+	if err := l.Add(ldap.NewAddRequest("uid=b,o=base", []ldap.Control{&ControlTransactionSpecification{Identifier: id}})); err != nil {
+		t.Fatalf("Queueing add failed: %v", err)
+	}
+	if err := endTransaction(l, id, false); err != nil {
+		t.Fatalf("Abort failed: %v", err)
+	}
+	if len(transactor.committed) != 1 {
+		t.Errorf("Aborted transaction was committed")
+	}
+
+	// The end transaction response has the result of the failed request.
+	id = startTransaction(t, l)
+	if err := l.Del(ldap.NewDelRequest("uid=c,o=base", []ldap.Control{&ControlTransactionSpecification{Identifier: id}})); err != nil {
+		t.Fatalf("Queueing delete failed: %v", err)
+	}
+	if err := endTransaction(l, id, true); !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		t.Errorf("Failing transaction returned %v", err)
+	}
+
+	if err := l.Del(ldap.NewDelRequest("uid=c,o=base", []ldap.Control{&ControlTransactionSpecification{Identifier: "unknown"}})); !ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform) {
+		t.Errorf("Update with unknown transaction returned %v", err)
+	}
+}
+
+func TestTransactionAnonymous(t *testing.T) {
+	server := NewServer()
+	l := startTestConn(t, server)
+	defer l.Close()
+	if _, err := l.Extended(ldap.NewExtendedRequest(startTransactionOID, nil)); !ldap.IsErrorWithCode(err, ldap.LDAPResultInsufficientAccessRights) {
+		t.Errorf("Anonymous start transaction returned %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package ldapserver
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// VirtualListView returns the bounds of the entries which are returned for
+// a virtual list view request control from a sorted list of count entries,
+// together with the response control. For a greaterThanOrEqual target,
+// atOrAfter reports whether the entry at index i sorts at or after the
+// assertion value. If the target cannot be selected, the result of the
+// response control is not success and the bounds are empty.
+func VirtualListView(control *ControlVLVRequest, count int, atOrAfter func(i int) bool) (start, end int, response *ControlVLVResponse) {
+	response = &ControlVLVResponse{
+		ContentCount: count,
+		Result:       ldap.LDAPResultSuccess,
+		ContextID:    control.ContextID,
+	}
+
+	// The target is the zero based index of the target entry.
+	var target int
+	switch {
+	case control.GreaterThanOrEqual != nil:
+		// Without a matching entry, the target is the end of the list.
+		target = sort.Search(count, atOrAfter)
+	case control.ContentCount > 0 && control.Offset > control.ContentCount:
+		response.Result = ldap.LDAPResultOffsetRangeError
+		return 0, 0, response
+	default:
+		offset := control.Offset
+		if control.ContentCount > 0 {
+			// Scale the offset from the content count known to the client.
+			offset = count * offset / control.ContentCount
+		}
+		target = max(min(offset, count)-1, 0)
+	}
+	if count > 0 {
+		response.TargetPosition = target + 1
+	}
+
+	start = max(target-control.BeforeCount, 0)
+	end = min(target+control.AfterCount+1, count)
+	return start, end, response
+}
+
+// checkVLVRequest checks whether searchReq can be answered with a virtual
+// list view if it has a virtual list view request control. If it cannot,
+// the returned response control contains the reason.
+func checkVLVRequest(searchReq *ldap.SearchRequest) (*ControlVLVResponse, error) {
+	control, ok := ldap.FindControl(searchReq.Controls, ldap.ControlTypeVLVRequest).(*ControlVLVRequest)
+	if !ok {
+		return nil, nil
+	}
+	response := &ControlVLVResponse{
+		ContextID: control.ContextID,
+	}
+	switch {
+	case ldap.FindControl(searchReq.Controls, ldap.ControlTypeServerSideSorting) == nil:
+		response.Result = ldap.LDAPResultSortControlMissing
+		return response, ldap.NewError(response.Result, errors.New("virtual list view requires the server side sort control"))
+	case ldap.FindControl(searchReq.Controls, ldap.ControlTypePaging) != nil:
+		response.Result = ldap.LDAPResultUnwillingToPerform
+		return response, ldap.NewError(response.Result, errors.New("virtual list view cannot be combined with paged results"))
+	}
+	return nil, nil
+}
+
+// vlvSearchResult reduces the entries of resp to the ones requested by the
+// virtual list view control of searchReq if it was not handled by the search
+// handler.
+func vlvSearchResult(server *Server, searchReq *ldap.SearchRequest, resp *ServerSearchResult) error {
+	control, ok := ldap.FindControl(searchReq.Controls, ldap.ControlTypeVLVRequest).(*ControlVLVRequest)
+	if !ok || ldap.FindControl(resp.Controls, ldap.ControlTypeVLVResponse) != nil {
+		return nil
+	}
+	sortControl := ldap.FindControl(searchReq.Controls, ldap.ControlTypeServerSideSorting).(*ControlServerSideSorting)
+	sorter, sortResult := NewEntrySorter(server.Schema(), sortControl)
+	if sorter == nil {
+		response := &ControlVLVResponse{
+			Result:    uint16(sortResult.Result),
+			ContextID: control.ContextID,
+		}
+		resp.Controls = append(resp.Controls, response)
+		return ldap.NewError(response.Result, errors.New("unable to sort search result"))
+	}
+
+	entries := resp.Entries
+	var atOrAfter func(i int) bool
+	if control.GreaterThanOrEqual != nil {
+		atOrAfter = func(i int) bool {
+			return sorter.atOrAfter(entries[i], *control.GreaterThanOrEqual)
+		}
+	}
+	start, end, response := VirtualListView(control, len(entries), atOrAfter)
+	resp.Controls = append(resp.Controls, response)
+	if response.Result != ldap.LDAPResultSuccess {
+		return ldap.NewError(response.Result, errors.New(ldap.LDAPResultCodeMap[response.Result]))
+	}
+	resp.Entries = entries[start:end]
+	return nil
+}
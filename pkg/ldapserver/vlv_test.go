@@ -0,0 +1,113 @@
+package ldapserver
+
+import (
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestVirtualListView(t *testing.T) {
+	values := []int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+	greaterThanOrEqual := func(value int) func(i int) bool {
+		return func(i int) bool {
+			return values[i] >= value
+		}
+	}
+	for _, tc := range []struct {
+		name      string
+		control   ControlVLVRequest
+		atOrAfter func(i int) bool
+		count     int
+		start     int
+		end       int
+		position  int
+		result    uint16
+	}{
+		{"first", ControlVLVRequest{AfterCount: 2, Offset: 1}, nil, 10, 0, 3, 1, ldap.LDAPResultSuccess},
+		{"middle", ControlVLVRequest{BeforeCount: 1, AfterCount: 1, Offset: 5}, nil, 10, 3, 6, 5, ldap.LDAPResultSuccess},
+		{"last", ControlVLVRequest{BeforeCount: 2, AfterCount: 2, Offset: 10, ContentCount: 10}, nil, 10, 7, 10, 10, ldap.LDAPResultSuccess},
+		{"beyond end", ControlVLVRequest{BeforeCount: 1, Offset: 20}, nil, 10, 8, 10, 10, ldap.LDAPResultSuccess},
+		{"scaled", ControlVLVRequest{Offset: 50, ContentCount: 100}, nil, 10, 4, 5, 5, ldap.LDAPResultSuccess},
+		{"out of range", ControlVLVRequest{Offset: 11, ContentCount: 10}, nil, 10, 0, 0, 0, ldap.LDAPResultOffsetRangeError},
+		{"empty", ControlVLVRequest{AfterCount: 5, Offset: 1}, nil, 0, 0, 0, 0, ldap.LDAPResultSuccess},
+		{"greaterThanOrEqual", ControlVLVRequest{BeforeCount: 1, AfterCount: 1, GreaterThanOrEqual: new(string)}, greaterThanOrEqual(35), 10, 2, 5, 4, ldap.LDAPResultSuccess},
+		{"greaterThanOrEqual none", ControlVLVRequest{BeforeCount: 2, GreaterThanOrEqual: new(string)}, greaterThanOrEqual(200), 10, 8, 10, 11, ldap.LDAPResultSuccess},
+	} {
+		start, end, response := VirtualListView(&tc.control, tc.count, tc.atOrAfter)
+		if start != tc.start || end != tc.end || response.TargetPosition != tc.position || response.Result != tc.result {
+			t.Errorf("%s: expected [%d:%d] at %d with result %d, got [%d:%d] at %d with result %d", tc.name, tc.start, tc.end, tc.position, tc.result, start, end, response.TargetPosition, response.Result)
+		}
+		if tc.result == ldap.LDAPResultSuccess && response.ContentCount != tc.count {
+			t.Errorf("%s: expected content count %d, got %d", tc.name, tc.count, response.ContentCount)
+		}
+	}
+}
+
+func TestControlVLVRequestDecode(t *testing.T) {
+	assertion := "smith"
+	for _, control := range []*ControlVLVRequest{
+		{Criticality: true, BeforeCount: 1, AfterCount: 10, Offset: 5, ContentCount: 100},
+		{BeforeCount: 0, AfterCount: 20, GreaterThanOrEqual: &assertion, ContextID: []byte("ctx")},
+	} {
+		packet, err := ber.DecodePacketErr(control.Encode().Bytes())
+		if err != nil {
+			t.Fatalf("Failed to decode packet: %s", err)
+		}
+		decoded, err := decodeControl(packet)
+		if err != nil {
+			t.Fatalf("Failed to decode control: %s", err)
+		}
+		if got, want := decoded.String(), control.String(); got != want {
+			t.Errorf("Unexpected decoded control: %s, expected %s", got, want)
+		}
+		if got := decoded.(*ControlVLVRequest).ContextID; string(got) != string(control.ContextID) {
+			t.Errorf("Unexpected context ID: %q", got)
+		}
+	}
+}
+
+func TestSearchVirtualListView(t *testing.T) {
+	server := NewServer()
+	server.EnforceLDAP = true
+	server.SearchFunc("", sortSearcher{entries: newSortTestEntries()})
+
+	// Failed searches end the connection, so each search uses its own.
+	search := func(controls ...ldap.Control) (*ldap.SearchResult, error) {
+		l := startTestConn(t, server)
+		defer l.Close()
+		return l.Search(ldap.NewSearchRequest("o=base", ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, "(givenName=*)", nil, controls))
+	}
+	sortControl := &ControlServerSideSorting{
+		SortKeys: []*ldap.SortKey{{AttributeType: "givenName"}},
+	}
+
+	res, err := search(sortControl, &ControlVLVRequest{BeforeCount: 1, AfterCount: 1, Offset: 3})
+	if err != nil {
+		t.Fatalf("Search failed: %s", err)
+	}
+	if got := sortedUIDs(res.Entries); got != "c,a,b" {
+		t.Errorf("Unexpected entries: %s", got)
+	}
+	if ldap.FindControl(res.Controls, ldap.ControlTypeVLVResponse) == nil {
+		t.Errorf("Expected virtual list view response control")
+	}
+
+	assertion := "b"
+	res, err = search(sortControl, &ControlVLVRequest{AfterCount: 1, GreaterThanOrEqual: &assertion})
+	if err != nil {
+		t.Fatalf("Search failed: %s", err)
+	}
+	if got := sortedUIDs(res.Entries); got != "a,b" {
+		t.Errorf("Unexpected entries: %s", got)
+	}
+
+	_, err = search(&ControlVLVRequest{Offset: 1})
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultSortControlMissing) {
+		t.Errorf("Expected SortControlMissing without sort control, got: %v", err)
+	}
+	_, err = search(sortControl, &ControlVLVRequest{Offset: 5, ContentCount: 4})
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultOffsetRangeError) {
+		t.Errorf("Expected OffsetRangeError, got: %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const whoAmIOID = "1.3.6.1.4.1.4203.1.11.3"
+
+func init() {
+	RegisterExtendedOperation(whoAmIOID, HandleWhoAmIExOp)
+}
+
+// HandleWhoAmIExOp returns the authorization identity of the operation as
+// defined in RFC 4532. It is the dn: authzId of boundDN, which is the
+// identity mapped by SASL binds or asserted with the proxied authorization
+// control, and empty for anonymous clients.
+func HandleWhoAmIExOp(ctx context.Context, req *ber.Packet, boundDN string, server *Server, conn net.Conn) (*ber.Packet, error) {
+	logger.V(1).Info("HandleWhoAmIExOp")
+	if req != nil {
+		return nil, ldap.NewError(ldap.LDAPResultProtocolError, errors.New("who am I? request with value"))
+	}
+	authzID := ""
+	if boundDN != "" {
+		authzID = "dn:" + boundDN
+	}
+	// The response value is the authzId itself and always present, as
+	// clients expect it for anonymous clients, too.
+	return ber.NewString(ber.ClassContext, ber.TypePrimitive, 11, authzID, "responseValue"), nil
+}
@@ -0,0 +1,32 @@
+package ldapserver
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestWhoAmI(t *testing.T) {
+	server := NewServer()
+	server.BindFunc("", testBinder{})
+	server.IdentityMapperFunc("", proxyTestMapper{})
+	server.ProxyAuthorization = server.ProxyGroupRule("cn=proxies,o=base", "o=base")
+	l := startTestConn(t, server)
+	defer l.Close()
+
+	res, err := l.WhoAmI(nil)
+	if err != nil || res.AuthzID != "" {
+		t.Errorf("Anonymous who am I? returned %v: %v", res, err)
+	}
+	if err = l.Bind("cn=test,o=base", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	res, err = l.WhoAmI(nil)
+	if err != nil || res.AuthzID != "dn:cn=test,o=base" {
+		t.Errorf("Who am I? returned %v: %v", res, err)
+	}
+	res, err = l.WhoAmI([]ldap.Control{&ControlProxiedAuthorization{Criticality: true, AuthzID: "u:alice"}})
+	if err != nil || res.AuthzID != "dn:uid=alice,o=base" {
+		t.Errorf("Proxied who am I? returned %v: %v", res, err)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2021 The LibreGraph Authors.
+ */
+
+package ldbbolt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/libregraph/idm/pkg/ldapdn"
+)
+
+// isAlias returns true if entry is an alias entry.
+func isAlias(entry *ldap.Entry) bool {
+	if entry == nil {
+		return false
+	}
+	for _, value := range entry.GetEqualFoldAttributeValues("objectClass") {
+		if strings.EqualFold(value, "alias") {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchAliases returns the alias entries in scope of base. The entries are
+// looked up by the alias index, so searches without aliases in scope do not
+// load any entries.
+func (bdb *LdbBolt) SearchAliases(base string, scope int) ([]*ldap.Entry, error) {
+	nDN, err := ldapdn.ParseNormalize(base)
+	if err != nil {
+		return nil, err
+	}
+	entries := []*ldap.Entry{}
+	err = bdb.db.View(func(tx *bolt.Tx) error {
+		entryID := bdb.getIDByDN(tx, nDN)
+		if entryID == 0 {
+			return ErrEntryNotFound
+		}
+		bucket := tx.Bucket([]byte("aliasindex"))
+		if bucket == nil {
+			// Read-only databases may not have the index yet.
+			for _, id := range bdb.getScopeIDs(tx, entryID, scope) {
+				entry, err := bdb.loadEntry(tx, id)
+				if err != nil {
+					return err
+				}
+				if isAlias(entry) {
+					entries = append(entries, entry)
+				}
+			}
+			return nil
+		}
+		return bucket.ForEach(func(k, _ []byte) error {
+			id := binary.LittleEndian.Uint64(k)
+			entry, err := bdb.loadEntry(tx, id)
+			if err != nil {
+				return err
+			}
+			if id == entryID {
+				if scope != ldap.ScopeSingleLevel {
+					entries = append(entries, entry)
+				}
+				return nil
+			}
+			parsed, err := ldap.ParseDN(entry.DN)
+			if err != nil {
+				return err
+			}
+			switch scope {
+			case ldap.ScopeSingleLevel:
+				if ldapdn.Normalize(&ldap.DN{RDNs: parsed.RDNs[1:]}) == nDN {
+					entries = append(entries, entry)
+				}
+			case ldap.ScopeWholeSubtree:
+				if strings.HasSuffix(ldapdn.Normalize(parsed), ","+nDN) {
+					entries = append(entries, entry)
+				}
+			}
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// updateAliasIndex updates the alias index for the change of the entry with
+// id from oldEntry to newEntry. oldEntry is nil for added entries and
+// newEntry is nil for deleted entries.
+func (bdb *LdbBolt) updateAliasIndex(tx *bolt.Tx, id uint64, oldEntry, newEntry *ldap.Entry) error {
+	bucket := tx.Bucket([]byte("aliasindex"))
+	if bucket == nil {
+		return nil
+	}
+	switch wasAlias, alias := isAlias(oldEntry), isAlias(newEntry); {
+	case alias && !wasAlias:
+		return bucket.Put(idToBytes(id), []byte{})
+	case wasAlias && !alias:
+		return bucket.Delete(idToBytes(id))
+	}
+	return nil
+}
+
+// initializeAliasIndex creates the bucket of the alias index. If it did not
+// exist yet, it is built from the existing entries.
+func (bdb *LdbBolt) initializeAliasIndex(tx *bolt.Tx) error {
+	if tx.Bucket([]byte("aliasindex")) != nil {
+		return nil
+	}
+	bucket, err := tx.CreateBucket([]byte("aliasindex"))
+	if err != nil {
+		return fmt.Errorf("create bucket 'aliasindex': %w", err)
+	}
+	id2entry := tx.Bucket([]byte("id2entry"))
+	err = id2entry.ForEach(func(k, _ []byte) error {
+		entry, err := bdb.getEntryByID(tx, binary.LittleEndian.Uint64(k))
+		if err != nil {
+			return err
+		}
+		if isAlias(entry) {
+			return bucket.Put(k, []byte{})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("build alias index: %w", err)
+	}
+	return nil
+}
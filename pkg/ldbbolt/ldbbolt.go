@@ -17,6 +17,15 @@
 //   - id2children: This bucket uses the entry-ids as and index and the values contain a list
 //     of the entry ids of its direct childdren
 //
+//   - sortindex: This bucket contains a bucket per sorted attribute, in which the entry ids
+//     are keyed by the ordering key of their least value of the attribute
+//
+//   - aliasindex: This bucket contains the ids of the alias entries as keys, so that
+//     aliases can be dereferenced without searching for them
+//
+//   - meta: This bucket contains the contextCSN, the change sequence number of the last
+//     change of the database
+//
 // Additional buckets will likely be added in the future to create efficient search indexes
 package ldbbolt
 
@@ -27,7 +36,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-ldap/ldap/v3"
 	"github.com/sirupsen/logrus"
@@ -36,15 +48,38 @@ import (
 	"github.com/libregraph/idm/pkg/ldapdn"
 	"github.com/libregraph/idm/pkg/ldapentry"
 	"github.com/libregraph/idm/pkg/ldappassword"
+	"github.com/libregraph/idm/pkg/schema"
 )
 
 type LdbBolt struct {
-	logger  logrus.FieldLogger
-	db      *bolt.DB
-	options *bolt.Options
-	base    string
+	logger     logrus.FieldLogger
+	db         *bolt.DB
+	options    *bolt.Options
+	base       string
+	entryCheck EntryCheckFunc
+	changeFunc ChangeFunc
+
+	// updateMutex serializes updates, so that changes are passed to
+	// changeFunc in the order of their CSNs.
+	updateMutex sync.Mutex
+
+	// schema is the built-in schema, which defines the ordering of the sort
+	// indexes and the equality of values in modifications.
+	schema      *schema.Schema
+	sortIndexes []*sortIndex
 }
 
+// EntryCheckFunc is called before an entry is written to the database. For
+// new entries oldEntry is nil. If it returns an error, the entry is not
+// written and the error is returned to the caller.
+type EntryCheckFunc func(oldEntry, newEntry *ldap.Entry) error
+
+// ChangeFunc is called after a change of the database was committed, with
+// the entry before and after the change and the CSN of the change. For added
+// entries oldEntry is nil, for deleted entries newEntry is nil. The entries
+// lack the hasSubordinates attribute. It must not update the database.
+type ChangeFunc func(oldEntry, newEntry *ldap.Entry, csn string)
+
 var (
 	ErrEntryAlreadyExists = errors.New("entry already exists")
 	ErrEntryNotFound      = errors.New("entry does not exist")
@@ -63,9 +98,22 @@ func (bdb *LdbBolt) Configure(logger logrus.FieldLogger, baseDN, dbfile string,
 	bdb.db = db
 	bdb.options = options
 	bdb.base, _ = ldapdn.ParseNormalize(baseDN)
+	bdb.schema, bdb.sortIndexes = newSortIndexes()
 	return nil
 }
 
+// SetEntryCheck sets the function which checks entries before they are
+// added or modified. Passing nil disables the check.
+func (bdb *LdbBolt) SetEntryCheck(check EntryCheckFunc) {
+	bdb.entryCheck = check
+}
+
+// SetChangeFunc sets the function which is called after changes. Passing
+// nil disables it.
+func (bdb *LdbBolt) SetChangeFunc(fn ChangeFunc) {
+	bdb.changeFunc = fn
+}
+
 // Initialize() opens the Database file and create the required buckets if they do not
 // exist yet. After calling initialize the database is ready to process transactions
 func (bdb *LdbBolt) Initialize() error {
@@ -86,7 +134,14 @@ func (bdb *LdbBolt) Initialize() error {
 			if err != nil {
 				return fmt.Errorf("create bucket 'id2entry': %w", err)
 			}
-			return nil
+			_, err = tx.CreateBucketIfNotExists([]byte("meta"))
+			if err != nil {
+				return fmt.Errorf("create bucket 'meta': %w", err)
+			}
+			if err = bdb.initializeSortIndexes(tx); err != nil {
+				return err
+			}
+			return bdb.initializeAliasIndex(tx)
 		})
 		if err != nil {
 			logger.WithError(err).Error("Error creating default buckets")
@@ -108,21 +163,11 @@ func (bdb *LdbBolt) Search(base string, scope int) ([]*ldap.Entry, error) {
 
 	err = bdb.db.View(func(tx *bolt.Tx) error {
 		entryID := bdb.getIDByDN(tx, nDN)
-		var entryIDs []uint64
 		if entryID == 0 {
-			return fmt.Errorf("not found")
-		}
-		switch scope {
-		case ldap.ScopeBaseObject:
-			entryIDs = append(entryIDs, entryID)
-		case ldap.ScopeSingleLevel:
-			entryIDs = bdb.getChildrenIDs(tx, entryID)
-		case ldap.ScopeWholeSubtree:
-			entryIDs = append(entryIDs, entryID)
-			entryIDs = append(entryIDs, bdb.getSubtreeIDs(tx, entryID)...)
-		}
-		for _, id := range entryIDs {
-			entry, err := bdb.getEntryByID(tx, id)
+			return ErrEntryNotFound
+		}
+		for _, id := range bdb.getScopeIDs(tx, entryID, scope) {
+			entry, err := bdb.loadEntry(tx, id)
 			if err != nil {
 				return err
 			}
@@ -133,12 +178,100 @@ func (bdb *LdbBolt) Search(base string, scope int) ([]*ldap.Entry, error) {
 	return entries, err
 }
 
+// SearchIDs returns the ids of the entries in scope of base, in the same
+// order as Search returns the entries. The entries can be loaded later with
+// LoadEntries, which allows to process the result of a search in parts.
+func (bdb *LdbBolt) SearchIDs(base string, scope int) ([]uint64, error) {
+	nDN, err := ldapdn.ParseNormalize(base)
+	if err != nil {
+		return nil, err
+	}
+	var entryIDs []uint64
+	err = bdb.db.View(func(tx *bolt.Tx) error {
+		entryID := bdb.getIDByDN(tx, nDN)
+		if entryID == 0 {
+			return ErrEntryNotFound
+		}
+		entryIDs = bdb.getScopeIDs(tx, entryID, scope)
+		return nil
+	})
+	return entryIDs, err
+}
+
+// SearchEach calls fn with each entry in scope of base, in the same order as
+// Search returns them, until fn returns false. Unlike Search it does not keep
+// the entries in memory and the ids and entries are read in the same
+// transaction, so fn must not modify the database.
+func (bdb *LdbBolt) SearchEach(base string, scope int, fn func(id uint64, entry *ldap.Entry) bool) error {
+	nDN, err := ldapdn.ParseNormalize(base)
+	if err != nil {
+		return err
+	}
+	return bdb.db.View(func(tx *bolt.Tx) error {
+		entryID := bdb.getIDByDN(tx, nDN)
+		if entryID == 0 {
+			return ErrEntryNotFound
+		}
+		for _, id := range bdb.getScopeIDs(tx, entryID, scope) {
+			entry, err := bdb.loadEntry(tx, id)
+			if err != nil {
+				return err
+			}
+			if !fn(id, entry) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// LoadEntries loads the entries with ids in order and calls fn with each of
+// them until it returns false. Entries which no longer exist are skipped. It
+// returns the number of ids which were processed.
+func (bdb *LdbBolt) LoadEntries(ids []uint64, fn func(id uint64, entry *ldap.Entry) bool) (int, error) {
+	processed := 0
+	err := bdb.db.View(func(tx *bolt.Tx) error {
+		id2entry := tx.Bucket([]byte("id2entry"))
+		for _, id := range ids {
+			processed++
+			if id2entry.Get(idToBytes(id)) == nil {
+				continue
+			}
+			entry, err := bdb.loadEntry(tx, id)
+			if err != nil {
+				return err
+			}
+			if !fn(id, entry) {
+				break
+			}
+		}
+		return nil
+	})
+	return processed, err
+}
+
 func idToBytes(id uint64) []byte {
 	b := make([]byte, 8)
 	binary.LittleEndian.PutUint64(b, id)
 	return b
 }
 
+// getScopeIDs returns the ids of the entries in scope of the entry with
+// baseID.
+func (bdb *LdbBolt) getScopeIDs(tx *bolt.Tx, baseID uint64, scope int) []uint64 {
+	var entryIDs []uint64
+	switch scope {
+	case ldap.ScopeBaseObject:
+		entryIDs = append(entryIDs, baseID)
+	case ldap.ScopeSingleLevel:
+		entryIDs = bdb.getChildrenIDs(tx, baseID)
+	case ldap.ScopeWholeSubtree:
+		entryIDs = append(entryIDs, baseID)
+		entryIDs = append(entryIDs, bdb.getSubtreeIDs(tx, baseID)...)
+	}
+	return entryIDs
+}
+
 func (bdb *LdbBolt) getChildrenIDs(tx *bolt.Tx, parent uint64) []uint64 {
 	id2Children := tx.Bucket([]byte("id2children"))
 	children := id2Children.Get(idToBytes(parent))
@@ -172,12 +305,27 @@ func (bdb *LdbBolt) getSubtreeIDs(tx *bolt.Tx, root uint64) []uint64 {
 	return res
 }
 
-func (bdb *LdbBolt) EntryPut(e *ldap.Entry) error {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	if err := enc.Encode(e); err != nil {
-		fmt.Printf("%v\n", err)
-		panic(err)
+// EntryPut adds the new entry e, which was added by creator. It maintains
+// the operational attributes createTimestamp, modifyTimestamp, creatorsName,
+// modifiersName and entryUUID of the entry, but keeps them if e already has
+// them. creator can be empty if it is unknown, for example when loading
+// entries. The entryCSN of e is always set to the CSN of the addition. The
+// checks are called with the new entry within the transaction, before it is
+// committed.
+func (bdb *LdbBolt) EntryPut(e *ldap.Entry, creator string, checks ...EntryCheckFunc) error {
+	return bdb.Transaction(func(txn *Txn) error {
+		return txn.EntryPut(e, creator, checks...)
+	})
+}
+
+// EntryPut adds the entry e within txn, see LdbBolt.EntryPut.
+func (txn *Txn) EntryPut(e *ldap.Entry, creator string, checks ...EntryCheckFunc) error {
+	bdb := txn.bdb
+	stampCreate(e, creator, time.Now())
+	if bdb.entryCheck != nil {
+		if err := bdb.entryCheck(nil, e); err != nil {
+			return err
+		}
 	}
 
 	dn, _ := ldap.ParseDN(e.DN)
@@ -191,13 +339,22 @@ func (bdb *LdbBolt) EntryPut(e *ldap.Entry) error {
 	}
 
 	nParentDN := ldapdn.Normalize(parentDN)
-	err := bdb.db.Update(func(tx *bolt.Tx) error {
+	err := txn.update(func(tx *bolt.Tx, c *change) error {
 		id2entry := tx.Bucket([]byte("id2entry"))
 		id := bdb.getIDByDN(tx, nDN)
 		if id != 0 {
 			return ErrEntryAlreadyExists
 		}
-		var err error
+		csn, err := bdb.nextCSN(tx)
+		if err != nil {
+			return err
+		}
+		ldapentry.SetAttributeValues(e, entryCSNAttribute, []string{csn})
+		var buf bytes.Buffer
+		enc := gob.NewEncoder(&buf)
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
 		if id, err = id2entry.NextSequence(); err != nil {
 			return err
 		}
@@ -214,12 +371,26 @@ func (bdb *LdbBolt) EntryPut(e *ldap.Entry) error {
 		if err := dn2id.Put([]byte(nDN), idToBytes(id)); err != nil {
 			return err
 		}
-		return nil
-	})
+		c.newEntry, c.csn = e, csn
+		if err := bdb.updateSortIndexes(tx, id, nil, e); err != nil {
+			return err
+		}
+		return bdb.updateAliasIndex(tx, id, nil, e)
+	}, checks...)
 	return err
 }
 
-func (bdb *LdbBolt) EntryDelete(dn string) error {
+// EntryDelete deletes the leaf entry dn. The checks are called with the
+// deleted entry within the transaction, before it is committed.
+func (bdb *LdbBolt) EntryDelete(dn string, checks ...EntryCheckFunc) error {
+	return bdb.Transaction(func(txn *Txn) error {
+		return txn.EntryDelete(dn, checks...)
+	})
+}
+
+// EntryDelete deletes the leaf entry dn within txn, see LdbBolt.EntryDelete.
+func (txn *Txn) EntryDelete(dn string, checks ...EntryCheckFunc) error {
+	bdb := txn.bdb
 	parsed, err := ldap.ParseDN(dn)
 	if err != nil {
 		return err
@@ -230,7 +401,7 @@ func (bdb *LdbBolt) EntryDelete(dn string) error {
 	pdn := ldapdn.Normalize(pparentDN)
 
 	ndn := ldapdn.Normalize(parsed)
-	err = bdb.db.Update(func(tx *bolt.Tx) error {
+	err = txn.update(func(tx *bolt.Tx, c *change) error {
 		// Does this entry even exist?
 		entryID := bdb.getIDByDN(tx, ndn)
 		if entryID == 0 {
@@ -249,17 +420,8 @@ func (bdb *LdbBolt) EntryDelete(dn string) error {
 		if parentid == 0 {
 			return ErrEntryNotFound
 		}
-		children = id2Children.Get(idToBytes(parentid))
-		r := bytes.NewReader(children)
-		var newids []byte
-		idBytes := make([]byte, 8)
-		for _, err = io.ReadFull(r, idBytes); err == nil; _, err = io.ReadFull(r, idBytes) {
-			if entryID != binary.LittleEndian.Uint64(idBytes) {
-				newids = append(newids, idBytes...)
-			}
-		}
-		if err = id2Children.Put(idToBytes(parentid), newids); err != nil {
-			return fmt.Errorf("error updating id2Children index for %d: %w", parentid, err)
+		if err = bdb.removeID2Children(tx, parentid, entryID); err != nil {
+			return err
 		}
 
 		// Remove entry from dn2id bucket
@@ -268,37 +430,74 @@ func (bdb *LdbBolt) EntryDelete(dn string) error {
 		if err != nil {
 			return err
 		}
+		entry, err := bdb.getEntryByID(tx, entryID)
+		if err != nil {
+			return err
+		}
+		if err = bdb.updateSortIndexes(tx, entryID, entry, nil); err != nil {
+			return err
+		}
+		if err = bdb.updateAliasIndex(tx, entryID, entry, nil); err != nil {
+			return err
+		}
 		id2entry := tx.Bucket([]byte("id2entry"))
 		err = id2entry.Delete(idToBytes(entryID))
 		if err != nil {
 			return err
 		}
 
-		return nil
-	})
+		c.oldEntry = entry
+		c.csn, err = bdb.nextCSN(tx)
+		return err
+	}, checks...)
 	return err
 }
 
-func (bdb *LdbBolt) EntryModify(req *ldap.ModifyRequest) error {
+// EntryModify applies the modify request req, which was issued by modifier.
+// The checks are called with the entry before and after the modification
+// within the transaction, before it is committed.
+func (bdb *LdbBolt) EntryModify(req *ldap.ModifyRequest, modifier string, checks ...EntryCheckFunc) error {
+	return bdb.Transaction(func(txn *Txn) error {
+		return txn.EntryModify(req, modifier, checks...)
+	})
+}
+
+// EntryModify applies req within txn, see LdbBolt.EntryModify.
+func (txn *Txn) EntryModify(req *ldap.ModifyRequest, modifier string, checks ...EntryCheckFunc) error {
+	bdb := txn.bdb
 	ndn, err := ldapdn.ParseNormalize(req.DN)
 	if err != nil {
 		return err
 	}
-	err = bdb.db.Update(func(tx *bolt.Tx) error {
+	err = txn.update(func(tx *bolt.Tx, c *change) error {
 		oldEntry, id, innerErr := bdb.getEntryByDN(tx, ndn)
 		if innerErr != nil {
 			return innerErr
 		}
-		return bdb.entryModifyWithTxn(tx, id, oldEntry, req)
-	})
+		return bdb.entryModifyWithTxn(tx, c, id, oldEntry, req, modifier)
+	}, checks...)
 	return err
 }
 
-func (bdb *LdbBolt) entryModifyWithTxn(tx *bolt.Tx, id uint64, entry *ldap.Entry, req *ldap.ModifyRequest) error {
-	newEntry, innerErr := ldapentry.ApplyModify(entry, req)
+// entryModifyWithTxn applies req to entry, which has id, and records the
+// change in c.
+func (bdb *LdbBolt) entryModifyWithTxn(tx *bolt.Tx, c *change, id uint64, entry *ldap.Entry, req *ldap.ModifyRequest, modifier string) error {
+	newEntry, innerErr := ldapentry.ApplyModifyWithSchema(bdb.schema, entry, req)
 	if innerErr != nil {
 		return innerErr
 	}
+	stampModify(newEntry, modifier, time.Now())
+	if bdb.entryCheck != nil {
+		if innerErr := bdb.entryCheck(entry, newEntry); innerErr != nil {
+			return innerErr
+		}
+	}
+	csn, innerErr := bdb.nextCSN(tx)
+	if innerErr != nil {
+		return innerErr
+	}
+	ldapentry.SetAttributeValues(newEntry, entryCSNAttribute, []string{csn})
+	c.oldEntry, c.newEntry, c.csn = entry, newEntry, csn
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
 	if innerErr := enc.Encode(newEntry); innerErr != nil {
@@ -308,10 +507,26 @@ func (bdb *LdbBolt) entryModifyWithTxn(tx *bolt.Tx, id uint64, entry *ldap.Entry
 	if innerErr := id2entry.Put(idToBytes(id), buf.Bytes()); innerErr != nil {
 		return innerErr
 	}
-	return nil
+	if innerErr := bdb.updateSortIndexes(tx, id, entry, newEntry); innerErr != nil {
+		return innerErr
+	}
+	return bdb.updateAliasIndex(tx, id, entry, newEntry)
 }
 
-func (bdb *LdbBolt) EntryModifyDN(req *ldap.ModifyDNRequest) error {
+// EntryModifyDN renames an entry as requested by req, which was issued by
+// modifier. Entries with subordinates are renamed together with their whole
+// subtree. If req.NewSuperior is set, the entry is moved below it. The
+// checks are called with the entry before and after the rename within the
+// transaction, before it is committed.
+func (bdb *LdbBolt) EntryModifyDN(req *ldap.ModifyDNRequest, modifier string, checks ...EntryCheckFunc) error {
+	return bdb.Transaction(func(txn *Txn) error {
+		return txn.EntryModifyDN(req, modifier, checks...)
+	})
+}
+
+// EntryModifyDN renames an entry within txn, see LdbBolt.EntryModifyDN.
+func (txn *Txn) EntryModifyDN(req *ldap.ModifyDNRequest, modifier string, checks ...EntryCheckFunc) error {
+	bdb := txn.bdb
 	olddn, err := ldap.ParseDN(req.DN)
 	if err != nil {
 		return err
@@ -322,15 +537,34 @@ func (bdb *LdbBolt) EntryModifyDN(req *ldap.ModifyDNRequest) error {
 		return err
 	}
 
+	oldParentDN := &ldap.DN{RDNs: olddn.RDNs[1:]}
+	newParentDN := oldParentDN
+	if req.NewSuperior != "" {
+		if newParentDN, err = ldap.ParseDN(req.NewSuperior); err != nil {
+			return err
+		}
+	}
+
 	var newDN ldap.DN
 
 	newDN.RDNs = []*ldap.RelativeDN{newrdn.RDNs[0]}
-	newDN.RDNs = append(newDN.RDNs, olddn.RDNs[1:]...)
-
-	err = bdb.db.Update(func(tx *bolt.Tx) error {
-		flatNewDN := ldapdn.Normalize(&newDN)
-		flatOldDN := ldapdn.Normalize(olddn)
+	newDN.RDNs = append(newDN.RDNs, newParentDN.RDNs...)
+
+	flatNewDN := ldapdn.Normalize(&newDN)
+	flatOldDN := ldapdn.Normalize(olddn)
+	flatNewParentDN := ldapdn.Normalize(newParentDN)
+	switch {
+	case flatOldDN == bdb.base:
+		return ldap.NewError(ldap.LDAPResultUnwillingToPerform, errors.New("the base entry cannot be renamed"))
+	case !strings.HasSuffix(flatNewDN, bdb.base):
+		return fmt.Errorf("'%s' is not a descendant of '%s'", newDN.String(), bdb.base)
+	case flatNewParentDN == flatOldDN || strings.HasSuffix(flatNewParentDN, ","+flatOldDN):
+		return ldap.NewError(ldap.LDAPResultUnwillingToPerform, errors.New("an entry cannot be moved below itself"))
+	}
 
+	// The changes of the renamed subordinates follow the change of the entry.
+	var subordinates []change
+	err = txn.update(func(tx *bolt.Tx, c *change) error {
 		// error out if there is an entry with the new name already
 		if id := bdb.getIDByDN(tx, flatNewDN); id != 0 {
 			return ErrEntryAlreadyExists
@@ -341,13 +575,14 @@ func (bdb *LdbBolt) EntryModifyDN(req *ldap.ModifyDNRequest) error {
 			return innerErr
 		}
 
-		// only allow renaming leaf entries
-		childIds := bdb.getChildrenIDs(tx, id)
-		if len(childIds) > 0 {
-			return ErrNonLeafEntry
+		oldParentID := bdb.getIDByDN(tx, ldapdn.Normalize(oldParentDN))
+		newParentID := bdb.getIDByDN(tx, flatNewParentDN)
+		if newParentID == 0 {
+			return ldap.NewError(ldap.LDAPResultNoSuchObject, fmt.Errorf("new superior '%s' does not exist", newParentDN.String()))
 		}
 
-		entry.DN = flatNewDN
+		oldEntry := *entry
+		entry.DN = newDN.String()
 
 		modReq := ldap.ModifyRequest{
 			DN: entry.DN,
@@ -363,10 +598,11 @@ func (bdb *LdbBolt) EntryModifyDN(req *ldap.ModifyDNRequest) error {
 		for _, ava := range newrdn.RDNs[0].Attributes {
 			modReq.Add(ava.Type, []string{ava.Value})
 		}
-		innerErr = bdb.entryModifyWithTxn(tx, id, entry, &modReq)
+		innerErr = bdb.entryModifyWithTxn(tx, c, id, entry, &modReq, modifier)
 		if innerErr != nil {
 			return innerErr
 		}
+		c.oldEntry = &oldEntry
 
 		// update the dn2id index
 		dn2id := tx.Bucket([]byte("dn2id"))
@@ -376,18 +612,98 @@ func (bdb *LdbBolt) EntryModifyDN(req *ldap.ModifyDNRequest) error {
 		if err := dn2id.Delete([]byte(flatOldDN)); err != nil {
 			return err
 		}
-		return nil
+
+		// update the id2children index of the old and the new parent
+		if newParentID != oldParentID {
+			if err := bdb.removeID2Children(tx, oldParentID, id); err != nil {
+				return err
+			}
+			if err := bdb.addID2Children(tx, flatNewParentDN, id); err != nil {
+				return err
+			}
+		}
+
+		subordinates, innerErr = bdb.renameSubtree(tx, id, olddn, &newDN)
+		return innerErr
+	}, checks...)
+	if err != nil {
+		return err
+	}
+	txn.changes = append(txn.changes, subordinates...)
+	return nil
+}
+
+// renameSubtree replaces the suffix oldDN of the DNs of the subordinates of
+// the entry with id by newDN, in the dn2id index and in the stored entries.
+// The stored DNs keep the RDNs of the subordinates as they are, only the
+// keys of the dn2id index are normalized. The entryCSN of every renamed
+// entry is updated, so that content synchronization picks up the new DNs.
+// It returns the changes of the renamed entries.
+func (bdb *LdbBolt) renameSubtree(tx *bolt.Tx, id uint64, oldDN, newDN *ldap.DN) ([]change, error) {
+	dn2id := tx.Bucket([]byte("dn2id"))
+	id2entry := tx.Bucket([]byte("id2entry"))
+	flatOldDN := ldapdn.Normalize(oldDN)
+	var changes []change
+	for _, subID := range bdb.getSubtreeIDs(tx, id) {
+		entry, err := bdb.getEntryByID(tx, subID)
+		if err != nil {
+			return nil, err
+		}
+		subDN, err := ldap.ParseDN(entry.DN)
+		if err != nil {
+			return nil, err
+		}
+		flatSubDN := ldapdn.Normalize(subDN)
+		depth := len(subDN.RDNs) - len(oldDN.RDNs)
+		if depth < 1 || !strings.HasSuffix(flatSubDN, ","+flatOldDN) {
+			return nil, fmt.Errorf("subordinate '%s' is not a descendant of '%s'", flatSubDN, flatOldDN)
+		}
+		newSubDN := &ldap.DN{RDNs: append(slices.Clone(subDN.RDNs[:depth]), newDN.RDNs...)}
+		flatNewSubDN := ldapdn.Normalize(newSubDN)
+
+		csn, err := bdb.nextCSN(tx)
+		if err != nil {
+			return nil, err
+		}
+		newEntry := &ldap.Entry{DN: newSubDN.String(), Attributes: entry.Attributes}
+		ldapentry.SetAttributeValues(newEntry, entryCSNAttribute, []string{csn})
+		var buf bytes.Buffer
+		enc := gob.NewEncoder(&buf)
+		if err := enc.Encode(newEntry); err != nil {
+			return nil, err
+		}
+		if err := id2entry.Put(idToBytes(subID), buf.Bytes()); err != nil {
+			return nil, err
+		}
+		if err := dn2id.Delete([]byte(flatSubDN)); err != nil {
+			return nil, err
+		}
+		if err := dn2id.Put([]byte(flatNewSubDN), idToBytes(subID)); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change{oldEntry: entry, newEntry: newEntry, csn: csn})
+	}
+	return changes, nil
+}
+
+// UpdatePassword sets the password of an entry as requested by req, which
+// was issued by modifier.
+func (bdb *LdbBolt) UpdatePassword(req *ldap.PasswordModifyRequest, modifier string) error {
+	return bdb.Transaction(func(txn *Txn) error {
+		return txn.UpdatePassword(req, modifier)
 	})
-	return err
 }
 
-func (bdb *LdbBolt) UpdatePassword(req *ldap.PasswordModifyRequest) error {
+// UpdatePassword sets the password of an entry within txn, see
+// LdbBolt.UpdatePassword.
+func (txn *Txn) UpdatePassword(req *ldap.PasswordModifyRequest, modifier string) error {
+	bdb := txn.bdb
 	ndn, err := ldapdn.ParseNormalize(req.UserIdentity)
 	if err != nil {
 		return err
 	}
 
-	err = bdb.db.Update(func(tx *bolt.Tx) error {
+	err = txn.update(func(tx *bolt.Tx, c *change) error {
 		userEntry, id, innerErr := bdb.getEntryByDN(tx, ndn)
 		if innerErr != nil {
 			return innerErr
@@ -415,7 +731,7 @@ func (bdb *LdbBolt) UpdatePassword(req *ldap.PasswordModifyRequest) error {
 		mod := ldap.ModifyRequest{}
 		mod.DN = req.UserIdentity
 		mod.Replace("userPassword", []string{req.NewPassword})
-		innerErr = bdb.entryModifyWithTxn(tx, id, userEntry, &mod)
+		innerErr = bdb.entryModifyWithTxn(tx, c, id, userEntry, &mod, modifier)
 		if innerErr != nil {
 			bdb.logger.Debugf("Failed to update password for '%s': '%s'", ndn, err)
 			return ldap.NewError(ldap.LDAPResultOperationsError, errors.New("Failed to update Password"))
@@ -429,7 +745,7 @@ func (bdb *LdbBolt) addID2Children(tx *bolt.Tx, nParentDN string, newChildID uin
 	bdb.logger.Debugf("AddID2Children '%s' id '%d'", nParentDN, newChildID)
 	parentID := bdb.getIDByDN(tx, nParentDN)
 	if parentID == 0 {
-		return fmt.Errorf("parent not found '%s'", nParentDN)
+		return fmt.Errorf("parent not found '%s': %w", nParentDN, ErrEntryNotFound)
 	}
 
 	bdb.logger.Debugf("Parent ID: %v", parentID)
@@ -446,6 +762,25 @@ func (bdb *LdbBolt) addID2Children(tx *bolt.Tx, nParentDN string, newChildID uin
 	return nil
 }
 
+// removeID2Children removes the child with childID from the children of the
+// entry with parentID.
+func (bdb *LdbBolt) removeID2Children(tx *bolt.Tx, parentID, childID uint64) error {
+	id2Children := tx.Bucket([]byte("id2children"))
+	r := bytes.NewReader(id2Children.Get(idToBytes(parentID)))
+	var newids []byte
+	idBytes := make([]byte, 8)
+	var err error
+	for _, err = io.ReadFull(r, idBytes); err == nil; _, err = io.ReadFull(r, idBytes) {
+		if childID != binary.LittleEndian.Uint64(idBytes) {
+			newids = append(newids, idBytes...)
+		}
+	}
+	if err = id2Children.Put(idToBytes(parentID), newids); err != nil {
+		return fmt.Errorf("error updating id2Children index for %d: %w", parentID, err)
+	}
+	return nil
+}
+
 func (bdb *LdbBolt) getIDByDN(tx *bolt.Tx, nDN string) uint64 {
 	dn2id := tx.Bucket([]byte("dn2id"))
 	if dn2id == nil {
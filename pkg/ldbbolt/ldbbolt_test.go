@@ -6,6 +6,8 @@ import (
 	"errors"
 	"io/ioutil"
 	"os"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/go-ldap/ldap/v3"
@@ -66,7 +68,7 @@ func setupTestDB(t *testing.T) *LdbBolt {
 func addTestData(bdb *LdbBolt, t *testing.T) {
 	// add	sample data
 	for _, entry := range []*ldap.Entry{baseEntry, subEntry, userEntry, otherUserEntry} {
-		if err := bdb.EntryPut(entry); err != nil {
+		if err := bdb.EntryPut(entry, ""); err != nil {
 			t.Fatalf("Failed to popluate test database: %s", err)
 		}
 	}
@@ -78,23 +80,23 @@ func TestEntryPutSingle(t *testing.T) {
 	defer bdb.Close()
 
 	// adding wrong base entry fails
-	if err := bdb.EntryPut(subEntry); err == nil {
+	if err := bdb.EntryPut(subEntry, ""); err == nil {
 		t.Fatal("Adding wrong base entry should fail")
 	}
 
 	// adding base entry succeeds
-	if err := bdb.EntryPut(baseEntry); err != nil {
+	if err := bdb.EntryPut(baseEntry, ""); err != nil {
 		t.Fatalf("Adding correct base entry should succeed. Got error:%s", err)
 	}
 
 	// adding the same entry again fails
-	err := bdb.EntryPut(baseEntry)
+	err := bdb.EntryPut(baseEntry, "")
 	if err == nil || !errors.Is(err, ErrEntryAlreadyExists) {
 		t.Fatalf("Adding the same entry	twice should fail with %v, got: %v", ErrEntryAlreadyExists, err)
 	}
 
 	// adding entry without parent fails
-	if err := bdb.EntryPut(userEntry); err == nil {
+	if err := bdb.EntryPut(userEntry, ""); err == nil {
 		t.Fatal("Adding entry without parent should fail")
 	}
 }
@@ -106,7 +108,7 @@ func TestEntryPutMulti(t *testing.T) {
 
 	// adding multiple entries succeeds
 	for _, entry := range []*ldap.Entry{baseEntry, subEntry, userEntry} {
-		if err := bdb.EntryPut(entry); err != nil {
+		if err := bdb.EntryPut(entry, ""); err != nil {
 			t.Fatalf("Adding more entries should succeed. Got error:%s", err)
 		}
 	}
@@ -233,3 +235,600 @@ func TestEntryDeleteSucceeds(t *testing.T) {
 		t.Errorf("Expected entry to be gone from values in id2children bucket.")
 	}
 }
+
+func TestEntryCheck(t *testing.T) {
+	bdb := setupTestDB(t)
+	defer os.Remove(bdb.db.Path())
+	defer bdb.Close()
+	addTestData(bdb, t)
+
+	checkErr := ldap.NewError(ldap.LDAPResultObjectClassViolation, errors.New("check failed"))
+	var checked [][2]*ldap.Entry
+	bdb.SetEntryCheck(func(oldEntry, newEntry *ldap.Entry) error {
+		checked = append(checked, [2]*ldap.Entry{oldEntry, newEntry})
+		return checkErr
+	})
+
+	newEntry := ldap.NewEntry("uid=user2,ou=sub,o=base", map[string][]string{"uid": {"user2"}})
+	if err := bdb.EntryPut(newEntry, ""); err != checkErr {
+		t.Errorf("Expected EntryPut to return the check error, got: %v", err)
+	}
+	if len(checked) != 1 || checked[0][0] != nil || checked[0][1] != newEntry {
+		t.Errorf("Expected check of new entry, got: %v", checked)
+	}
+	if entries, _ := bdb.Search("uid=user2,ou=sub,o=base", ldap.ScopeBaseObject); len(entries) != 0 {
+		t.Errorf("Expected entry to not be added")
+	}
+
+	mod := ldap.NewModifyRequest("uid=user,ou=sub,o=base", nil)
+	mod.Replace("mail", []string{"other@example"})
+	if err := bdb.EntryModify(mod, ""); err != checkErr {
+		t.Errorf("Expected EntryModify to return the check error, got: %v", err)
+	}
+	if len(checked) != 2 || checked[1][0].GetAttributeValue("mail") != "user@example" || checked[1][1].GetAttributeValue("mail") != "other@example" {
+		t.Errorf("Expected check of old and modified entry, got: %v", checked)
+	}
+	if entries, _ := bdb.Search("uid=user,ou=sub,o=base", ldap.ScopeBaseObject); len(entries) != 1 || entries[0].GetAttributeValue("mail") != "user@example" {
+		t.Errorf("Expected entry to not be modified")
+	}
+
+	bdb.SetEntryCheck(nil)
+	if err := bdb.EntryModify(mod, ""); err != nil {
+		t.Errorf("Expected EntryModify without check to succeed, got: %v", err)
+	}
+}
+
+func TestOperationChecks(t *testing.T) {
+	bdb := setupTestDB(t)
+	defer os.Remove(bdb.db.Path())
+	defer bdb.Close()
+	addTestData(bdb, t)
+	changes := 0
+	bdb.SetChangeFunc(func(oldEntry, newEntry *ldap.Entry, csn string) {
+		changes++
+	})
+
+	checkErr := ldap.NewError(ldap.LDAPResultAssertionFailed, errors.New("assertion failed"))
+	var oldDN, newDN string
+	check := func(fail bool) EntryCheckFunc {
+		return func(oldEntry, newEntry *ldap.Entry) error {
+			oldDN, newDN = "", ""
+			if oldEntry != nil {
+				oldDN = oldEntry.DN
+			}
+			if newEntry != nil {
+				newDN = newEntry.DN
+			}
+			if fail {
+				return checkErr
+			}
+			return nil
+		}
+	}
+
+	mod := ldap.NewModifyRequest("uid=user,ou=sub,o=base", nil)
+	mod.Replace("mail", []string{"other@example"})
+	if err := bdb.EntryModify(mod, "", check(true)); err != checkErr {
+		t.Errorf("Expected EntryModify to return the check error, got: %v", err)
+	}
+	if entries, _ := bdb.Search("uid=user,ou=sub,o=base", ldap.ScopeBaseObject); len(entries) != 1 || entries[0].GetAttributeValue("mail") != "user@example" {
+		t.Errorf("Expected entry to not be modified")
+	}
+	if err := bdb.EntryModifyDN(&ldap.ModifyDNRequest{DN: "uid=user,ou=sub,o=base", NewRDN: "uid=user2", DeleteOldRDN: true}, "", check(false)); err != nil {
+		t.Errorf("Expected EntryModifyDN to succeed, got: %v", err)
+	}
+	if oldDN != "uid=user,ou=sub,o=base" || newDN != "uid=user2,ou=sub,o=base" {
+		t.Errorf("Expected check of renamed entry, got: %s, %s", oldDN, newDN)
+	}
+	if err := bdb.EntryDelete("uid=user2,ou=sub,o=base", check(true)); err != checkErr {
+		t.Errorf("Expected EntryDelete to return the check error, got: %v", err)
+	}
+	if oldDN != "uid=user2,ou=sub,o=base" || newDN != "" {
+		t.Errorf("Expected check of deleted entry, got: %s, %s", oldDN, newDN)
+	}
+	if entries, _ := bdb.Search("uid=user2,ou=sub,o=base", ldap.ScopeBaseObject); len(entries) != 1 {
+		t.Errorf("Expected entry to not be deleted")
+	}
+	if changes != 1 {
+		t.Errorf("Expected 1 change, got: %d", changes)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	bdb := setupTestDB(t)
+	defer os.Remove(bdb.db.Path())
+	defer bdb.Close()
+	addTestData(bdb, t)
+	csns := []string{}
+	bdb.SetChangeFunc(func(oldEntry, newEntry *ldap.Entry, csn string) {
+		csns = append(csns, csn)
+	})
+
+	// A failing operation rolls back the whole transaction.
+	mod := ldap.NewModifyRequest("uid=user,ou=sub,o=base", nil)
+	mod.Replace("mail", []string{"other@example"})
+	err := bdb.Transaction(func(txn *Txn) error {
+		if err := txn.EntryModify(mod, ""); err != nil {
+			return err
+		}
+		return txn.EntryDelete("ou=sub,o=base")
+	})
+	if err == nil {
+		t.Fatalf("Expected transaction deleting a non-leaf entry to fail")
+	}
+	if entries, _ := bdb.Search("uid=user,ou=sub,o=base", ldap.ScopeBaseObject); len(entries) != 1 || entries[0].GetAttributeValue("mail") != "user@example" {
+		t.Errorf("Expected entry to not be modified by failed transaction")
+	}
+	if len(csns) != 0 {
+		t.Errorf("Expected no changes of failed transaction, got: %v", csns)
+	}
+
+	err = bdb.Transaction(func(txn *Txn) error {
+		if err := txn.EntryModify(mod, ""); err != nil {
+			return err
+		}
+		return txn.EntryDelete("uid=user1,ou=sub,o=base")
+	})
+	if err != nil {
+		t.Fatalf("Expected transaction to succeed, got: %v", err)
+	}
+	if entries, _ := bdb.Search("uid=user,ou=sub,o=base", ldap.ScopeBaseObject); len(entries) != 1 || entries[0].GetAttributeValue("mail") != "other@example" {
+		t.Errorf("Expected entry to be modified by transaction")
+	}
+	if entries, _ := bdb.Search("uid=user1,ou=sub,o=base", ldap.ScopeBaseObject); len(entries) != 0 {
+		t.Errorf("Expected entry to be deleted by transaction")
+	}
+	if len(csns) != 2 || csns[0] >= csns[1] {
+		t.Errorf("Expected 2 ordered changes, got: %v", csns)
+	}
+}
+
+func TestEntryModifyDNSubtree(t *testing.T) {
+	bdb := setupTestDB(t)
+	defer os.Remove(bdb.db.Path())
+	defer bdb.Close()
+	addTestData(bdb, t)
+	otherEntry := ldap.NewEntry("ou=other,o=base", map[string][]string{
+		"ou":          {"other"},
+		"objectclass": {"organizationalUnit"},
+	})
+	if err := bdb.EntryPut(otherEntry, ""); err != nil {
+		t.Fatalf("Failed to add entry: %s", err)
+	}
+	renamed := map[string]string{}
+	bdb.SetChangeFunc(func(oldEntry, newEntry *ldap.Entry, csn string) {
+		if oldEntry != nil && newEntry != nil {
+			renamed[oldEntry.DN] = newEntry.DN
+		}
+	})
+
+	// Rename a subtree.
+	if err := bdb.EntryModifyDN(&ldap.ModifyDNRequest{DN: "ou=sub,o=base", NewRDN: "ou=renamed", DeleteOldRDN: true}, ""); err != nil {
+		t.Fatalf("Failed to rename subtree: %s", err)
+	}
+	if len(renamed) != 3 || renamed["uid=user,ou=sub,o=base"] != "uid=user,ou=renamed,o=base" {
+		t.Errorf("Unexpected changes of subtree rename: %v", renamed)
+	}
+	if entries, err := bdb.Search("ou=renamed,o=base", ldap.ScopeWholeSubtree); err != nil || len(entries) != 3 {
+		t.Errorf("Expected 3 entries in renamed subtree, got: %v %v", entries, err)
+	}
+	if entries, err := bdb.Search("uid=user1,ou=renamed,o=base", ldap.ScopeBaseObject); err != nil || len(entries) != 1 || entries[0].DN != "uid=user1,ou=renamed,o=base" {
+		t.Errorf("Expected renamed subordinate, got: %v %v", entries, err)
+	}
+	if _, err := bdb.Search("ou=sub,o=base", ldap.ScopeWholeSubtree); err == nil {
+		t.Errorf("Expected old subtree to be gone")
+	}
+
+	// Move the subtree to a new parent.
+	if err := bdb.EntryModifyDN(&ldap.ModifyDNRequest{DN: "ou=renamed,o=base", NewRDN: "ou=renamed", NewSuperior: "ou=other,o=base"}, ""); err != nil {
+		t.Fatalf("Failed to move subtree: %s", err)
+	}
+	if entries, err := bdb.Search("ou=other,o=base", ldap.ScopeSingleLevel); err != nil || len(entries) != 1 || entries[0].DN != "ou=renamed,ou=other,o=base" {
+		t.Errorf("Expected moved entry below new parent, got: %v %v", entries, err)
+	}
+	if entries, err := bdb.Search("o=base", ldap.ScopeSingleLevel); err != nil || len(entries) != 1 || entries[0].DN != "ou=other,o=base" {
+		t.Errorf("Expected moved entry to be removed from old parent, got: %v %v", entries, err)
+	}
+	if entries, err := bdb.Search("uid=user,ou=renamed,ou=other,o=base", ldap.ScopeBaseObject); err != nil || len(entries) != 1 || entries[0].GetAttributeValue("mail") != "user@example" {
+		t.Errorf("Expected moved subordinate, got: %v %v", entries, err)
+	}
+
+	for _, test := range []struct {
+		req  *ldap.ModifyDNRequest
+		code uint16
+	}{
+		{&ldap.ModifyDNRequest{DN: "ou=other,o=base", NewRDN: "ou=other", NewSuperior: "uid=user,ou=renamed,ou=other,o=base"}, ldap.LDAPResultUnwillingToPerform},
+		{&ldap.ModifyDNRequest{DN: "ou=other,o=base", NewRDN: "ou=other", NewSuperior: "ou=other,o=base"}, ldap.LDAPResultUnwillingToPerform},
+		{&ldap.ModifyDNRequest{DN: "ou=renamed,ou=other,o=base", NewRDN: "ou=renamed", NewSuperior: "ou=missing,o=base"}, ldap.LDAPResultNoSuchObject},
+		{&ldap.ModifyDNRequest{DN: "o=base", NewRDN: "o=other"}, ldap.LDAPResultUnwillingToPerform},
+	} {
+		if err := bdb.EntryModifyDN(test.req, ""); !ldap.IsErrorWithCode(err, test.code) {
+			t.Errorf("Expected moving %s below %s to fail with %d, got: %v", test.req.DN, test.req.NewSuperior, test.code, err)
+		}
+	}
+
+	// The stored DNs keep the case of their RDNs.
+	for _, entry := range []*ldap.Entry{
+		ldap.NewEntry("ou=People,o=base", map[string][]string{"ou": {"People"}, "objectclass": {"organizationalUnit"}}),
+		ldap.NewEntry("ou=Admins,ou=People,o=base", map[string][]string{"ou": {"Admins"}, "objectclass": {"organizationalUnit"}}),
+		ldap.NewEntry("uid=JDoe,ou=Admins,ou=People,o=base", map[string][]string{"uid": {"JDoe"}, "objectclass": {"inetOrgPerson"}}),
+	} {
+		if err := bdb.EntryPut(entry, ""); err != nil {
+			t.Fatalf("Failed to add entry: %s", err)
+		}
+	}
+	renamed = map[string]string{}
+	if err := bdb.EntryModifyDN(&ldap.ModifyDNRequest{DN: "ou=people,o=base", NewRDN: "ou=Staff", DeleteOldRDN: true, NewSuperior: "ou=Other,o=base"}, ""); err != nil {
+		t.Fatalf("Failed to move mixed-case subtree: %s", err)
+	}
+	for oldDN, newDN := range map[string]string{
+		"ou=People,o=base":                    "ou=Staff,ou=Other,o=base",
+		"ou=Admins,ou=People,o=base":          "ou=Admins,ou=Staff,ou=Other,o=base",
+		"uid=JDoe,ou=Admins,ou=People,o=base": "uid=JDoe,ou=Admins,ou=Staff,ou=Other,o=base",
+	} {
+		if renamed[oldDN] != newDN {
+			t.Errorf("Expected change of %s to %s, got: %q", oldDN, newDN, renamed[oldDN])
+		}
+	}
+	entries, err := bdb.Search("uid=jdoe,ou=admins,ou=staff,ou=other,o=base", ldap.ScopeBaseObject)
+	if err != nil || len(entries) != 1 || entries[0].DN != "uid=JDoe,ou=Admins,ou=Staff,ou=Other,o=base" {
+		t.Errorf("Expected moved mixed-case entry, got: %v %v", entries, err)
+	}
+}
+
+func TestSearchSorted(t *testing.T) {
+	bdb := setupTestDB(t)
+	defer os.Remove(bdb.db.Path())
+	defer bdb.Close()
+	addTestData(bdb, t)
+	for _, entry := range []*ldap.Entry{
+		ldap.NewEntry("uid=user2,ou=sub,o=base", map[string][]string{"uid": {"user2"}, "sn": {"Brown"}}),
+		ldap.NewEntry("uid=user3,ou=sub,o=base", map[string][]string{"uid": {"user3"}, "sn": {"adams", "Zuse"}}),
+		ldap.NewEntry("uid=user4,ou=sub,o=base", map[string][]string{"uid": {"user4"}, "sn": {"Clark"}}),
+	} {
+		if err := bdb.EntryPut(entry, ""); err != nil {
+			t.Fatalf("Failed to add entry: %s", err)
+		}
+	}
+
+	all := func(keys [][]byte) (int, int) {
+		return 0, len(keys)
+	}
+	uids := func(entries []*ldap.Entry) string {
+		var result []string
+		for _, entry := range entries {
+			result = append(result, entry.GetAttributeValue("uid"))
+		}
+		return strings.Join(result, ",")
+	}
+	searchSorted := func(reverse bool, match func(*ldap.Entry) bool, window func([][]byte) (int, int)) string {
+		t.Helper()
+		entries, err := bdb.SearchSorted("ou=sub,o=base", ldap.ScopeSingleLevel, "surname", reverse, match, window)
+		if err != nil {
+			t.Fatalf("SearchSorted failed: %s", err)
+		}
+		return uids(entries)
+	}
+
+	if got := searchSorted(false, nil, all); got != "user3,user2,user4,user,user1" {
+		t.Errorf("Unexpected order: %s", got)
+	}
+	if got := searchSorted(true, nil, all); got != "user,user1,user4,user2,user3" {
+		t.Errorf("Unexpected reverse order: %s", got)
+	}
+	var keys [][]byte
+	got := searchSorted(false, func(entry *ldap.Entry) bool {
+		return entry.GetAttributeValue("uid") != "user2"
+	}, func(k [][]byte) (int, int) {
+		keys = k
+		return 1, 3
+	})
+	if got != "user4,user" {
+		t.Errorf("Unexpected window: %s", got)
+	}
+	if len(keys) != 4 || string(keys[0]) != "adams" || string(keys[1]) != "clark" || keys[2] != nil {
+		t.Errorf("Unexpected sort keys: %q", keys)
+	}
+
+	mod := ldap.NewModifyRequest("uid=user3,ou=sub,o=base", nil)
+	mod.Replace("sn", []string{"Young"})
+	if err := bdb.EntryModify(mod, ""); err != nil {
+		t.Fatalf("Failed to modify entry: %s", err)
+	}
+	if err := bdb.EntryDelete("uid=user2,ou=sub,o=base"); err != nil {
+		t.Fatalf("Failed to delete entry: %s", err)
+	}
+	if got := searchSorted(false, nil, all); got != "user4,user3,user,user1" {
+		t.Errorf("Unexpected order after modification: %s", got)
+	}
+
+	// Sort indexes missing in an existing database are built on initialization.
+	err := bdb.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("sortindex"))
+	})
+	if err != nil {
+		t.Fatalf("Failed to delete sort index: %s", err)
+	}
+	if err := bdb.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize database: %s", err)
+	}
+	if got := searchSorted(false, nil, all); got != "user4,user3,user,user1" {
+		t.Errorf("Unexpected order after rebuilding the index: %s", got)
+	}
+
+	if _, err := bdb.SearchSorted("o=base", ldap.ScopeWholeSubtree, "uidNumber", false, nil, all); !errors.Is(err, ErrNoSortIndex) {
+		t.Errorf("Expected ErrNoSortIndex, got: %v", err)
+	}
+	if _, err := bdb.SearchSorted("ou=missing,o=base", ldap.ScopeWholeSubtree, "uid", false, nil, all); !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("Expected ErrEntryNotFound, got: %v", err)
+	}
+}
+
+func TestLoadEntries(t *testing.T) {
+	bdb := setupTestDB(t)
+	defer os.Remove(bdb.db.Path())
+	defer bdb.Close()
+	addTestData(bdb, t)
+
+	ids, err := bdb.SearchIDs("o=base", ldap.ScopeWholeSubtree)
+	if err != nil || len(ids) != 4 {
+		t.Fatalf("Expected 4 ids, got %v: %v", ids, err)
+	}
+	if err := bdb.EntryDelete("uid=user,ou=sub,o=base"); err != nil {
+		t.Fatalf("Failed to delete entry: %s", err)
+	}
+
+	var dns []string
+	processed, err := bdb.LoadEntries(ids, func(id uint64, entry *ldap.Entry) bool {
+		dns = append(dns, entry.DN)
+		return len(dns) < 2
+	})
+	if err != nil || processed != 2 || strings.Join(dns, ";") != "o=base;ou=sub,o=base" {
+		t.Errorf("Unexpected first part: %d %v %v", processed, dns, err)
+	}
+
+	dns = nil
+	processed, err = bdb.LoadEntries(ids[processed:], func(id uint64, entry *ldap.Entry) bool {
+		dns = append(dns, entry.DN)
+		return true
+	})
+	if err != nil || processed != 2 || strings.Join(dns, ";") != "uid=user1,ou=sub,o=base" {
+		t.Errorf("Expected deleted entry to be skipped, got: %d %v %v", processed, dns, err)
+	}
+}
+
+func TestSearchEach(t *testing.T) {
+	bdb := setupTestDB(t)
+	defer os.Remove(bdb.db.Path())
+	defer bdb.Close()
+	addTestData(bdb, t)
+
+	var dns []string
+	err := bdb.SearchEach("ou=sub,o=base", ldap.ScopeWholeSubtree, func(id uint64, entry *ldap.Entry) bool {
+		dns = append(dns, entry.DN)
+		return len(dns) < 2
+	})
+	if err != nil || strings.Join(dns, ";") != "ou=sub,o=base;uid=user,ou=sub,o=base" {
+		t.Errorf("Unexpected entries: %v %v", dns, err)
+	}
+
+	err = bdb.SearchEach("ou=missing,o=base", ldap.ScopeWholeSubtree, func(id uint64, entry *ldap.Entry) bool {
+		return true
+	})
+	if !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("Expected ErrEntryNotFound, got: %v", err)
+	}
+}
+
+func TestSearchAliases(t *testing.T) {
+	bdb := setupTestDB(t)
+	defer os.Remove(bdb.db.Path())
+	defer bdb.Close()
+	addTestData(bdb, t)
+	for _, entry := range []*ldap.Entry{
+		ldap.NewEntry("cn=alias,o=base", map[string][]string{"objectclass": {"alias", "extensibleObject"}, "cn": {"alias"}, "aliasedObjectName": {"ou=sub,o=base"}}),
+		ldap.NewEntry("cn=alias,ou=sub,o=base", map[string][]string{"objectclass": {"alias", "extensibleObject"}, "cn": {"alias"}, "aliasedObjectName": {"o=base"}}),
+	} {
+		if err := bdb.EntryPut(entry, ""); err != nil {
+			t.Fatalf("Failed to add alias: %s", err)
+		}
+	}
+
+	searchAliases := func(base string, scope int) string {
+		t.Helper()
+		entries, err := bdb.SearchAliases(base, scope)
+		if err != nil {
+			t.Fatalf("SearchAliases failed: %s", err)
+		}
+		var dns []string
+		for _, entry := range entries {
+			dns = append(dns, entry.DN)
+		}
+		slices.Sort(dns)
+		return strings.Join(dns, ";")
+	}
+	for _, test := range []struct {
+		base   string
+		scope  int
+		result string
+	}{
+		{"o=base", ldap.ScopeWholeSubtree, "cn=alias,o=base;cn=alias,ou=sub,o=base"},
+		{"o=base", ldap.ScopeSingleLevel, "cn=alias,o=base"},
+		{"ou=sub,o=base", ldap.ScopeWholeSubtree, "cn=alias,ou=sub,o=base"},
+		{"cn=alias,o=base", ldap.ScopeBaseObject, "cn=alias,o=base"},
+		{"uid=user,ou=sub,o=base", ldap.ScopeWholeSubtree, ""},
+	} {
+		if got := searchAliases(test.base, test.scope); got != test.result {
+			t.Errorf("SearchAliases of %s with scope %d returned %s, expected %s", test.base, test.scope, got, test.result)
+		}
+	}
+	if _, err := bdb.SearchAliases("ou=missing,o=base", ldap.ScopeWholeSubtree); !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("Expected ErrEntryNotFound, got: %v", err)
+	}
+
+	// The index follows modifications and deletions.
+	modReq := ldap.NewModifyRequest("cn=alias,ou=sub,o=base", nil)
+	modReq.Delete("objectclass", []string{"alias"})
+	if err := bdb.EntryModify(modReq, ""); err != nil {
+		t.Fatalf("Failed to modify alias: %s", err)
+	}
+	if err := bdb.EntryDelete("cn=alias,o=base"); err != nil {
+		t.Fatalf("Failed to delete alias: %s", err)
+	}
+	if got := searchAliases("o=base", ldap.ScopeWholeSubtree); got != "" {
+		t.Errorf("Unexpected aliases after modification: %s", got)
+	}
+
+	// The alias index missing in an existing database is built on
+	// initialization.
+	if err := bdb.EntryPut(ldap.NewEntry("cn=alias,o=base", map[string][]string{"objectclass": {"alias", "extensibleObject"}, "cn": {"alias"}, "aliasedObjectName": {"ou=sub,o=base"}}), ""); err != nil {
+		t.Fatalf("Failed to add alias: %s", err)
+	}
+	err := bdb.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("aliasindex"))
+	})
+	if err != nil {
+		t.Fatalf("Failed to delete alias index: %s", err)
+	}
+	if err := bdb.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize database: %s", err)
+	}
+	if got := searchAliases("o=base", ldap.ScopeWholeSubtree); got != "cn=alias,o=base" {
+		t.Errorf("Unexpected aliases after rebuilding the index: %s", got)
+	}
+}
+
+func TestOperationalAttributes(t *testing.T) {
+	bdb := setupTestDB(t)
+	defer os.Remove(bdb.db.Path())
+	defer bdb.Close()
+
+	for _, entry := range []*ldap.Entry{baseEntry, subEntry} {
+		if err := bdb.EntryPut(entry, ""); err != nil {
+			t.Fatalf("Failed to popluate test database: %s", err)
+		}
+	}
+	newEntry := ldap.NewEntry("uid=user2,ou=sub,o=base", map[string][]string{"uid": {"user2"}})
+	if err := bdb.EntryPut(newEntry, "cn=admin,o=base"); err != nil {
+		t.Fatalf("Failed to add entry: %s", err)
+	}
+	entries, err := bdb.Search("uid=user2,ou=sub,o=base", ldap.ScopeBaseObject)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Failed to find added entry: %v", err)
+	}
+	created := entries[0]
+	for name, value := range map[string]string{
+		"creatorsName":    "cn=admin,o=base",
+		"modifiersName":   "cn=admin,o=base",
+		"hasSubordinates": "FALSE",
+	} {
+		if got := created.GetEqualFoldAttributeValue(name); got != value {
+			t.Errorf("Expected %s to be %s, got: %s", name, value, got)
+		}
+	}
+	createTimestamp := created.GetEqualFoldAttributeValue("createTimestamp")
+	if len(createTimestamp) != 15 || created.GetEqualFoldAttributeValue("modifyTimestamp") != createTimestamp {
+		t.Errorf("Expected the timestamps of the added entry, got: %s", createTimestamp)
+	}
+	entryUUID := created.GetEqualFoldAttributeValue("entryUUID")
+	if len(entryUUID) != 36 {
+		t.Errorf("Expected an entryUUID, got: %s", entryUUID)
+	}
+
+	// Existing operational attributes are kept.
+	loadedEntry := ldap.NewEntry("uid=user3,ou=sub,o=base", map[string][]string{"uid": {"user3"}, "entryUUID": {"abcd-defg"}, "createTimestamp": {"20200101000000Z"}})
+	if err := bdb.EntryPut(loadedEntry, ""); err != nil {
+		t.Fatalf("Failed to add entry: %s", err)
+	}
+	entries, _ = bdb.Search("uid=user3,ou=sub,o=base", ldap.ScopeBaseObject)
+	if len(entries) != 1 || entries[0].GetEqualFoldAttributeValue("entryUUID") != "abcd-defg" || entries[0].GetEqualFoldAttributeValue("createTimestamp") != "20200101000000Z" || entries[0].GetEqualFoldAttributeValue("creatorsName") != "" {
+		t.Errorf("Expected the operational attributes of the loaded entry to be kept, got: %v", entries)
+	}
+
+	mod := ldap.NewModifyRequest("uid=user2,ou=sub,o=base", nil)
+	mod.Replace("mail", []string{"user2@example"})
+	if err := bdb.EntryModify(mod, "uid=user3,ou=sub,o=base"); err != nil {
+		t.Fatalf("Failed to modify entry: %s", err)
+	}
+	entries, _ = bdb.Search("uid=user2,ou=sub,o=base", ldap.ScopeBaseObject)
+	modified := entries[0]
+	if modified.GetEqualFoldAttributeValue("modifiersName") != "uid=user3,ou=sub,o=base" || modified.GetEqualFoldAttributeValue("creatorsName") != "cn=admin,o=base" {
+		t.Errorf("Expected modifiersName to be updated, got: %v", modified.Attributes)
+	}
+	if modified.GetEqualFoldAttributeValue("modifyTimestamp") < createTimestamp || modified.GetEqualFoldAttributeValue("createTimestamp") != createTimestamp || modified.GetEqualFoldAttributeValue("entryUUID") != entryUUID {
+		t.Errorf("Expected only modifyTimestamp to change, got: %v", modified.Attributes)
+	}
+
+	entries, _ = bdb.Search("ou=sub,o=base", ldap.ScopeBaseObject)
+	if got := entries[0].GetEqualFoldAttributeValue("hasSubordinates"); got != "TRUE" {
+		t.Errorf("Expected hasSubordinates of the parent entry to be TRUE, got: %s", got)
+	}
+}
+
+func TestChangeSequence(t *testing.T) {
+	bdb := setupTestDB(t)
+	defer os.Remove(bdb.db.Path())
+	defer bdb.Close()
+
+	type change struct {
+		oldDN, newDN, csn string
+	}
+	var changes []change
+	bdb.SetChangeFunc(func(oldEntry, newEntry *ldap.Entry, csn string) {
+		c := change{csn: csn}
+		if oldEntry != nil {
+			c.oldDN = oldEntry.DN
+		}
+		if newEntry != nil {
+			c.newDN = newEntry.DN
+			if got := newEntry.GetEqualFoldAttributeValue("entryCSN"); got != csn {
+				t.Errorf("Expected entryCSN %s of the changed entry, got: %s", csn, got)
+			}
+		}
+		changes = append(changes, c)
+	})
+
+	if csn, err := bdb.ContextCSN(); err != nil || csn != "" {
+		t.Errorf("Expected no contextCSN of the empty database, got: %s %v", csn, err)
+	}
+	addTestData(bdb, t)
+	mod := ldap.NewModifyRequest("uid=user,ou=sub,o=base", nil)
+	mod.Replace("mail", []string{"user2@example"})
+	if err := bdb.EntryModify(mod, ""); err != nil {
+		t.Fatalf("Failed to modify entry: %s", err)
+	}
+	if err := bdb.EntryModifyDN(&ldap.ModifyDNRequest{DN: "uid=user1,ou=sub,o=base", NewRDN: "uid=user2", DeleteOldRDN: true}, ""); err != nil {
+		t.Fatalf("Failed to rename entry: %s", err)
+	}
+	if err := bdb.EntryDelete("uid=user2,ou=sub,o=base"); err != nil {
+		t.Fatalf("Failed to delete entry: %s", err)
+	}
+	// Failed changes are not passed on.
+	if err := bdb.EntryDelete("uid=user2,ou=sub,o=base"); err == nil {
+		t.Fatalf("Deleting a deleted entry succeeded")
+	}
+
+	expected := []change{
+		{newDN: "o=base"},
+		{newDN: "ou=sub,o=base"},
+		{newDN: "uid=user,ou=sub,o=base"},
+		{newDN: "uid=user1,ou=sub,o=base"},
+		{oldDN: "uid=user,ou=sub,o=base", newDN: "uid=user,ou=sub,o=base"},
+		{oldDN: "uid=user1,ou=sub,o=base", newDN: "uid=user2,ou=sub,o=base"},
+		{oldDN: "uid=user2,ou=sub,o=base"},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got: %v", len(expected), changes)
+	}
+	for i, c := range changes {
+		if c.oldDN != expected[i].oldDN || c.newDN != expected[i].newDN {
+			t.Errorf("Unexpected change #%d: %v", i, c)
+		}
+		if i > 0 && c.csn <= changes[i-1].csn {
+			t.Errorf("CSN of change #%d is not increasing: %s", i, c.csn)
+		}
+	}
+	if csn, err := bdb.ContextCSN(); err != nil || csn != changes[len(changes)-1].csn {
+		t.Errorf("Expected the contextCSN to be the CSN of the last change, got: %s %v", csn, err)
+	}
+}
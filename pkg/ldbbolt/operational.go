@@ -0,0 +1,167 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2021 The LibreGraph Authors.
+ */
+
+package ldbbolt
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/libregraph/idm/pkg/ldapentry"
+)
+
+// The operational attributes maintained by the database. All of them but
+// hasSubordinates are stored with the entries.
+const (
+	createTimestampAttribute = "createTimestamp"
+	modifyTimestampAttribute = "modifyTimestamp"
+	creatorsNameAttribute    = "creatorsName"
+	modifiersNameAttribute   = "modifiersName"
+	entryUUIDAttribute       = "entryUUID"
+	entryCSNAttribute        = "entryCSN"
+	hasSubordinatesAttribute = "hasSubordinates"
+)
+
+// contextCSNKey is the key of the contextCSN in the meta bucket.
+var contextCSNKey = []byte("contextCSN")
+
+// generalizedTime formats t with the Generalized Time syntax in UTC.
+func generalizedTime(t time.Time) string {
+	return t.UTC().Format("20060102150405Z")
+}
+
+// stampCreate adds the operational attributes of an entry added by creator
+// to the new entry e. Operational attributes which are already present in e
+// are kept, so that loaded entries retain them.
+func stampCreate(e *ldap.Entry, creator string, now time.Time) {
+	setDefault := func(name, value string) {
+		if value != "" && len(e.GetEqualFoldAttributeValues(name)) == 0 {
+			ldapentry.SetAttributeValues(e, name, []string{value})
+		}
+	}
+	timestamp := generalizedTime(now)
+	setDefault(createTimestampAttribute, timestamp)
+	setDefault(modifyTimestampAttribute, timestamp)
+	setDefault(creatorsNameAttribute, creator)
+	setDefault(modifiersNameAttribute, creator)
+	setDefault(entryUUIDAttribute, uuid.NewString())
+}
+
+// stampModify sets the operational attributes of the entry e which was
+// modified by modifier. Without a modifier, modifiersName is kept.
+func stampModify(e *ldap.Entry, modifier string, now time.Time) {
+	ldapentry.SetAttributeValues(e, modifyTimestampAttribute, []string{generalizedTime(now)})
+	if modifier != "" {
+		ldapentry.SetAttributeValues(e, modifiersNameAttribute, []string{modifier})
+	}
+}
+
+// loadEntry returns the entry with id together with its hasSubordinates
+// attribute.
+func (bdb *LdbBolt) loadEntry(tx *bolt.Tx, id uint64) (*ldap.Entry, error) {
+	entry, err := bdb.getEntryByID(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	hasSubordinates := "FALSE"
+	if len(tx.Bucket([]byte("id2children")).Get(idToBytes(id))) > 0 {
+		hasSubordinates = "TRUE"
+	}
+	ldapentry.SetAttributeValues(entry, hasSubordinatesAttribute, []string{hasSubordinates})
+	return entry, nil
+}
+
+// change is a change of an entry, which is passed to the ChangeFunc of the
+// database once its transaction was committed.
+type change struct {
+	oldEntry *ldap.Entry
+	newEntry *ldap.Entry
+	csn      string
+}
+
+// Txn is a read-write transaction of the database. The changes made with
+// its methods are committed together or not at all.
+type Txn struct {
+	bdb     *LdbBolt
+	tx      *bolt.Tx
+	changes []change
+}
+
+// Transaction runs fn in a single read-write transaction, which is committed
+// if fn returns nil. If fn returns an error, none of the changes made with
+// txn are applied. An error returned by a method of txn must therefore be
+// returned by fn. The committed changes are passed to the ChangeFunc in the
+// order they were made.
+func (bdb *LdbBolt) Transaction(fn func(txn *Txn) error) error {
+	bdb.updateMutex.Lock()
+	defer bdb.updateMutex.Unlock()
+	var changes []change
+	if err := bdb.db.Update(func(tx *bolt.Tx) error {
+		txn := &Txn{bdb: bdb, tx: tx}
+		if err := fn(txn); err != nil {
+			return err
+		}
+		changes = txn.changes
+		return nil
+	}); err != nil {
+		return err
+	}
+	if bdb.changeFunc != nil {
+		for _, c := range changes {
+			bdb.changeFunc(c.oldEntry, c.newEntry, c.csn)
+		}
+	}
+	return nil
+}
+
+// update runs fn within txn and records the change made by fn. The checks
+// are called with the entry before and after the change. If one of them
+// returns an error, it is returned and the transaction must be rolled back.
+func (txn *Txn) update(fn func(tx *bolt.Tx, c *change) error, checks ...EntryCheckFunc) error {
+	var c change
+	if err := fn(txn.tx, &c); err != nil {
+		return err
+	}
+	for _, check := range checks {
+		if err := check(c.oldEntry, c.newEntry); err != nil {
+			return err
+		}
+	}
+	if c.csn != "" {
+		txn.changes = append(txn.changes, c)
+	}
+	return nil
+}
+
+// nextCSN returns the CSN of a change in tx, which is greater than the CSNs
+// of all previous changes, and stores it as the contextCSN.
+func (bdb *LdbBolt) nextCSN(tx *bolt.Tx) (string, error) {
+	meta := tx.Bucket([]byte("meta"))
+	if meta == nil {
+		return "", errors.New("bucket 'meta' does not exist")
+	}
+	csn := ldapentry.NextCSN(string(meta.Get(contextCSNKey)), time.Now())
+	if err := meta.Put(contextCSNKey, []byte(csn)); err != nil {
+		return "", err
+	}
+	return csn, nil
+}
+
+// ContextCSN returns the CSN of the last change of the database. It is empty
+// if the database was not changed since it maintains CSNs.
+func (bdb *LdbBolt) ContextCSN() (string, error) {
+	var csn string
+	err := bdb.db.View(func(tx *bolt.Tx) error {
+		if meta := tx.Bucket([]byte("meta")); meta != nil {
+			csn = string(meta.Get(contextCSNKey))
+		}
+		return nil
+	})
+	return csn, err
+}
@@ -0,0 +1,276 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2021 The LibreGraph Authors.
+ */
+
+package ldbbolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/libregraph/idm/pkg/ldapdn"
+	"github.com/libregraph/idm/pkg/schema"
+)
+
+// sortIndexAttributes are the attributes which have a sort index. The
+// entries are indexed by their least value according to the ordering rule of
+// the attribute in the built-in schema.
+var sortIndexAttributes = []string{"cn", "displayName", "givenName", "mail", "sn", "uid"}
+
+var ErrNoSortIndex = errors.New("attribute has no sort index")
+
+type sortIndex struct {
+	attribute *schema.AttributeType
+	rule      *schema.MatchingRule
+}
+
+func newSortIndexes() (*schema.Schema, []*sortIndex) {
+	s := schema.New()
+	var indexes []*sortIndex
+	for _, name := range sortIndexAttributes {
+		a, ok := s.AttributeType(name)
+		if !ok {
+			continue
+		}
+		if rule, ok := s.OrderingRule(a); ok {
+			indexes = append(indexes, &sortIndex{
+				attribute: a,
+				rule:      rule,
+			})
+		}
+	}
+	return s, indexes
+}
+
+// SortIndexRule returns the ordering matching rule of the sort index of
+// attribute, which is used to order the entries returned by SearchSorted. It
+// returns false if attribute has no sort index.
+func (bdb *LdbBolt) SortIndexRule(attribute string) (*schema.MatchingRule, bool) {
+	if index := bdb.sortIndex(attribute); index != nil {
+		return index.rule, true
+	}
+	return nil, false
+}
+
+func (bdb *LdbBolt) sortIndex(attribute string) *sortIndex {
+	a, ok := bdb.schema.AttributeType(attribute)
+	if !ok {
+		return nil
+	}
+	for _, index := range bdb.sortIndexes {
+		if index.attribute == a {
+			return index
+		}
+	}
+	return nil
+}
+
+// SearchSorted performs a search like Search, but returns the entries
+// ordered by the sort index of attribute, in reverse order if reverse is set.
+// Entries without a value of attribute are ordered after all others, which
+// is first in reverse order. Entries are not loaded for ordering, only to
+// call match and to return the selected ones. A nil match includes all
+// entries in scope. window is called with the sort keys (see
+// schema.MatchingRule.OrderingKey) of the ordered entries, which are nil for
+// entries without a value, and returns the bounds of the entries to return.
+func (bdb *LdbBolt) SearchSorted(base string, scope int, attribute string, reverse bool, match func(*ldap.Entry) bool, window func(keys [][]byte) (start, end int)) ([]*ldap.Entry, error) {
+	index := bdb.sortIndex(attribute)
+	if index == nil {
+		return nil, ErrNoSortIndex
+	}
+	nDN, err := ldapdn.ParseNormalize(base)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*ldap.Entry{}
+	err = bdb.db.View(func(tx *bolt.Tx) error {
+		bucket := sortIndexBucket(tx, index)
+		if bucket == nil {
+			return ErrNoSortIndex
+		}
+		entryID := bdb.getIDByDN(tx, nDN)
+		if entryID == 0 {
+			return ErrEntryNotFound
+		}
+		entryIDs := bdb.getScopeIDs(tx, entryID, scope)
+		inScope := make(map[uint64]bool, len(entryIDs))
+		for _, id := range entryIDs {
+			inScope[id] = true
+		}
+
+		// The index is walked in order and the entries in scope are matched
+		// as they are found, so only the ids and keys of the matching entries
+		// are kept.
+		var ids []uint64
+		var keys [][]byte
+		matches := func(id uint64) (bool, error) {
+			if match == nil {
+				return true, nil
+			}
+			entry, err := bdb.loadEntry(tx, id)
+			if err != nil {
+				return false, err
+			}
+			return match(entry), nil
+		}
+		c := bucket.Cursor()
+		next := c.Next
+		k, v := c.First()
+		if reverse {
+			next = c.Prev
+			k, v = c.Last()
+		}
+		for ; k != nil; k, v = next() {
+			id := binary.LittleEndian.Uint64(v)
+			if !inScope[id] {
+				continue
+			}
+			delete(inScope, id)
+			ok, err := matches(id)
+			if err != nil {
+				return err
+			}
+			if ok {
+				ids = append(ids, id)
+				keys = append(keys, bytes.Clone(k[:len(k)-9]))
+			}
+		}
+		// Entries without a value are not part of the index, they sort after
+		// all others and therefore first in reverse order.
+		var missing []uint64
+		for _, id := range entryIDs {
+			if !inScope[id] {
+				continue
+			}
+			ok, err := matches(id)
+			if err != nil {
+				return err
+			}
+			if ok {
+				missing = append(missing, id)
+			}
+		}
+		if reverse {
+			ids = append(missing, ids...)
+			keys = append(make([][]byte, len(missing)), keys...)
+		} else {
+			ids = append(ids, missing...)
+			keys = append(keys, make([][]byte, len(missing))...)
+		}
+
+		start, end := window(keys)
+		for _, id := range ids[start:end] {
+			entry, err := bdb.loadEntry(tx, id)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func sortIndexBucket(tx *bolt.Tx, index *sortIndex) *bolt.Bucket {
+	sortindex := tx.Bucket([]byte("sortindex"))
+	if sortindex == nil {
+		return nil
+	}
+	return sortindex.Bucket([]byte(index.attribute.Name()))
+}
+
+// key returns the index key of entry with id, which consists of the
+// ordering key of the least value of the indexed attribute, a zero byte and
+// the id. It returns nil if entry has no value which can be ordered.
+func (index *sortIndex) key(entry *ldap.Entry, id uint64) []byte {
+	if entry == nil {
+		return nil
+	}
+	var least []byte
+	for _, attribute := range entry.Attributes {
+		if strings.Contains(attribute.Name, ";") || !index.attribute.HasName(attribute.Name) {
+			continue
+		}
+		for _, value := range attribute.Values {
+			key, ok := index.rule.OrderingKey(value)
+			if ok && (least == nil || bytes.Compare(key, least) < 0) {
+				least = key
+			}
+		}
+	}
+	if least == nil {
+		return nil
+	}
+	return append(append(least, 0), idToBytes(id)...)
+}
+
+// updateSortIndexes updates the sort indexes for the change of the entry
+// with id from oldEntry to newEntry. oldEntry is nil for added entries and
+// newEntry is nil for deleted entries.
+func (bdb *LdbBolt) updateSortIndexes(tx *bolt.Tx, id uint64, oldEntry, newEntry *ldap.Entry) error {
+	for _, index := range bdb.sortIndexes {
+		bucket := sortIndexBucket(tx, index)
+		if bucket == nil {
+			continue
+		}
+		oldKey := index.key(oldEntry, id)
+		newKey := index.key(newEntry, id)
+		if bytes.Equal(oldKey, newKey) {
+			continue
+		}
+		if oldKey != nil {
+			if err := bucket.Delete(oldKey); err != nil {
+				return err
+			}
+		}
+		if newKey != nil {
+			if err := bucket.Put(newKey, idToBytes(id)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// initializeSortIndexes creates the buckets of the sort indexes. Indexes
+// which did not exist yet are built from the existing entries.
+func (bdb *LdbBolt) initializeSortIndexes(tx *bolt.Tx) error {
+	sortindex, err := tx.CreateBucketIfNotExists([]byte("sortindex"))
+	if err != nil {
+		return fmt.Errorf("create bucket 'sortindex': %w", err)
+	}
+	id2entry := tx.Bucket([]byte("id2entry"))
+	for _, index := range bdb.sortIndexes {
+		name := index.attribute.Name()
+		if sortindex.Bucket([]byte(name)) != nil {
+			continue
+		}
+		bucket, err := sortindex.CreateBucket([]byte(name))
+		if err != nil {
+			return fmt.Errorf("create sort index bucket '%s': %w", name, err)
+		}
+		err = id2entry.ForEach(func(k, _ []byte) error {
+			id := binary.LittleEndian.Uint64(k)
+			entry, err := bdb.getEntryByID(tx, id)
+			if err != nil {
+				return err
+			}
+			if key := index.key(entry, id); key != nil {
+				return bucket.Put(key, idToBytes(id))
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("build sort index '%s': %w", name, err)
+		}
+	}
+	return nil
+}
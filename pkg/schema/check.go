@@ -0,0 +1,158 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2021 The LibreGraph Authors.
+ */
+
+package schema
+
+import (
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// CheckEntry checks e against s. It returns an *ldap.Error with the result
+// code objectClassViolation if the object classes of e are unknown, do not
+// include exactly one structural object class chain, or do not permit the
+// attributes of e. Unknown attribute types result in undefinedAttributeType,
+// values not matching the attribute syntax in invalidAttributeSyntax and
+// multiple values of single valued attributes in constraintViolation.
+func (s *Schema) CheckEntry(e *ldap.Entry) error {
+	classes, err := s.entryObjectClasses(e)
+	if err != nil {
+		return err
+	}
+	if _, err = structuralObjectClass(classes); err != nil {
+		return err
+	}
+
+	extensible := false
+	allowed := map[*AttributeType]bool{}
+	for _, o := range classes {
+		if o.HasName("extensibleObject") {
+			extensible = true
+		}
+		for _, a := range o.RequiredAttributes() {
+			allowed[a] = true
+		}
+		for _, a := range o.AllowedAttributes() {
+			allowed[a] = true
+		}
+	}
+
+	present := map[*AttributeType]bool{}
+	for _, attribute := range e.Attributes {
+		a, ok := s.AttributeType(attribute.Name)
+		if !ok {
+			return ldap.NewError(ldap.LDAPResultUndefinedAttributeType, fmt.Errorf("%s: attribute type undefined", attribute.Name))
+		}
+		if len(attribute.Values) == 0 {
+			continue
+		}
+		present[a] = true
+		if !extensible && !a.IsOperational() && !allowed[a] {
+			return ldap.NewError(ldap.LDAPResultObjectClassViolation, fmt.Errorf("attribute '%s' not allowed", attribute.Name))
+		}
+		if a.SingleValue && len(attribute.Values) > 1 {
+			return ldap.NewError(ldap.LDAPResultConstraintViolation, fmt.Errorf("attribute '%s' cannot have multiple values", attribute.Name))
+		}
+		for i, value := range attribute.Values {
+			if !a.Syntax.Validate(value) {
+				return ldap.NewError(ldap.LDAPResultInvalidAttributeSyntax, fmt.Errorf("%s: value #%d invalid per syntax", attribute.Name, i))
+			}
+		}
+	}
+	for _, o := range classes {
+		for _, a := range o.RequiredAttributes() {
+			if !present[a] && !presentAsSubtype(a, present) {
+				return ldap.NewError(ldap.LDAPResultObjectClassViolation, fmt.Errorf("object class '%s' requires attribute '%s'", o.Name(), a.Name()))
+			}
+		}
+	}
+	return nil
+}
+
+// CheckModify checks the modified entry newEntry with CheckEntry and makes
+// sure the structural object class of oldEntry was not changed. Changing it
+// results in objectClassModsProhibited.
+func (s *Schema) CheckModify(oldEntry, newEntry *ldap.Entry) error {
+	if err := s.CheckEntry(newEntry); err != nil {
+		return err
+	}
+	oldClasses, err := s.entryObjectClasses(oldEntry)
+	if err != nil {
+		// The old entry was stored without a schema check, allow to fix it.
+		return nil
+	}
+	oldStructural, err := structuralObjectClass(oldClasses)
+	if err != nil {
+		return nil
+	}
+	newClasses, _ := s.entryObjectClasses(newEntry)
+	newStructural, _ := structuralObjectClass(newClasses)
+	if oldStructural != newStructural {
+		return ldap.NewError(ldap.LDAPResultObjectClassModsProhibited, fmt.Errorf("structural object class modification from '%s' to '%s' not allowed", oldStructural.Name(), newStructural.Name()))
+	}
+	return nil
+}
+
+// StructuralObjectClass returns the structural object class of e (RFC 4512
+// 2.4.2). It fails like CheckEntry if the object classes of e are unknown or
+// do not include exactly one structural object class chain.
+func (s *Schema) StructuralObjectClass(e *ldap.Entry) (*ObjectClass, error) {
+	classes, err := s.entryObjectClasses(e)
+	if err != nil {
+		return nil, err
+	}
+	return structuralObjectClass(classes)
+}
+
+// entryObjectClasses returns the object classes of e.
+func (s *Schema) entryObjectClasses(e *ldap.Entry) ([]*ObjectClass, error) {
+	names := e.GetEqualFoldAttributeValues("objectClass")
+	if len(names) == 0 {
+		return nil, ldap.NewError(ldap.LDAPResultObjectClassViolation, fmt.Errorf("no objectClass attribute"))
+	}
+	classes := make([]*ObjectClass, 0, len(names))
+	for _, name := range names {
+		o, ok := s.ObjectClass(name)
+		if !ok {
+			return nil, ldap.NewError(ldap.LDAPResultObjectClassViolation, fmt.Errorf("unrecognized objectClass '%s'", name))
+		}
+		classes = append(classes, o)
+	}
+	return classes, nil
+}
+
+// structuralObjectClass returns the most specific structural object class of
+// classes. All other structural object classes must be its superclasses.
+func structuralObjectClass(classes []*ObjectClass) (*ObjectClass, error) {
+	var structural *ObjectClass
+	for _, o := range classes {
+		if o.Kind != Structural {
+			continue
+		}
+		switch {
+		case structural == nil || o.IsSubclassOf(structural):
+			structural = o
+		case structural.IsSubclassOf(o):
+		default:
+			return nil, ldap.NewError(ldap.LDAPResultObjectClassViolation, fmt.Errorf("invalid structural object class chain (%s/%s)", structural.Name(), o.Name()))
+		}
+	}
+	if structural == nil {
+		return nil, ldap.NewError(ldap.LDAPResultObjectClassViolation, fmt.Errorf("no structural object class provided"))
+	}
+	return structural, nil
+}
+
+// presentAsSubtype returns true if a subtype of a is present, for example
+// cn for a required name.
+func presentAsSubtype(a *AttributeType, present map[*AttributeType]bool) bool {
+	for p := range present {
+		if p.IsSubtypeOf(a) {
+			return true
+		}
+	}
+	return false
+}
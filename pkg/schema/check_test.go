@@ -0,0 +1,127 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestCheckEntry(t *testing.T) {
+	s := New()
+	person := map[string][]string{
+		"objectClass":     {"top", "person", "organizationalPerson", "inetOrgPerson", "posixAccount"},
+		"uid":             {"jdoe"},
+		"cn":              {"John Doe"},
+		"sn":              {"Doe"},
+		"displayName":     {"John"},
+		"uidNumber":       {"1000"},
+		"gidNumber":       {"-5"},
+		"homeDirectory":   {"/home/jdoe"},
+		"manager":         {"uid=boss,ou=users,dc=example,dc=org"},
+		"createTimestamp": {"20210304112233Z"},
+	}
+	if err := s.CheckEntry(ldap.NewEntry("uid=jdoe,ou=users,dc=example,dc=org", person)); err != nil {
+		t.Fatalf("Expected valid entry, got: %s", err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		change     map[string][]string
+		resultCode uint16
+	}{
+		{"no objectClass", map[string][]string{"objectClass": nil}, ldap.LDAPResultObjectClassViolation},
+		{"unknown objectClass", map[string][]string{"objectClass": {"inetOrgPerson", "unknown"}}, ldap.LDAPResultObjectClassViolation},
+		{"no structural objectClass", map[string][]string{"objectClass": {"top", "posixAccount"}}, ldap.LDAPResultObjectClassViolation},
+		{"two structural objectClasses", map[string][]string{"objectClass": {"inetOrgPerson", "posixAccount", "organizationalUnit"}}, ldap.LDAPResultObjectClassViolation},
+		{"missing MUST", map[string][]string{"sn": nil}, ldap.LDAPResultObjectClassViolation},
+		{"not allowed", map[string][]string{"dc": {"example"}}, ldap.LDAPResultObjectClassViolation},
+		{"undefined attribute", map[string][]string{"mailAlternateAddress": {"a@example.org"}}, ldap.LDAPResultUndefinedAttributeType},
+		{"single value", map[string][]string{"displayName": {"John", "Johnny"}}, ldap.LDAPResultConstraintViolation},
+		{"invalid integer", map[string][]string{"uidNumber": {"01000"}}, ldap.LDAPResultInvalidAttributeSyntax},
+		{"invalid DN", map[string][]string{"manager": {"boss"}}, ldap.LDAPResultInvalidAttributeSyntax},
+		{"invalid GeneralizedTime", map[string][]string{"createTimestamp": {"20211304112233Z"}}, ldap.LDAPResultInvalidAttributeSyntax},
+		{"invalid IA5 string", map[string][]string{"homeDirectory": {"/home/jöe"}}, ldap.LDAPResultInvalidAttributeSyntax},
+	} {
+		attributes := map[string][]string{}
+		for name, values := range person {
+			attributes[name] = values
+		}
+		for name, values := range tc.change {
+			if values == nil {
+				delete(attributes, name)
+			} else {
+				attributes[name] = values
+			}
+		}
+		err := s.CheckEntry(ldap.NewEntry("uid=jdoe,ou=users,dc=example,dc=org", attributes))
+		var ldapErr *ldap.Error
+		if !errors.As(err, &ldapErr) || ldapErr.ResultCode != tc.resultCode {
+			t.Errorf("%s: expected result code %d, got: %v", tc.name, tc.resultCode, err)
+		}
+	}
+
+	extensible := ldap.NewEntry("cn=x,dc=example,dc=org", map[string][]string{
+		"objectClass": {"device", "extensibleObject"},
+		"cn":          {"x"},
+		"mail":        {"x@example.org"},
+	})
+	if err := s.CheckEntry(extensible); err != nil {
+		t.Errorf("Expected extensibleObject to allow any attribute, got: %s", err)
+	}
+}
+
+func TestCheckModify(t *testing.T) {
+	s := New()
+	oldEntry := ldap.NewEntry("cn=x,dc=example,dc=org", map[string][]string{
+		"objectClass": {"person"},
+		"cn":          {"x"},
+		"sn":          {"x"},
+	})
+	derived := ldap.NewEntry("cn=x,dc=example,dc=org", map[string][]string{
+		"objectClass": {"person", "organizationalPerson"},
+		"cn":          {"x"},
+		"sn":          {"x"},
+	})
+	var ldapErr *ldap.Error
+	if err := s.CheckModify(oldEntry, derived); !errors.As(err, &ldapErr) || ldapErr.ResultCode != ldap.LDAPResultObjectClassModsProhibited {
+		t.Errorf("Expected structural object class change to be prohibited, got: %v", err)
+	}
+	auxiliary := ldap.NewEntry("cn=x,dc=example,dc=org", map[string][]string{
+		"objectClass": {"person", "shadowAccount"},
+		"cn":          {"x"},
+		"sn":          {"x"},
+		"uid":         {"x"},
+	})
+	if err := s.CheckModify(oldEntry, auxiliary); err != nil {
+		t.Errorf("Expected adding an auxiliary object class to succeed, got: %s", err)
+	}
+	removed := ldap.NewEntry("cn=x,dc=example,dc=org", map[string][]string{
+		"objectClass": {"top"},
+		"cn":          {"x"},
+	})
+	if err := s.CheckModify(oldEntry, removed); !errors.As(err, &ldapErr) || ldapErr.ResultCode != ldap.LDAPResultObjectClassViolation {
+		t.Errorf("Expected removing the structural object class to fail, got: %v", err)
+	}
+}
+
+func TestSyntaxValidate(t *testing.T) {
+	s := New()
+	for oid, values := range map[string]map[string]bool{
+		"1.3.6.1.4.1.1466.115.121.1.27": {"0": true, "-12": true, "12": true, "-0": false, "012": false, "1a": false, "": false},
+		"1.3.6.1.4.1.1466.115.121.1.24": {"2021030411Z": true, "202103041122Z": true, "20210304112233.5+0100": true, "20210304112233": false, "20210231112299Z": false},
+		"1.3.6.1.4.1.1466.115.121.1.12": {"": true, "cn=x,dc=example": true, "cn": false},
+		"1.3.6.1.4.1.1466.115.121.1.7":  {"TRUE": true, "FALSE": true, "true": false},
+		"1.3.6.1.4.1.1466.115.121.1.40": {"\x00\xff": true},
+	} {
+		syntax, ok := s.Syntax(oid)
+		if !ok {
+			t.Fatalf("Syntax %s not found", oid)
+		}
+		for value, valid := range values {
+			if got := syntax.Validate(value); got != valid {
+				t.Errorf("Syntax %s: expected %v for %q, got %v", syntax.Description, valid, value, got)
+			}
+		}
+	}
+}
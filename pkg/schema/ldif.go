@@ -0,0 +1,122 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2021 The LibreGraph Authors.
+ */
+
+package schema
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/go-ldap/ldif"
+)
+
+// ldifAttributes are the schema attributes of OpenLDAP cn=schema,cn=config
+// entries in load order.
+var ldifAttributes = []struct {
+	name string
+	add  func(s *Schema, args string) error
+}{
+	{"olcObjectIdentifier", (*Schema).addOIDMacro},
+	{"olcLdapSyntaxes", (*Schema).addSyntax},
+	{"olcAttributeTypes", (*Schema).addAttributeType},
+	{"olcObjectClasses", (*Schema).addObjectClass},
+}
+
+// ldifOrderRegexp matches the "{n}" ordering prefix of OpenLDAP cn=config
+// attribute values.
+var ldifOrderRegexp = regexp.MustCompile(`^\{[0-9]+\}`)
+
+// LoadDir loads all schema files with the extensions .schema and .ldif from
+// the named directory into s in sorted order. Files with the extension .ldif
+// are loaded with LoadLDIFFile.
+func (s *Schema) LoadDir(dir string) error {
+	matches, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, match := range matches {
+		if match.IsDir() {
+			continue
+		}
+		switch filepath.Ext(match.Name()) {
+		case ".schema", ".ldif":
+			names = append(names, filepath.Join(dir, match.Name()))
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if filepath.Ext(name) == ".ldif" {
+			err = s.LoadLDIFFile(name)
+		} else {
+			err = s.LoadFile(name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadLDIFFile loads the definitions of the named LDIF schema file into s.
+func (s *Schema) LoadLDIFFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = s.LoadLDIF(f); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// LoadLDIF loads the definitions of the OpenLDAP cn=schema,cn=config entries
+// read from r into s. Entries can be plain records or add change records.
+// Within an entry, object identifiers are loaded first, followed by syntaxes,
+// attribute types and object classes.
+func (s *Schema) LoadLDIF(r io.Reader) error {
+	l := &ldif.LDIF{}
+	if err := ldif.Unmarshal(r, l); err != nil {
+		return err
+	}
+	for _, record := range l.Entries {
+		var dn string
+		var attributes []*ldap.EntryAttribute
+		switch {
+		case record.Entry != nil:
+			dn = record.Entry.DN
+			attributes = record.Entry.Attributes
+		case record.Add != nil:
+			dn = record.Add.DN
+			for _, attribute := range record.Add.Attributes {
+				attributes = append(attributes, ldap.NewEntryAttribute(attribute.Type, attribute.Vals))
+			}
+		default:
+			return fmt.Errorf("unsupported change record")
+		}
+
+		for _, kind := range ldifAttributes {
+			for _, attribute := range attributes {
+				if !strings.EqualFold(attribute.Name, kind.name) {
+					continue
+				}
+				for _, value := range attribute.Values {
+					if err := kind.add(s, ldifOrderRegexp.ReplaceAllString(value, "")); err != nil {
+						return fmt.Errorf("%s: %s: %w", dn, kind.name, err)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,422 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2021 The LibreGraph Authors.
+ */
+
+package schema
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/cases"
+
+	"github.com/libregraph/idm/pkg/ldapdn"
+)
+
+// orderingFuncs compare two attribute values according to the ordering
+// matching rule with the OID of the key. They return a negative number if the
+// first value is less than the second, zero if both are equal and a positive
+// number otherwise.
+var orderingFuncs = map[string]func(a, b string) int{
+	"2.5.13.3":       caseIgnoreOrdering,      // caseIgnoreOrderingMatch
+	"2.5.13.6":       caseExactOrdering,       // caseExactOrderingMatch
+	"2.5.13.9":       numericStringOrdering,   // numericStringOrderingMatch
+	"2.5.13.15":      integerOrdering,         // integerOrderingMatch
+	"2.5.13.18":      strings.Compare,         // octetStringOrderingMatch
+	"2.5.13.28":      generalizedTimeOrdering, // generalizedTimeOrderingMatch
+	"1.3.6.1.1.16.3": caseIgnoreOrdering,      // uuidOrderingMatch
+}
+
+// orderingKeyFuncs return a key for an attribute value of which the byte
+// order is the order of the ordering matching rule with the OID of the key.
+// They return false if the value cannot be ordered by the rule.
+var orderingKeyFuncs = map[string]func(value string) ([]byte, bool){
+	"2.5.13.3":       caseIgnoreOrderingKey,
+	"2.5.13.6":       caseExactOrderingKey,
+	"2.5.13.9":       numericStringOrderingKey,
+	"2.5.13.15":      integerOrderingKey,
+	"2.5.13.18":      octetStringOrderingKey,
+	"2.5.13.28":      generalizedTimeOrderingKey,
+	"1.3.6.1.1.16.3": caseIgnoreOrderingKey,
+}
+
+// equalityOrderings map equality matching rules to the ordering matching rule
+// which is used for attribute types without an ORDERING rule.
+var equalityOrderings = map[string]string{
+	"2.5.13.2":                   "2.5.13.3",  // caseIgnoreMatch
+	"1.3.6.1.4.1.1466.109.114.2": "2.5.13.3",  // caseIgnoreIA5Match
+	"2.5.13.5":                   "2.5.13.6",  // caseExactMatch
+	"1.3.6.1.4.1.1466.109.114.1": "2.5.13.6",  // caseExactIA5Match
+	"2.5.13.8":                   "2.5.13.9",  // numericStringMatch
+	"2.5.13.14":                  "2.5.13.15", // integerMatch
+	"2.5.13.17":                  "2.5.13.18", // octetStringMatch
+	"2.5.13.27":                  "2.5.13.28", // generalizedTimeMatch
+}
+
+// equalityFuncs normalize an attribute value for the equality matching rule
+// with the OID of the key, so that two values are equal according to the
+// rule if their normalized values are equal. They return false if the value
+// is not valid for the rule.
+var equalityFuncs = map[string]func(value string) (string, bool){
+	"2.5.13.0":                   caseIgnoreNormalize,        // objectIdentifierMatch
+	"2.5.13.1":                   distinguishedNameNormalize, // distinguishedNameMatch
+	"2.5.13.2":                   caseIgnoreNormalize,        // caseIgnoreMatch
+	"2.5.13.5":                   caseExactNormalize,         // caseExactMatch
+	"2.5.13.8":                   numericStringNormalize,     // numericStringMatch
+	"2.5.13.13":                  booleanNormalize,           // booleanMatch
+	"2.5.13.14":                  integerNormalize,           // integerMatch
+	"2.5.13.17":                  octetStringNormalize,       // octetStringMatch
+	"2.5.13.20":                  telephoneNumberNormalize,   // telephoneNumberMatch
+	"2.5.13.23":                  uniqueMemberNormalize,      // uniqueMemberMatch
+	"2.5.13.27":                  generalizedTimeNormalize,   // generalizedTimeMatch
+	"1.3.6.1.4.1.1466.109.114.1": caseExactNormalize,         // caseExactIA5Match
+	"1.3.6.1.4.1.1466.109.114.2": caseIgnoreNormalize,        // caseIgnoreIA5Match
+	"1.3.6.1.1.16.2":             caseIgnoreNormalize,        // uuidMatch
+}
+
+// assertionFuncs evaluate the assertion of an extensible match with the
+// matching rule with the OID of the key, which is neither an equality nor an
+// ordering matching rule, against an attribute value.
+var assertionFuncs = map[string]func(value, assertion string) (bool, bool){
+	"1.2.840.113556.1.4.803": integerBitAndMatch,
+	"1.2.840.113556.1.4.804": integerBitOrMatch,
+}
+
+// IsOrdering returns true if m is an ordering matching rule which can be used
+// with Compare.
+func (m *MatchingRule) IsOrdering() bool {
+	_, ok := orderingFuncs[m.OID]
+	return ok
+}
+
+// Compare compares the values a and b according to the ordering matching
+// rule m. The result is negative if a is less than b, zero if both are equal
+// and positive if a is greater than b. Values are compared as octet strings
+// if m is not an ordering matching rule.
+func (m *MatchingRule) Compare(a, b string) int {
+	if compare, ok := orderingFuncs[m.OID]; ok {
+		return compare(a, b)
+	}
+	return strings.Compare(a, b)
+}
+
+// OrderingKey returns a key for value of which the byte order is the order
+// of the ordering matching rule m, so that bytes.Compare of the keys of two
+// values has the same result as Compare of the values. It returns false if m
+// is not an ordering matching rule or value is not valid for m.
+func (m *MatchingRule) OrderingKey(value string) ([]byte, bool) {
+	if key, ok := orderingKeyFuncs[m.OID]; ok {
+		return key(value)
+	}
+	return nil, false
+}
+
+// IsEquality returns true if m is an equality matching rule which can be
+// used with Normalize and Equal.
+func (m *MatchingRule) IsEquality() bool {
+	_, ok := equalityFuncs[m.OID]
+	return ok
+}
+
+// Normalize returns the normalized form of value according to the equality
+// matching rule m. Two values are equal according to m if their normalized
+// forms are equal. It returns false if m is not an equality matching rule or
+// value is not valid for m.
+func (m *MatchingRule) Normalize(value string) (string, bool) {
+	if normalize, ok := equalityFuncs[m.OID]; ok {
+		return normalize(value)
+	}
+	return "", false
+}
+
+// Equal returns true if the values a and b are equal according to the
+// equality matching rule m. Values which are not valid for m and values of
+// other matching rules are compared as octet strings.
+func (m *MatchingRule) Equal(a, b string) bool {
+	x, okA := m.Normalize(a)
+	y, okB := m.Normalize(b)
+	if !okA || !okB {
+		return a == b
+	}
+	return x == y
+}
+
+// EqualityRule returns the equality matching rule of the attribute type
+// with name. Attribute types which are not in s are compared as case-ignore
+// strings. It returns false if the attribute type has no equality matching
+// rule which can be evaluated.
+func (s *Schema) EqualityRule(name string) (*MatchingRule, bool) {
+	if a, ok := s.AttributeType(name); ok {
+		if a.Equality == nil || !a.Equality.IsEquality() {
+			return nil, false
+		}
+		return a.Equality, true
+	}
+	return s.MatchingRule("caseIgnoreMatch")
+}
+
+// Match evaluates the assertion of an extensible match filter (RFC 4511
+// 4.5.1.7.7) with the matching rule m against value. Equality matching rules
+// match values equal to the assertion and ordering matching rules values
+// less than the assertion. The second result is false if the match is
+// undefined, because m cannot be evaluated or one of the values is not valid
+// for m.
+func (m *MatchingRule) Match(value, assertion string) (bool, bool) {
+	if key, ok := orderingKeyFuncs[m.OID]; ok {
+		v, okV := key(value)
+		a, okA := key(assertion)
+		if !okV || !okA {
+			return false, false
+		}
+		return bytes.Compare(v, a) < 0, true
+	}
+	if normalize, ok := equalityFuncs[m.OID]; ok {
+		v, okV := normalize(value)
+		a, okA := normalize(assertion)
+		if !okV || !okA {
+			return false, false
+		}
+		return v == a, true
+	}
+	if match, ok := assertionFuncs[m.OID]; ok {
+		return match(value, assertion)
+	}
+	return false, false
+}
+
+// OrderingRule returns the ordering matching rule of a. For attribute types
+// without an ORDERING rule, the ordering matching rule corresponding to its
+// EQUALITY rule is returned if there is one.
+func (s *Schema) OrderingRule(a *AttributeType) (*MatchingRule, bool) {
+	if a.Ordering != nil {
+		return a.Ordering, true
+	}
+	if a.Equality != nil {
+		if oid, ok := equalityOrderings[a.Equality.OID]; ok {
+			return s.MatchingRule(oid)
+		}
+	}
+	return nil, false
+}
+
+// prepareString applies a simplified version of the string preparation of
+// RFC 4518, removing leading and trailing spaces and collapsing inner spaces.
+func prepareString(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+func caseIgnoreOrdering(a, b string) int {
+	casefold := cases.Fold()
+	return strings.Compare(casefold.String(prepareString(a)), casefold.String(prepareString(b)))
+}
+
+func caseExactOrdering(a, b string) int {
+	return strings.Compare(prepareString(a), prepareString(b))
+}
+
+func numericStringOrdering(a, b string) int {
+	a = strings.ReplaceAll(a, " ", "")
+	b = strings.ReplaceAll(b, " ", "")
+	return strings.Compare(a, b)
+}
+
+func integerOrdering(a, b string) int {
+	x, okA := new(big.Int).SetString(strings.TrimSpace(a), 10)
+	y, okB := new(big.Int).SetString(strings.TrimSpace(b), 10)
+	if !okA || !okB {
+		return strings.Compare(a, b)
+	}
+	return x.Cmp(y)
+}
+
+func generalizedTimeOrdering(a, b string) int {
+	x, errA := ParseGeneralizedTime(a)
+	y, errB := ParseGeneralizedTime(b)
+	if errA != nil || errB != nil {
+		return strings.Compare(a, b)
+	}
+	return x.Compare(y)
+}
+
+func caseIgnoreOrderingKey(value string) ([]byte, bool) {
+	return []byte(cases.Fold().String(prepareString(value))), true
+}
+
+func caseExactOrderingKey(value string) ([]byte, bool) {
+	return []byte(prepareString(value)), true
+}
+
+func numericStringOrderingKey(value string) ([]byte, bool) {
+	return []byte(strings.ReplaceAll(value, " ", "")), true
+}
+
+func octetStringOrderingKey(value string) ([]byte, bool) {
+	return []byte(value), true
+}
+
+// integerOrderingKey encodes the sign, the number of digits and the digits of
+// value. The length and the digits of negative values are inverted, so that
+// larger absolute values sort first.
+func integerOrderingKey(value string) ([]byte, bool) {
+	n, ok := new(big.Int).SetString(strings.TrimSpace(value), 10)
+	if !ok {
+		return nil, false
+	}
+	digits := []byte(n.Text(10))
+	switch n.Sign() {
+	case 0:
+		return []byte{1}, true
+	case 1:
+		return append(binary.BigEndian.AppendUint32([]byte{2}, uint32(len(digits))), digits...), true
+	}
+	digits = digits[1:]
+	key := binary.BigEndian.AppendUint32([]byte{0}, ^uint32(len(digits)))
+	for _, digit := range digits {
+		key = append(key, ^digit)
+	}
+	return key, true
+}
+
+func generalizedTimeOrderingKey(value string) ([]byte, bool) {
+	t, err := ParseGeneralizedTime(value)
+	if err != nil {
+		return nil, false
+	}
+	return []byte(t.UTC().Format("20060102150405.000000000")), true
+}
+
+func caseIgnoreNormalize(value string) (string, bool) {
+	return cases.Fold().String(prepareString(value)), true
+}
+
+func caseExactNormalize(value string) (string, bool) {
+	return prepareString(value), true
+}
+
+func numericStringNormalize(value string) (string, bool) {
+	return strings.ReplaceAll(value, " ", ""), true
+}
+
+func booleanNormalize(value string) (string, bool) {
+	switch value {
+	case "TRUE", "FALSE":
+		return value, true
+	}
+	return "", false
+}
+
+func integerNormalize(value string) (string, bool) {
+	n, ok := new(big.Int).SetString(strings.TrimSpace(value), 10)
+	if !ok {
+		return "", false
+	}
+	return n.Text(10), true
+}
+
+func octetStringNormalize(value string) (string, bool) {
+	return value, true
+}
+
+// telephoneNumberNormalize ignores case, spaces and hyphens (RFC 4517
+// 4.2.29).
+func telephoneNumberNormalize(value string) (string, bool) {
+	value = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, value)
+	return cases.Fold().String(value), true
+}
+
+func distinguishedNameNormalize(value string) (string, bool) {
+	dn, err := ldapdn.ParseNormalize(value)
+	if err != nil {
+		return "", false
+	}
+	return dn, true
+}
+
+// uniqueMemberNormalize normalizes the DN of a Name And Optional UID value
+// (RFC 4517 3.3.21) and keeps the optional UID.
+func uniqueMemberNormalize(value string) (string, bool) {
+	dn, uid := value, ""
+	if i := strings.LastIndex(value, "#'"); i >= 0 && strings.HasSuffix(value, "'B") {
+		dn, uid = value[:i], value[i:]
+	}
+	dn, ok := distinguishedNameNormalize(dn)
+	if !ok {
+		return "", false
+	}
+	return dn + uid, true
+}
+
+func generalizedTimeNormalize(value string) (string, bool) {
+	key, ok := generalizedTimeOrderingKey(value)
+	return string(key), ok
+}
+
+func integerBitAndMatch(value, assertion string) (bool, bool) {
+	v, okV := new(big.Int).SetString(strings.TrimSpace(value), 10)
+	a, okA := new(big.Int).SetString(strings.TrimSpace(assertion), 10)
+	if !okV || !okA {
+		return false, false
+	}
+	return new(big.Int).And(v, a).Cmp(a) == 0, true
+}
+
+func integerBitOrMatch(value, assertion string) (bool, bool) {
+	v, okV := new(big.Int).SetString(strings.TrimSpace(value), 10)
+	a, okA := new(big.Int).SetString(strings.TrimSpace(assertion), 10)
+	if !okV || !okA {
+		return false, false
+	}
+	return new(big.Int).And(v, a).Sign() != 0, true
+}
+
+// ParseGeneralizedTime parses value in the GeneralizedTime syntax of RFC 4517
+// 3.3.13.
+func ParseGeneralizedTime(value string) (time.Time, error) {
+	if !validGeneralizedTime(value) {
+		return time.Time{}, &time.ParseError{Layout: "GeneralizedTime", Value: value}
+	}
+	match := generalizedTimeRegexp.FindStringSubmatch(value)
+	n := make([]int, 6)
+	for i := range n {
+		n[i], _ = strconv.Atoi(match[i+1])
+	}
+
+	location := time.UTC
+	if zone := match[8]; zone != "Z" {
+		hours, _ := strconv.Atoi(zone[1:3])
+		minutes := 0
+		if len(zone) == 5 {
+			minutes, _ = strconv.Atoi(zone[3:])
+		}
+		offset := hours*3600 + minutes*60
+		if zone[0] == '-' {
+			offset = -offset
+		}
+		location = time.FixedZone(zone, offset)
+	}
+	t := time.Date(n[0], time.Month(n[1]), n[2], n[3], n[4], n[5], 0, location)
+
+	if fraction := match[7]; fraction != "" {
+		// The fraction applies to the least significant unit present.
+		unit := time.Hour
+		switch {
+		case match[6] != "":
+			unit = time.Second
+		case match[5] != "":
+			unit = time.Minute
+		}
+		f, _ := strconv.ParseFloat("0."+fraction, 64)
+		t = t.Add(time.Duration(f * float64(unit)))
+	}
+	return t, nil
+}
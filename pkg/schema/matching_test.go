@@ -0,0 +1,149 @@
+package schema
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMatchingRuleCompare(t *testing.T) {
+	s := New()
+	for _, tc := range []struct {
+		rule   string
+		a, b   string
+		result int
+	}{
+		{"caseIgnoreOrderingMatch", "abc", "ABD", -1},
+		{"caseIgnoreOrderingMatch", " Foo  Bar ", "foo bar", 0},
+		{"caseExactOrderingMatch", "abc", "ABC", 1},
+		{"numericStringOrderingMatch", "1 23", "124", -1},
+		{"integerOrderingMatch", "9", "10", -1},
+		{"integerOrderingMatch", "-10", "-9", -1},
+		{"integerOrderingMatch", "-13", "-12", -1},
+		{"integerOrderingMatch", "-1", "0", -1},
+		{"integerOrderingMatch", "0", "007", -1},
+		{"integerOrderingMatch", "123", "45", 1},
+		{"octetStringOrderingMatch", "9", "10", 1},
+		{"generalizedTimeOrderingMatch", "20210304112233Z", "20210304122233+0200", 1},
+		{"generalizedTimeOrderingMatch", "2021030411Z", "20210304110000.0Z", 0},
+	} {
+		rule, ok := s.MatchingRule(tc.rule)
+		if !ok || !rule.IsOrdering() {
+			t.Fatalf("Expected ordering matching rule %s", tc.rule)
+		}
+		if got := rule.Compare(tc.a, tc.b); got != tc.result {
+			t.Errorf("%s(%q, %q): expected %d, got %d", tc.rule, tc.a, tc.b, tc.result, got)
+		}
+		keyA, okA := rule.OrderingKey(tc.a)
+		keyB, okB := rule.OrderingKey(tc.b)
+		if !okA || !okB {
+			t.Errorf("%s: expected ordering keys for %q and %q", tc.rule, tc.a, tc.b)
+		} else if got := bytes.Compare(keyA, keyB); got != tc.result {
+			t.Errorf("%s: ordering keys of %q and %q compare as %d, expected %d", tc.rule, tc.a, tc.b, got, tc.result)
+		}
+	}
+
+	for attribute, expected := range map[string]string{
+		"cn":              "caseIgnoreOrderingMatch",
+		"uidNumber":       "integerOrderingMatch",
+		"createTimestamp": "generalizedTimeOrderingMatch",
+		"mail":            "caseIgnoreOrderingMatch",
+		"jpegPhoto":       "",
+	} {
+		a, _ := s.AttributeType(attribute)
+		rule, ok := s.OrderingRule(a)
+		if expected == "" {
+			if ok {
+				t.Errorf("Expected no ordering rule for %s, got %s", attribute, rule.Names)
+			}
+			continue
+		}
+		if !ok || !rule.HasName(expected) {
+			t.Errorf("Expected ordering rule %s for %s", expected, attribute)
+		}
+	}
+}
+
+func TestParseGeneralizedTime(t *testing.T) {
+	for value, expected := range map[string]time.Time{
+		"20210304112233Z":   time.Date(2021, 3, 4, 11, 22, 33, 0, time.UTC),
+		"20210304112233.5Z": time.Date(2021, 3, 4, 11, 22, 33, 500000000, time.UTC),
+		"202103041122-0130": time.Date(2021, 3, 4, 12, 52, 0, 0, time.UTC),
+		"2021030411.25+01":  time.Date(2021, 3, 4, 10, 15, 0, 0, time.UTC),
+	} {
+		got, err := ParseGeneralizedTime(value)
+		if err != nil {
+			t.Errorf("Failed to parse %s: %s", value, err)
+		} else if !got.Equal(expected) {
+			t.Errorf("Parsed %s as %s, expected %s", value, got, expected)
+		}
+	}
+	if _, err := ParseGeneralizedTime("20210304"); err == nil {
+		t.Errorf("Expected error for invalid GeneralizedTime")
+	}
+}
+
+func TestMatchingRuleMatch(t *testing.T) {
+	s := New()
+	for _, tc := range []struct {
+		rule      string
+		value     string
+		assertion string
+		match     bool
+		defined   bool
+	}{
+		{"caseIgnoreMatch", "Foo  Bar", "foo bar", true, true},
+		{"caseExactMatch", "Foo", "foo", false, true},
+		{"integerMatch", "0010", "10", true, true},
+		{"integerMatch", "ten", "10", false, false},
+		{"integerOrderingMatch", "9", "10", true, true},
+		{"integerOrderingMatch", "10", "10", false, true},
+		{"generalizedTimeMatch", "20210304112233Z", "20210304122233+0100", true, true},
+		{"integerBitAndMatch", "514", "2", true, true},
+		{"integerBitAndMatch", "514", "3", false, true},
+		{"integerBitOrMatch", "514", "3", true, true},
+		{"objectIdentifierMatch", "Person", "person", true, true},
+		{"bitStringMatch", "'0101'B", "'0101'B", false, false},
+	} {
+		rule, ok := s.MatchingRule(tc.rule)
+		if !ok {
+			t.Fatalf("Expected matching rule %s", tc.rule)
+		}
+		match, defined := rule.Match(tc.value, tc.assertion)
+		if match != tc.match || defined != tc.defined {
+			t.Errorf("%s(%q, %q): expected %v/%v, got %v/%v", tc.rule, tc.value, tc.assertion, tc.match, tc.defined, match, defined)
+		}
+	}
+}
+
+func TestMatchingRuleEqual(t *testing.T) {
+	s := New()
+	for _, tc := range []struct {
+		attribute string
+		a, b      string
+		equal     bool
+	}{
+		{"cn", "John  Smith", "john smith", true},
+		{"userPassword", "Secret", "secret", false},
+		{"userPassword", "secret", "secret", true},
+		{"telephoneNumber", "+49 30 1234-567", "+49301234567", true},
+		{"uidNumber", "01000", "1000", true},
+		{"member", "UID=User, OU=Users,o=Base", "uid=user,ou=users,o=base", true},
+		{"uniqueMember", "uid=user,o=base#'0101'B", "UID=user,o=base#'0101'B", true},
+		{"uniqueMember", "uid=user,o=base#'0101'B", "uid=user,o=base", false},
+		{"createTimestamp", "20210304112233Z", "20210304122233+0100", true},
+		{"unknownAttribute", "Value", "value", true},
+	} {
+		rule, ok := s.EqualityRule(tc.attribute)
+		if !ok {
+			t.Fatalf("Expected equality rule for %s", tc.attribute)
+		}
+		if got := rule.Equal(tc.a, tc.b); got != tc.equal {
+			t.Errorf("%s %s(%q, %q): expected %v, got %v", tc.attribute, rule.Name(), tc.a, tc.b, tc.equal, got)
+		}
+	}
+
+	if _, ok := s.EqualityRule("jpegPhoto"); ok {
+		t.Errorf("Expected no equality rule for jpegPhoto")
+	}
+}
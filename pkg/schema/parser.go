@@ -0,0 +1,259 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2021 The LibreGraph Authors.
+ */
+
+package schema
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenOpen tokenKind = iota
+	tokenClose
+	tokenDollar
+	tokenQuoted
+	tokenWord
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+// flagKeywords are the keywords of RFC 4512 descriptions which have no value.
+var flagKeywords = map[string]bool{
+	"OBSOLETE":             true,
+	"SINGLE-VALUE":         true,
+	"COLLECTIVE":           true,
+	"NO-USER-MODIFICATION": true,
+	"ABSTRACT":             true,
+	"STRUCTURAL":           true,
+	"AUXILIARY":            true,
+}
+
+// definition is a parsed RFC 4512 description like an AttributeTypeDescription
+// or an ObjectClassDescription. Keywords are stored upper case in the order of
+// the definition, flags have no values.
+type definition struct {
+	oid    string
+	keys   []string
+	values map[string][]string
+}
+
+func (d *definition) has(key string) bool {
+	_, ok := d.values[key]
+	return ok
+}
+
+func (d *definition) first(key string) string {
+	if values := d.values[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// String formats d as RFC 4512 description.
+func (d *definition) String() string {
+	var b strings.Builder
+	b.WriteString("( ")
+	b.WriteString(d.oid)
+	for _, key := range d.keys {
+		b.WriteString(" ")
+		b.WriteString(key)
+		values := d.values[key]
+		if len(values) == 0 {
+			continue
+		}
+		quoted := key == "NAME" || key == "DESC" || strings.HasPrefix(key, "X-")
+		if len(values) == 1 {
+			b.WriteString(" ")
+			b.WriteString(formatValue(values[0], quoted))
+			continue
+		}
+		b.WriteString(" (")
+		for i, value := range values {
+			if i > 0 && !quoted {
+				b.WriteString(" $")
+			}
+			b.WriteString(" ")
+			b.WriteString(formatValue(value, quoted))
+		}
+		b.WriteString(" )")
+	}
+	b.WriteString(" )")
+	return b.String()
+}
+
+func formatValue(value string, quoted bool) string {
+	if !quoted {
+		return value
+	}
+	value = strings.ReplaceAll(value, `\`, `\5C`)
+	value = strings.ReplaceAll(value, `'`, `\27`)
+	return "'" + value + "'"
+}
+
+// tokenize splits an RFC 4512 description into its tokens.
+func tokenize(s string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenOpen})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenClose})
+			i++
+		case c == '$':
+			tokens = append(tokens, token{kind: tokenDollar})
+			i++
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quoted string at offset %d", i)
+			}
+			value := s[i+1 : i+1+end]
+			value = strings.ReplaceAll(value, `\27`, `'`)
+			value = strings.ReplaceAll(value, `\5C`, `\`)
+			value = strings.ReplaceAll(value, `\5c`, `\`)
+			tokens = append(tokens, token{kind: tokenQuoted, value: value})
+			i += end + 2
+		default:
+			end := strings.IndexAny(s[i:], " \t\n\r()$'")
+			if end < 0 {
+				end = len(s) - i
+			}
+			tokens = append(tokens, token{kind: tokenWord, value: s[i : i+end]})
+			i += end
+		}
+	}
+	return tokens, nil
+}
+
+// parseDefinition parses an RFC 4512 description. Keywords which are not in
+// allowed and which are no extensions are rejected.
+func parseDefinition(s string, allowed map[string]bool) (*definition, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) < 3 || tokens[0].kind != tokenOpen || tokens[len(tokens)-1].kind != tokenClose {
+		return nil, fmt.Errorf("definition must be enclosed in parentheses")
+	}
+	tokens = tokens[1 : len(tokens)-1]
+	if tokens[0].kind != tokenWord {
+		return nil, fmt.Errorf("definition must start with an OID")
+	}
+
+	d := &definition{
+		oid:    tokens[0].value,
+		values: make(map[string][]string),
+	}
+	for i := 1; i < len(tokens); {
+		if tokens[i].kind != tokenWord {
+			return nil, fmt.Errorf("unexpected token in definition of %s", d.oid)
+		}
+		key := strings.ToUpper(tokens[i].value)
+		i++
+		if !allowed[key] && !strings.HasPrefix(key, "X-") {
+			return nil, fmt.Errorf("unknown keyword %s in definition of %s", key, d.oid)
+		}
+		if d.has(key) {
+			return nil, fmt.Errorf("duplicate keyword %s in definition of %s", key, d.oid)
+		}
+		d.keys = append(d.keys, key)
+		if flagKeywords[key] {
+			d.values[key] = nil
+			continue
+		}
+		if i >= len(tokens) {
+			return nil, fmt.Errorf("missing value for %s in definition of %s", key, d.oid)
+		}
+
+		var values []string
+		switch tokens[i].kind {
+		case tokenWord, tokenQuoted:
+			values = []string{tokens[i].value}
+			i++
+		case tokenOpen:
+			i++
+			for ; i < len(tokens) && tokens[i].kind != tokenClose; i++ {
+				switch tokens[i].kind {
+				case tokenWord, tokenQuoted:
+					values = append(values, tokens[i].value)
+				case tokenDollar:
+				default:
+					return nil, fmt.Errorf("unexpected token in value of %s in definition of %s", key, d.oid)
+				}
+			}
+			if i >= len(tokens) {
+				return nil, fmt.Errorf("unterminated value list for %s in definition of %s", key, d.oid)
+			}
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected token in value of %s in definition of %s", key, d.oid)
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("empty value for %s in definition of %s", key, d.oid)
+		}
+		d.values[key] = values
+	}
+	return d, nil
+}
+
+// statement is a single statement of a schema file.
+type statement struct {
+	keyword string
+	args    string
+	line    int
+}
+
+// readStatements reads the statements of a schema file in the format used by
+// OpenLDAP. Lines starting with "#" are comments, lines starting with white
+// space continue the previous statement.
+func readStatements(r io.Reader) ([]statement, error) {
+	statements := []statement{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var current *statement
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "#"):
+			continue
+		case strings.TrimSpace(line) == "":
+			continue
+		case line[0] == ' ' || line[0] == '\t':
+			if current == nil {
+				return nil, fmt.Errorf("line %d: continuation line without statement", lineNumber)
+			}
+			current.args += " " + strings.TrimSpace(line)
+			continue
+		}
+		keyword, args, _ := strings.Cut(line, " ")
+		if k, a, ok := strings.Cut(line, "\t"); ok && len(k) < len(keyword) {
+			keyword, args = k, a
+		}
+		statements = append(statements, statement{
+			keyword: strings.ToLower(keyword),
+			args:    strings.TrimSpace(args),
+			line:    lineNumber,
+		})
+		current = &statements[len(statements)-1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return statements, nil
+}
@@ -0,0 +1,465 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2021 The LibreGraph Authors.
+ */
+
+// Package schema implements an LDAP schema with attribute types, object
+// classes, matching rules and syntaxes as defined in RFC 4512. Definitions are
+// loaded from files in the schema file format used by OpenLDAP. A new Schema
+// contains the built-in core, cosine, inetOrgPerson and nis definitions.
+package schema
+
+import (
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+//go:embed builtin/*.schema
+var builtinFS embed.FS
+
+// builtinFiles are the built-in schema files in load order.
+var builtinFiles = []string{
+	"builtin/system.schema",
+	"builtin/core.schema",
+	"builtin/cosine.schema",
+	"builtin/inetorgperson.schema",
+	"builtin/nis.schema",
+}
+
+var (
+	attributeTypeKeywords = map[string]bool{
+		"NAME": true, "DESC": true, "OBSOLETE": true, "SUP": true,
+		"EQUALITY": true, "ORDERING": true, "SUBSTR": true, "SYNTAX": true,
+		"SINGLE-VALUE": true, "COLLECTIVE": true, "NO-USER-MODIFICATION": true,
+		"USAGE": true,
+	}
+	objectClassKeywords = map[string]bool{
+		"NAME": true, "DESC": true, "OBSOLETE": true, "SUP": true,
+		"ABSTRACT": true, "STRUCTURAL": true, "AUXILIARY": true,
+		"MUST": true, "MAY": true,
+	}
+	matchingRuleKeywords = map[string]bool{
+		"NAME": true, "DESC": true, "OBSOLETE": true, "SYNTAX": true,
+	}
+	syntaxKeywords = map[string]bool{
+		"DESC": true,
+	}
+
+	numericOIDRegexp = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+	syntaxRegexp     = regexp.MustCompile(`^([0-9.]+)(?:\{([0-9]+)\})?$`)
+)
+
+// Schema is a set of LDAP schema definitions. Definitions are looked up by
+// name case-insensitively or by OID. A Schema must not be modified once it
+// is used concurrently.
+type Schema struct {
+	syntaxes       map[string]*Syntax
+	matchingRules  map[string]*MatchingRule
+	attributeTypes map[string]*AttributeType
+	objectClasses  map[string]*ObjectClass
+	oidMacros      map[string]string
+	oids           map[string]string
+
+	syntaxList        []*Syntax
+	matchingRuleList  []*MatchingRule
+	attributeTypeList []*AttributeType
+	objectClassList   []*ObjectClass
+}
+
+// New returns a new Schema with the built-in definitions.
+func New() *Schema {
+	s := &Schema{
+		syntaxes:       make(map[string]*Syntax),
+		matchingRules:  make(map[string]*MatchingRule),
+		attributeTypes: make(map[string]*AttributeType),
+		objectClasses:  make(map[string]*ObjectClass),
+		oidMacros:      make(map[string]string),
+		oids:           make(map[string]string),
+	}
+	for _, name := range builtinFiles {
+		f, err := builtinFS.Open(name)
+		if err != nil {
+			panic(err)
+		}
+		err = s.Load(f)
+		f.Close()
+		if err != nil {
+			panic(fmt.Errorf("schema: failed to load built-in %s: %w", name, err))
+		}
+	}
+	return s
+}
+
+// LoadFile loads the definitions of the named schema file into s.
+func (s *Schema) LoadFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = s.Load(f); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// Load loads the definitions read from r into s. Definitions can only refer
+// to definitions which were loaded before. On error, the definitions before
+// the failing one remain loaded.
+func (s *Schema) Load(r io.Reader) error {
+	statements, err := readStatements(r)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range statements {
+		switch stmt.keyword {
+		case "objectidentifier":
+			err = s.addOIDMacro(stmt.args)
+		case "ldapsyntax":
+			err = s.addSyntax(stmt.args)
+		case "matchingrule":
+			err = s.addMatchingRule(stmt.args)
+		case "attributetype":
+			err = s.addAttributeType(stmt.args)
+		case "objectclass":
+			err = s.addObjectClass(stmt.args)
+		default:
+			err = fmt.Errorf("unknown statement %q", stmt.keyword)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", stmt.line, err)
+		}
+	}
+	return nil
+}
+
+// Syntax returns the syntax with the passed OID.
+func (s *Schema) Syntax(oid string) (*Syntax, bool) {
+	syntax, ok := s.syntaxes[oid]
+	return syntax, ok
+}
+
+// MatchingRule returns the matching rule with the passed name or OID.
+func (s *Schema) MatchingRule(name string) (*MatchingRule, bool) {
+	m, ok := s.matchingRules[strings.ToLower(name)]
+	return m, ok
+}
+
+// AttributeType returns the attribute type with the passed name or OID.
+// Attribute options like ";binary" are ignored.
+func (s *Schema) AttributeType(name string) (*AttributeType, bool) {
+	name, _, _ = strings.Cut(name, ";")
+	a, ok := s.attributeTypes[strings.ToLower(name)]
+	return a, ok
+}
+
+// ObjectClass returns the object class with the passed name or OID.
+func (s *Schema) ObjectClass(name string) (*ObjectClass, bool) {
+	o, ok := s.objectClasses[strings.ToLower(name)]
+	return o, ok
+}
+
+// Syntaxes returns all syntaxes in definition order.
+func (s *Schema) Syntaxes() []*Syntax {
+	return append([]*Syntax{}, s.syntaxList...)
+}
+
+// MatchingRules returns all matching rules in definition order.
+func (s *Schema) MatchingRules() []*MatchingRule {
+	return append([]*MatchingRule{}, s.matchingRuleList...)
+}
+
+// AttributeTypes returns all attribute types in definition order.
+func (s *Schema) AttributeTypes() []*AttributeType {
+	return append([]*AttributeType{}, s.attributeTypeList...)
+}
+
+// ObjectClasses returns all object classes in definition order.
+func (s *Schema) ObjectClasses() []*ObjectClass {
+	return append([]*ObjectClass{}, s.objectClassList...)
+}
+
+// Entry returns the subschema subentry (RFC 4512 4.2) of s with the passed DN.
+func (s *Schema) Entry(dn string) *ldap.Entry {
+	attributes := map[string][]string{
+		"objectClass": {"top", "subschema", "extensibleObject"},
+	}
+	if parsed, err := ldap.ParseDN(dn); err == nil && len(parsed.RDNs) > 0 {
+		for _, ava := range parsed.RDNs[0].Attributes {
+			attributes[ava.Type] = append(attributes[ava.Type], ava.Value)
+		}
+	}
+	for _, syntax := range s.syntaxList {
+		attributes["ldapSyntaxes"] = append(attributes["ldapSyntaxes"], syntax.String())
+	}
+	for _, m := range s.matchingRuleList {
+		attributes["matchingRules"] = append(attributes["matchingRules"], m.String())
+	}
+	for _, a := range s.attributeTypeList {
+		attributes["attributeTypes"] = append(attributes["attributeTypes"], a.String())
+	}
+	for _, o := range s.objectClassList {
+		attributes["objectClasses"] = append(attributes["objectClasses"], o.String())
+	}
+	return ldap.NewEntry(dn, attributes)
+}
+
+func (s *Schema) addOIDMacro(args string) error {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		return fmt.Errorf("objectidentifier requires a name and an OID")
+	}
+	name := strings.ToLower(fields[0])
+	if _, exists := s.oidMacros[name]; exists {
+		return fmt.Errorf("duplicate objectidentifier %s", fields[0])
+	}
+	oid, err := s.expandOID(fields[1])
+	if err != nil {
+		return err
+	}
+	s.oidMacros[name] = oid
+	return nil
+}
+
+// expandOID expands OID macros of the form "name" or "name:suffix" and
+// returns the numeric OID.
+func (s *Schema) expandOID(oid string) (string, error) {
+	if numericOIDRegexp.MatchString(oid) {
+		return oid, nil
+	}
+	name, suffix, hasSuffix := strings.Cut(oid, ":")
+	prefix, ok := s.oidMacros[strings.ToLower(name)]
+	if !ok {
+		return "", fmt.Errorf("invalid OID %s", oid)
+	}
+	if hasSuffix {
+		if !numericOIDRegexp.MatchString(suffix) {
+			return "", fmt.Errorf("invalid OID %s", oid)
+		}
+		return prefix + "." + suffix, nil
+	}
+	return prefix, nil
+}
+
+func (s *Schema) parse(args string, keywords map[string]bool) (*definition, error) {
+	d, err := parseDefinition(args, keywords)
+	if err != nil {
+		return nil, err
+	}
+	if d.oid, err = s.expandOID(d.oid); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (s *Schema) addSyntax(args string) error {
+	d, err := s.parse(args, syntaxKeywords)
+	if err != nil {
+		return err
+	}
+	if err = s.checkOID(d, "syntax"); err != nil {
+		return err
+	}
+	syntax := &Syntax{
+		OID:         d.oid,
+		Description: d.first("DESC"),
+		def:         d,
+	}
+	s.syntaxes[d.oid] = syntax
+	s.oids[d.oid] = "syntax " + d.oid
+	s.syntaxList = append(s.syntaxList, syntax)
+	return nil
+}
+
+func (s *Schema) addMatchingRule(args string) error {
+	d, err := s.parse(args, matchingRuleKeywords)
+	if err != nil {
+		return err
+	}
+	if err = s.checkOID(d, "matching rule"); err != nil {
+		return err
+	}
+	if err = checkNames(d, s.matchingRules); err != nil {
+		return err
+	}
+	syntax, ok := s.syntaxes[d.first("SYNTAX")]
+	if !ok {
+		return fmt.Errorf("unknown syntax %q in matching rule %s", d.first("SYNTAX"), d.oid)
+	}
+	m := &MatchingRule{
+		OID:         d.oid,
+		Names:       d.values["NAME"],
+		Description: d.first("DESC"),
+		Obsolete:    d.has("OBSOLETE"),
+		Syntax:      syntax,
+		def:         d,
+	}
+	addNames(s.matchingRules, d, m)
+	s.oids[d.oid] = "matching rule " + primaryName(d.values["NAME"], d.oid)
+	s.matchingRuleList = append(s.matchingRuleList, m)
+	return nil
+}
+
+func (s *Schema) addAttributeType(args string) error {
+	d, err := s.parse(args, attributeTypeKeywords)
+	if err != nil {
+		return err
+	}
+	if err = s.checkOID(d, "attribute type"); err != nil {
+		return err
+	}
+	if err = checkNames(d, s.attributeTypes); err != nil {
+		return err
+	}
+	a := &AttributeType{
+		OID:                d.oid,
+		Names:              d.values["NAME"],
+		Description:        d.first("DESC"),
+		Obsolete:           d.has("OBSOLETE"),
+		SingleValue:        d.has("SINGLE-VALUE"),
+		Collective:         d.has("COLLECTIVE"),
+		NoUserModification: d.has("NO-USER-MODIFICATION"),
+		def:                d,
+	}
+
+	if d.has("SUP") {
+		sup, ok := s.AttributeType(d.first("SUP"))
+		if !ok {
+			return fmt.Errorf("unknown superior %q of attribute type %s", d.first("SUP"), d.oid)
+		}
+		a.Superior = sup
+		a.Equality = sup.Equality
+		a.Ordering = sup.Ordering
+		a.Substring = sup.Substring
+		a.Syntax = sup.Syntax
+		a.SyntaxLength = sup.SyntaxLength
+		a.Usage = sup.Usage
+	}
+	for key, rule := range map[string]**MatchingRule{
+		"EQUALITY": &a.Equality,
+		"ORDERING": &a.Ordering,
+		"SUBSTR":   &a.Substring,
+	} {
+		if !d.has(key) {
+			continue
+		}
+		m, ok := s.MatchingRule(d.first(key))
+		if !ok {
+			return fmt.Errorf("unknown %s matching rule %q in attribute type %s", strings.ToLower(key), d.first(key), d.oid)
+		}
+		*rule = m
+	}
+	if d.has("SYNTAX") {
+		match := syntaxRegexp.FindStringSubmatch(d.first("SYNTAX"))
+		if match == nil {
+			return fmt.Errorf("invalid syntax %q in attribute type %s", d.first("SYNTAX"), d.oid)
+		}
+		syntax, ok := s.syntaxes[match[1]]
+		if !ok {
+			return fmt.Errorf("unknown syntax %q in attribute type %s", match[1], d.oid)
+		}
+		a.Syntax = syntax
+		a.SyntaxLength = 0
+		if match[2] != "" {
+			a.SyntaxLength, _ = strconv.Atoi(match[2])
+		}
+	}
+	if a.Syntax == nil {
+		return fmt.Errorf("attribute type %s has neither a syntax nor a superior", d.oid)
+	}
+	if d.has("USAGE") {
+		usage, ok := usageNames[strings.ToLower(d.first("USAGE"))]
+		if !ok {
+			return fmt.Errorf("invalid usage %q in attribute type %s", d.first("USAGE"), d.oid)
+		}
+		a.Usage = usage
+	}
+
+	addNames(s.attributeTypes, d, a)
+	s.oids[d.oid] = "attribute type " + primaryName(d.values["NAME"], d.oid)
+	s.attributeTypeList = append(s.attributeTypeList, a)
+	return nil
+}
+
+func (s *Schema) addObjectClass(args string) error {
+	d, err := s.parse(args, objectClassKeywords)
+	if err != nil {
+		return err
+	}
+	if err = s.checkOID(d, "object class"); err != nil {
+		return err
+	}
+	if err = checkNames(d, s.objectClasses); err != nil {
+		return err
+	}
+	o := &ObjectClass{
+		OID:         d.oid,
+		Names:       d.values["NAME"],
+		Description: d.first("DESC"),
+		Obsolete:    d.has("OBSOLETE"),
+		def:         d,
+	}
+	switch {
+	case d.has("ABSTRACT"):
+		o.Kind = Abstract
+	case d.has("AUXILIARY"):
+		o.Kind = Auxiliary
+	}
+	for _, name := range d.values["SUP"] {
+		sup, ok := s.ObjectClass(name)
+		if !ok {
+			return fmt.Errorf("unknown superior %q of object class %s", name, d.oid)
+		}
+		o.Superiors = append(o.Superiors, sup)
+	}
+	for key, attributes := range map[string]*[]*AttributeType{
+		"MUST": &o.Must,
+		"MAY":  &o.May,
+	} {
+		for _, name := range d.values[key] {
+			a, ok := s.AttributeType(name)
+			if !ok {
+				return fmt.Errorf("unknown attribute type %q in object class %s", name, d.oid)
+			}
+			*attributes = append(*attributes, a)
+		}
+	}
+
+	addNames(s.objectClasses, d, o)
+	s.oids[d.oid] = "object class " + primaryName(d.values["NAME"], d.oid)
+	s.objectClassList = append(s.objectClassList, o)
+	return nil
+}
+
+// checkOID returns an error if the OID of d is already used by another
+// definition of any kind.
+func (s *Schema) checkOID(d *definition, kind string) error {
+	if other, exists := s.oids[d.oid]; exists {
+		return fmt.Errorf("OID %s of %s %s is already used by %s", d.oid, kind, primaryName(d.values["NAME"], d.oid), other)
+	}
+	return nil
+}
+
+// checkNames returns an error if one of the names of d is already used in m.
+func checkNames[T any](d *definition, m map[string]T) error {
+	for _, name := range d.values["NAME"] {
+		if _, exists := m[strings.ToLower(name)]; exists {
+			return fmt.Errorf("duplicate name %s", name)
+		}
+	}
+	return nil
+}
+
+func addNames[T any](m map[string]T, d *definition, v T) {
+	m[d.oid] = v
+	for _, name := range d.values["NAME"] {
+		m[strings.ToLower(name)] = v
+	}
+}
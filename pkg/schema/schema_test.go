@@ -0,0 +1,227 @@
+package schema
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinSchema(t *testing.T) {
+	s := New()
+
+	cn, ok := s.AttributeType("commonName")
+	if !ok {
+		t.Fatalf("commonName not found")
+	}
+	if cn.Name() != "cn" || cn.OID != "2.5.4.3" {
+		t.Errorf("Unexpected commonName attribute type: %s %s", cn.Name(), cn.OID)
+	}
+	if cn.Equality == nil || cn.Equality.Name() != "caseIgnoreMatch" {
+		t.Errorf("Expected cn to inherit caseIgnoreMatch from name")
+	}
+	if cn.Syntax == nil || cn.Syntax.OID != "1.3.6.1.4.1.1466.115.121.1.15" || cn.SyntaxLength != 32768 {
+		t.Errorf("Expected cn to inherit the syntax from name")
+	}
+	if a, ok := s.AttributeType("CN;lang-de"); !ok || a != cn {
+		t.Errorf("Expected lookup by name with options to find cn")
+	}
+	if a, ok := s.AttributeType("2.5.4.3"); !ok || a != cn {
+		t.Errorf("Expected lookup by OID to find cn")
+	}
+
+	if a, _ := s.AttributeType("createTimestamp"); a == nil || !a.IsOperational() || !a.NoUserModification || !a.SingleValue {
+		t.Errorf("Expected createTimestamp to be a single valued operational attribute")
+	}
+
+	person, ok := s.ObjectClass("inetorgperson")
+	if !ok {
+		t.Fatalf("inetOrgPerson not found")
+	}
+	if person.Kind != Structural {
+		t.Errorf("Expected inetOrgPerson to be structural")
+	}
+	top, _ := s.ObjectClass("top")
+	if !person.IsSubclassOf(top) || top.Kind != Abstract {
+		t.Errorf("Expected inetOrgPerson to be derived from the abstract class top")
+	}
+	required := []string{}
+	for _, a := range person.RequiredAttributes() {
+		required = append(required, a.Name())
+	}
+	if strings.Join(required, ",") != "sn,cn,objectClass" {
+		t.Errorf("Unexpected required attributes of inetOrgPerson: %v", required)
+	}
+	allowed := map[string]bool{}
+	for _, a := range person.AllowedAttributes() {
+		allowed[a.Name()] = true
+	}
+	for _, name := range []string{"mail", "uid", "title", "telephoneNumber"} {
+		if !allowed[name] {
+			t.Errorf("Expected %s to be allowed for inetOrgPerson", name)
+		}
+	}
+
+	if posix, _ := s.ObjectClass("posixAccount"); posix == nil || posix.Kind != Auxiliary {
+		t.Errorf("Expected posixAccount to be auxiliary")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	s := New()
+	err := s.Load(strings.NewReader(`
+# Example schema
+objectidentifier exampleOID 1.3.6.1.4.1.99999
+objectidentifier exampleAttrs exampleOID:1
+
+attributetype ( exampleAttrs:1 NAME 'exampleName'
+	DESC 'An example'
+	SUP name
+	X-ORIGIN 'test' )
+
+objectclass ( exampleOID:2.1 NAME 'exampleObject'
+	SUP top AUXILIARY
+	MUST exampleName
+	MAY ( mail $ description ) )
+`))
+	if err != nil {
+		t.Fatalf("Load failed: %s", err)
+	}
+	a, ok := s.AttributeType("examplename")
+	if !ok || a.OID != "1.3.6.1.4.1.99999.1.1" {
+		t.Fatalf("Unexpected example attribute type: %v", a)
+	}
+	if a.Superior == nil || a.Superior.Name() != "name" {
+		t.Errorf("Expected exampleName to be derived from name")
+	}
+	if got := a.String(); got != "( 1.3.6.1.4.1.99999.1.1 NAME 'exampleName' DESC 'An example' SUP name X-ORIGIN 'test' )" {
+		t.Errorf("Unexpected attribute type description: %s", got)
+	}
+	o, ok := s.ObjectClass("exampleObject")
+	if !ok || o.OID != "1.3.6.1.4.1.99999.2.1" || len(o.May) != 2 {
+		t.Fatalf("Unexpected example object class: %v", o)
+	}
+	if got := o.String(); got != "( 1.3.6.1.4.1.99999.2.1 NAME 'exampleObject' SUP top AUXILIARY MUST exampleName MAY ( mail $ description ) )" {
+		t.Errorf("Unexpected object class description: %s", got)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, def := range []string{
+		"attributetype ( 1.2.3.4 NAME 'x' )",
+		"attributetype ( 1.2.3.4 NAME 'x' SYNTAX 1.2.3 )",
+		"attributetype ( 1.2.3.4 NAME 'x' SUP unknown )",
+		"attributetype ( 1.2.3.4 NAME 'x' SUP name FOO bar )",
+		"attributetype ( 2.5.4.3 NAME 'x' SUP name )",
+		"attributetype ( 1.2.3.4 NAME 'cn' SUP name )",
+		"attributetype ( 1.2.3.4 NAME 'x' SUP name",
+		"objectclass ( 2.5.4.3 NAME 'x' )",
+		"objectclass ( 1.2.3.4 NAME 'x' MUST unknown )",
+		"objectclass ( 1.2.3.4 NAME 'x' SUP unknown )",
+		"objectclass ( unknownOID:1 NAME 'x' )",
+		"unknown ( 1.2.3.4 )",
+	} {
+		if err := New().Load(strings.NewReader(def)); err == nil {
+			t.Errorf("Expected error for %q", def)
+		}
+	}
+}
+
+func TestLoadConflictingOID(t *testing.T) {
+	err := New().Load(strings.NewReader("objectclass ( 2.5.4.3 NAME 'example' SUP top AUXILIARY )"))
+	if err == nil {
+		t.Fatalf("Expected error for conflicting OID")
+	}
+	if got := err.Error(); got != "line 1: OID 2.5.4.3 of object class example is already used by attribute type cn" {
+		t.Errorf("Unexpected error: %s", got)
+	}
+}
+
+func TestLoadLDIF(t *testing.T) {
+	s := New()
+	err := s.LoadLDIF(strings.NewReader(`dn: cn=openssh-lpk,cn=schema,cn=config
+objectClass: olcSchemaConfig
+cn: openssh-lpk
+olcObjectIdentifier: {0}sshOID 1.3.6.1.4.1.24552.500.1.1
+olcAttributeTypes: {0}( sshOID:1.13 NAME 'sshPublicKey' DESC 'MANDATORY: O
+ penSSH Public key' EQUALITY octetStringMatch SYNTAX 1.3.6.1.4.1.1466.115.121
+ .1.40 )
+olcObjectClasses: {0}( sshOID:2.0 NAME 'ldapPublicKey' DESC 'MANDATORY: Op
+ enSSH LPK objectclass' SUP top AUXILIARY MUST uid MAY sshPublicKey )
+`))
+	if err != nil {
+		t.Fatalf("LoadLDIF failed: %s", err)
+	}
+	a, ok := s.AttributeType("sshPublicKey")
+	if !ok || a.OID != "1.3.6.1.4.1.24552.500.1.1.1.13" || a.Description != "MANDATORY: OpenSSH Public key" {
+		t.Fatalf("Unexpected sshPublicKey attribute type: %v", a)
+	}
+	o, ok := s.ObjectClass("ldapPublicKey")
+	if !ok || o.Kind != Auxiliary || len(o.May) != 1 || o.May[0] != a {
+		t.Fatalf("Unexpected ldapPublicKey object class: %v", o)
+	}
+
+	err = New().LoadLDIF(strings.NewReader(`dn: cn=broken,cn=schema,cn=config
+olcAttributeTypes: {0}( 1.2.3.4 NAME 'broken' SUP unknown )
+`))
+	if err == nil || !strings.HasPrefix(err.Error(), "cn=broken,cn=schema,cn=config: olcAttributeTypes: ") {
+		t.Errorf("Unexpected error for broken LDIF: %v", err)
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"10-base.schema": "attributetype ( 1.3.6.1.4.1.99999.1 NAME 'exampleQuota' SUP name )\n",
+		"20-more.ldif":   "dn: cn=more,cn=schema,cn=config\nolcObjectClasses: ( 1.3.6.1.4.1.99999.2 NAME 'exampleMailbox' SUP top AUXILIARY MAY exampleQuota )\n",
+		"README":         "not a schema file",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := New()
+	if err := s.LoadDir(dir); err != nil {
+		t.Fatalf("LoadDir failed: %s", err)
+	}
+	if _, ok := s.ObjectClass("exampleMailbox"); !ok {
+		t.Errorf("Expected exampleMailbox to be loaded")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "30-conflict.schema"), []byte("attributetype ( 1.3.6.1.4.1.99999.2 NAME 'exampleOther' SUP name )\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err := New().LoadDir(dir)
+	if err == nil || !strings.Contains(err.Error(), "30-conflict.schema: line 1: OID 1.3.6.1.4.1.99999.2 of attribute type exampleOther is already used by object class exampleMailbox") {
+		t.Errorf("Unexpected error for conflicting OID: %v", err)
+	}
+}
+
+func TestParseDefinition(t *testing.T) {
+	d, err := parseDefinition(`( 1.2.3 NAME ( 'a' 'b' ) DESC 'with \27quote\27 and \5C' SINGLE-VALUE X-ORIGIN ( 'x' 'y' ) )`, attributeTypeKeywords)
+	if err != nil {
+		t.Fatalf("parseDefinition failed: %s", err)
+	}
+	if d.oid != "1.2.3" || len(d.values["NAME"]) != 2 || d.first("DESC") != `with 'quote' and \` || !d.has("SINGLE-VALUE") {
+		t.Errorf("Unexpected definition: %#v", d)
+	}
+	if got := d.String(); got != `( 1.2.3 NAME ( 'a' 'b' ) DESC 'with \27quote\27 and \5C' SINGLE-VALUE X-ORIGIN ( 'x' 'y' ) )` {
+		t.Errorf("Unexpected formatted definition: %s", got)
+	}
+}
+
+func TestEntry(t *testing.T) {
+	s := New()
+	entry := s.Entry("cn=Subschema")
+	if entry.GetAttributeValue("cn") != "Subschema" {
+		t.Errorf("Expected cn of subschema entry")
+	}
+	for _, name := range []string{"attributeTypes", "objectClasses", "ldapSyntaxes", "matchingRules"} {
+		if len(entry.GetAttributeValues(name)) == 0 {
+			t.Errorf("Expected %s values in subschema entry", name)
+		}
+	}
+	if got := len(entry.GetAttributeValues("attributeTypes")); got != len(s.AttributeTypes()) {
+		t.Errorf("Expected %d attributeTypes, got %d", len(s.AttributeTypes()), got)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2021 The LibreGraph Authors.
+ */
+
+package schema
+
+import (
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	integerRegexp         = regexp.MustCompile(`^(0|-?[1-9][0-9]*)$`)
+	numericStringRegexp   = regexp.MustCompile(`^[0-9 ]+$`)
+	printableStringRegexp = regexp.MustCompile(`^[A-Za-z0-9'()+,\-./:?= ]+$`)
+	oidRegexp             = regexp.MustCompile(`^([0-9]+(\.[0-9]+)+|[A-Za-z][A-Za-z0-9-]*)$`)
+	uuidRegexp            = regexp.MustCompile(`^[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}$`)
+
+	// generalizedTimeRegexp matches the GeneralizedTime syntax of RFC 4517
+	// 3.3.13, capturing year, month, day, hour, minute, second, fraction and
+	// time zone.
+	generalizedTimeRegexp = regexp.MustCompile(`^([0-9]{4})([0-9]{2})([0-9]{2})([0-9]{2})(?:([0-9]{2})([0-9]{2})?)?(?:[.,]([0-9]+))?(Z|[+-][0-9]{2}(?:[0-9]{2})?)$`)
+)
+
+// syntaxValidators are the value checks for the syntaxes of RFC 4517 which
+// are validated. Values of other syntaxes are always accepted.
+var syntaxValidators = map[string]func(string) bool{
+	"1.3.6.1.4.1.1466.115.121.1.7":  validBoolean,
+	"1.3.6.1.4.1.1466.115.121.1.11": validCountryString,
+	"1.3.6.1.4.1.1466.115.121.1.12": validDN,
+	"1.3.6.1.4.1.1466.115.121.1.15": validDirectoryString,
+	"1.3.6.1.4.1.1466.115.121.1.24": validGeneralizedTime,
+	"1.3.6.1.4.1.1466.115.121.1.26": validIA5String,
+	"1.3.6.1.4.1.1466.115.121.1.27": integerRegexp.MatchString,
+	"1.3.6.1.4.1.1466.115.121.1.36": numericStringRegexp.MatchString,
+	"1.3.6.1.4.1.1466.115.121.1.38": oidRegexp.MatchString,
+	"1.3.6.1.4.1.1466.115.121.1.44": printableStringRegexp.MatchString,
+	"1.3.6.1.1.16.1":                uuidRegexp.MatchString,
+}
+
+// Validate returns true if value is valid for s. Values of syntaxes without
+// a known validation are always valid.
+func (s *Syntax) Validate(value string) bool {
+	if validate, ok := syntaxValidators[s.OID]; ok {
+		return validate(value)
+	}
+	return true
+}
+
+func validBoolean(value string) bool {
+	return value == "TRUE" || value == "FALSE"
+}
+
+func validCountryString(value string) bool {
+	return len(value) == 2 && printableStringRegexp.MatchString(value)
+}
+
+func validDN(value string) bool {
+	_, err := ldap.ParseDN(value)
+	return err == nil
+}
+
+func validDirectoryString(value string) bool {
+	return value != "" && utf8.ValidString(value)
+}
+
+func validIA5String(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] > 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func validGeneralizedTime(value string) bool {
+	match := generalizedTimeRegexp.FindStringSubmatch(value)
+	if match == nil {
+		return false
+	}
+	for i, limit := range []struct{ min, max int }{{1, 12}, {1, 31}, {0, 23}, {0, 59}, {0, 60}} {
+		if match[i+2] == "" {
+			continue
+		}
+		n, _ := strconv.Atoi(match[i+2])
+		if n < limit.min || n > limit.max {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,222 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2021 The LibreGraph Authors.
+ */
+
+package schema
+
+import (
+	"strings"
+)
+
+// Usage is the usage of an attribute type as defined in RFC 4512 4.1.2.
+type Usage int
+
+// Attribute type usages.
+const (
+	UserApplications Usage = iota
+	DirectoryOperation
+	DistributedOperation
+	DSAOperation
+)
+
+var usageNames = map[string]Usage{
+	"userapplications":     UserApplications,
+	"directoryoperation":   DirectoryOperation,
+	"distributedoperation": DistributedOperation,
+	"dsaoperation":         DSAOperation,
+}
+
+// ObjectClassKind is the kind of an object class as defined in RFC 4512 4.1.1.
+type ObjectClassKind int
+
+// Object class kinds.
+const (
+	Structural ObjectClassKind = iota
+	Abstract
+	Auxiliary
+)
+
+// Syntax is an LDAP syntax (RFC 4512 4.1.5).
+type Syntax struct {
+	OID         string
+	Description string
+
+	def *definition
+}
+
+// String returns the LDAPSyntaxDescription of s.
+func (s *Syntax) String() string {
+	return s.def.String()
+}
+
+// MatchingRule is a matching rule (RFC 4512 4.1.3).
+type MatchingRule struct {
+	OID         string
+	Names       []string
+	Description string
+	Obsolete    bool
+	Syntax      *Syntax
+
+	def *definition
+}
+
+// Name returns the primary name of m or its OID if it has no name.
+func (m *MatchingRule) Name() string {
+	return primaryName(m.Names, m.OID)
+}
+
+// HasName returns true if name is one of the names or the OID of m.
+func (m *MatchingRule) HasName(name string) bool {
+	return hasName(m.Names, m.OID, name)
+}
+
+// String returns the MatchingRuleDescription of m.
+func (m *MatchingRule) String() string {
+	return m.def.String()
+}
+
+// AttributeType is an attribute type (RFC 4512 4.1.2). The matching rules and
+// the syntax are inherited from the superior type if not defined by the type
+// itself.
+type AttributeType struct {
+	OID                string
+	Names              []string
+	Description        string
+	Obsolete           bool
+	Superior           *AttributeType
+	Equality           *MatchingRule
+	Ordering           *MatchingRule
+	Substring          *MatchingRule
+	Syntax             *Syntax
+	SyntaxLength       int
+	SingleValue        bool
+	Collective         bool
+	NoUserModification bool
+	Usage              Usage
+
+	def *definition
+}
+
+// Name returns the primary name of a or its OID if it has no name.
+func (a *AttributeType) Name() string {
+	return primaryName(a.Names, a.OID)
+}
+
+// HasName returns true if name is one of the names or the OID of a.
+func (a *AttributeType) HasName(name string) bool {
+	return hasName(a.Names, a.OID, name)
+}
+
+// IsOperational returns true if a is an operational attribute type.
+func (a *AttributeType) IsOperational() bool {
+	return a.Usage != UserApplications
+}
+
+// IsSubtypeOf returns true if a is other or derived from other.
+func (a *AttributeType) IsSubtypeOf(other *AttributeType) bool {
+	for t := a; t != nil; t = t.Superior {
+		if t == other {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the AttributeTypeDescription of a.
+func (a *AttributeType) String() string {
+	return a.def.String()
+}
+
+// ObjectClass is an object class (RFC 4512 4.1.1).
+type ObjectClass struct {
+	OID         string
+	Names       []string
+	Description string
+	Obsolete    bool
+	Superiors   []*ObjectClass
+	Kind        ObjectClassKind
+	Must        []*AttributeType
+	May         []*AttributeType
+
+	def *definition
+}
+
+// Name returns the primary name of o or its OID if it has no name.
+func (o *ObjectClass) Name() string {
+	return primaryName(o.Names, o.OID)
+}
+
+// HasName returns true if name is one of the names or the OID of o.
+func (o *ObjectClass) HasName(name string) bool {
+	return hasName(o.Names, o.OID, name)
+}
+
+// IsSubclassOf returns true if o is other or derived from other.
+func (o *ObjectClass) IsSubclassOf(other *ObjectClass) bool {
+	if o == other {
+		return true
+	}
+	for _, sup := range o.Superiors {
+		if sup.IsSubclassOf(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiredAttributes returns the attribute types which are required by o
+// including the ones required by its superclasses.
+func (o *ObjectClass) RequiredAttributes() []*AttributeType {
+	return o.collect(func(c *ObjectClass) []*AttributeType { return c.Must })
+}
+
+// AllowedAttributes returns the attribute types which are allowed by o
+// including the ones allowed by its superclasses. Required attributes are
+// not included.
+func (o *ObjectClass) AllowedAttributes() []*AttributeType {
+	return o.collect(func(c *ObjectClass) []*AttributeType { return c.May })
+}
+
+func (o *ObjectClass) collect(attributes func(*ObjectClass) []*AttributeType) []*AttributeType {
+	seen := map[*AttributeType]bool{}
+	result := []*AttributeType{}
+	var walk func(*ObjectClass)
+	walk = func(c *ObjectClass) {
+		for _, a := range attributes(c) {
+			if !seen[a] {
+				seen[a] = true
+				result = append(result, a)
+			}
+		}
+		for _, sup := range c.Superiors {
+			walk(sup)
+		}
+	}
+	walk(o)
+	return result
+}
+
+// String returns the ObjectClassDescription of o.
+func (o *ObjectClass) String() string {
+	return o.def.String()
+}
+
+func primaryName(names []string, oid string) string {
+	if len(names) > 0 {
+		return names[0]
+	}
+	return oid
+}
+
+func hasName(names []string, oid string, name string) bool {
+	if name == oid {
+		return true
+	}
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
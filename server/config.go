@@ -19,15 +19,28 @@ type Config struct {
 	LDAPListenAddr  string
 	LDAPSListenAddr string
 
-	TLSCertFile string
-	TLSKeyFile  string
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+
+	LDAPRequireTLSForBind bool
+
+	LDAPMaxConcurrentOperations int
+
+	SASLExternalMapping   string
+	SASLUsernameAttribute string
+
+	LDAPProxyAuthzGroup string
 
 	LDAPBaseDN  string
 	LDAPAdminDN string
 
 	LDAPAllowLocalAnonymousBind bool
 
-	BoltDBFile string
+	SchemaDir string
+
+	BoltDBFile        string
+	BoltDBSchemaCheck bool
 
 	LDIFMain   string
 	LDIFConfig string
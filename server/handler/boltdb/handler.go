@@ -6,14 +6,19 @@
 package boltdb
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"net"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/go-ldap/ldap/v3"
+	"github.com/longsleep/rndm"
+	cmap "github.com/orcaman/concurrent-map"
 	"github.com/sirupsen/logrus"
 
 	"github.com/libregraph/idm/pkg/ldapdn"
@@ -21,6 +26,7 @@ import (
 	"github.com/libregraph/idm/pkg/ldappassword"
 	"github.com/libregraph/idm/pkg/ldapserver"
 	"github.com/libregraph/idm/pkg/ldbbolt"
+	"github.com/libregraph/idm/pkg/schema"
 	"github.com/libregraph/idm/server/handler"
 )
 
@@ -32,6 +38,40 @@ type boltdbHandler struct {
 	allowLocalAnonymousBind bool
 	ctx                     context.Context
 	bdb                     *ldbbolt.LdbBolt
+	schema                  func() *schema.Schema
+	schemaCheck             bool
+
+	activeSearchPagings cmap.ConcurrentMap
+
+	// changes passes the changes of the database to the persistent content
+	// synchronization searches.
+	changes *ldapserver.SyncChanges
+}
+
+// searchPagingTimeout is the time after which a paged search expires if the
+// next page is not requested.
+var searchPagingTimeout = 1 * time.Minute
+
+// searchPaging is a paged search, which is continued by the requests for the
+// following pages. It holds the ids of the remaining entries, which were
+// selected when the search started, together with the connection, identity
+// and request it was started with.
+type searchPaging struct {
+	ids   []uint64
+	timer *time.Timer
+
+	conn    net.Conn
+	boundDN string
+	baseDN  string
+	scope   int
+	filter  string
+}
+
+// continues returns true if req of boundDN on conn continues the paged search.
+// The requests for the following pages must be the same as the first one
+// (RFC 2696 3).
+func (p *searchPaging) continues(req *ldap.SearchRequest, boundDN string, conn net.Conn) bool {
+	return p.conn == conn && p.boundDN == boundDN && p.baseDN == req.BaseDN && p.scope == req.Scope && p.filter == req.Filter
 }
 
 type Options struct {
@@ -39,6 +79,14 @@ type Options struct {
 	AdminDN string
 
 	AllowLocalAnonymousBind bool
+
+	// Schema returns the schema, which is used to sort search results and
+	// to check added and modified entries. The built-in schema is used if
+	// Schema is nil.
+	Schema func() *schema.Schema
+	// SchemaCheck enables the check of added and modified entries against
+	// the schema.
+	SchemaCheck bool
 }
 
 func NewBoltDBHandler(logger logrus.FieldLogger, fn string, options *Options) (handler.Handler, error) {
@@ -60,6 +108,18 @@ func NewBoltDBHandler(logger logrus.FieldLogger, fn string, options *Options) (h
 
 		allowLocalAnonymousBind: options.AllowLocalAnonymousBind,
 		ctx:                     context.Background(),
+		schema:                  options.Schema,
+		schemaCheck:             options.SchemaCheck,
+
+		activeSearchPagings: cmap.New(),
+
+		changes: &ldapserver.SyncChanges{},
+	}
+	if h.schema == nil {
+		builtin := schema.New()
+		h.schema = func() *schema.Schema {
+			return builtin
+		}
 	}
 	if h.baseDN, err = ldapdn.ParseNormalize(options.BaseDN); err != nil {
 		return nil, err
@@ -85,14 +145,19 @@ func (h *boltdbHandler) setup() error {
 	if err := bdb.Initialize(); err != nil {
 		return err
 	}
+	if h.schemaCheck {
+		bdb.SetEntryCheck(h.checkEntry)
+	}
+	bdb.SetChangeFunc(h.publishChange)
 	h.bdb = bdb
 	return nil
 }
 
-func (h *boltdbHandler) Add(boundDN string, req *ldap.AddRequest, conn net.Conn) (ldapserver.LDAPResultCode, error) {
+func (h *boltdbHandler) Add(ctx context.Context, boundDN string, req *ldap.AddRequest, conn net.Conn) (ldapserver.LDAPResultCode, error) {
 	logger := h.logger.WithFields(logrus.Fields{
 		"op":          "add",
 		"bind_dn":     boundDN,
+		"authc_dn":    ldapserver.AuthenticatedDN(ctx, boundDN),
 		"remote_addr": conn.RemoteAddr().String(),
 	})
 
@@ -101,14 +166,18 @@ func (h *boltdbHandler) Add(boundDN string, req *ldap.AddRequest, conn net.Conn)
 	}
 
 	e := ldapentry.EntryFromAddRequest(req)
+	for _, attr := range req.Attributes {
+		if err := h.checkUserModification(attr.Type); err != nil {
+			return ldap.LDAPResultConstraintViolation, err
+		}
+	}
 
-	if err := h.bdb.EntryPut(e); err != nil {
+	w := h.newWriteControls(req.Controls)
+	if err := h.bdb.EntryPut(e, boundDN, w.check); err != nil {
 		logger.WithError(err).WithField("entrydn", e.DN).Debugln("ldap add failed")
-		if errors.Is(err, ldbbolt.ErrEntryAlreadyExists) {
-			return ldap.LDAPResultEntryAlreadyExists, nil
-		}
-		return ldap.LDAPResultUnwillingToPerform, err
+		return writeResult(err)
 	}
+	w.addResponseControls(ctx)
 	return ldap.LDAPResultSuccess, nil
 }
 
@@ -174,10 +243,46 @@ func (h *boltdbHandler) validatePassword(logger logrus.FieldLogger, bindDN, bind
 	return ldap.LDAPResultInvalidCredentials, nil
 }
 
-func (h *boltdbHandler) Delete(boundDN string, req *ldap.DelRequest, conn net.Conn) (ldapserver.LDAPResultCode, error) {
+func (h *boltdbHandler) Compare(ctx context.Context, boundDN string, req *ldap.CompareRequest, conn net.Conn) (ldapserver.LDAPResultCode, error) {
+	logger := h.logger.WithFields(logrus.Fields{
+		"op":          "compare",
+		"bind_dn":     boundDN,
+		"authc_dn":    ldapserver.AuthenticatedDN(ctx, boundDN),
+		"entrydn":     req.DN,
+		"attribute":   req.Attribute,
+		"remote_addr": conn.RemoteAddr().String(),
+	})
+
+	// Only the entry itself and the admin may compare passwords, otherwise
+	// clients could guess passwords without failing any binds.
+	if strings.EqualFold(req.Attribute, "userPassword") {
+		targetDN, err := ldapdn.ParseNormalize(req.DN)
+		if boundDN == "" || err != nil || (boundDN != targetDN && !h.writeAllowed(boundDN)) {
+			return ldap.LDAPResultInsufficientAccessRights, nil
+		}
+	}
+
+	entries, err := h.bdb.Search(req.DN, ldap.ScopeBaseObject)
+	if err != nil || len(entries) != 1 {
+		logger.WithError(err).Debugln("ldap compare entry lookup failed")
+		if errors.Is(err, ldbbolt.ErrEntryNotFound) {
+			return ldap.LDAPResultNoSuchObject, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("unexpected number of entries for '%s'", req.DN)
+		}
+		return ldap.LDAPResultOperationsError, err
+	}
+	resultCode := ldapserver.ServerCompareEntry(h.withOperationalAttributes(entries[0]), req.Attribute, req.Value)
+	logger.Debugf("compare result %d", resultCode)
+	return resultCode, nil
+}
+
+func (h *boltdbHandler) Delete(ctx context.Context, boundDN string, req *ldap.DelRequest, conn net.Conn) (ldapserver.LDAPResultCode, error) {
 	logger := h.logger.WithFields(logrus.Fields{
 		"op":          "delete",
 		"bind_dn":     boundDN,
+		"authc_dn":    ldapserver.AuthenticatedDN(ctx, boundDN),
 		"remote_addr": conn.RemoteAddr().String(),
 	})
 
@@ -186,21 +291,21 @@ func (h *boltdbHandler) Delete(boundDN string, req *ldap.DelRequest, conn net.Co
 	}
 
 	logger.Debug("Calling boltdb delete")
-	if err := h.bdb.EntryDelete(req.DN); err != nil {
+	w := h.newWriteControls(req.Controls)
+	if err := h.bdb.EntryDelete(req.DN, w.check); err != nil {
 		logger.WithError(err).WithField("entrydn", req.DN).Debugln("ldap delete failed")
-		if errors.Is(err, ldbbolt.ErrEntryAlreadyExists) {
-			return ldap.LDAPResultEntryAlreadyExists, nil
-		}
-		return ldap.LDAPResultUnwillingToPerform, err
+		return writeResult(err)
 	}
+	w.addResponseControls(ctx)
 	logger.Debug("delete succeeded")
 	return ldap.LDAPResultSuccess, nil
 }
 
-func (h *boltdbHandler) Modify(boundDN string, req *ldap.ModifyRequest, conn net.Conn) (ldapserver.LDAPResultCode, error) {
+func (h *boltdbHandler) Modify(ctx context.Context, boundDN string, req *ldap.ModifyRequest, conn net.Conn) (ldapserver.LDAPResultCode, error) {
 	logger := h.logger.WithFields(logrus.Fields{
 		"op":          "modify",
 		"bind_dn":     boundDN,
+		"authc_dn":    ldapserver.AuthenticatedDN(ctx, boundDN),
 		"remote_addr": conn.RemoteAddr().String(),
 		"entrydn":     req.DN,
 	})
@@ -209,26 +314,28 @@ func (h *boltdbHandler) Modify(boundDN string, req *ldap.ModifyRequest, conn net
 		return ldap.LDAPResultInsufficientAccessRights, nil
 	}
 
+	for _, change := range req.Changes {
+		if err := h.checkUserModification(change.Modification.Type); err != nil {
+			return ldap.LDAPResultConstraintViolation, err
+		}
+	}
+
 	logger.Debug("Calling boltdb modify")
-	if err := h.bdb.EntryModify(req); err != nil {
+	w := h.newWriteControls(req.Controls)
+	if err := h.bdb.EntryModify(req, boundDN, w.check); err != nil {
 		logger.WithError(err).Debug("ldap modify failed")
-		if errors.Is(err, ldbbolt.ErrEntryAlreadyExists) {
-			return ldap.LDAPResultEntryAlreadyExists, nil
-		}
-		ldapError, ok := err.(*ldap.Error)
-		if !ok {
-			return ldap.LDAPResultUnwillingToPerform, err
-		}
-		return ldapserver.LDAPResultCode(ldapError.ResultCode), ldapError.Err
+		return writeResult(err)
 	}
+	w.addResponseControls(ctx)
 	logger.Debug("modify succeeded")
 	return ldap.LDAPResultSuccess, nil
 }
 
-func (h *boltdbHandler) ModifyDN(boundDN string, req *ldap.ModifyDNRequest, conn net.Conn) (ldapserver.LDAPResultCode, error) {
+func (h *boltdbHandler) ModifyDN(ctx context.Context, boundDN string, req *ldap.ModifyDNRequest, conn net.Conn) (ldapserver.LDAPResultCode, error) {
 	logger := h.logger.WithFields(logrus.Fields{
 		"op":          "modifyDN",
 		"bind_dn":     boundDN,
+		"authc_dn":    ldapserver.AuthenticatedDN(ctx, boundDN),
 		"remote_addr": conn.RemoteAddr().String(),
 		"entrydn":     req.DN,
 	})
@@ -237,26 +344,101 @@ func (h *boltdbHandler) ModifyDN(boundDN string, req *ldap.ModifyDNRequest, conn
 		return ldap.LDAPResultInsufficientAccessRights, nil
 	}
 	logger.Debug("Calling boltdb modify DN")
-	if err := h.bdb.EntryModifyDN(req); err != nil {
+	w := h.newWriteControls(req.Controls)
+	if err := h.bdb.EntryModifyDN(req, boundDN, w.check); err != nil {
 		logger.WithError(err).Debug("ldap modifyDN failed")
-		if errors.Is(err, ldbbolt.ErrEntryAlreadyExists) {
-			return ldap.LDAPResultEntryAlreadyExists, nil
-		}
-		ldapError, ok := err.(*ldap.Error)
-		if !ok {
-			return ldap.LDAPResultUnwillingToPerform, err
-		}
-		return ldapserver.LDAPResultCode(ldapError.ResultCode), ldapError.Err
+		return writeResult(err)
 	}
+	w.addResponseControls(ctx)
 	logger.Debug("modify DN succeeded")
 	return ldap.LDAPResultSuccess, nil
 	return ldap.LDAPResultUnwillingToPerform, errors.New("unsupported operation")
 }
 
-func (h *boltdbHandler) ModifyPasswordExop(boundDN string, req *ldap.PasswordModifyRequest, conn net.Conn) (ldapserver.LDAPResultCode, error) {
+// CommitTransaction applies the update requests of an LDAP transaction in a
+// single transaction of the database. If one of them fails, none of them is
+// applied.
+func (h *boltdbHandler) CommitTransaction(ctx context.Context, boundDN string, requests []ldapserver.TransactionRequest, conn net.Conn) (ldapserver.TransactionResult, error) {
+	logger := h.logger.WithFields(logrus.Fields{
+		"op":          "transaction",
+		"bind_dn":     boundDN,
+		"authc_dn":    ldapserver.AuthenticatedDN(ctx, boundDN),
+		"remote_addr": conn.RemoteAddr().String(),
+	})
+
+	if !h.writeAllowed(boundDN) {
+		return ldapserver.TransactionResult{ResultCode: ldap.LDAPResultInsufficientAccessRights}, nil
+	}
+
+	var failed int64
+	err := h.bdb.Transaction(func(txn *ldbbolt.Txn) error {
+		for _, request := range requests {
+			failed = request.MessageID
+			if err := h.applyTransactionRequest(txn, boundDN, request.Request); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.WithError(err).WithField("message_id", failed).Debugln("ldap transaction failed")
+		code, err := writeResult(err)
+		return ldapserver.TransactionResult{ResultCode: code, MessageID: failed}, err
+	}
+	logger.WithField("requests", len(requests)).Debug("transaction succeeded")
+	return ldapserver.TransactionResult{ResultCode: ldap.LDAPResultSuccess}, nil
+}
+
+// applyTransactionRequest applies a single update request of a transaction
+// within txn.
+func (h *boltdbHandler) applyTransactionRequest(txn *ldbbolt.Txn, boundDN string, request any) error {
+	switch req := request.(type) {
+	case *ldap.AddRequest:
+		for _, attr := range req.Attributes {
+			if err := h.checkUserModification(attr.Type); err != nil {
+				return ldap.NewError(ldap.LDAPResultConstraintViolation, err)
+			}
+		}
+		return txn.EntryPut(ldapentry.EntryFromAddRequest(req), boundDN, h.newWriteControls(req.Controls).check)
+	case *ldap.DelRequest:
+		return txn.EntryDelete(req.DN, h.newWriteControls(req.Controls).check)
+	case *ldap.ModifyRequest:
+		for _, change := range req.Changes {
+			if err := h.checkUserModification(change.Modification.Type); err != nil {
+				return ldap.NewError(ldap.LDAPResultConstraintViolation, err)
+			}
+		}
+		return txn.EntryModify(req, boundDN, h.newWriteControls(req.Controls).check)
+	case *ldap.ModifyDNRequest:
+		return txn.EntryModifyDN(req, boundDN, h.newWriteControls(req.Controls).check)
+	}
+	return ldap.NewError(ldap.LDAPResultUnwillingToPerform, fmt.Errorf("unsupported request %T", request))
+}
+
+// writeResult returns the result code and the diagnostic message for the
+// error of a write to the database. It is used for single operations and
+// transactions alike, so that both report the same result codes.
+func writeResult(err error) (ldapserver.LDAPResultCode, error) {
+	switch {
+	case errors.Is(err, ldbbolt.ErrEntryAlreadyExists):
+		return ldap.LDAPResultEntryAlreadyExists, nil
+	case errors.Is(err, ldbbolt.ErrEntryNotFound):
+		return ldap.LDAPResultNoSuchObject, nil
+	case errors.Is(err, ldbbolt.ErrNonLeafEntry):
+		return ldap.LDAPResultNotAllowedOnNonLeaf, nil
+	}
+	var ldapError *ldap.Error
+	if errors.As(err, &ldapError) {
+		return ldapserver.LDAPResultCode(ldapError.ResultCode), ldapError.Err
+	}
+	return ldap.LDAPResultUnwillingToPerform, err
+}
+
+func (h *boltdbHandler) ModifyPasswordExop(ctx context.Context, boundDN string, req *ldap.PasswordModifyRequest, conn net.Conn) (ldapserver.LDAPResultCode, error) {
 	logger := h.logger.WithFields(logrus.Fields{
 		"op":           "modpw_exop",
 		"binddn":       boundDN,
+		"authc_dn":     ldapserver.AuthenticatedDN(ctx, boundDN),
 		"UserIdentity": req.UserIdentity,
 		"OldPWPresent": req.OldPassword != "",
 		"NewPwPresent": req.NewPassword != "",
@@ -277,7 +459,7 @@ func (h *boltdbHandler) ModifyPasswordExop(boundDN string, req *ldap.PasswordMod
 	}
 
 	logger.Debug("Calling boltdb UpdatePassword")
-	err := h.bdb.UpdatePassword(req)
+	err := h.bdb.UpdatePassword(req, boundDN)
 	if err != nil {
 		logger.Debugf("boltdb UpdatePassword returned '%s'", err)
 		return ldap.LDAPResultOther, err
@@ -285,19 +467,68 @@ func (h *boltdbHandler) ModifyPasswordExop(boundDN string, req *ldap.PasswordMod
 	return ldap.LDAPResultSuccess, err
 }
 
-func (h *boltdbHandler) Search(boundDN string, req *ldap.SearchRequest, conn net.Conn) (ldapserver.ServerSearchResult, error) {
+func (h *boltdbHandler) Search(ctx context.Context, boundDN string, req *ldap.SearchRequest, conn net.Conn) (ldapserver.ServerSearchResult, error) {
 	logger := h.logger.WithFields(logrus.Fields{
-		"op":     "search",
-		"binddn": boundDN,
-		"basedn": req.BaseDN,
-		"filter": req.Filter,
-		"attrs":  req.Attributes,
+		"op":       "search",
+		"binddn":   boundDN,
+		"authc_dn": ldapserver.AuthenticatedDN(ctx, boundDN),
+		"basedn":   req.BaseDN,
+		"filter":   req.Filter,
+		"attrs":    req.Attributes,
 	})
 
+	if result, ok, err := h.searchVirtualListView(logger, req); ok {
+		return result, err
+	}
+	if pagingControl, ok := ldap.FindControl(req.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging); ok {
+		// Searches with a size limit not above the page size are not paged.
+		if req.SizeLimit == 0 || pagingControl.PagingSize < uint32(req.SizeLimit) {
+			return h.searchPaged(ctx, logger, boundDN, req, pagingControl, conn)
+		}
+	}
+
+	// Searches for alias entries, which are issued to dereference aliases,
+	// use the alias index instead of loading the whole scope.
+	if strings.EqualFold(req.Filter, "(objectClass=alias)") {
+		logger.Debug("Calling boltdb alias search")
+		entries, err := h.bdb.SearchAliases(req.BaseDN, req.Scope)
+		if err != nil {
+			logger.WithError(err).Debugln("ldap alias search failed")
+			return searchLookupFailed(err)
+		}
+		for _, entry := range entries {
+			h.withOperationalAttributes(entry)
+		}
+		return ldapserver.ServerSearchResult{
+			Entries:    entries,
+			Referrals:  []string{},
+			Controls:   []ldap.Control{},
+			ResultCode: ldap.LDAPResultSuccess,
+		}, nil
+	}
+
 	logger.Debug("Calling boltdb search")
-	entries, _ := h.bdb.Search(req.BaseDN, req.Scope)
+	entries := []*ldap.Entry{}
+	err := h.bdb.SearchEach(req.BaseDN, req.Scope, func(_ uint64, entry *ldap.Entry) bool {
+		entries = append(entries, h.withOperationalAttributes(entry))
+		return ctx.Err() == nil
+	})
+	if err != nil {
+		logger.WithError(err).Debugln("ldap search failed")
+		return searchLookupFailed(err)
+	}
 	logger.Debugf("boltdb search returned %d entries", len(entries))
 
+	if err := ctx.Err(); err != nil {
+		// Searches which exceeded their time limit return the entries found
+		// so far.
+		logger.Debugln("search abandoned")
+		return ldapserver.ServerSearchResult{
+			Entries:    entries,
+			ResultCode: ldapserver.ContextResultCode(ctx),
+		}, context.Cause(ctx)
+	}
+
 	return ldapserver.ServerSearchResult{
 		Entries:    entries,
 		Referrals:  []string{},
@@ -306,6 +537,395 @@ func (h *boltdbHandler) Search(boundDN string, req *ldap.SearchRequest, conn net
 	}, nil
 }
 
+// searchLookupFailed returns the result of a search whose base entry could
+// not be looked up.
+func searchLookupFailed(err error) (ldapserver.ServerSearchResult, error) {
+	if errors.Is(err, ldbbolt.ErrEntryNotFound) {
+		return ldapserver.ServerSearchResult{
+			ResultCode: ldap.LDAPResultNoSuchObject,
+		}, err
+	}
+	return ldapserver.ServerSearchResult{
+		ResultCode: ldap.LDAPResultOperationsError,
+	}, err
+}
+
+// searchPaged answers a search with a paged results control. The first
+// request selects the ids of the entries in scope, in sorted order if the
+// search has a sort control. The following requests load the next page of
+// entries matching the filter by these ids. Entries which were deleted in
+// between are skipped, modified entries are returned in their current state.
+// A paged search can only be continued on the connection and with the
+// identity and request it was started with.
+func (h *boltdbHandler) searchPaged(ctx context.Context, logger logrus.FieldLogger, boundDN string, req *ldap.SearchRequest, pagingControl *ldap.ControlPaging, conn net.Conn) (ldapserver.ServerSearchResult, error) {
+	cookie := string(pagingControl.Cookie)
+	logger = logger.WithField("paging_cookie", cookie)
+	doneControls := []ldap.Control{}
+
+	filterPacket, err := ldapserver.CompileFilter(req.Filter)
+	if err != nil {
+		return ldapserver.ServerSearchResult{
+			ResultCode: ldap.LDAPResultOperationsError,
+		}, err
+	}
+	match := func(entry *ldap.Entry) (bool, ldapserver.LDAPResultCode) {
+		keep, resultCode := ldapserver.ServerApplyFilterWithSchema(h.schema(), filterPacket, h.withOperationalAttributes(entry))
+		if !keep || resultCode != ldap.LDAPResultSuccess {
+			return false, resultCode
+		}
+		return ldapserver.ServerFilterScope(req.BaseDN, req.Scope, entry)
+	}
+
+	var sorter *ldapserver.EntrySorter
+	if sortControl, ok := ldap.FindControl(req.Controls, ldap.ControlTypeServerSideSorting).(*ldapserver.ControlServerSideSorting); ok {
+		var sortResult *ldapserver.ControlServerSideSortingResult
+		sorter, sortResult = ldapserver.NewEntrySorter(h.schema(), sortControl)
+		doneControls = append(doneControls, sortResult)
+		if sorter == nil && sortControl.Criticality {
+			return ldapserver.ServerSearchResult{
+				Controls:   doneControls,
+				ResultCode: ldap.LDAPResultUnavailableCriticalExtension,
+			}, errors.New("search unable to sort")
+		}
+	}
+
+	var paging *searchPaging
+	if cookie == "" {
+		ids, err := h.bdb.SearchIDs(req.BaseDN, req.Scope)
+		if err != nil {
+			logger.WithError(err).Debugln("ldap search paging failed")
+			return searchLookupFailed(err)
+		}
+		if sorter != nil {
+			// Sorted searches load all matching entries once to sort them.
+			type sortItem struct {
+				id    uint64
+				entry *ldap.Entry
+			}
+			var items []sortItem
+			_, err = h.bdb.LoadEntries(ids, func(id uint64, entry *ldap.Entry) bool {
+				if keep, _ := match(entry); keep {
+					items = append(items, sortItem{id, entry})
+				}
+				return ctx.Err() == nil
+			})
+			if err != nil {
+				logger.WithError(err).Debugln("ldap search paging failed")
+				return ldapserver.ServerSearchResult{
+					ResultCode: ldap.LDAPResultOperationsError,
+				}, err
+			}
+			ldapserver.SortFunc(sorter, items, func(item sortItem) *ldap.Entry {
+				return item.entry
+			})
+			ids = ids[:0]
+			for _, item := range items {
+				ids = append(ids, item.id)
+			}
+		}
+		paging = &searchPaging{
+			ids:     ids,
+			conn:    conn,
+			boundDN: boundDN,
+			baseDN:  req.BaseDN,
+			scope:   req.Scope,
+			filter:  req.Filter,
+		}
+		cookie = base64.RawStdEncoding.EncodeToString(rndm.GenerateRandomBytes(8))
+		logger = logger.WithField("paging_cookie", cookie)
+		logger.Debugln("ldap search paging start")
+	} else {
+		pagingRecord, ok := h.activeSearchPagings.Get(cookie)
+		if ok && !pagingRecord.(*searchPaging).continues(req, boundDN, conn) {
+			logger.Debugln("ldap search paging cookie of other search")
+			ok = false
+		}
+		if ok {
+			_, ok = h.activeSearchPagings.Pop(cookie)
+		}
+		if !ok {
+			err := fmt.Errorf("search unable to perform: %d", ldap.LDAPResultUnwillingToPerform)
+			return ldapserver.ServerSearchResult{
+				ResultCode: ldap.LDAPResultUnwillingToPerform,
+			}, err
+		}
+		paging = pagingRecord.(*searchPaging)
+		paging.timer.Stop()
+		if pagingControl.PagingSize == 0 {
+			// No paging size with cookie, means abandon.
+			logger.Debugln("ldap search paging abandon")
+			paging.ids = nil
+		}
+	}
+
+	entries := []*ldap.Entry{}
+	var resultCode ldapserver.LDAPResultCode = ldap.LDAPResultSuccess
+	if len(paging.ids) > 0 {
+		var processed int
+		processed, err = h.bdb.LoadEntries(paging.ids, func(id uint64, entry *ldap.Entry) bool {
+			var keep bool
+			if keep, resultCode = match(entry); keep {
+				entries = append(entries, entry)
+			}
+			return resultCode == ldap.LDAPResultSuccess && uint32(len(entries)) < pagingControl.PagingSize && ctx.Err() == nil
+		})
+		paging.ids = paging.ids[processed:]
+	}
+	if err == nil && ctx.Err() != nil {
+		// The paged search ends, with the entries of this page found so far
+		// if it exceeded its time limit.
+		logger.Debugln("search abandoned")
+		return ldapserver.ServerSearchResult{
+			Entries:    entries,
+			ResultCode: ldapserver.ContextResultCode(ctx),
+		}, context.Cause(ctx)
+	}
+	if err != nil {
+		logger.WithError(err).Debugln("ldap search paging failed")
+		return ldapserver.ServerSearchResult{
+			ResultCode: ldap.LDAPResultOperationsError,
+		}, err
+	}
+	if resultCode != ldap.LDAPResultSuccess {
+		return ldapserver.ServerSearchResult{
+			ResultCode: resultCode,
+		}, errors.New("search filter apply error")
+	}
+
+	if len(paging.ids) > 0 {
+		next := &searchPaging{}
+		*next = *paging
+		next.timer = time.AfterFunc(searchPagingTimeout, func() {
+			h.activeSearchPagings.RemoveCb(cookie, func(_ string, v interface{}, _ bool) bool {
+				return v == next
+			})
+		})
+		h.activeSearchPagings.Set(cookie, next)
+	} else {
+		// All done, set cookie to empty.
+		logger.Debugln("ldap search paging end")
+		cookie = ""
+	}
+	doneControls = append(doneControls, &ldap.ControlPaging{
+		PagingSize: 0,
+		Cookie:     []byte(cookie),
+	})
+
+	return ldapserver.ServerSearchResult{
+		Entries:    entries,
+		Referrals:  []string{},
+		Controls:   doneControls,
+		ResultCode: ldap.LDAPResultSuccess,
+	}, nil
+}
+
+// searchVirtualListView answers a search with a virtual list view control
+// using the sort index of the database, so that only the entries of the list
+// view are loaded. This is possible if the search is sorted by a single
+// attribute with a sort index. Otherwise it returns false and the virtual
+// list view is left to the frontend. Other database errors, like a missing
+// base entry, are returned as the result of the search.
+func (h *boltdbHandler) searchVirtualListView(logger logrus.FieldLogger, req *ldap.SearchRequest) (ldapserver.ServerSearchResult, bool, error) {
+	vlvControl, ok := ldap.FindControl(req.Controls, ldap.ControlTypeVLVRequest).(*ldapserver.ControlVLVRequest)
+	if !ok {
+		return ldapserver.ServerSearchResult{}, false, nil
+	}
+	sortControl, ok := ldap.FindControl(req.Controls, ldap.ControlTypeServerSideSorting).(*ldapserver.ControlServerSideSorting)
+	if !ok || len(sortControl.SortKeys) != 1 {
+		return ldapserver.ServerSearchResult{}, false, nil
+	}
+	sortKey := sortControl.SortKeys[0]
+	rule, ok := h.bdb.SortIndexRule(sortKey.AttributeType)
+	if !ok || (sortKey.MatchingRule != "" && !rule.HasName(sortKey.MatchingRule)) {
+		return ldapserver.ServerSearchResult{}, false, nil
+	}
+	var assertion []byte
+	if vlvControl.GreaterThanOrEqual != nil {
+		if assertion, ok = rule.OrderingKey(*vlvControl.GreaterThanOrEqual); !ok {
+			return ldapserver.ServerSearchResult{}, false, nil
+		}
+	}
+
+	var match func(*ldap.Entry) bool
+	if !strings.EqualFold(req.Filter, "(objectClass=*)") {
+		filterPacket, err := ldapserver.CompileFilter(req.Filter)
+		if err != nil {
+			return ldapserver.ServerSearchResult{}, false, nil
+		}
+		match = func(entry *ldap.Entry) bool {
+			keep, resultCode := ldapserver.ServerApplyFilterWithSchema(h.schema(), filterPacket, h.withOperationalAttributes(entry))
+			return keep && resultCode == ldap.LDAPResultSuccess
+		}
+	}
+
+	logger.Debug("Calling boltdb sorted search")
+	var vlvResponse *ldapserver.ControlVLVResponse
+	entries, err := h.bdb.SearchSorted(req.BaseDN, req.Scope, sortKey.AttributeType, sortKey.Reverse, match, func(keys [][]byte) (start, end int) {
+		start, end, vlvResponse = ldapserver.VirtualListView(vlvControl, len(keys), func(i int) bool {
+			if keys[i] == nil {
+				return !sortKey.Reverse
+			}
+			result := bytes.Compare(keys[i], assertion)
+			if sortKey.Reverse {
+				result = -result
+			}
+			return result >= 0
+		})
+		return start, end
+	})
+	if errors.Is(err, ldbbolt.ErrNoSortIndex) {
+		logger.WithError(err).Debugln("boltdb sorted search not possible")
+		return ldapserver.ServerSearchResult{}, false, nil
+	}
+	if err != nil {
+		logger.WithError(err).Debugln("boltdb sorted search failed")
+		result, err := searchLookupFailed(err)
+		return result, true, err
+	}
+	logger.Debugf("boltdb sorted search returned %d entries", len(entries))
+	for _, entry := range entries {
+		h.withOperationalAttributes(entry)
+	}
+
+	controls := []ldap.Control{
+		&ldapserver.ControlServerSideSortingResult{Result: ldap.ControlServerSideSortingCodeSuccess},
+		vlvResponse,
+	}
+	if vlvResponse.Result != ldap.LDAPResultSuccess {
+		return ldapserver.ServerSearchResult{
+			Controls:   controls,
+			ResultCode: ldapserver.LDAPResultCode(vlvResponse.Result),
+		}, true, errors.New(ldap.LDAPResultCodeMap[vlvResponse.Result])
+	}
+	return ldapserver.ServerSearchResult{
+		Entries:    entries,
+		Referrals:  []string{},
+		Controls:   controls,
+		ResultCode: ldap.LDAPResultSuccess,
+	}, true, nil
+}
+
+// Sync returns the entries in scope of req for content synchronization,
+// together with the contextCSN of the database. Changes are subscribed to
+// before the entries are read, so that none are missed.
+func (h *boltdbHandler) Sync(ctx context.Context, boundDN string, req *ldap.SearchRequest, conn net.Conn) (ldapserver.ServerSyncResult, error) {
+	logger := h.logger.WithFields(logrus.Fields{
+		"op":       "sync",
+		"binddn":   boundDN,
+		"authc_dn": ldapserver.AuthenticatedDN(ctx, boundDN),
+		"basedn":   req.BaseDN,
+		"filter":   req.Filter,
+	})
+
+	var changes <-chan ldapserver.SyncChange
+	if ldapserver.IsPersistent(req) {
+		changes = h.changes.Subscribe(ctx)
+	}
+	csn, err := h.bdb.ContextCSN()
+	if err != nil {
+		logger.WithError(err).Debugln("sync failed")
+		return ldapserver.ServerSyncResult{
+			ResultCode: ldap.LDAPResultOperationsError,
+		}, err
+	}
+	ids, err := h.bdb.SearchIDs(req.BaseDN, req.Scope)
+	if err != nil {
+		return ldapserver.ServerSyncResult{
+			ResultCode: ldap.LDAPResultNoSuchObject,
+		}, err
+	}
+	entries := make([]*ldap.Entry, 0, len(ids))
+	_, err = h.bdb.LoadEntries(ids, func(_ uint64, entry *ldap.Entry) bool {
+		entries = append(entries, h.withOperationalAttributes(entry))
+		return ctx.Err() == nil
+	})
+	if err == nil && ctx.Err() != nil {
+		err = context.Cause(ctx)
+		return ldapserver.ServerSyncResult{
+			ResultCode: ldapserver.ContextResultCode(ctx),
+		}, err
+	}
+	if err != nil {
+		logger.WithError(err).Debugln("sync failed")
+		return ldapserver.ServerSyncResult{
+			ResultCode: ldap.LDAPResultOperationsError,
+		}, err
+	}
+	logger.Debugf("sync returned %d entries at %s", len(entries), csn)
+
+	return ldapserver.ServerSyncResult{
+		Entries:    entries,
+		CSN:        csn,
+		Changes:    changes,
+		ResultCode: ldap.LDAPResultSuccess,
+	}, nil
+}
+
+func (h *boltdbHandler) MapIdentity(req *ldap.SearchRequest, conn net.Conn) (string, error) {
+	logger := h.logger.WithFields(logrus.Fields{
+		"op":          "map_identity",
+		"basedn":      req.BaseDN,
+		"filter":      req.Filter,
+		"remote_addr": conn.RemoteAddr().String(),
+	})
+
+	baseDN := req.BaseDN
+	if baseDN == "" {
+		baseDN = h.baseDN
+	}
+	filterPacket, err := ldapserver.CompileFilter(req.Filter)
+	if err != nil {
+		return "", err
+	}
+
+	// The lookup stops at the second match, as the identity is ambiguous
+	// then.
+	var matches []*ldap.Entry
+	var filterErr error
+	err = h.bdb.SearchEach(baseDN, req.Scope, func(_ uint64, entry *ldap.Entry) bool {
+		keep, resultCode := ldapserver.ServerApplyFilterWithSchema(h.schema(), filterPacket, entry)
+		if resultCode != ldap.LDAPResultSuccess {
+			filterErr = fmt.Errorf("identity filter apply error: %d", resultCode)
+			return false
+		}
+		if keep {
+			matches = append(matches, entry)
+		}
+		return len(matches) < 2
+	})
+	if err != nil {
+		logger.WithError(err).Debugln("identity lookup failed")
+		return "", ldbbolt.ErrEntryNotFound
+	}
+	if filterErr != nil {
+		return "", filterErr
+	}
+	switch len(matches) {
+	case 0:
+		return "", ldbbolt.ErrEntryNotFound
+	case 1:
+		logger.WithField("entrydn", matches[0].DN).Debugln("identity mapped")
+		return matches[0].DN, nil
+	default:
+		return "", errors.New("identity matches more than one entry")
+	}
+}
+
+func (h *boltdbHandler) SCRAMVerifier(dn, mechanism string, conn net.Conn) (*ldappassword.SCRAMVerifier, error) {
+	dn, err := ldapdn.ParseNormalize(dn)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := h.bdb.Search(dn, ldap.ScopeBaseObject)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, ldbbolt.ErrEntryNotFound
+	}
+	return ldappassword.SCRAMVerifierFromHash(entries[0].GetEqualFoldAttributeValue("userPassword"), mechanism)
+}
+
 func (h *boltdbHandler) Close(boundDN string, conn net.Conn) error {
 	return nil
 }
@@ -325,6 +945,85 @@ func (h *boltdbHandler) Reload(ctx context.Context) error {
 	return nil
 }
 
+// checkEntry checks added and modified entries against the current schema.
+func (h *boltdbHandler) checkEntry(oldEntry, newEntry *ldap.Entry) error {
+	if oldEntry == nil {
+		return h.schema().CheckEntry(newEntry)
+	}
+	return h.schema().CheckModify(oldEntry, newEntry)
+}
+
+// withOperationalAttributes adds the operational attributes to entry which
+// are not stored in the database and returns it.
+func (h *boltdbHandler) withOperationalAttributes(entry *ldap.Entry) *ldap.Entry {
+	ldapserver.SetOperationalAttributes(h.schema(), entry)
+	return entry
+}
+
+// writeControls processes the assertion, pre-read and post-read controls of
+// a write operation.
+type writeControls struct {
+	h        *boltdbHandler
+	controls []ldap.Control
+
+	preEntry  *ldap.Entry
+	postEntry *ldap.Entry
+}
+
+func (h *boltdbHandler) newWriteControls(controls []ldap.Control) *writeControls {
+	return &writeControls{h: h, controls: controls}
+}
+
+// check evaluates the assertion within the transaction of the operation. It
+// is applied to the target entry before the change, or to the new entry of
+// additions. The entries are kept for the read entry controls.
+func (w *writeControls) check(oldEntry, newEntry *ldap.Entry) error {
+	w.preEntry, w.postEntry = w.h.operationalCopy(oldEntry), w.h.operationalCopy(newEntry)
+	target := w.preEntry
+	if target == nil {
+		target = w.postEntry
+	}
+	return ldapserver.CheckAssertion(w.h.schema(), w.controls, target)
+}
+
+// addResponseControls adds the read entry response controls to the response
+// of the operation, which ctx belongs to.
+func (w *writeControls) addResponseControls(ctx context.Context) {
+	ldapserver.AddResponseControls(ctx, ldapserver.ReadEntryControls(w.h.schema(), w.controls, w.preEntry, w.postEntry)...)
+}
+
+// operationalCopy returns a copy of entry with the operational attributes
+// which are not stored in the database, or nil if entry is nil.
+func (h *boltdbHandler) operationalCopy(entry *ldap.Entry) *ldap.Entry {
+	if entry == nil {
+		return nil
+	}
+	return h.withOperationalAttributes(&ldap.Entry{DN: entry.DN, Attributes: entry.Attributes})
+}
+
+// publishChange passes a committed change of the database to the persistent
+// content synchronization searches.
+func (h *boltdbHandler) publishChange(oldEntry, newEntry *ldap.Entry, csn string) {
+	change := ldapserver.SyncChange{CSN: csn}
+	if oldEntry != nil {
+		change.OldEntry = h.withOperationalAttributes(oldEntry)
+	}
+	if newEntry != nil {
+		change.NewEntry = h.withOperationalAttributes(newEntry)
+	}
+	h.changes.Publish(change)
+}
+
+// checkUserModification returns an error if the attribute attrType cannot be
+// modified by clients, because it is maintained by the server (RFC 4512
+// 4.1.2).
+func (h *boltdbHandler) checkUserModification(attrType string) error {
+	if a, ok := h.schema().AttributeType(attrType); ok && a.NoUserModification {
+		return fmt.Errorf("attribute '%s' cannot be modified by clients", attrType)
+	}
+	return nil
+}
+
 func (h *boltdbHandler) writeAllowed(boundDN string) bool {
 	if h.adminDN != "" && h.adminDN == boundDN {
 		return true
@@ -0,0 +1,261 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2021 The LibreGraph Authors.
+ */
+
+package boltdb
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+
+	"github.com/libregraph/idm/pkg/ldapserver"
+)
+
+const testAdminDN = "cn=admin,o=base"
+
+// setupTestHandler returns a handler with a new database containing o=base
+// and the users uid=a,o=base and uid=b,o=base, and a connection to pass to
+// its operations.
+func setupTestHandler(t *testing.T) (*boltdbHandler, net.Conn) {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	h, err := NewBoltDBHandler(logger, filepath.Join(t.TempDir(), "test.db"), &Options{BaseDN: "o=base", AdminDN: testAdminDN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bh := h.(*boltdbHandler)
+	t.Cleanup(func() { bh.bdb.Close() })
+	for _, entry := range []*ldap.Entry{
+		ldap.NewEntry("o=base", map[string][]string{"o": {"base"}, "objectClass": {"organization"}}),
+		ldap.NewEntry("uid=a,o=base", map[string][]string{"uid": {"a"}, "objectClass": {"account"}, "userPassword": {"secret"}}),
+		ldap.NewEntry("uid=b,o=base", map[string][]string{"uid": {"b"}, "objectClass": {"account"}, "userPassword": {"secret"}}),
+	} {
+		if err := bh.bdb.EntryPut(entry, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn, peer := net.Pipe()
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+	return bh, conn
+}
+
+func TestBoltDBHandler_Compare(t *testing.T) {
+	h, conn := setupTestHandler(t)
+
+	for _, test := range []struct {
+		boundDN   string
+		dn        string
+		attribute string
+		value     string
+		code      ldapserver.LDAPResultCode
+	}{
+		{"", "uid=a,o=base", "userPassword", "secret", ldap.LDAPResultInsufficientAccessRights},
+		{"uid=b,o=base", "uid=a,o=base", "userPassword", "secret", ldap.LDAPResultInsufficientAccessRights},
+		{"uid=a,o=base", "uid=a,o=base", "userPassword", "secret", ldap.LDAPResultCompareTrue},
+		{"uid=a,o=base", "uid=a,o=base", "userPassword", "wrong", ldap.LDAPResultCompareFalse},
+		{testAdminDN, "uid=b,o=base", "userPassword", "secret", ldap.LDAPResultCompareTrue},
+		{"uid=b,o=base", "uid=a,o=base", "uid", "a", ldap.LDAPResultCompareTrue},
+		{"uid=b,o=base", "uid=c,o=base", "uid", "c", ldap.LDAPResultNoSuchObject},
+	} {
+		code, _ := h.Compare(context.Background(), test.boundDN, &ldap.CompareRequest{DN: test.dn, Attribute: test.attribute, Value: test.value}, conn)
+		if code != test.code {
+			t.Errorf("Compare of %s %s by %q returned %d, expected %d", test.dn, test.attribute, test.boundDN, code, test.code)
+		}
+	}
+
+	h.bdb.Close()
+	if code, _ := h.Compare(context.Background(), testAdminDN, &ldap.CompareRequest{DN: "uid=a,o=base", Attribute: "uid", Value: "a"}, conn); code != ldap.LDAPResultOperationsError {
+		t.Errorf("Compare on closed database returned %d, expected %d", code, ldap.LDAPResultOperationsError)
+	}
+}
+
+func TestBoltDBHandler_SearchPaged(t *testing.T) {
+	h, conn := setupTestHandler(t)
+	for _, uid := range []string{"c", "d"} {
+		if err := h.bdb.EntryPut(ldap.NewEntry("uid="+uid+",o=base", map[string][]string{"uid": {uid}, "objectClass": {"account"}}), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	otherConn, otherPeer := net.Pipe()
+	defer otherConn.Close()
+	defer otherPeer.Close()
+
+	search := func(boundDN, baseDN, filter string, size uint32, cookie string, conn net.Conn) (ldapserver.ServerSearchResult, string) {
+		t.Helper()
+		control := &ldap.ControlPaging{PagingSize: size, Cookie: []byte(cookie)}
+		req := ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, filter, nil, []ldap.Control{control})
+		result, _ := h.Search(context.Background(), boundDN, req, conn)
+		if paging, ok := ldap.FindControl(result.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging); ok {
+			cookie = string(paging.Cookie)
+		}
+		return result, cookie
+	}
+
+	// The pages together return all matching entries.
+	var dns []string
+	result, cookie := search("uid=a,o=base", "o=base", "(uid=*)", 3, "", conn)
+	for _, entry := range result.Entries {
+		dns = append(dns, entry.DN)
+	}
+	if result.ResultCode != ldap.LDAPResultSuccess || len(result.Entries) != 3 || cookie == "" {
+		t.Fatalf("First page returned %d with %d entries and cookie %q", result.ResultCode, len(result.Entries), cookie)
+	}
+
+	// The cookie cannot be used by other connections, identities or searches.
+	for _, test := range []struct {
+		boundDN string
+		filter  string
+		conn    net.Conn
+	}{
+		{"uid=a,o=base", "(uid=*)", otherConn},
+		{"uid=b,o=base", "(uid=*)", conn},
+		{"uid=a,o=base", "(objectClass=*)", conn},
+	} {
+		if result, _ := search(test.boundDN, "o=base", test.filter, 3, cookie, test.conn); result.ResultCode != ldap.LDAPResultUnwillingToPerform {
+			t.Errorf("Next page as %s with %s returned %d", test.boundDN, test.filter, result.ResultCode)
+		}
+	}
+
+	result, cookie = search("uid=a,o=base", "o=base", "(uid=*)", 3, cookie, conn)
+	for _, entry := range result.Entries {
+		dns = append(dns, entry.DN)
+	}
+	if result.ResultCode != ldap.LDAPResultSuccess || cookie != "" {
+		t.Errorf("Last page returned %d and cookie %q", result.ResultCode, cookie)
+	}
+	if len(dns) != 4 {
+		t.Errorf("Paged search returned %v", dns)
+	}
+
+	// A page size of 0 abandons the paged search.
+	_, cookie = search("uid=a,o=base", "o=base", "(uid=*)", 1, "", conn)
+	result, next := search("uid=a,o=base", "o=base", "(uid=*)", 0, cookie, conn)
+	if result.ResultCode != ldap.LDAPResultSuccess || len(result.Entries) != 0 || next != "" {
+		t.Errorf("Abandoning paged search returned %d with %d entries and cookie %q", result.ResultCode, len(result.Entries), next)
+	}
+	if result, _ = search("uid=a,o=base", "o=base", "(uid=*)", 1, cookie, conn); result.ResultCode != ldap.LDAPResultUnwillingToPerform {
+		t.Errorf("Next page of abandoned search returned %d", result.ResultCode)
+	}
+
+	// Paged searches expire if the next page is not requested in time.
+	timeout := searchPagingTimeout
+	searchPagingTimeout = time.Millisecond
+	defer func() {
+		searchPagingTimeout = timeout
+	}()
+	_, cookie = search("uid=a,o=base", "o=base", "(uid=*)", 1, "", conn)
+	for deadline := time.Now().Add(5 * time.Second); h.activeSearchPagings.Has(cookie) && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if result, _ = search("uid=a,o=base", "o=base", "(uid=*)", 1, cookie, conn); result.ResultCode != ldap.LDAPResultUnwillingToPerform {
+		t.Errorf("Next page of expired search returned %d", result.ResultCode)
+	}
+
+	if result, _ = search("uid=a,o=base", "ou=missing,o=base", "(uid=*)", 1, "", conn); result.ResultCode != ldap.LDAPResultNoSuchObject {
+		t.Errorf("Paged search of missing base returned %d", result.ResultCode)
+	}
+}
+
+func TestBoltDBHandler_MapIdentity(t *testing.T) {
+	h, conn := setupTestHandler(t)
+
+	for _, test := range []struct {
+		filter string
+		dn     string
+		ok     bool
+	}{
+		{"(uid=a)", "uid=a,o=base", true},
+		{"(uid=c)", "", false},
+		{"(objectClass=account)", "", false},
+	} {
+		req := ldap.NewSearchRequest("o=base", ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, test.filter, nil, nil)
+		dn, err := h.MapIdentity(req, conn)
+		if dn != test.dn || (err == nil) != test.ok {
+			t.Errorf("MapIdentity with %s returned %q, %v", test.filter, dn, err)
+		}
+	}
+}
+
+func TestBoltDBHandler_SearchVirtualListView(t *testing.T) {
+	h, conn := setupTestHandler(t)
+
+	search := func(baseDN, filter string) ldapserver.ServerSearchResult {
+		t.Helper()
+		controls := []ldap.Control{
+			&ldapserver.ControlServerSideSorting{SortKeys: []*ldap.SortKey{{AttributeType: "uid", Reverse: true}}},
+			&ldapserver.ControlVLVRequest{AfterCount: 1, Offset: 1},
+		}
+		req := ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, filter, nil, controls)
+		result, _ := h.Search(context.Background(), testAdminDN, req, conn)
+		return result
+	}
+
+	result := search("o=base", "(uid=*)")
+	if result.ResultCode != ldap.LDAPResultSuccess || len(result.Entries) != 2 || result.Entries[0].DN != "uid=b,o=base" {
+		t.Errorf("Sorted search returned %d with %v", result.ResultCode, result.Entries)
+	}
+	if result = search("ou=missing,o=base", "(uid=*)"); result.ResultCode != ldap.LDAPResultNoSuchObject {
+		t.Errorf("Sorted search of missing base returned %d", result.ResultCode)
+	}
+}
+
+func TestBoltDBHandler_SearchAliases(t *testing.T) {
+	h, conn := setupTestHandler(t)
+	if err := h.bdb.EntryPut(ldap.NewEntry("cn=alias,o=base", map[string][]string{"cn": {"alias"}, "objectClass": {"alias", "extensibleObject"}, "aliasedObjectName": {"uid=a,o=base"}}), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	req := ldap.NewSearchRequest("o=base", ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=alias)", nil, nil)
+	result, _ := h.Search(context.Background(), testAdminDN, req, conn)
+	if result.ResultCode != ldap.LDAPResultSuccess || len(result.Entries) != 1 || result.Entries[0].DN != "cn=alias,o=base" {
+		t.Errorf("Alias search returned %d with %v", result.ResultCode, result.Entries)
+	}
+}
+
+func TestBoltDBHandler_WriteResults(t *testing.T) {
+	h, conn := setupTestHandler(t)
+
+	for _, test := range []struct {
+		request any
+		code    ldapserver.LDAPResultCode
+	}{
+		{ldap.NewAddRequest("uid=a,o=base", nil), ldap.LDAPResultEntryAlreadyExists},
+		{ldap.NewAddRequest("uid=c,ou=missing,o=base", nil), ldap.LDAPResultNoSuchObject},
+		{ldap.NewDelRequest("uid=c,o=base", nil), ldap.LDAPResultNoSuchObject},
+		{ldap.NewDelRequest("o=base", nil), ldap.LDAPResultNotAllowedOnNonLeaf},
+		{ldap.NewModifyRequest("uid=c,o=base", nil), ldap.LDAPResultNoSuchObject},
+		{ldap.NewModifyDNRequest("uid=c,o=base", "uid=d", true, ""), ldap.LDAPResultNoSuchObject},
+		{ldap.NewModifyDNRequest("uid=a,o=base", "uid=b", true, ""), ldap.LDAPResultEntryAlreadyExists},
+	} {
+		// Single operations and transactions report the same result.
+		var code ldapserver.LDAPResultCode
+		switch req := test.request.(type) {
+		case *ldap.AddRequest:
+			code, _ = h.Add(context.Background(), testAdminDN, req, conn)
+		case *ldap.DelRequest:
+			code, _ = h.Delete(context.Background(), testAdminDN, req, conn)
+		case *ldap.ModifyRequest:
+			code, _ = h.Modify(context.Background(), testAdminDN, req, conn)
+		case *ldap.ModifyDNRequest:
+			code, _ = h.ModifyDN(context.Background(), testAdminDN, req, conn)
+		}
+		if code != test.code {
+			t.Errorf("%T returned %d, expected %d", test.request, code, test.code)
+		}
+		result, _ := h.CommitTransaction(context.Background(), testAdminDN, []ldapserver.TransactionRequest{{MessageID: 2, Request: test.request}}, conn)
+		if result.ResultCode != test.code {
+			t.Errorf("%T in transaction returned %d, expected %d", test.request, result.ResultCode, test.code)
+		}
+	}
+}
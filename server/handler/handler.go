@@ -15,11 +15,16 @@ import (
 type Handler interface {
 	ldapserver.Adder
 	ldapserver.Binder
+	ldapserver.Comparer
 	ldapserver.Deleter
 	ldapserver.Modifier
 	ldapserver.PasswordUpdater
 	ldapserver.Renamer
 	ldapserver.Searcher
+	ldapserver.Syncer
+	ldapserver.Transactor
+	ldapserver.IdentityMapper
+	ldapserver.SCRAMProvider
 	ldapserver.Closer
 
 	WithContext(context.Context) Handler
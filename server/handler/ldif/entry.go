@@ -7,16 +7,22 @@ package ldif
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/go-ldap/ldap/v3"
 
 	"github.com/libregraph/idm/pkg/ldappassword"
+	"github.com/libregraph/idm/pkg/ldapserver"
 )
 
 type ldifEntry struct {
 	*ldap.Entry
 
 	UserPassword *ldap.EntryAttribute
+
+	// source are the attributes of the entry in the LDIF, which are
+	// compared on reload to detect changes.
+	source []*ldap.EntryAttribute
 }
 
 func (entry *ldifEntry) validatePassword(bindSimplePw string) error {
@@ -40,3 +46,19 @@ func (entry *ldifEntry) validatePassword(bindSimplePw string) error {
 	}
 	return nil
 }
+
+func (entry *ldifEntry) scramVerifier(mechanism string) (*ldappassword.SCRAMVerifier, error) {
+	if entry.UserPassword == nil || len(entry.UserPassword.Values) == 0 {
+		return nil, fmt.Errorf("user has no password attribute")
+	}
+	return ldappassword.SCRAMVerifierFromHash(entry.UserPassword.Values[0], mechanism)
+}
+
+// compare compares value against the named attribute of the entry, including
+// the separately stored userPassword.
+func (entry *ldifEntry) compare(attribute, value string) ldapserver.LDAPResultCode {
+	if strings.EqualFold(attribute, "userPassword") {
+		return ldapserver.ServerCompareAttribute(entry.UserPassword, value)
+	}
+	return ldapserver.ServerCompareEntry(entry.Entry, attribute, value)
+}
@@ -168,3 +168,28 @@ func BenchmarkLdifEntry_validatePassword_MissingPassword(b *testing.B) {
 		_ = entry.validatePassword("password123")
 	}
 }
+
+func TestLdifEntry_compare(t *testing.T) {
+	entry := &ldifEntry{
+		Entry: ldap.NewEntry("uid=test,ou=users,dc=example,dc=com", map[string][]string{
+			"uid": {"test"},
+		}),
+		UserPassword: &ldap.EntryAttribute{
+			Name:   "userPassword",
+			Values: []string{"password123"},
+		},
+	}
+
+	if resultCode := entry.compare("uid", "TEST"); resultCode != ldap.LDAPResultCompareTrue {
+		t.Errorf("Expected compareTrue for uid but got %d", resultCode)
+	}
+	if resultCode := entry.compare("userPassword", "password123"); resultCode != ldap.LDAPResultCompareTrue {
+		t.Errorf("Expected compareTrue for correct password but got %d", resultCode)
+	}
+	if resultCode := entry.compare("userPassword", "wrong"); resultCode != ldap.LDAPResultCompareFalse {
+		t.Errorf("Expected compareFalse for wrong password but got %d", resultCode)
+	}
+	if resultCode := entry.compare("mail", "test@example.com"); resultCode != ldap.LDAPResultNoSuchAttribute {
+		t.Errorf("Expected noSuchAttribute for missing attribute but got %d", resultCode)
+	}
+}
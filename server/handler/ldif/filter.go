@@ -78,14 +78,15 @@ func parseFilterMatchLeavesForIndex(f *ber.Packet, parent [][]string, level stri
 			return nil, errors.New("unsupported number of children in substrings filter")
 		}
 		attribute := f.Children[0].Value.(string)
-		if len(f.Children[1].Children) != 1 {
-			return nil, errors.New("unsupported number of children in substrings filter")
+		if _, ok := ldapserver.ParseSubstringAssertion(f.Children[1]); !ok {
+			return nil, errors.New("invalid substrings in substrings filter")
 		}
-		value := f.Children[1].Children[0].Value.(string)
-		switch f.Children[1].Children[0].Tag {
-		case ldapserver.FilterSubstringsInitial, ldapserver.FilterSubstringsAny, ldapserver.FilterSubstringsFinal:
-			parent = append(parent, []string{level, attribute, "sub", value, strconv.FormatInt(int64(f.Children[1].Children[0].Tag), 10)})
+		// The substrings are passed as pairs of value and tag.
+		leaf := []string{level, attribute, "sub"}
+		for _, child := range f.Children[1].Children {
+			leaf = append(leaf, ber.DecodeString(child.Data.Bytes()), strconv.FormatInt(int64(child.Tag), 10))
 		}
+		parent = append(parent, leaf)
 
 	case ldapserver.FilterAnd:
 		for idx, child := range f.Children {
@@ -103,8 +104,12 @@ func parseFilterMatchLeavesForIndex(f *ber.Packet, parent [][]string, level stri
 			}
 		}
 
-	case ldapserver.FilterNot:
-		// Ignored for now.
+	case ldapserver.FilterNot, ldapserver.FilterGreaterOrEqual, ldapserver.FilterLessOrEqual, ldapserver.FilterApproxMatch, ldapserver.FilterExtensibleMatch:
+		// Not supported by the index. Ignoring these is fine in an "and",
+		// but in an "or" the index would miss the entries matching them.
+		if strings.Contains(level, "|") {
+			return nil, errors.New("unsupported filter in or filter")
+		}
 
 	default:
 	}
@@ -24,7 +24,10 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/libregraph/idm/pkg/ldapdn"
+	"github.com/libregraph/idm/pkg/ldapentry"
+	"github.com/libregraph/idm/pkg/ldappassword"
 	"github.com/libregraph/idm/pkg/ldapserver"
+	"github.com/libregraph/idm/pkg/schema"
 	"github.com/libregraph/idm/server/handler"
 )
 
@@ -42,6 +45,19 @@ type ldifHandler struct {
 	current atomic.Value
 
 	activeSearchPagings cmap.ConcurrentMap
+
+	schema func() *schema.Schema
+
+	// changes passes the changes found on reload to the persistent content
+	// synchronization searches.
+	changes *ldapserver.SyncChanges
+}
+
+// searchPaging is the pump of a paged search, which is continued by the
+// requests for the following pages.
+type searchPaging struct {
+	pumpCh <-chan *ldifEntry
+	cancel context.CancelFunc
 }
 
 var _ handler.Handler = (*ldifHandler)(nil) // Verify that *ldifHandler implements handler.Handler.
@@ -70,6 +86,16 @@ func NewLDIFHandler(logger logrus.FieldLogger, fn string, options *Options) (han
 		ctx: context.Background(),
 
 		activeSearchPagings: cmap.New(),
+
+		schema: options.Schema,
+
+		changes: &ldapserver.SyncChanges{},
+	}
+	if h.schema == nil {
+		builtin := schema.New()
+		h.schema = func() *schema.Schema {
+			return builtin
+		}
 	}
 	if h.baseDN, err = ldapdn.ParseNormalize(options.BaseDN); err != nil {
 		return nil, err
@@ -97,7 +123,9 @@ func (h *ldifHandler) open() error {
 	}
 
 	var l *ldif.LDIF
-	index := newIndexMapRegister()
+	s := h.schema()
+	index := newIndexMapRegister(s)
+	loaded := time.Now()
 
 	if info.IsDir() {
 		h.logger.Debugln("loading LDIF files from folder")
@@ -122,7 +150,21 @@ func (h *ldifHandler) open() error {
 		}
 	}
 
-	t, err := treeFromLDIF(l, index, h.options)
+	// Entries which did not change since the previous load keep their
+	// entryCSN, the others get a new one.
+	previous, _ := h.current.Load().(*ldifMemoryValue)
+	load := &ldifLoad{
+		schema: s,
+		loaded: loaded,
+	}
+	if previous != nil {
+		load.csn = ldapentry.NextCSN(previous.csn, loaded)
+		load.previous = previous.t
+	} else {
+		load.csn = ldapentry.NextCSN("", loaded)
+	}
+
+	t, err := treeFromLDIF(l, index, h.options, load)
 	if err != nil {
 		return err
 	}
@@ -134,7 +176,24 @@ func (h *ldifHandler) open() error {
 
 		index: index,
 	}
-	h.current.Store(value)
+	if previous == nil {
+		if t.Len() > 0 {
+			value.csn = load.csn
+		}
+		h.current.Store(value)
+	} else {
+		// Pass the differences to the previous load to the persistent
+		// content synchronization searches.
+		value.csn = load.csn
+		changes := value.syncChanges(previous)
+		if len(changes) == 0 {
+			value.csn = previous.csn
+		}
+		h.current.Store(value)
+		for _, change := range changes {
+			h.changes.Publish(change)
+		}
+	}
 
 	h.logger.WithFields(logrus.Fields{
 		"version":       l.Version,
@@ -142,6 +201,7 @@ func (h *ldifHandler) open() error {
 		"tree_length":   t.Len(),
 		"base_dn":       h.options.BaseDN,
 		"indexes":       len(index),
+		"csn":           value.csn,
 	}).Debugln("loaded LDIF")
 
 	return nil
@@ -167,7 +227,7 @@ func (h *ldifHandler) Reload(ctx context.Context) error {
 	return h.open()
 }
 
-func (h *ldifHandler) Add(_ string, _ *ldap.AddRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
+func (h *ldifHandler) Add(_ context.Context, _ string, _ *ldap.AddRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
 	return ldap.LDAPResultUnwillingToPerform, errors.New("unsupported operation")
 }
 
@@ -212,27 +272,75 @@ func (h *ldifHandler) Bind(bindDN, bindSimplePw string, conn net.Conn) (ldapserv
 	return ldap.LDAPResultSuccess, nil
 }
 
-func (h *ldifHandler) Delete(_ string, _ *ldap.DelRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
+func (h *ldifHandler) SCRAMVerifier(dn, mechanism string, conn net.Conn) (*ldappassword.SCRAMVerifier, error) {
+	dn = strings.ToLower(dn)
+
+	entryRecord, found := h.load().t.Get([]byte(dn))
+	if !found {
+		return nil, fmt.Errorf("user not found")
+	}
+	return entryRecord.(*ldifEntry).scramVerifier(mechanism)
+}
+
+func (h *ldifHandler) Compare(ctx context.Context, bindDN string, req *ldap.CompareRequest, conn net.Conn) (ldapserver.LDAPResultCode, error) {
+	bindDN = strings.ToLower(bindDN)
+	logger := h.logger.WithFields(logrus.Fields{
+		"bind_dn":     bindDN,
+		"authc_dn":    ldapserver.AuthenticatedDN(ctx, bindDN),
+		"entry_dn":    req.DN,
+		"attribute":   req.Attribute,
+		"remote_addr": conn.RemoteAddr().String(),
+	})
+
+	if err := h.validateBindDN(bindDN, conn); err != nil {
+		logger.WithError(err).Debugln("ldap compare request BindDN validation failed")
+		return ldap.LDAPResultInsufficientAccessRights, err
+	}
+	// Only the entry itself and the admin may compare passwords, otherwise
+	// clients could guess passwords without failing any binds.
+	if strings.EqualFold(req.Attribute, "userPassword") {
+		boundDN, bindErr := ldapdn.ParseNormalize(bindDN)
+		targetDN, err := ldapdn.ParseNormalize(req.DN)
+		if bindDN == "" || bindErr != nil || err != nil || (boundDN != targetDN && boundDN != h.adminDN) {
+			return ldap.LDAPResultInsufficientAccessRights, nil
+		}
+	}
+
+	entryRecord, found := h.load().t.Get([]byte(strings.ToLower(req.DN)))
+	if !found {
+		return ldap.LDAPResultNoSuchObject, nil
+	}
+	resultCode := entryRecord.(*ldifEntry).compare(req.Attribute, req.Value)
+	logger.Debugf("ldap compare result %d", resultCode)
+	return resultCode, nil
+}
+
+func (h *ldifHandler) Delete(_ context.Context, _ string, _ *ldap.DelRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
 	return ldap.LDAPResultUnwillingToPerform, errors.New("unsupported operation")
 }
 
-func (h *ldifHandler) Modify(_ string, _ *ldap.ModifyRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
+func (h *ldifHandler) Modify(_ context.Context, _ string, _ *ldap.ModifyRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
 	return ldap.LDAPResultUnwillingToPerform, errors.New("unsupported operation")
 }
 
-func (h *ldifHandler) ModifyDN(_ string, _ *ldap.ModifyDNRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
+func (h *ldifHandler) ModifyDN(_ context.Context, _ string, _ *ldap.ModifyDNRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
 	return ldap.LDAPResultUnwillingToPerform, errors.New("unsupported operation")
 }
 
-func (h *ldifHandler) ModifyPasswordExop(_ string, _ *ldap.PasswordModifyRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
+func (h *ldifHandler) CommitTransaction(_ context.Context, _ string, _ []ldapserver.TransactionRequest, _ net.Conn) (ldapserver.TransactionResult, error) {
+	return ldapserver.TransactionResult{ResultCode: ldap.LDAPResultUnwillingToPerform}, errors.New("unsupported operation")
+}
+
+func (h *ldifHandler) ModifyPasswordExop(_ context.Context, _ string, _ *ldap.PasswordModifyRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
 	return ldap.LDAPResultUnwillingToPerform, errors.New("unsupported operation")
 }
 
-func (h *ldifHandler) Search(bindDN string, searchReq *ldap.SearchRequest, conn net.Conn) (ldapserver.ServerSearchResult, error) {
+func (h *ldifHandler) Search(ctx context.Context, bindDN string, searchReq *ldap.SearchRequest, conn net.Conn) (ldapserver.ServerSearchResult, error) {
 	bindDN = strings.ToLower(bindDN)
 	searchBaseDN := strings.ToLower(searchReq.BaseDN)
 	logger := h.logger.WithFields(logrus.Fields{
 		"bind_dn":        bindDN,
+		"authc_dn":       ldapserver.AuthenticatedDN(ctx, bindDN),
 		"search_base_dn": searchBaseDN,
 		"remote_addr":    conn.RemoteAddr().String(),
 		"controls":       searchReq.Controls,
@@ -269,43 +377,69 @@ func (h *ldifHandler) Search(bindDN string, searchReq *ldap.SearchRequest, conn
 		}
 	}
 
+	var sorter *ldapserver.EntrySorter
+	if sortControl, ok := ldap.FindControl(searchReq.Controls, ldap.ControlTypeServerSideSorting).(*ldapserver.ControlServerSideSorting); ok {
+		var sortResult *ldapserver.ControlServerSideSortingResult
+		sorter, sortResult = ldapserver.NewEntrySorter(h.schema(), sortControl)
+		doneControls = append(doneControls, sortResult)
+		if sorter == nil && sortControl.Criticality {
+			return ldapserver.ServerSearchResult{
+				Controls:   doneControls,
+				ResultCode: ldap.LDAPResultUnavailableCriticalExtension,
+			}, errors.New("search unable to sort")
+		}
+	}
+
+	// The pump of a paged search outlives the request and is bound to the
+	// handler context. Otherwise the pump ends with the request.
+	var pumpCancel context.CancelFunc
+	pumpStop := func() bool { return false }
 	pumpCh, resultCode := func() (<-chan *ldifEntry, ldapserver.LDAPResultCode) {
 		var pumpCh chan *ldifEntry
-		var start = true
+		var pumpCtx context.Context
 		if pagingControl != nil {
 			if len(pagingCookie) == 0 {
 				pagingCookie = []byte(base64.RawStdEncoding.EncodeToString(rndm.GenerateRandomBytes(8)))
 				pagingControl.Cookie = pagingCookie
 				pumpCh = make(chan *ldifEntry)
-				h.activeSearchPagings.Set(string(pagingControl.Cookie), pumpCh)
+				pumpCtx, pumpCancel = context.WithCancel(h.ctx)
+				h.activeSearchPagings.Set(string(pagingControl.Cookie), &searchPaging{
+					pumpCh: pumpCh,
+					cancel: pumpCancel,
+				})
 				logger.WithField("paging_cookie", string(pagingControl.Cookie)).Debugln("ldap search paging pump start")
 			} else {
-				pumpChRecord, ok := h.activeSearchPagings.Get(string(pagingControl.Cookie))
+				pagingRecord, ok := h.activeSearchPagings.Get(string(pagingControl.Cookie))
 				if !ok {
 					return nil, ldap.LDAPResultUnwillingToPerform
 				}
+				paging := pagingRecord.(*searchPaging)
+				pumpCancel = paging.cancel
 				if pagingControl.PagingSize > 0 {
 					logger.WithField("paging_cookie", string(pagingControl.Cookie)).Debugln("ldap search paging pump continue")
-					pumpCh = pumpChRecord.(chan *ldifEntry)
-					start = false
-				} else {
-					// No paging size with cookie, means abandon.
-					start = false
-					logger.WithField("paging_cookie", string(pagingControl.Cookie)).Debugln("search paging pump abandon")
-					// TODO(longsleep): Cancel paging pump context.
-					h.activeSearchPagings.Remove(string(pagingControl.Cookie))
+					return paging.pumpCh, ldap.LDAPResultSuccess
 				}
+				// No paging size with cookie, means abandon.
+				logger.WithField("paging_cookie", string(pagingControl.Cookie)).Debugln("search paging pump abandon")
+				paging.cancel()
+				h.activeSearchPagings.Remove(string(pagingControl.Cookie))
+				pagingCookie = []byte{}
+				return nil, ldap.LDAPResultSuccess
 			}
 		} else {
 			pumpCh = make(chan *ldifEntry)
+			pumpCtx, pumpCancel = context.WithCancel(ctx)
+			pumpStop = context.AfterFunc(h.ctx, pumpCancel)
 		}
-		if start {
-			current := h.load()
-			go h.searchEntriesPump(h.ctx, current, pumpCh, searchReq, pagingControl, indexFilter)
-		}
+		current := h.load()
+		go h.searchEntriesPump(pumpCtx, current, pumpCh, searchReq, pagingControl, indexFilter, sorter)
 
 		return pumpCh, ldap.LDAPResultSuccess
 	}()
+	if pagingControl == nil && pumpCancel != nil {
+		defer pumpStop()
+		defer pumpCancel()
+	}
 	if resultCode != ldap.LDAPResultSuccess {
 		err := fmt.Errorf("search unable to perform: %d", resultCode)
 		return ldapserver.ServerSearchResult{
@@ -326,8 +460,21 @@ func (h *ldifHandler) Search(bindDN string, searchReq *ldap.SearchRequest, conn
 	var count uint32
 	var keep bool
 results:
-	for {
+	for pumpCh != nil {
 		select {
+		case <-ctx.Done():
+			// Abandoned, canceled or out of time, a paged search cannot be
+			// continued. Searches which exceeded their time limit return the
+			// entries found so far.
+			if pagingControl != nil {
+				pumpCancel()
+				h.activeSearchPagings.Remove(string(pagingControl.Cookie))
+			}
+			return ldapserver.ServerSearchResult{
+				Entries:    entries,
+				ResultCode: ldapserver.ContextResultCode(ctx),
+			}, context.Cause(ctx)
+
 		case entryRecord = <-pumpCh:
 			if entryRecord == nil {
 				// All done, set cookie to empty.
@@ -338,7 +485,7 @@ results:
 				entry = entryRecord.Entry
 
 				// Apply filter.
-				keep, resultCode = ldapserver.ServerApplyFilter(filterPacket, entry)
+				keep, resultCode = ldapserver.ServerApplyFilterWithSchema(h.schema(), filterPacket, entry)
 				if resultCode != ldap.LDAPResultSuccess {
 					return ldapserver.ServerSearchResult{
 						ResultCode: resultCode,
@@ -367,7 +514,7 @@ results:
 				copy(e.Attributes, entry.Attributes)
 
 				// Filter attributes from entry.
-				resultCode, err = ldapserver.ServerFilterAttributes(searchReq.Attributes, e)
+				resultCode, err = ldapserver.ServerFilterAttributesWithSchema(h.schema(), searchReq.Attributes, e)
 				if err != nil {
 					return ldapserver.ServerSearchResult{
 						ResultCode: resultCode,
@@ -407,7 +554,7 @@ results:
 	}, nil
 }
 
-func (h *ldifHandler) searchEntriesPump(ctx context.Context, current *ldifMemoryValue, pumpCh chan<- *ldifEntry, searchReq *ldap.SearchRequest, pagingControl *ldap.ControlPaging, indexFilter [][]string) {
+func (h *ldifHandler) searchEntriesPump(ctx context.Context, current *ldifMemoryValue, pumpCh chan<- *ldifEntry, searchReq *ldap.SearchRequest, pagingControl *ldap.ControlPaging, indexFilter [][]string, sorter *ldapserver.EntrySorter) {
 	defer func() {
 		if pagingControl != nil {
 			h.activeSearchPagings.Remove(string(pagingControl.Cookie))
@@ -418,7 +565,7 @@ func (h *ldifHandler) searchEntriesPump(ctx context.Context, current *ldifMemory
 		}
 	}()
 
-	pump := func(entryRecord *ldifEntry) bool {
+	send := func(entryRecord *ldifEntry) bool {
 		select {
 		case pumpCh <- entryRecord:
 		case <-ctx.Done():
@@ -439,6 +586,27 @@ func (h *ldifHandler) searchEntriesPump(ctx context.Context, current *ldifMemory
 		return true
 	}
 
+	// Sorted searches collect all entries first and send them once sorted,
+	// so the pages of a paged search follow the sort order.
+	pump := send
+	var sorted []*ldifEntry
+	if sorter != nil {
+		pump = func(entryRecord *ldifEntry) bool {
+			sorted = append(sorted, entryRecord)
+			return true
+		}
+		defer func() {
+			ldapserver.SortFunc(sorter, sorted, func(entryRecord *ldifEntry) *ldap.Entry {
+				return entryRecord.Entry
+			})
+			for _, entryRecord := range sorted {
+				if ok := send(entryRecord); !ok {
+					return
+				}
+			}
+		}()
+	}
+
 	searchBaseDN := strings.ToLower(searchReq.BaseDN)
 
 	load := true
@@ -484,6 +652,69 @@ func (h *ldifHandler) searchEntriesPump(ctx context.Context, current *ldifMemory
 	}
 }
 
+// Sync returns the entries in scope of searchReq for content synchronization,
+// together with the CSN of the current LDIF data. The changes found when
+// reloading the LDIF are passed on to persistent searches.
+func (h *ldifHandler) Sync(ctx context.Context, bindDN string, searchReq *ldap.SearchRequest, conn net.Conn) (ldapserver.ServerSyncResult, error) {
+	bindDN = strings.ToLower(bindDN)
+	searchBaseDN := strings.ToLower(searchReq.BaseDN)
+	logger := h.logger.WithFields(logrus.Fields{
+		"bind_dn":        bindDN,
+		"authc_dn":       ldapserver.AuthenticatedDN(ctx, bindDN),
+		"search_base_dn": searchBaseDN,
+		"remote_addr":    conn.RemoteAddr().String(),
+	})
+
+	if err := h.validateBindDN(bindDN, conn); err != nil {
+		logger.WithError(err).Debugln("ldap sync request BindDN validation failed")
+		return ldapserver.ServerSyncResult{
+			ResultCode: ldap.LDAPResultInsufficientAccessRights,
+		}, err
+	}
+	if !strings.HasSuffix(searchBaseDN, h.baseDN) {
+		err := fmt.Errorf("ldap sync BaseDN is not in our BaseDN %s", h.baseDN)
+		return ldapserver.ServerSyncResult{
+			ResultCode: ldap.LDAPResultInsufficientAccessRights,
+		}, err
+	}
+
+	// Subscribe before loading, so that no reload is missed.
+	var changes <-chan ldapserver.SyncChange
+	if ldapserver.IsPersistent(searchReq) {
+		changes = h.changes.Subscribe(ctx)
+	}
+	current := h.load()
+	var entries []*ldap.Entry
+	current.t.WalkSuffix([]byte(searchBaseDN), func(key []byte, entryRecord interface{}) bool {
+		entries = append(entries, entryRecord.(*ldifEntry).Entry)
+		return false
+	})
+	logger.Debugf("ldap sync returned %d entries at %s", len(entries), current.csn)
+
+	return ldapserver.ServerSyncResult{
+		Entries:    entries,
+		CSN:        current.csn,
+		Changes:    changes,
+		ResultCode: ldap.LDAPResultSuccess,
+	}, nil
+}
+
+func (h *ldifHandler) MapIdentity(req *ldap.SearchRequest, conn net.Conn) (string, error) {
+	logger := h.logger.WithFields(logrus.Fields{
+		"base_dn":     req.BaseDN,
+		"filter":      req.Filter,
+		"remote_addr": conn.RemoteAddr().String(),
+	})
+
+	dn, err := mapIdentity(h.load(), h.baseDN, req)
+	if err != nil {
+		logger.WithError(err).Debugln("ldap identity lookup failed")
+		return "", err
+	}
+	logger.WithField("entry_dn", dn).Debugln("ldap identity mapped")
+	return dn, nil
+}
+
 func (h *ldifHandler) validateBindDN(bindDN string, conn net.Conn) error {
 	if bindDN == "" {
 		if h.allowLocalAnonymousBind {
@@ -0,0 +1,130 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2021 The LibreGraph Authors.
+ */
+
+package ldif
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+
+	"github.com/libregraph/idm/pkg/ldapserver"
+)
+
+func TestLDIFHandler_ReloadSyncChanges(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.ldif")
+	write := func(data string) {
+		if err := os.WriteFile(fn, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("dn: o=base\nobjectClass: organization\no: base\n\n" +
+		"dn: uid=a,o=base\nobjectClass: account\nuid: a\n\n" +
+		"dn: uid=b,o=base\nobjectClass: account\nuid: b\n")
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	h, err := NewLDIFHandler(logger, fn, &Options{BaseDN: "o=base", AllowLocalAnonymousBind: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lh := h.(*ldifHandler)
+	first := lh.load()
+	if first.csn == "" {
+		t.Fatal("Initial load has no CSN")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := lh.changes.Subscribe(ctx)
+
+	write("dn: o=base\nobjectClass: organization\no: base\n\n" +
+		"dn: uid=a,o=base\nobjectClass: account\nuid: a\ndescription: changed\n\n" +
+		"dn: uid=c,o=base\nobjectClass: account\nuid: c\n")
+	if err = h.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	second := lh.load()
+	if second.csn <= first.csn {
+		t.Errorf("Reload CSN %s is not greater than %s", second.csn, first.csn)
+	}
+
+	got := map[string]string{}
+	for range 3 {
+		change := <-changes
+		if change.CSN != second.csn {
+			t.Errorf("Change has CSN %s, expected %s", change.CSN, second.csn)
+		}
+		switch {
+		case change.OldEntry == nil:
+			got[change.NewEntry.DN] = "add"
+		case change.NewEntry == nil:
+			got[change.OldEntry.DN] = "delete"
+		default:
+			got[change.NewEntry.DN] = "modify"
+		}
+	}
+	for dn, kind := range map[string]string{"uid=a,o=base": "modify", "uid=b,o=base": "delete", "uid=c,o=base": "add"} {
+		if got[dn] != kind {
+			t.Errorf("Reload change of %s is %q, expected %q", dn, got[dn], kind)
+		}
+	}
+	select {
+	case change := <-changes:
+		t.Errorf("Reload returned unexpected change %v", change)
+	default:
+	}
+
+	entry, _ := second.t.Get([]byte("o=base"))
+	if csn := entry.(*ldifEntry).GetEqualFoldAttributeValue(ldapserver.EntryCSNAttribute); csn != first.csn {
+		t.Errorf("Unchanged entry has entryCSN %s, expected %s", csn, first.csn)
+	}
+
+}
+
+func TestLDIFHandler_Compare(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.ldif")
+	if err := os.WriteFile(fn, []byte("dn: o=base\nobjectClass: organization\no: base\n\n"+
+		"dn: uid=a,o=base\nobjectClass: account\nuid: a\nuserPassword: secret\n\n"+
+		"dn: uid=b,o=base\nobjectClass: account\nuid: b\nuserPassword: secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	h, err := NewLDIFHandler(logger, fn, &Options{BaseDN: "o=base", AdminDN: "cn=admin,o=base"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	for _, test := range []struct {
+		boundDN   string
+		dn        string
+		attribute string
+		value     string
+		code      ldapserver.LDAPResultCode
+	}{
+		{"", "uid=a,o=base", "userPassword", "secret", ldap.LDAPResultInsufficientAccessRights},
+		{"uid=b,o=base", "uid=a,o=base", "userPassword", "secret", ldap.LDAPResultInsufficientAccessRights},
+		{"uid=a,o=base", "uid=a,o=base", "userPassword", "secret", ldap.LDAPResultCompareTrue},
+		{"UID=a, o=base", "uid=a,o=base", "userPassword", "secret", ldap.LDAPResultCompareTrue},
+		{"uid=a,o=base", "uid=a,o=base", "userPassword", "wrong", ldap.LDAPResultCompareFalse},
+		{"cn=admin,o=base", "uid=b,o=base", "userPassword", "secret", ldap.LDAPResultCompareTrue},
+		{"uid=b,o=base", "uid=a,o=base", "uid", "a", ldap.LDAPResultCompareTrue},
+		{"uid=b,o=base", "uid=c,o=base", "uid", "c", ldap.LDAPResultNoSuchObject},
+	} {
+		code, _ := h.Compare(context.Background(), test.boundDN, &ldap.CompareRequest{DN: test.dn, Attribute: test.attribute, Value: test.value}, conn)
+		if code != test.code {
+			t.Errorf("Compare of %s %s by %q returned %d, expected %d", test.dn, test.attribute, test.boundDN, code, test.code)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * Copyright 2021 The LibreGraph Authors.
+ */
+
+package ldif
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/libregraph/idm/pkg/ldapserver"
+)
+
+// mapIdentity returns the DN of the single entry in current which matches the
+// provided search request. An empty base DN in the request means baseDN.
+func mapIdentity(current *ldifMemoryValue, baseDN string, req *ldap.SearchRequest) (string, error) {
+	searchBaseDN := strings.ToLower(req.BaseDN)
+	if searchBaseDN == "" {
+		searchBaseDN = baseDN
+	}
+	if !strings.HasSuffix(searchBaseDN, baseDN) {
+		return "", fmt.Errorf("identity base DN is not in our BaseDN %s", baseDN)
+	}
+
+	filterPacket, err := ldapserver.CompileFilter(req.Filter)
+	if err != nil {
+		return "", err
+	}
+
+	var matches []string
+	var walkErr error
+	current.t.WalkSuffix([]byte(searchBaseDN), func(key []byte, entryRecord interface{}) bool {
+		entry := entryRecord.(*ldifEntry).Entry
+		keep, resultCode := ldapserver.ServerFilterScope(searchBaseDN, req.Scope, entry)
+		if resultCode != ldap.LDAPResultSuccess {
+			walkErr = fmt.Errorf("identity scope apply error: %d", resultCode)
+			return true
+		}
+		if !keep {
+			return false
+		}
+		keep, resultCode = ldapserver.ServerApplyFilter(filterPacket, entry)
+		if resultCode != ldap.LDAPResultSuccess {
+			walkErr = fmt.Errorf("identity filter apply error: %d", resultCode)
+			return true
+		}
+		if keep {
+			matches = append(matches, entry.DN)
+		}
+		return false
+	})
+	if walkErr != nil {
+		return "", walkErr
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("identity not found")
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("identity matches %d entries", len(matches))
+	}
+}
@@ -11,7 +11,9 @@ import (
 
 	"github.com/armon/go-radix"
 	"github.com/libregraph/idm/pkg/ldapserver"
+	"github.com/libregraph/idm/pkg/schema"
 	"github.com/spacewander/go-suffix-tree"
+	"golang.org/x/text/cases"
 )
 
 var indexAttributes = map[string]string{
@@ -71,6 +73,38 @@ func (im indexMap) Load(name, op string, value ...string) ([]*ldifEntry, bool) {
 	return entries, true
 }
 
+// indexEqualityMap indexes values in their normalized form according to
+// the equality matching rule of the attribute.
+type indexEqualityMap struct {
+	m    indexMap
+	rule *schema.MatchingRule
+}
+
+func newIndexEqualityMap(rule *schema.MatchingRule) *indexEqualityMap {
+	return &indexEqualityMap{
+		m:    newIndexMap(),
+		rule: rule,
+	}
+}
+
+func (iem *indexEqualityMap) Add(name, op string, values []string, entry *ldifEntry) bool {
+	for _, value := range values {
+		// Values which are not valid for the rule never match.
+		if normalized, ok := iem.rule.Normalize(value); ok {
+			iem.m[normalized] = append(iem.m[normalized], entry)
+		}
+	}
+	return true
+}
+
+func (iem *indexEqualityMap) Load(name, op string, value ...string) ([]*ldifEntry, bool) {
+	normalized, ok := iem.rule.Normalize(value[0])
+	if !ok {
+		return nil, true
+	}
+	return iem.m[normalized], true
+}
+
 type indexSuffixTree struct {
 	t *suffix.Tree
 }
@@ -138,54 +172,83 @@ func (irt *indexRadixTree) Load(name, op string, value ...string) ([]*ldifEntry,
 }
 
 type indexSubTree struct {
-	pres indexMap
-	irt  *indexRadixTree
-	ist  *indexSuffixTree
+	irt *indexRadixTree
+	ist *indexSuffixTree
 }
 
 func newIndexSubTree() *indexSubTree {
 	return &indexSubTree{
-		pres: newIndexMap(),
-		irt:  newIndexRadixTree(),
-		ist:  newIndexSuffixTree(),
+		irt: newIndexRadixTree(),
+		ist: newIndexSuffixTree(),
 	}
 }
 
 func (idx *indexSubTree) Add(name, op string, values []string, entry *ldifEntry) bool {
-	ok0 := idx.pres.Add(name, op, []string{""}, entry)
-	ok1 := idx.irt.Add(name, op, values, entry)
-	ok2 := idx.ist.Add(name, op, values, entry)
-	return ok0 || ok1 || ok2
+	casefold := cases.Fold()
+	folded := make([]string, len(values))
+	for i, value := range values {
+		folded[i] = casefold.String(value)
+	}
+	ok1 := idx.irt.Add(name, op, folded, entry)
+	ok2 := idx.ist.Add(name, op, folded, entry)
+	return ok1 || ok2
 }
 
+// Load returns the entries with a value matching the substrings in params,
+// which are pairs of value and substrings filter tag. The values in the
+// prefix tree starting with the initial substring, or else the values in
+// the suffix tree ending with the final substring, are matched against all
+// substrings.
 func (idx *indexSubTree) Load(name, op string, params ...string) ([]*ldifEntry, bool) {
-	if len(params) != 2 {
-		// Require one value and sub tag.
+	if len(params) == 0 || len(params)%2 != 0 {
+		// Require pairs of value and sub tag.
 		return nil, false
 	}
-	tag, err := strconv.ParseInt(params[1], 10, 64)
-	if err != nil {
-		panic(err)
-	}
-
-	switch tag {
-	case ldapserver.FilterSubstringsAny:
-		// TODO(longsleep): Find a suitable way for full text search substring
-		// matching, for example with Knuth-Morris-Pratt algorithm. Currently
-		// we just do a presence match.
-		return idx.pres.Load(name, op, "")
-	case ldapserver.FilterSubstringsInitial:
-		return idx.irt.Load(name, op, params[0])
-	case ldapserver.FilterSubstringsFinal:
-		return idx.ist.Load(name, op, params[0])
+	casefold := cases.Fold()
+	assertion := &ldapserver.SubstringAssertion{}
+	for i := 0; i < len(params); i += 2 {
+		tag, err := strconv.ParseInt(params[i+1], 10, 64)
+		if err != nil {
+			panic(err)
+		}
+		value := casefold.String(params[i])
+		switch tag {
+		case ldapserver.FilterSubstringsInitial:
+			assertion.Initial = value
+		case ldapserver.FilterSubstringsAny:
+			assertion.Any = append(assertion.Any, value)
+		case ldapserver.FilterSubstringsFinal:
+			assertion.Final = value
+		default:
+			return nil, false
+		}
+	}
+
+	var entries []*ldifEntry
+	switch {
+	case assertion.Initial != "" || assertion.Final == "":
+		idx.irt.t.WalkPrefix(assertion.Initial, func(key string, value interface{}) bool {
+			if assertion.Match(key) {
+				entries = append(entries, value.([]*ldifEntry)...)
+			}
+			return false
+		})
 	default:
-		return nil, false
+		idx.ist.t.WalkSuffix([]byte(assertion.Final), func(key []byte, value interface{}) bool {
+			if assertion.Match(string(key)) {
+				entries = append(entries, value.([]*ldifEntry)...)
+			}
+			return false
+		})
 	}
+	return entries, true
 }
 
 type indexMapRegister map[string]Index
 
-func newIndexMapRegister() indexMapRegister {
+// newIndexMapRegister returns the indexes of the index attributes. Equality
+// indexes use the equality matching rules of the attribute types in s.
+func newIndexMapRegister(s *schema.Schema) indexMapRegister {
 	imr := make(indexMapRegister)
 	for name, ops := range indexAttributes {
 		for _, op := range strings.Split(ops, ",") {
@@ -195,7 +258,11 @@ func newIndexMapRegister() indexMapRegister {
 			case "pres":
 				imr[imr.getKey(name, op)] = newIndexMap()
 			case "eq":
-				imr[imr.getKey(name, op)] = newIndexMap()
+				if rule, ok := s.EqualityRule(name); ok {
+					imr[imr.getKey(name, op)] = newIndexEqualityMap(rule)
+				} else {
+					imr[imr.getKey(name, op)] = newIndexMap()
+				}
 			}
 		}
 	}
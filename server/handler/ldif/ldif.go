@@ -12,13 +12,21 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/go-ldap/ldap/v3"
 	"github.com/go-ldap/ldif"
+	"github.com/google/uuid"
 	"github.com/spacewander/go-suffix-tree"
+
+	"github.com/libregraph/idm/pkg/ldapdn"
+	"github.com/libregraph/idm/pkg/ldapentry"
+	"github.com/libregraph/idm/pkg/ldapserver"
+	"github.com/libregraph/idm/pkg/schema"
 )
 
 // parseLDIFFile opens the named file for reading and parses it as LDIF.
@@ -138,23 +146,44 @@ func parseLDIF(r io.Reader, options *Options) (*ldif.LDIF, error) {
 	return l, nil
 }
 
+// ldifLoad is a load of LDIF data, for which the operational attributes of
+// the entries are synthesized.
+type ldifLoad struct {
+	schema *schema.Schema
+	loaded time.Time
+
+	// csn is the entryCSN of the entries which are new or changed since
+	// the previous load, whose entries are in previous.
+	csn      string
+	previous *suffix.Tree
+}
+
 // treeFromLDIF makes a tree out of the provided LDIF and if index is not nil,
-// also indexes each entry in the provided index.
-func treeFromLDIF(l *ldif.LDIF, index Index, options *Options) (*suffix.Tree, error) {
+// also indexes each entry in the provided index. If load is not nil, the
+// operational attributes of the entries are synthesized for it.
+func treeFromLDIF(l *ldif.LDIF, index Index, options *Options, load *ldifLoad) (*suffix.Tree, error) {
 	t := suffix.NewTree()
 
 	// NOTE(longsleep): Create in memory tree records from LDIF data.
 	var entry *ldap.Entry
+	var entries []*ldifEntry
 	for _, entryRecord := range l.Entries {
 		if entryRecord == nil || entryRecord.Entry == nil {
 			// NOTE(longsleep): We don't use l.AllEntries as "nil" records can happen.
 			continue
 		}
-		entry = entryRecord.Entry
+		entry = &ldap.Entry{
+			DN:         strings.ToLower(entryRecord.Entry.DN),
+			Attributes: entryRecord.Entry.Attributes,
+		}
+		if load != nil {
+			load.setOperationalAttributes(entry, options.AdminDN)
+		}
 		e := &ldifEntry{
 			Entry: &ldap.Entry{
-				DN: strings.ToLower(entry.DN),
+				DN: entry.DN,
 			},
+			source: entryRecord.Entry.Attributes,
 		}
 		for _, a := range entry.Attributes {
 			switch strings.ToLower(a.Name) {
@@ -184,7 +213,94 @@ func treeFromLDIF(l *ldif.LDIF, index Index, options *Options) (*suffix.Tree, er
 		if !ok || v != nil {
 			return nil, fmt.Errorf("duplicate dn value: %s", e.DN)
 		}
+		entries = append(entries, e)
+	}
+	if load != nil {
+		setHasSubordinates(entries)
 	}
 
 	return t, nil
 }
+
+// setOperationalAttributes synthesizes the operational attributes of the
+// LDIF entry e, which are not present in the LDIF. Entries are considered
+// to be created and modified by adminDN at the time the LDIF was loaded.
+// Their entryUUID is derived from their DN, so that it is stable.
+func setOperationalAttributes(s *schema.Schema, e *ldap.Entry, adminDN string, loaded time.Time) {
+	ldapserver.SetOperationalAttributes(s, e)
+
+	nDN, err := ldapdn.ParseNormalize(e.DN)
+	if err != nil {
+		nDN = e.DN
+	}
+	timestamp := ldapserver.GeneralizedTime(loaded)
+	for _, attr := range []struct {
+		name  string
+		value string
+	}{
+		{ldapserver.CreateTimestampAttribute, timestamp},
+		{ldapserver.ModifyTimestampAttribute, timestamp},
+		{ldapserver.CreatorsNameAttribute, adminDN},
+		{ldapserver.ModifiersNameAttribute, adminDN},
+		{ldapserver.EntryUUIDAttribute, uuid.NewSHA1(uuid.NameSpaceX500, []byte(nDN)).String()},
+	} {
+		if attr.value != "" && len(e.GetEqualFoldAttributeValues(attr.name)) == 0 {
+			ldapentry.SetAttributeValues(e, attr.name, []string{attr.value})
+		}
+	}
+}
+
+// setOperationalAttributes synthesizes the operational attributes of e for
+// the load. Entries which are unchanged since the previous load keep their
+// entryCSN and timestamps, changed entries keep their createTimestamp. New
+// and changed entries get the CSN of the load.
+func (load *ldifLoad) setOperationalAttributes(e *ldap.Entry, adminDN string) {
+	var previous *ldifEntry
+	if load.previous != nil {
+		if v, found := load.previous.Get([]byte(e.DN)); found {
+			previous = v.(*ldifEntry)
+		}
+	}
+	var kept []string
+	switch {
+	case previous == nil:
+	case sameAttributes(previous.source, e.Attributes):
+		kept = []string{ldapserver.EntryCSNAttribute, ldapserver.CreateTimestampAttribute, ldapserver.ModifyTimestampAttribute}
+	default:
+		kept = []string{ldapserver.CreateTimestampAttribute}
+	}
+	for _, name := range kept {
+		if values := previous.GetEqualFoldAttributeValues(name); len(values) > 0 && len(e.GetEqualFoldAttributeValues(name)) == 0 {
+			ldapentry.SetAttributeValues(e, name, values)
+		}
+	}
+	if load.csn != "" && len(e.GetEqualFoldAttributeValues(ldapserver.EntryCSNAttribute)) == 0 {
+		ldapentry.SetAttributeValues(e, ldapserver.EntryCSNAttribute, []string{load.csn})
+	}
+	setOperationalAttributes(load.schema, e, adminDN, load.loaded)
+}
+
+// sameAttributes returns true if a and b have the same attributes with the
+// same values in the same order.
+func sameAttributes(a, b []*ldap.EntryAttribute) bool {
+	return slices.EqualFunc(a, b, func(x, y *ldap.EntryAttribute) bool {
+		return x.Name == y.Name && slices.Equal(x.Values, y.Values)
+	})
+}
+
+// setHasSubordinates sets the hasSubordinates attribute of entries.
+func setHasSubordinates(entries []*ldifEntry) {
+	parents := make(map[string]bool)
+	for _, e := range entries {
+		if dn, err := ldap.ParseDN(e.DN); err == nil && len(dn.RDNs) > 1 {
+			parents[ldapdn.Normalize(&ldap.DN{RDNs: dn.RDNs[1:]})] = true
+		}
+	}
+	for _, e := range entries {
+		hasSubordinates := "FALSE"
+		if nDN, err := ldapdn.ParseNormalize(e.DN); err == nil && parents[nDN] {
+			hasSubordinates = "TRUE"
+		}
+		ldapentry.SetAttributeValues(e.Entry, ldapserver.HasSubordinatesAttribute, []string{hasSubordinates})
+	}
+}
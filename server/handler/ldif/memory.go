@@ -8,6 +8,8 @@ package ldif
 import (
 	"github.com/go-ldap/ldif"
 	"github.com/spacewander/go-suffix-tree"
+
+	"github.com/libregraph/idm/pkg/ldapserver"
 )
 
 type ldifMemoryValue struct {
@@ -15,4 +17,32 @@ type ldifMemoryValue struct {
 	t *suffix.Tree
 
 	index Index
+
+	// csn is the largest entryCSN of the entries.
+	csn string
+}
+
+// syncChanges returns the changes of the entries of value since previous.
+// Entries which were changed have a different entryCSN. All changes have the
+// CSN of value.
+func (value *ldifMemoryValue) syncChanges(previous *ldifMemoryValue) []ldapserver.SyncChange {
+	var changes []ldapserver.SyncChange
+	value.t.Walk(func(key []byte, v interface{}) bool {
+		entry := v.(*ldifEntry)
+		old, found := previous.t.Get(key)
+		switch {
+		case !found:
+			changes = append(changes, ldapserver.SyncChange{NewEntry: entry.Entry, CSN: value.csn})
+		case old.(*ldifEntry).GetEqualFoldAttributeValue(ldapserver.EntryCSNAttribute) != entry.GetEqualFoldAttributeValue(ldapserver.EntryCSNAttribute):
+			changes = append(changes, ldapserver.SyncChange{OldEntry: old.(*ldifEntry).Entry, NewEntry: entry.Entry, CSN: value.csn})
+		}
+		return false
+	})
+	previous.t.Walk(func(key []byte, v interface{}) bool {
+		if _, found := value.t.Get(key); !found {
+			changes = append(changes, ldapserver.SyncChange{OldEntry: v.(*ldifEntry).Entry, CSN: value.csn})
+		}
+		return false
+	})
+	return changes
 }
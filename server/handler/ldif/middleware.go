@@ -17,6 +17,7 @@ import (
 	"github.com/go-ldap/ldap/v3"
 	"github.com/sirupsen/logrus"
 
+	"github.com/libregraph/idm/pkg/ldappassword"
 	"github.com/libregraph/idm/pkg/ldapserver"
 	"github.com/libregraph/idm/server/handler"
 )
@@ -76,7 +77,7 @@ func (h *ldifMiddleware) open() error {
 		return err
 	}
 
-	t, err := treeFromLDIF(l, nil, h.options)
+	t, err := treeFromLDIF(l, nil, h.options, nil)
 	if err != nil {
 		return err
 	}
@@ -128,7 +129,7 @@ func (h *ldifMiddleware) Reload(ctx context.Context) error {
 	return h.next.Reload(ctx)
 }
 
-func (h *ldifMiddleware) Add(_ string, _ *ldap.AddRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
+func (h *ldifMiddleware) Add(_ context.Context, _ string, _ *ldap.AddRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
 	return ldap.LDAPResultUnwillingToPerform, errors.New("unsupported operation")
 }
 
@@ -165,24 +166,58 @@ func (h *ldifMiddleware) Bind(bindDN, bindSimplePw string, conn net.Conn) (resul
 	return h.next.Bind(bindDN, bindSimplePw, conn)
 }
 
-func (h *ldifMiddleware) Delete(_ string, _ *ldap.DelRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
+func (h *ldifMiddleware) Compare(ctx context.Context, bindDN string, req *ldap.CompareRequest, conn net.Conn) (ldapserver.LDAPResultCode, error) {
+	if bindDN != "" || !strings.EqualFold(req.Attribute, "userPassword") {
+		if entryRecord, found := h.load().t.Get([]byte(strings.ToLower(req.DN))); found {
+			return entryRecord.(*ldifEntry).compare(req.Attribute, req.Value), nil
+		}
+	}
+	return h.next.Compare(ctx, bindDN, req, conn)
+}
+
+func (h *ldifMiddleware) Delete(_ context.Context, _ string, _ *ldap.DelRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
 	return ldap.LDAPResultUnwillingToPerform, errors.New("unsupported operation")
 }
 
-func (h *ldifMiddleware) Modify(_ string, _ *ldap.ModifyRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
+func (h *ldifMiddleware) Modify(_ context.Context, _ string, _ *ldap.ModifyRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
 	return ldap.LDAPResultUnwillingToPerform, errors.New("unsupported operation")
 }
 
-func (h *ldifMiddleware) ModifyDN(_ string, _ *ldap.ModifyDNRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
+func (h *ldifMiddleware) ModifyDN(_ context.Context, _ string, _ *ldap.ModifyDNRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
 	return ldap.LDAPResultUnwillingToPerform, errors.New("unsupported operation")
 }
 
-func (h *ldifMiddleware) ModifyPasswordExop(_ string, _ *ldap.PasswordModifyRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
+func (h *ldifMiddleware) CommitTransaction(_ context.Context, _ string, _ []ldapserver.TransactionRequest, _ net.Conn) (ldapserver.TransactionResult, error) {
+	return ldapserver.TransactionResult{ResultCode: ldap.LDAPResultUnwillingToPerform}, errors.New("unsupported operation")
+}
+
+func (h *ldifMiddleware) ModifyPasswordExop(_ context.Context, _ string, _ *ldap.PasswordModifyRequest, _ net.Conn) (ldapserver.LDAPResultCode, error) {
 	return ldap.LDAPResultUnwillingToPerform, errors.New("unsupported operation")
 }
 
-func (h *ldifMiddleware) Search(bindDN string, searchReq *ldap.SearchRequest, conn net.Conn) (result ldapserver.ServerSearchResult, err error) {
-	return h.next.Search(bindDN, searchReq, conn)
+func (h *ldifMiddleware) Search(ctx context.Context, bindDN string, searchReq *ldap.SearchRequest, conn net.Conn) (result ldapserver.ServerSearchResult, err error) {
+	return h.next.Search(ctx, bindDN, searchReq, conn)
+}
+
+func (h *ldifMiddleware) Sync(ctx context.Context, bindDN string, searchReq *ldap.SearchRequest, conn net.Conn) (ldapserver.ServerSyncResult, error) {
+	return h.next.Sync(ctx, bindDN, searchReq, conn)
+}
+
+func (h *ldifMiddleware) MapIdentity(req *ldap.SearchRequest, conn net.Conn) (string, error) {
+	dn, err := mapIdentity(h.load(), h.baseDN, req)
+	if err == nil {
+		return dn, nil
+	}
+	return h.next.MapIdentity(req, conn)
+}
+
+func (h *ldifMiddleware) SCRAMVerifier(dn, mechanism string, conn net.Conn) (*ldappassword.SCRAMVerifier, error) {
+	dn = strings.ToLower(dn)
+
+	if entryRecord, found := h.load().t.Get([]byte(dn)); found {
+		return entryRecord.(*ldifEntry).scramVerifier(mechanism)
+	}
+	return h.next.SCRAMVerifier(dn, mechanism, conn)
 }
 
 func (h *ldifMiddleware) Close(bindDN string, conn net.Conn) error {
@@ -5,11 +5,19 @@
 
 package ldif
 
+import (
+	"github.com/libregraph/idm/pkg/schema"
+)
+
 type Options struct {
 	BaseDN                  string
 	AdminDN                 string
 	AllowLocalAnonymousBind bool
 
+	// Schema returns the schema which is used to sort search results. The
+	// built-in schema is used if Schema is nil.
+	Schema func() *schema.Schema
+
 	DefaultCompany    string
 	DefaultMailDomain string
 
@@ -7,6 +7,8 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log"
 	"os"
@@ -20,6 +22,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/libregraph/idm/pkg/ldapserver"
+	"github.com/libregraph/idm/pkg/schema"
 	"github.com/libregraph/idm/server/handler"
 	"github.com/libregraph/idm/server/handler/boltdb"
 	"github.com/libregraph/idm/server/handler/ldif"
@@ -50,7 +53,61 @@ func NewServer(c *Config) (*Server, error) {
 	s.LDAPServer.GeneratedPasswordLength = DefaultGeneratedPasswordLength
 	ldapserver.Logger(logrusr.New(c.Logger))
 
-	var err error
+	if c.TLSCertFile != "" && c.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		s.LDAPServer.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+		if c.TLSClientCAFile != "" {
+			pem, err := os.ReadFile(c.TLSClientCAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read TLS client CA file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in TLS client CA file")
+			}
+			s.LDAPServer.TLSConfig.ClientCAs = pool
+			s.LDAPServer.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	s.LDAPServer.RequireTLSForBind = c.LDAPRequireTLSForBind
+	if c.LDAPMaxConcurrentOperations > 0 {
+		s.LDAPServer.MaxConcurrentOperations = c.LDAPMaxConcurrentOperations
+	}
+	if c.SASLExternalMapping != "" {
+		if err := ldapserver.ValidateSASLExternalMapping(c.SASLExternalMapping); err != nil {
+			return nil, err
+		}
+		s.LDAPServer.SASLExternalMapping = c.SASLExternalMapping
+	}
+	if c.SASLUsernameAttribute != "" {
+		if err := ldapserver.ValidateSASLUsernameAttribute(c.SASLUsernameAttribute); err != nil {
+			return nil, err
+		}
+		s.LDAPServer.SASLUsernameAttribute = c.SASLUsernameAttribute
+	}
+
+	if c.LDAPProxyAuthzGroup != "" {
+		if _, err := ldap.ParseDN(c.LDAPProxyAuthzGroup); err != nil {
+			return nil, fmt.Errorf("invalid proxy authorization group: %w", err)
+		}
+		s.LDAPServer.ProxyAuthorization = s.LDAPServer.ProxyGroupRule(c.LDAPProxyAuthzGroup, c.LDAPBaseDN, c.LDAPAdminDN)
+		s.LDAPServer.SupportedControls = append(s.LDAPServer.SupportedControls, ldapserver.ControlTypeProxiedAuthorization)
+	}
+
+	if c.LDAPBaseDN != "" {
+		s.LDAPServer.NamingContexts = []string{c.LDAPBaseDN}
+	}
+
+	ldapSchema, err := s.loadSchema()
+	if err != nil {
+		return nil, err
+	}
+	s.LDAPServer.SetSchema(ldapSchema)
+
 	switch c.LDAPHandler {
 	case "ldif":
 		ldifHandlerOptions := &ldif.Options{
@@ -58,6 +115,8 @@ func NewServer(c *Config) (*Server, error) {
 			AdminDN:                 s.config.LDAPAdminDN,
 			AllowLocalAnonymousBind: s.config.LDAPAllowLocalAnonymousBind,
 
+			Schema: s.LDAPServer.Schema,
+
 			DefaultCompany:    s.config.LDIFDefaultCompany,
 			DefaultMailDomain: s.config.LDIFDefaultMailDomain,
 			TemplateExtraVars: s.config.LDIFTemplateExtraVars,
@@ -82,6 +141,9 @@ func NewServer(c *Config) (*Server, error) {
 			AdminDN: s.config.LDAPAdminDN,
 
 			AllowLocalAnonymousBind: s.config.LDAPAllowLocalAnonymousBind,
+
+			Schema:      s.LDAPServer.Schema,
+			SchemaCheck: s.config.BoltDBSchemaCheck,
 		}
 		s.LDAPHandler, err = boltdb.NewBoltDBHandler(s.logger, s.config.BoltDBFile, boltOptions)
 		if err != nil {
@@ -90,9 +152,13 @@ func NewServer(c *Config) (*Server, error) {
 
 		// FIXME Let the frontend (LDAPServer) handle filtering and attribute list until we added backend support
 		s.LDAPServer.EnforceLDAP = true
+		// The controls of write operations and transactions are processed by
+		// the BoltDB handler.
+		s.LDAPServer.SupportedControls = append(s.LDAPServer.SupportedControls, ldapserver.ControlTypeAssertion, ldapserver.ControlTypePreRead, ldapserver.ControlTypePostRead, ldapserver.ControlTypeTransactionSpecification)
 	default:
 		return nil, fmt.Errorf("unknown LDAPHandler: '%s'", c.LDAPHandler)
 	}
+	s.LDAPServer.SupportedControls = append(s.LDAPServer.SupportedControls, ldap.ControlTypePaging, ldap.ControlTypeSyncRequest, ldapserver.ControlTypePersistentSearch)
 
 	if c.Metrics != nil {
 		s.LDAPServer.SetStats(true)
@@ -102,6 +168,18 @@ func NewServer(c *Config) (*Server, error) {
 	return s, nil
 }
 
+// loadSchema returns the built-in schema extended with the schema files of the
+// configured schema directory.
+func (s *Server) loadSchema() (*schema.Schema, error) {
+	ldapSchema := schema.New()
+	if s.config.SchemaDir != "" {
+		if err := ldapSchema.LoadDir(s.config.SchemaDir); err != nil {
+			return nil, fmt.Errorf("failed to load schema: %w", err)
+		}
+	}
+	return ldapSchema, nil
+}
+
 // Serve starts all the accociated servers resources and listeners and blocks
 // forever until signals or error occurs.
 func (s *Server) Serve(ctx context.Context) error {
@@ -138,11 +216,16 @@ func (s *Server) Serve(ctx context.Context) error {
 	ldapHandler := s.LDAPHandler.WithContext(serveCtx)
 	s.LDAPServer.AddFunc("", ldapHandler)
 	s.LDAPServer.BindFunc("", ldapHandler)
+	s.LDAPServer.CompareFunc("", ldapHandler)
 	s.LDAPServer.DeleteFunc("", ldapHandler)
 	s.LDAPServer.ModifyFunc("", ldapHandler)
 	s.LDAPServer.ModifyDNFunc("", ldapHandler)
 	s.LDAPServer.PasswordExOpFunc("", ldapHandler)
 	s.LDAPServer.SearchFunc("", ldapHandler)
+	s.LDAPServer.SyncFunc("", ldapHandler)
+	s.LDAPServer.TransactionFunc("", ldapHandler)
+	s.LDAPServer.IdentityMapperFunc("", ldapHandler)
+	s.LDAPServer.SCRAMProviderFunc("", ldapHandler)
 	s.LDAPServer.CloseFunc("", ldapHandler)
 
 	serversWg.Add(1)
@@ -151,6 +234,11 @@ func (s *Server) Serve(ctx context.Context) error {
 		for {
 			select {
 			case <-triggerCh:
+				if ldapSchema, schemaErr := s.loadSchema(); schemaErr != nil {
+					logger.WithError(schemaErr).Errorln("schema reload failed, keeping previous schema")
+				} else {
+					s.LDAPServer.SetSchema(ldapSchema)
+				}
 				reloadErr := ldapHandler.Reload(serveCtx)
 				if reloadErr != nil {
 					logger.Debugln("reload error: %w", reloadErr)